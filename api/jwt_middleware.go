@@ -3,18 +3,24 @@ package api
 import (
 	"github.com/auth0-community/go-auth0"
 	"github.com/kelseyhightower/envconfig"
+	"github.com/kyrstenkelly/rsvp-api/db/access"
+	"github.com/kyrstenkelly/rsvp-api/db/models"
+	"github.com/kyrstenkelly/rsvp-api/utils"
 	log "github.com/sirupsen/logrus"
 	jose "gopkg.in/square/go-jose.v2"
 	"net/http"
 )
 
-var authenticatedEndpoints = []string{"/admin"}
-
 // AuthConfig holds the auth configuration
 type AuthConfig struct {
 	ClientAudience string `envconfig:"AUTH_CLIENT_AUDIENCE"`
 	ClientDomain   string `envconfig:"AUTH_CLIENT_DOMAIN"`
 	ClientSecret   string `envconfig:"AUTH_CLIENT_SECRET"`
+	RolesClaim     string `envconfig:"AUTH_ROLES_CLAIM" default:"roles"`
+	DefaultRole    string `envconfig:"AUTH_DEFAULT_ROLE"`
+
+	OrganizationClaim   string `envconfig:"AUTH_ORGANIZATION_CLAIM" default:"org"`
+	DefaultOrganization string `envconfig:"AUTH_DEFAULT_ORGANIZATION"`
 }
 
 // GetConfig loads the config object from env vars and returns it
@@ -28,7 +34,19 @@ func GetConfig() (*AuthConfig, error) {
 	return &config, nil
 }
 
-func authMiddleware(next http.Handler) http.Handler {
+// middleware wraps a handler so it requires a permission
+type middleware func(next http.Handler, permission Permission) http.Handler
+
+// newAuthMiddleware creates a middleware that checks the request has a valid token whose roles
+// grant the permission, and scopes the request to the organization the token names
+func newAuthMiddleware(transactor access.Transactor, organizationsDAO access.OrganizationsAccess) middleware {
+	return func(next http.Handler, permission Permission) http.Handler {
+		return authMiddleware(next, permission, transactor, organizationsDAO)
+	}
+}
+
+func authMiddleware(next http.Handler, permission Permission, transactor access.Transactor,
+	organizationsDAO access.OrganizationsAccess) http.Handler {
 	config, err := GetConfig()
 	if err != nil {
 		log.Error("Unable to configure auth0")
@@ -50,8 +68,60 @@ func authMiddleware(next http.Handler) http.Handler {
 			}).Error("Token is not valid")
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusUnauthorized)
-		} else {
-			next.ServeHTTP(w, r)
+			return
+		}
+
+		claims := map[string]interface{}{}
+		if err := validator.Claims(r, token, &claims); err != nil {
+			log.WithFields(log.Fields{
+				"err": err,
+			}).Error("Token claims are not valid")
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		principal := &utils.Principal{
+			Roles: rolesFromClaim(claims[config.RolesClaim]),
+		}
+		principal.Subject, _ = claims["sub"].(string)
+		if len(principal.Roles) == 0 && config.DefaultRole != "" {
+			principal.Roles = []string{config.DefaultRole}
+		}
+
+		if !hasPermission(principal.Roles, permission) {
+			log.WithFields(log.Fields{
+				"sub":        principal.Subject,
+				"roles":      principal.Roles,
+				"permission": permission,
+			}).Error("Token does not grant permission")
+			utils.WriteError(w, utils.HTTPForbiddenError.Here(), http.StatusForbidden)
+			return
+		}
+
+		slug, _ := claims[config.OrganizationClaim].(string)
+		if slug == "" {
+			slug = config.DefaultOrganization
+		}
+		organization, err := access.Run(r.Context(), transactor, func(tx access.Tx) (interface{}, error) {
+			return organizationsDAO.GetOrganizationBySlug(tx, slug)
+		})
+		if err != nil {
+			utils.WriteError(w, err, http.StatusInternalServerError)
+			return
 		}
+		if slug == "" || organization.(*models.Organization) == nil {
+			log.WithFields(log.Fields{
+				"sub":          principal.Subject,
+				"organization": slug,
+			}).Error("Token does not name an organization")
+			utils.WriteError(w, utils.HTTPForbiddenError.Here().WithMessage("403 Forbidden: unknown organization"), http.StatusForbidden)
+			return
+		}
+		principal.OrganizationID = organization.(*models.Organization).ID
+
+		ctx := access.WithOrganization(r.Context(), principal.OrganizationID)
+		r = r.WithContext(access.WithActor(ctx, principal.Subject))
+		next.ServeHTTP(w, utils.WithPrincipal(r, principal))
 	})
 }
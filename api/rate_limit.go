@@ -0,0 +1,164 @@
+package api
+
+import (
+	"context"
+	"github.com/kelseyhightower/envconfig"
+	"github.com/kyrstenkelly/rsvp-api/utils"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimitConfig holds the configuration for limiting failed RSVP code lookups. TrustedProxies is how
+// many proxies in front of the API append to X-Forwarded-For; with none, the header is ignored.
+type RateLimitConfig struct {
+	MaxFailures    int           `envconfig:"RSVP_LOOKUP_MAX_FAILURES" default:"10"`
+	Window         time.Duration `envconfig:"RSVP_LOOKUP_WINDOW" default:"15m"`
+	TrustedProxies int           `envconfig:"RSVP_LOOKUP_TRUSTED_PROXIES" default:"0"`
+}
+
+// GetRateLimitConfig loads the rate limit config object from env vars and returns it
+func GetRateLimitConfig() (*RateLimitConfig, error) {
+	var config RateLimitConfig
+
+	if err := envconfig.Process("", &config); err != nil {
+		return nil, err
+	}
+
+	return &config, nil
+}
+
+// failedLookupLimiter blocks clients that have made too many lookups that came back
+// 404 within the configured window, so RSVP codes can't be brute forced
+type failedLookupLimiter struct {
+	mu       sync.Mutex
+	config   RateLimitConfig
+	failures map[string][]time.Time
+}
+
+func newFailedLookupLimiter() *failedLookupLimiter {
+	config, err := GetRateLimitConfig()
+	if err != nil {
+		log.Error("Unable to configure rate limiting, using defaults")
+		config = &RateLimitConfig{MaxFailures: 10, Window: 15 * time.Minute}
+	}
+	return &failedLookupLimiter{
+		config:   *config,
+		failures: map[string][]time.Time{},
+	}
+}
+
+// start sweeps out clients whose failures have all aged out once every window, until ctx is done
+func (l *failedLookupLimiter) start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(l.config.Window)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				l.sweep(now)
+			}
+		}
+	}()
+}
+
+func (l *failedLookupLimiter) sweep(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for client := range l.failures {
+		l.recent(client, now)
+	}
+}
+
+// recent drops failures that have aged out of the window. Callers must hold the lock.
+func (l *failedLookupLimiter) recent(client string, now time.Time) []time.Time {
+	cutoff := now.Add(-l.config.Window)
+	var kept []time.Time
+	for _, t := range l.failures[client] {
+		if t.After(cutoff) {
+			kept = append(kept, t)
+		}
+	}
+	if len(kept) == 0 {
+		delete(l.failures, client)
+	} else {
+		l.failures[client] = kept
+	}
+	return kept
+}
+
+func (l *failedLookupLimiter) blocked(client string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.recent(client, now)) >= l.config.MaxFailures
+}
+
+func (l *failedLookupLimiter) recordFailure(client string, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.failures[client] = append(l.recent(client, now), now)
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (l *failedLookupLimiter) middleware(next http.Handler) http.Handler {
+	tooManyRequests := utils.WrapHandler(func(r *http.Request, vars map[string]string) ([]byte, int, error) {
+		return nil, http.StatusTooManyRequests, utils.HTTPTooManyRequestsError
+	})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client := clientIP(r, l.config.TrustedProxies)
+		if l.blocked(client, time.Now()) {
+			log.WithFields(log.Fields{
+				"client": client,
+			}).Warn("Too many failed RSVP code lookups")
+			tooManyRequests.ServeHTTP(w, r)
+			return
+		}
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+		if recorder.status == http.StatusNotFound {
+			l.recordFailure(client, time.Now())
+		}
+	})
+}
+
+// clientIP is the address of the client behind the given number of trusted proxies. Each proxy appends
+// the address it was reached from to X-Forwarded-For, so the client is that many entries from the end;
+// anything before it may have been made up by the client. Requests that didn't come through every
+// proxy are keyed on the address they came from.
+func clientIP(r *http.Request, trustedProxies int) string {
+	if trustedProxies > 0 {
+		var hops []string
+		for _, header := range r.Header["X-Forwarded-For"] {
+			for _, hop := range strings.Split(header, ",") {
+				hops = append(hops, strings.TrimSpace(hop))
+			}
+		}
+		if len(hops) >= trustedProxies {
+			if hop := hops[len(hops)-trustedProxies]; net.ParseIP(hop) != nil {
+				return hop
+			}
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
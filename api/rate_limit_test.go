@@ -0,0 +1,70 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClientIP(t *testing.T) {
+	cases := []struct {
+		name           string
+		forwardedFor   []string
+		trustedProxies int
+		want           string
+	}{
+		{"No proxies ignores the header", []string{"203.0.113.9"}, 0, "10.0.0.1"},
+		{"One proxy takes the last hop", []string{"198.51.100.1, 203.0.113.9"}, 1, "203.0.113.9"},
+		{"Two proxies skip the nearer one", []string{"198.51.100.1, 203.0.113.9", "10.0.0.2"}, 2, "203.0.113.9"},
+		{"Too few hops use the connection", []string{"203.0.113.9"}, 2, "10.0.0.1"},
+		{"No header uses the connection", nil, 1, "10.0.0.1"},
+		{"An address that isn't one uses the connection", []string{"unknown"}, 1, "10.0.0.1"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/rsvp/code", nil)
+			r.RemoteAddr = "10.0.0.1:4321"
+			for _, header := range c.forwardedFor {
+				r.Header.Add("X-Forwarded-For", header)
+			}
+			if got := clientIP(r, c.trustedProxies); got != c.want {
+				t.Errorf("Got %q, expected %q", got, c.want)
+			}
+		})
+	}
+}
+
+func TestFailedLookupLimiter(t *testing.T) {
+	limiter := &failedLookupLimiter{
+		config:   RateLimitConfig{MaxFailures: 2, Window: time.Minute, TrustedProxies: 1},
+		failures: map[string][]time.Time{},
+	}
+	notFound := limiter.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	lookup := func(client string) int {
+		r := httptest.NewRequest("GET", "/rsvp/missing", nil)
+		r.Header.Set("X-Forwarded-For", client)
+		w := httptest.NewRecorder()
+		notFound.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	for i := 0; i < 2; i++ {
+		if code := lookup("203.0.113.9"); code != http.StatusNotFound {
+			t.Fatalf("Lookup %d got %d, expected %d", i, code, http.StatusNotFound)
+		}
+	}
+	if code := lookup("203.0.113.9"); code != http.StatusTooManyRequests {
+		t.Errorf("A client past its failures got %d, expected %d", code, http.StatusTooManyRequests)
+	}
+	if code := lookup("198.51.100.1"); code != http.StatusNotFound {
+		t.Errorf("Another client behind the same proxy got %d, expected %d", code, http.StatusNotFound)
+	}
+
+	limiter.sweep(time.Now().Add(2 * time.Minute))
+	if len(limiter.failures) != 0 {
+		t.Errorf("Sweeping after the window kept %d clients", len(limiter.failures))
+	}
+}
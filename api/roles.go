@@ -0,0 +1,70 @@
+package api
+
+import (
+	"strings"
+)
+
+// Permission is something a role is allowed to do. Each route in Serve requires one.
+type Permission string
+
+const (
+	// PermissionPublic routes need no token at all. Only the guest RSVP routes are public; it is not
+	// the zero value, so a route registered without a permission is refused rather than left open.
+	PermissionPublic Permission = "public"
+	// PermissionViewEvents allows reading events and their headcount and meal reports
+	PermissionViewEvents Permission = "view_events"
+	// PermissionViewGuests allows reading invitations, guests, rsvps and emails, including contact details
+	PermissionViewGuests Permission = "view_guests"
+	// PermissionEditGuests allows creating and changing invitations, guests and rsvps, and emailing guests
+	PermissionEditGuests Permission = "edit_guests"
+	// PermissionManage allows deleting anything
+	PermissionManage Permission = "manage"
+)
+
+// Roles that may be granted in a token's roles claim
+const (
+	RoleOwner    = "owner"
+	RolePlanner  = "planner"
+	RoleReadOnly = "read-only"
+	RoleCaterer  = "caterer"
+)
+
+// rolePermissions is what each role is allowed to do. Caterers see the food report,
+// but nothing with a guest's email or address.
+var rolePermissions = map[string][]Permission{
+	RoleOwner:    {PermissionViewEvents, PermissionViewGuests, PermissionEditGuests, PermissionManage},
+	RolePlanner:  {PermissionViewEvents, PermissionViewGuests, PermissionEditGuests},
+	RoleReadOnly: {PermissionViewEvents, PermissionViewGuests},
+	RoleCaterer:  {PermissionViewEvents},
+}
+
+// hasPermission reports whether any of the roles grants the permission
+func hasPermission(roles []string, permission Permission) bool {
+	for _, role := range roles {
+		for _, granted := range rolePermissions[strings.ToLower(role)] {
+			if granted == permission {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// rolesFromClaim reads roles from a claim holding either a list of roles or a single,
+// space or comma separated string of them
+func rolesFromClaim(claim interface{}) []string {
+	var roles []string
+	switch value := claim.(type) {
+	case string:
+		roles = strings.FieldsFunc(value, func(r rune) bool {
+			return r == ' ' || r == ','
+		})
+	case []interface{}:
+		for _, role := range value {
+			if s, ok := role.(string); ok {
+				roles = append(roles, s)
+			}
+		}
+	}
+	return roles
+}
@@ -1,65 +1,179 @@
 package api
 
 import (
+	"context"
 	muxHandlers "github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
 	"github.com/kyrstenkelly/rsvp-api/db/access"
 	"github.com/kyrstenkelly/rsvp-api/handlers"
+	"github.com/kyrstenkelly/rsvp-api/importer"
+	"github.com/kyrstenkelly/rsvp-api/notifications"
+	"github.com/kyrstenkelly/rsvp-api/trash"
 	"github.com/kyrstenkelly/rsvp-api/utils"
-	log "github.com/sirupsen/logrus"
+	"github.com/kyrstenkelly/rsvp-api/webhooks"
 	"net/http"
 )
 
-func buildHandler(handlerMethod func(request *http.Request, vars map[string]string) ([]byte, int, error), authRequired bool) http.Handler {
-	handlerFunc := utils.WrapHandler(handlerMethod)
-	if authRequired {
-		return authMiddleware(handlerFunc)
+// newHandlerBuilder creates a function that wraps handler methods for the router, requiring the
+// permission through authMiddleware unless it is public. No role grants an empty permission, so
+// a route registered without one is refused to everyone.
+func newHandlerBuilder(authMiddleware middleware) func(func(*http.Request, map[string]string) ([]byte, int, error), Permission) http.Handler {
+	return func(handlerMethod func(request *http.Request, vars map[string]string) ([]byte, int, error), permission Permission) http.Handler {
+		handlerFunc := utils.WrapHandler(handlerMethod)
+		if permission == PermissionPublic {
+			return handlerFunc
+		}
+		return authMiddleware(handlerFunc, permission)
 	}
-	return handlerFunc
 }
 
-// Serve sets up handlers and serves at the given port
-func Serve(port int64) {
+// Serve builds the router with handlers for the given store. The caller serves it, so it can
+// be served over a real listener or driven directly, e.g. with httptest. The reminder scheduler,
+// trash purger and webhook dispatcher it starts run until ctx is done.
+func Serve(ctx context.Context, store *access.Store) http.Handler {
 	router := mux.NewRouter()
+	transactor := store.Transactor
+	authMiddleware := newAuthMiddleware(transactor, store.Organizations)
+	buildHandler := newHandlerBuilder(authMiddleware)
 
 	router.Handle("/", http.FileServer(http.Dir("./views/")))
 	router.PathPrefix("/static/").Handler(http.StripPrefix("/static/", http.FileServer(http.Dir("./static/"))))
 
-	addressDAO := access.NewAddressesDAO()
-	addressHandler := handlers.NewAddressesHandler(addressDAO)
-	router.Handle("/addresses", buildHandler(addressHandler.GetAddressesHandler, true)).Methods("GET")
-	router.Handle("/addresses", buildHandler(addressHandler.FindOrCreateAddressHandler, true)).Methods("POST")
-	router.Handle("/addresses/{id}", buildHandler(addressHandler.GetAddressHandler, true)).Methods("GET")
-	router.Handle("/addresses/{id}", buildHandler(addressHandler.UpdateAddressHandler, true)).Methods("PUT")
-	router.Handle("/addresses/{id}", buildHandler(addressHandler.DeleteAddressHandler, true)).Methods("DELETE")
-
-	eventsDAO := access.NewEventsDAO()
-	eventsHandler := handlers.NewEventsHandler(eventsDAO)
-	router.Handle("/events", buildHandler(eventsHandler.GetEventsHandler, true)).Methods("GET")
-	router.Handle("/events", buildHandler(eventsHandler.CreateEventHandler, false)).Methods("POST")
-	router.Handle("/events/{id}", buildHandler(eventsHandler.GetEventHandler, false)).Methods("GET")
-	router.Handle("/events/{id}", buildHandler(eventsHandler.UpdateEventHandler, false)).Methods("PUT")
-	router.Handle("/events/{id}", buildHandler(eventsHandler.DeleteEventHandler, true)).Methods("DELETE")
-
-	invitationsDAO := access.NewInvitationsDAO()
-	invitationsHandler := handlers.NewInvitationsHandler(invitationsDAO)
-	router.Handle("/invitations", buildHandler(invitationsHandler.GetInvitationsHandler, true)).Methods("GET")
-	router.Handle("/invitations", buildHandler(invitationsHandler.CreateInvitationHandler, false)).Methods("POST")
-	router.Handle("/invitations/{id}", buildHandler(invitationsHandler.GetInvitationHandler, false)).Methods("GET")
-	router.Handle("/invitations/{id}", buildHandler(invitationsHandler.UpdateInvitationHandler, false)).Methods("PUT")
-	router.Handle("/invitations/{id}", buildHandler(invitationsHandler.DeleteInvitationHandler, true)).Methods("DELETE")
-
-	rsvpsDAO := access.NewRSVPsDAO()
-	rsvpsHandler := handlers.NewRSVPsHandler(rsvpsDAO)
-	router.Handle("/rsvps", buildHandler(rsvpsHandler.GetRSVPsHandler, true)).Methods("GET")
-	router.Handle("/rsvps", buildHandler(rsvpsHandler.CreateRSVPHandler, false)).Methods("POST")
-	router.Handle("/rsvps/{id}", buildHandler(rsvpsHandler.GetRSVPHandler, false)).Methods("GET")
-	router.Handle("/rsvps/{id}", buildHandler(rsvpsHandler.UpdateRSVPHandler, false)).Methods("PUT")
-	router.Handle("/rsvps/{id}", buildHandler(rsvpsHandler.DeleteRSVPHandler, true)).Methods("DELETE")
-
-	headersOk := muxHandlers.AllowedHeaders([]string{"X-Requested-With", "Content-Type", "Authorization"})
+	auditHandler := handlers.NewAuditHandler(transactor, store.Audit)
+	router.Handle("/audit", buildHandler(auditHandler.GetAuditHandler, PermissionManage)).Methods("GET")
+	history := func(entity string) http.Handler {
+		return buildHandler(auditHandler.HistoryHandler(entity), PermissionManage)
+	}
+
+	addressDAO := store.Addresses
+	addressHandler := handlers.NewAddressesHandler(transactor, addressDAO)
+	router.Handle("/addresses", buildHandler(addressHandler.GetAddressesHandler, PermissionViewGuests)).Methods("GET")
+	router.Handle("/addresses", buildHandler(addressHandler.FindOrCreateAddressHandler, PermissionEditGuests)).Methods("POST")
+	router.Handle("/addresses/{id}", buildHandler(addressHandler.GetAddressHandler, PermissionViewGuests)).Methods("GET")
+	router.Handle("/addresses/{id}", buildHandler(addressHandler.UpdateAddressHandler, PermissionEditGuests)).Methods("PUT", "PATCH")
+	router.Handle("/addresses/{id}", buildHandler(addressHandler.DeleteAddressHandler, PermissionManage)).Methods("DELETE")
+	router.Handle("/addresses/{id}/history", history(access.AuditAddress)).Methods("GET")
+
+	eventsDAO := store.Events
+	eventsHandler := handlers.NewEventsHandler(transactor, eventsDAO)
+	router.Handle("/events", buildHandler(eventsHandler.GetEventsHandler, PermissionViewEvents)).Methods("GET")
+	router.Handle("/events", buildHandler(eventsHandler.CreateEventHandler, PermissionEditGuests)).Methods("POST")
+	router.Handle("/events/{id}", buildHandler(eventsHandler.GetEventHandler, PermissionViewEvents)).Methods("GET")
+	router.Handle("/events/{id}", buildHandler(eventsHandler.UpdateEventHandler, PermissionEditGuests)).Methods("PUT", "PATCH")
+	router.Handle("/events/{id}", buildHandler(eventsHandler.DeleteEventHandler, PermissionManage)).Methods("DELETE")
+	router.Handle("/events/{id}/history", history(access.AuditEvent)).Methods("GET")
+	router.Handle("/events/{id}/restore", buildHandler(eventsHandler.RestoreEventHandler, PermissionManage)).Methods("POST")
+
+	guestsHandler := handlers.NewGuestsHandler(transactor, store.Guests)
+	router.Handle("/guests", buildHandler(guestsHandler.GetGuestsHandler, PermissionViewGuests)).Methods("GET")
+	router.Handle("/guests/duplicates", buildHandler(guestsHandler.GetDuplicateGuestsHandler, PermissionViewGuests)).Methods("GET")
+	router.Handle("/guests/{id}", buildHandler(guestsHandler.GetGuestHandler, PermissionViewGuests)).Methods("GET")
+	router.Handle("/guests/{id}", buildHandler(guestsHandler.UpdateGuestHandler, PermissionEditGuests)).Methods("PUT", "PATCH")
+	router.Handle("/guests/{id}/merge", buildHandler(guestsHandler.MergeGuestsHandler, PermissionManage)).Methods("POST")
+	router.Handle("/guests/{id}/history", history(access.AuditGuest)).Methods("GET")
+
+	reportsDAO := store.Reports
+	reportsHandler := handlers.NewReportsHandler(transactor, reportsDAO)
+	router.Handle("/events/{id}/report", buildHandler(reportsHandler.GetEventReportHandler, PermissionViewEvents)).Methods("GET")
+
+	invitationsDAO := store.Invitations
+	rsvpsDAO := store.RSVPs
+	notificationsDAO := store.Notifications
+	notifier := notifications.NewNotifierFromEnv(transactor, invitationsDAO, eventsDAO, rsvpsDAO, notificationsDAO)
+
+	invitationsHandler := handlers.NewInvitationsHandler(transactor, invitationsDAO, notifier)
+	router.Handle("/invitations", buildHandler(invitationsHandler.GetInvitationsHandler, PermissionViewGuests)).Methods("GET")
+	router.Handle("/invitations", buildHandler(invitationsHandler.CreateInvitationHandler, PermissionEditGuests)).Methods("POST")
+	importsHandler := handlers.NewImportsHandler(transactor, importer.NewImporter(invitationsDAO, eventsDAO))
+	router.Handle("/invitations/import", buildHandler(importsHandler.ImportInvitationsHandler, PermissionEditGuests)).Methods("POST")
+	router.Handle("/invitations/{id}", buildHandler(invitationsHandler.GetInvitationHandler, PermissionViewGuests)).Methods("GET")
+	router.Handle("/invitations/{id}", buildHandler(invitationsHandler.UpdateInvitationHandler, PermissionEditGuests)).Methods("PUT", "PATCH")
+	router.Handle("/invitations/{id}", buildHandler(invitationsHandler.DeleteInvitationHandler, PermissionManage)).Methods("DELETE")
+	router.Handle("/invitations/{id}/history", history(access.AuditInvitation)).Methods("GET")
+	router.Handle("/invitations/{id}/restore", buildHandler(invitationsHandler.RestoreInvitationHandler, PermissionManage)).Methods("POST")
+	router.Handle("/invitations/{id}/send", buildHandler(invitationsHandler.SendInvitationHandler, PermissionEditGuests)).Methods("POST")
+	router.Handle("/invitations/{id}/plus-ones", buildHandler(guestsHandler.GetPlusOnesHandler, PermissionViewGuests)).Methods("GET")
+	router.Handle("/invitations/{invitation_id}/plus-ones/{id}", buildHandler(guestsHandler.UpdatePlusOneHandler, PermissionEditGuests)).Methods("PUT", "PATCH")
+
+	rsvpsHandler := handlers.NewRSVPsHandler(transactor, rsvpsDAO, notifier)
+	router.Handle("/rsvps", buildHandler(rsvpsHandler.GetRSVPsHandler, PermissionViewGuests)).Methods("GET")
+	router.Handle("/rsvps", buildHandler(rsvpsHandler.CreateRSVPHandler, PermissionEditGuests)).Methods("POST")
+	router.Handle("/rsvps/{id}", buildHandler(rsvpsHandler.GetRSVPHandler, PermissionViewGuests)).Methods("GET")
+	router.Handle("/rsvps/{id}", buildHandler(rsvpsHandler.UpdateRSVPHandler, PermissionEditGuests)).Methods("PUT", "PATCH")
+	router.Handle("/rsvps/{id}", buildHandler(rsvpsHandler.DeleteRSVPHandler, PermissionManage)).Methods("DELETE")
+	router.Handle("/rsvps/{id}/history", history(access.AuditRSVP)).Methods("GET")
+	router.Handle("/rsvps/{id}/restore", buildHandler(rsvpsHandler.RestoreRSVPHandler, PermissionManage)).Methods("POST")
+	router.Handle("/admin/rsvps", buildHandler(rsvpsHandler.AdminCreateRSVPHandler, PermissionEditGuests)).Methods("POST")
+	router.Handle("/admin/rsvps/{id}", buildHandler(rsvpsHandler.AdminUpdateRSVPHandler, PermissionEditGuests)).Methods("PUT", "PATCH")
+
+	notificationsHandler := handlers.NewNotificationsHandler(transactor, notificationsDAO, notifier)
+	router.Handle("/notifications", buildHandler(notificationsHandler.GetNotificationsHandler, PermissionViewGuests)).Methods("GET")
+	router.Handle("/notifications/retry", buildHandler(notificationsHandler.RetryNotificationsHandler, PermissionEditGuests)).Methods("POST")
+
+	campaignsDAO := store.ReminderCampaigns
+	reminderScheduler := notifications.NewReminderScheduler(transactor, notifier, campaignsDAO, eventsDAO)
+	reminderScheduler.Start(ctx)
+	remindersHandler := handlers.NewRemindersHandler(transactor, campaignsDAO, eventsDAO, notificationsDAO, reminderScheduler)
+	router.Handle("/campaigns", buildHandler(remindersHandler.GetCampaignsHandler, PermissionViewGuests)).Methods("GET")
+	router.Handle("/campaigns", buildHandler(remindersHandler.CreateCampaignHandler, PermissionEditGuests)).Methods("POST")
+	router.Handle("/campaigns/{id}", buildHandler(remindersHandler.GetCampaignHandler, PermissionViewGuests)).Methods("GET")
+	router.Handle("/campaigns/{id}", buildHandler(remindersHandler.UpdateCampaignHandler, PermissionEditGuests)).Methods("PUT")
+	router.Handle("/campaigns/{id}", buildHandler(remindersHandler.DeleteCampaignHandler, PermissionManage)).Methods("DELETE")
+	router.Handle("/campaigns/{id}/history", history(access.AuditCampaign)).Methods("GET")
+	router.Handle("/campaigns/{id}/pause", buildHandler(remindersHandler.PauseCampaignHandler, PermissionEditGuests)).Methods("POST")
+	router.Handle("/campaigns/{id}/resume", buildHandler(remindersHandler.ResumeCampaignHandler, PermissionEditGuests)).Methods("POST")
+	router.Handle("/campaigns/{id}/preview", buildHandler(remindersHandler.PreviewCampaignHandler, PermissionViewGuests)).Methods("GET")
+	router.Handle("/invitations/{id}/reminders", buildHandler(remindersHandler.GetInvitationRemindersHandler, PermissionViewGuests)).Methods("GET")
+
+	purger := trash.NewPurgerFromEnv(transactor, store.Organizations, store.Trash)
+	purger.Start(ctx)
+	trashHandler := handlers.NewTrashHandler(transactor, store.Trash, purger)
+	router.Handle("/trash", buildHandler(trashHandler.GetTrashHandler, PermissionManage)).Methods("GET")
+	router.Handle("/trash/purge", buildHandler(trashHandler.PurgeTrashHandler, PermissionManage)).Methods("POST")
+
+	webhooksDAO := store.Webhooks
+	webhookDispatcher := webhooks.NewDispatcherFromEnv(transactor, store.Organizations, webhooksDAO)
+	webhookDispatcher.Start(ctx)
+	webhooksHandler := handlers.NewWebhooksHandler(transactor, webhooksDAO)
+	router.Handle("/webhooks", buildHandler(webhooksHandler.GetWebhooksHandler, PermissionManage)).Methods("GET")
+	router.Handle("/webhooks", buildHandler(webhooksHandler.CreateWebhookHandler, PermissionManage)).Methods("POST")
+	router.Handle("/webhooks/{id}", buildHandler(webhooksHandler.GetWebhookHandler, PermissionManage)).Methods("GET")
+	router.Handle("/webhooks/{id}", buildHandler(webhooksHandler.UpdateWebhookHandler, PermissionManage)).Methods("PUT", "PATCH")
+	router.Handle("/webhooks/{id}", buildHandler(webhooksHandler.DeleteWebhookHandler, PermissionManage)).Methods("DELETE")
+	router.Handle("/webhooks/{id}/history", history(access.AuditWebhook)).Methods("GET")
+	router.Handle("/webhooks/{id}/deliveries", buildHandler(webhooksHandler.GetWebhookDeliveriesHandler, PermissionManage)).Methods("GET")
+	router.Handle("/webhooks/{id}/replay", buildHandler(webhooksHandler.ReplayDeadWebhookDeliveriesHandler, PermissionManage)).Methods("POST")
+	router.Handle("/webhooks/deliveries/{id}/replay", buildHandler(webhooksHandler.ReplayWebhookDeliveryHandler, PermissionManage)).Methods("POST")
+
+	seatingHandler := handlers.NewSeatingHandler(transactor, store.Seating, eventsDAO)
+	router.Handle("/events/{id}/tables", buildHandler(seatingHandler.GetSeatingTablesHandler, PermissionViewGuests)).Methods("GET")
+	router.Handle("/events/{id}/tables", buildHandler(seatingHandler.CreateSeatingTableHandler, PermissionEditGuests)).Methods("POST")
+	router.Handle("/tables/{id}", buildHandler(seatingHandler.GetSeatingTableHandler, PermissionViewGuests)).Methods("GET")
+	router.Handle("/tables/{id}", buildHandler(seatingHandler.UpdateSeatingTableHandler, PermissionEditGuests)).Methods("PUT", "PATCH")
+	router.Handle("/tables/{id}", buildHandler(seatingHandler.DeleteSeatingTableHandler, PermissionManage)).Methods("DELETE")
+	router.Handle("/tables/{id}/history", history(access.AuditTable)).Methods("GET")
+	router.Handle("/events/{id}/seating", buildHandler(seatingHandler.GetSeatingChartHandler, PermissionViewGuests)).Methods("GET")
+	router.Handle("/events/{id}/seats", buildHandler(seatingHandler.SetSeatsHandler, PermissionEditGuests)).Methods("PUT")
+	router.Handle("/events/{id}/seating/auto-assign", buildHandler(seatingHandler.AutoAssignSeatsHandler, PermissionEditGuests)).Methods("POST")
+	router.Handle("/events/{id}/seating-constraints", buildHandler(seatingHandler.GetSeatingConstraintsHandler, PermissionViewGuests)).Methods("GET")
+	router.Handle("/events/{id}/seating-constraints", buildHandler(seatingHandler.CreateSeatingConstraintHandler, PermissionEditGuests)).Methods("POST")
+	router.Handle("/seating-constraints/{id}", buildHandler(seatingHandler.DeleteSeatingConstraintHandler, PermissionManage)).Methods("DELETE")
+	router.Handle("/seating-constraints/{id}/history", history(access.AuditConstraint)).Methods("GET")
+
+	exportsDAO := store.Exports
+	exportsHandler := handlers.NewExportsHandler(transactor, exportsDAO)
+	router.Handle("/exports/guests", authMiddleware(http.HandlerFunc(exportsHandler.ExportGuestsHandler), PermissionViewGuests)).Methods("GET")
+
+	guestRSVPsHandler := handlers.NewGuestRSVPsHandler(transactor, invitationsDAO, rsvpsDAO, notifier)
+	lookupLimiter := newFailedLookupLimiter()
+	lookupLimiter.start(ctx)
+	router.Handle("/rsvp/{code}", lookupLimiter.middleware(buildHandler(guestRSVPsHandler.GetGuestInvitationHandler, PermissionPublic))).Methods("GET")
+	router.Handle("/rsvp/{code}", lookupLimiter.middleware(buildHandler(guestRSVPsHandler.SubmitGuestRSVPHandler, PermissionPublic))).Methods("POST")
+
+	headersOk := muxHandlers.AllowedHeaders([]string{"X-Requested-With", "Content-Type", "Authorization", "If-Match", "If-None-Match"})
 	originsOk := muxHandlers.AllowedOrigins([]string{"*"})
-	methodsOk := muxHandlers.AllowedMethods([]string{"GET", "HEAD", "POST", "PUT", "OPTIONS"})
+	methodsOk := muxHandlers.AllowedMethods([]string{"GET", "HEAD", "POST", "PUT", "PATCH", "OPTIONS"})
+	exposedOk := muxHandlers.ExposedHeaders([]string{"X-Total-Count", "Link", "ETag"})
 
-	log.Fatal(http.ListenAndServe(":8000", muxHandlers.CORS(originsOk, headersOk, methodsOk)(router)))
+	return muxHandlers.CORS(originsOk, headersOk, methodsOk, exposedOk)(router)
 }
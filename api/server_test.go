@@ -0,0 +1,460 @@
+package api_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/kyrstenkelly/rsvp-api/api"
+	"github.com/kyrstenkelly/rsvp-api/db/access"
+	"github.com/kyrstenkelly/rsvp-api/db/dbtest"
+	"github.com/kyrstenkelly/rsvp-api/db/models"
+	jose "gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testAudience = "rsvp-api-test"
+	testIssuer   = "https://rsvp-api.test/"
+	testSecret   = "rsvp-api-test-secret"
+
+	// missingID is what the permission cases put in their paths, so no request that gets past auth changes anything
+	missingID = 999999
+)
+
+func TestMain(m *testing.M) {
+	os.Setenv("AUTH_CLIENT_AUDIENCE", testAudience)
+	os.Setenv("AUTH_CLIENT_DOMAIN", testIssuer)
+	os.Setenv("AUTH_CLIENT_SECRET", testSecret)
+	// Anything in the trash is old enough to purge, and the guest routes can be hit without being rate limited
+	os.Setenv("TRASH_RETENTION", "1ns")
+	os.Setenv("RSVP_LOOKUP_MAX_FAILURES", "1000")
+	os.Setenv("MAIL_TRANSPORT", "none")
+	os.Exit(dbtest.Run(m))
+}
+
+func TestMemoryServer(t *testing.T) {
+	t.Run("Permissions", func(t *testing.T) {
+		runPermissionCases(t, access.NewMemoryStore())
+	})
+	t.Run("Organizations", func(t *testing.T) {
+		runOrganizationCases(t, access.NewMemoryStore())
+	})
+	t.Run("ForeignKeys", func(t *testing.T) {
+		runForeignKeyCases(t, access.NewMemoryStore())
+	})
+}
+
+func TestPostgresServer(t *testing.T) {
+	for name, run := range map[string]func(*testing.T, *access.Store){
+		"Permissions":   runPermissionCases,
+		"Organizations": runOrganizationCases,
+		"ForeignKeys":   runForeignKeyCases,
+	} {
+		run := run
+		t.Run(name, func(t *testing.T) {
+			conn, drop := dbtest.Postgres(t)
+			defer drop()
+			run(t, access.NewPostgresStore(conn))
+		})
+	}
+}
+
+// routes is every route Serve registers, with the permission it requires
+var routes = []struct {
+	method     string
+	path       string
+	permission api.Permission
+}{
+	{"GET", "/audit", api.PermissionManage},
+
+	{"GET", "/addresses", api.PermissionViewGuests},
+	{"POST", "/addresses", api.PermissionEditGuests},
+	{"GET", "/addresses/{id}", api.PermissionViewGuests},
+	{"PUT", "/addresses/{id}", api.PermissionEditGuests},
+	{"PATCH", "/addresses/{id}", api.PermissionEditGuests},
+	{"DELETE", "/addresses/{id}", api.PermissionManage},
+	{"GET", "/addresses/{id}/history", api.PermissionManage},
+
+	{"GET", "/events", api.PermissionViewEvents},
+	{"POST", "/events", api.PermissionEditGuests},
+	{"GET", "/events/{id}", api.PermissionViewEvents},
+	{"PUT", "/events/{id}", api.PermissionEditGuests},
+	{"PATCH", "/events/{id}", api.PermissionEditGuests},
+	{"DELETE", "/events/{id}", api.PermissionManage},
+	{"GET", "/events/{id}/history", api.PermissionManage},
+	{"POST", "/events/{id}/restore", api.PermissionManage},
+	{"GET", "/events/{id}/report", api.PermissionViewEvents},
+
+	{"GET", "/guests", api.PermissionViewGuests},
+	{"GET", "/guests/duplicates", api.PermissionViewGuests},
+	{"GET", "/guests/{id}", api.PermissionViewGuests},
+	{"PUT", "/guests/{id}", api.PermissionEditGuests},
+	{"PATCH", "/guests/{id}", api.PermissionEditGuests},
+	{"POST", "/guests/{id}/merge", api.PermissionManage},
+	{"GET", "/guests/{id}/history", api.PermissionManage},
+
+	{"GET", "/invitations", api.PermissionViewGuests},
+	{"POST", "/invitations", api.PermissionEditGuests},
+	{"POST", "/invitations/import", api.PermissionEditGuests},
+	{"GET", "/invitations/{id}", api.PermissionViewGuests},
+	{"PUT", "/invitations/{id}", api.PermissionEditGuests},
+	{"PATCH", "/invitations/{id}", api.PermissionEditGuests},
+	{"DELETE", "/invitations/{id}", api.PermissionManage},
+	{"GET", "/invitations/{id}/history", api.PermissionManage},
+	{"POST", "/invitations/{id}/restore", api.PermissionManage},
+	{"POST", "/invitations/{id}/send", api.PermissionEditGuests},
+	{"GET", "/invitations/{id}/plus-ones", api.PermissionViewGuests},
+	{"PUT", "/invitations/{id}/plus-ones/{id}", api.PermissionEditGuests},
+	{"PATCH", "/invitations/{id}/plus-ones/{id}", api.PermissionEditGuests},
+	{"GET", "/invitations/{id}/reminders", api.PermissionViewGuests},
+
+	{"GET", "/rsvps", api.PermissionViewGuests},
+	{"POST", "/rsvps", api.PermissionEditGuests},
+	{"GET", "/rsvps/{id}", api.PermissionViewGuests},
+	{"PUT", "/rsvps/{id}", api.PermissionEditGuests},
+	{"PATCH", "/rsvps/{id}", api.PermissionEditGuests},
+	{"DELETE", "/rsvps/{id}", api.PermissionManage},
+	{"GET", "/rsvps/{id}/history", api.PermissionManage},
+	{"POST", "/rsvps/{id}/restore", api.PermissionManage},
+	{"POST", "/admin/rsvps", api.PermissionEditGuests},
+	{"PUT", "/admin/rsvps/{id}", api.PermissionEditGuests},
+	{"PATCH", "/admin/rsvps/{id}", api.PermissionEditGuests},
+
+	{"GET", "/notifications", api.PermissionViewGuests},
+	{"POST", "/notifications/retry", api.PermissionEditGuests},
+
+	{"GET", "/campaigns", api.PermissionViewGuests},
+	{"POST", "/campaigns", api.PermissionEditGuests},
+	{"GET", "/campaigns/{id}", api.PermissionViewGuests},
+	{"PUT", "/campaigns/{id}", api.PermissionEditGuests},
+	{"DELETE", "/campaigns/{id}", api.PermissionManage},
+	{"GET", "/campaigns/{id}/history", api.PermissionManage},
+	{"POST", "/campaigns/{id}/pause", api.PermissionEditGuests},
+	{"POST", "/campaigns/{id}/resume", api.PermissionEditGuests},
+	{"GET", "/campaigns/{id}/preview", api.PermissionViewGuests},
+
+	{"GET", "/trash", api.PermissionManage},
+	{"POST", "/trash/purge", api.PermissionManage},
+
+	{"GET", "/webhooks", api.PermissionManage},
+	{"POST", "/webhooks", api.PermissionManage},
+	{"GET", "/webhooks/{id}", api.PermissionManage},
+	{"PUT", "/webhooks/{id}", api.PermissionManage},
+	{"PATCH", "/webhooks/{id}", api.PermissionManage},
+	{"DELETE", "/webhooks/{id}", api.PermissionManage},
+	{"GET", "/webhooks/{id}/history", api.PermissionManage},
+	{"GET", "/webhooks/{id}/deliveries", api.PermissionManage},
+	{"POST", "/webhooks/{id}/replay", api.PermissionManage},
+	{"POST", "/webhooks/deliveries/{id}/replay", api.PermissionManage},
+
+	{"GET", "/events/{id}/tables", api.PermissionViewGuests},
+	{"POST", "/events/{id}/tables", api.PermissionEditGuests},
+	{"GET", "/tables/{id}", api.PermissionViewGuests},
+	{"PUT", "/tables/{id}", api.PermissionEditGuests},
+	{"PATCH", "/tables/{id}", api.PermissionEditGuests},
+	{"DELETE", "/tables/{id}", api.PermissionManage},
+	{"GET", "/tables/{id}/history", api.PermissionManage},
+	{"GET", "/events/{id}/seating", api.PermissionViewGuests},
+	{"PUT", "/events/{id}/seats", api.PermissionEditGuests},
+	{"POST", "/events/{id}/seating/auto-assign", api.PermissionEditGuests},
+	{"GET", "/events/{id}/seating-constraints", api.PermissionViewGuests},
+	{"POST", "/events/{id}/seating-constraints", api.PermissionEditGuests},
+	{"DELETE", "/seating-constraints/{id}", api.PermissionManage},
+	{"GET", "/seating-constraints/{id}/history", api.PermissionManage},
+
+	{"GET", "/exports/guests", api.PermissionViewGuests},
+
+	{"GET", "/rsvp/{id}", api.PermissionPublic},
+	{"POST", "/rsvp/{id}", api.PermissionPublic},
+}
+
+// rolesGranting is which roles are allowed each permission
+var rolesGranting = map[api.Permission][]string{
+	api.PermissionViewEvents: {api.RoleOwner, api.RolePlanner, api.RoleReadOnly, api.RoleCaterer},
+	api.PermissionViewGuests: {api.RoleOwner, api.RolePlanner, api.RoleReadOnly},
+	api.PermissionEditGuests: {api.RoleOwner, api.RolePlanner},
+	api.PermissionManage:     {api.RoleOwner},
+}
+
+var roles = []string{api.RoleOwner, api.RolePlanner, api.RoleReadOnly, api.RoleCaterer}
+
+// runPermissionCases checks every route refuses requests without a token, and requests from roles
+// or organizations it isn't open to, apart from the guest routes, which need no token at all
+func runPermissionCases(t *testing.T, store *access.Store) {
+	s := newTestServer(t, store)
+	defer s.stop()
+
+	for _, route := range routes {
+		path := routePath(route.path)
+		t.Run(route.method+" "+route.path, func(t *testing.T) {
+			public := route.permission == api.PermissionPublic
+
+			status := s.do(route.method, path, "", nil).Code
+			if public && (status == http.StatusUnauthorized || status == http.StatusForbidden) {
+				t.Errorf("Without a token got %d from a public route", status)
+			} else if !public && status != http.StatusUnauthorized {
+				t.Errorf("Without a token got %d, expected %d", status, http.StatusUnauthorized)
+			}
+			if public {
+				return
+			}
+
+			if status := s.do(route.method, path, s.token(api.RoleOwner, "no-such-organization"), nil).Code; status != http.StatusForbidden {
+				t.Errorf("With a token for an unknown organization got %d, expected %d", status, http.StatusForbidden)
+			}
+			if status := s.do(route.method, path, s.tokenWithKey(api.RoleOwner, "default", "not-the-secret"), nil).Code; status != http.StatusUnauthorized {
+				t.Errorf("With a token signed with another key got %d, expected %d", status, http.StatusUnauthorized)
+			}
+
+			granted := map[string]bool{}
+			for _, role := range rolesGranting[route.permission] {
+				granted[role] = true
+			}
+			for _, role := range roles {
+				status := s.do(route.method, path, s.token(role, "default"), nil).Code
+				if granted[role] && (status == http.StatusUnauthorized || status == http.StatusForbidden) {
+					t.Errorf("As %s got %d, but the role grants %s", role, status, route.permission)
+				} else if !granted[role] && status != http.StatusForbidden {
+					t.Errorf("As %s got %d, expected %d", role, status, http.StatusForbidden)
+				}
+			}
+		})
+	}
+}
+
+// runOrganizationCases checks one organization can't see or change another's rows through the api
+func runOrganizationCases(t *testing.T, store *access.Store) {
+	s := newTestServer(t, store)
+	defer s.stop()
+	s.createOrganization("other")
+	f := s.createFixtures()
+	owner := s.token(api.RoleOwner, "default")
+	other := s.token(api.RoleOwner, "other")
+
+	cases := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		body   interface{}
+		want   int
+	}{
+		{"Get event", "GET", fmt.Sprintf("/events/%d", f.eventID), other, nil, http.StatusNotFound},
+		{"Get invitation", "GET", fmt.Sprintf("/invitations/%d", f.invitationID), other, nil, http.StatusNotFound},
+		{"Get guest", "GET", fmt.Sprintf("/guests/%d", f.guestID), other, nil, http.StatusNotFound},
+		{"Get rsvp", "GET", fmt.Sprintf("/rsvps/%d", f.rsvpID), other, nil, http.StatusNotFound},
+		{"Get address", "GET", fmt.Sprintf("/addresses/%d", f.addressID), other, nil, http.StatusNotFound},
+		{"Update guest", "PUT", fmt.Sprintf("/guests/%d", f.guestID), other, map[string]string{"name": "Taken"}, http.StatusNotFound},
+		{"Delete invitation", "DELETE", fmt.Sprintf("/invitations/%d", f.invitationID), other, nil, http.StatusNotFound},
+		{"Invitation is still there", "GET", fmt.Sprintf("/invitations/%d", f.invitationID), owner, nil, http.StatusOK},
+		{"Rsvp is still there", "GET", fmt.Sprintf("/rsvps/%d", f.rsvpID), owner, nil, http.StatusOK},
+		{"Guest is unchanged", "GET", fmt.Sprintf("/guests/%d", f.guestID), owner, nil, http.StatusOK},
+	}
+	for _, c := range cases {
+		response := s.do(c.method, c.path, c.token, c.body)
+		if response.Code != c.want {
+			t.Errorf("%s: %s %s got %d, expected %d: %s", c.name, c.method, c.path, response.Code, c.want, response.Body)
+		}
+	}
+
+	var guest models.Guest
+	s.get(fmt.Sprintf("/guests/%d", f.guestID), owner, &guest)
+	if guest.Name != "Ada Lovelace" {
+		t.Errorf("Another organization renamed a guest to %q", guest.Name)
+	}
+	for _, list := range []string{"/events", "/invitations", "/guests", "/rsvps", "/addresses"} {
+		var listed []map[string]interface{}
+		s.get(list, other, &listed)
+		if len(listed) != 0 {
+			t.Errorf("Another organization listed %d of the default organization's rows from %s", len(listed), list)
+		}
+	}
+}
+
+// runForeignKeyCases checks rows still referenced can't be deleted, and that purging an invitation
+// takes its rsvp and guests with it. The cases run in order against the same rows.
+func runForeignKeyCases(t *testing.T, store *access.Store) {
+	s := newTestServer(t, store)
+	defer s.stop()
+	f := s.createFixtures()
+	owner := s.token(api.RoleOwner, "default")
+
+	cases := []struct {
+		name   string
+		method string
+		path   string
+		want   int
+	}{
+		{"Event covered by a live invitation", "DELETE", fmt.Sprintf("/events/%d", f.eventID), http.StatusBadRequest},
+		{"Address in use", "DELETE", fmt.Sprintf("/addresses/%d", f.addressID), http.StatusBadRequest},
+		{"Trash the invitation", "DELETE", fmt.Sprintf("/invitations/%d", f.invitationID), http.StatusOK},
+		{"Its rsvp is trashed with it", "GET", fmt.Sprintf("/rsvps/%d", f.rsvpID), http.StatusNotFound},
+		{"Event covered only by a trashed invitation", "DELETE", fmt.Sprintf("/events/%d", f.eventID), http.StatusOK},
+		{"Purge the trash", "POST", "/trash/purge", http.StatusOK},
+		{"Purged invitation", "GET", fmt.Sprintf("/invitations/%d", f.invitationID), http.StatusNotFound},
+		{"Purged invitation can't be restored", "POST", fmt.Sprintf("/invitations/%d/restore", f.invitationID), http.StatusNotFound},
+		{"Purged rsvp", "GET", fmt.Sprintf("/rsvps/%d", f.rsvpID), http.StatusNotFound},
+		{"Purged rsvp can't be restored", "POST", fmt.Sprintf("/rsvps/%d/restore", f.rsvpID), http.StatusNotFound},
+		{"Purged guest", "GET", fmt.Sprintf("/guests/%d", f.guestID), http.StatusNotFound},
+		{"Purged event", "POST", fmt.Sprintf("/events/%d/restore", f.eventID), http.StatusNotFound},
+		{"Address no longer in use", "DELETE", fmt.Sprintf("/addresses/%d", f.addressID), http.StatusOK},
+	}
+	for _, c := range cases {
+		response := s.do(c.method, c.path, owner, nil)
+		if response.Code != c.want {
+			t.Fatalf("%s: %s %s got %d, expected %d: %s", c.name, c.method, c.path, response.Code, c.want, response.Body)
+		}
+	}
+}
+
+// testServer is the router Serve builds for a store, stopped with stop
+type testServer struct {
+	t       *testing.T
+	store   *access.Store
+	handler http.Handler
+	stop    context.CancelFunc
+}
+
+func newTestServer(t *testing.T, store *access.Store) *testServer {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &testServer{t: t, store: store, handler: api.Serve(ctx, store), stop: cancel}
+}
+
+// do makes a request, as the token's bearer when there is one. Writes are made against any version.
+func (s *testServer) do(method string, path string, token string, body interface{}) *httptest.ResponseRecorder {
+	var encoded []byte
+	if body != nil {
+		var err error
+		if encoded, err = json.Marshal(body); err != nil {
+			s.t.Fatal(err)
+		}
+	}
+	request := httptest.NewRequest(method, path, bytes.NewReader(encoded))
+	request.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	if method == "PUT" || method == "PATCH" || method == "DELETE" {
+		request.Header.Set("If-Match", "*")
+	}
+	response := httptest.NewRecorder()
+	s.handler.ServeHTTP(response, request)
+	return response
+}
+
+// get makes a GET request that must succeed, decoding its response into result
+func (s *testServer) get(path string, token string, result interface{}) {
+	s.send("GET", path, token, nil, result)
+}
+
+// send makes a request that must succeed, decoding its response into result
+func (s *testServer) send(method string, path string, token string, body interface{}, result interface{}) {
+	s.t.Helper()
+	response := s.do(method, path, token, body)
+	if response.Code != http.StatusOK {
+		s.t.Fatalf("%s %s got %d: %s", method, path, response.Code, response.Body)
+	}
+	if err := json.NewDecoder(response.Body).Decode(result); err != nil {
+		s.t.Fatalf("%s %s: %v", method, path, err)
+	}
+}
+
+func (s *testServer) token(role string, organization string) string {
+	return s.tokenWithKey(role, organization, testSecret)
+}
+
+// tokenWithKey signs a token for a role in an organization, the way the auth provider does
+func (s *testServer) tokenWithKey(role string, organization string, key string) string {
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.HS256, Key: []byte(key)}, (&jose.SignerOptions{}).WithType("JWT"))
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	token, err := jwt.Signed(signer).
+		Claims(jwt.Claims{
+			Subject:  "server-test",
+			Issuer:   testIssuer,
+			Audience: jwt.Audience{testAudience},
+			Expiry:   jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		}).
+		Claims(map[string]interface{}{"roles": role, "org": organization}).
+		CompactSerialize()
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	return token
+}
+
+func (s *testServer) createOrganization(slug string) {
+	_, err := access.Run(context.Background(), s.store.Transactor, func(tx access.Tx) (interface{}, error) {
+		return s.store.Organizations.CreateOrganization(tx, &models.Organization{Slug: slug, Name: slug})
+	})
+	if err != nil {
+		s.t.Fatal(err)
+	}
+}
+
+// fixtures are an event, an invitation to it for one guest sharing its address, and the guest's rsvp
+type fixtures struct {
+	eventID      int64
+	addressID    int64
+	invitationID int64
+	guestID      int64
+	rsvpID       int64
+}
+
+// createFixtures creates the fixtures in the default organization through the api
+func (s *testServer) createFixtures() fixtures {
+	owner := s.token(api.RoleOwner, "default")
+	address := map[string]string{"line1": "1 Fixture St", "city": "Portland", "state": "OR", "zip": "97201"}
+
+	var event models.Event
+	s.send("POST", "/events", owner, map[string]interface{}{
+		"name":         "Wedding",
+		"date":         "2030-06-01",
+		"address":      address,
+		"food_options": []string{"Fish", "Pasta"},
+	}, &event)
+
+	var invitation models.Invitation
+	s.send("POST", "/invitations", owner, map[string]interface{}{
+		"name":    "The Lovelaces",
+		"email":   "ada@example.com",
+		"address": address,
+		"events":  []map[string]interface{}{{"event_id": event.ID}},
+		"guests":  []map[string]string{{"first_name": "Ada", "last_name": "Lovelace"}},
+	}, &invitation)
+	if invitation.Guests == nil || len(*invitation.Guests) != 1 {
+		s.t.Fatalf("Expected the invitation to have one guest, got %v", invitation.Guests)
+	}
+	guestID := (*invitation.Guests)[0].ID
+
+	var rsvp models.RSVP
+	s.send("POST", "/rsvp/"+invitation.RSVPCode, "", map[string]interface{}{
+		"rsvp_guests": []map[string]interface{}{{
+			"guest":       map[string]int64{"id": guestID},
+			"event_id":    event.ID,
+			"attending":   true,
+			"food_choice": "Fish",
+		}},
+	}, &rsvp)
+
+	return fixtures{
+		eventID:      event.ID,
+		addressID:    event.Address.ID,
+		invitationID: invitation.ID,
+		guestID:      guestID,
+		rsvpID:       rsvp.ID,
+	}
+}
+
+// routePath fills in a route's ids with one that doesn't exist
+func routePath(route string) string {
+	return strings.Replace(route, "{id}", fmt.Sprint(missingID), -1)
+}
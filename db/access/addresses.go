@@ -3,8 +3,8 @@ package access
 import (
 	"github.com/go-pg/pg/v9"
 	"github.com/kyrstenkelly/rsvp-api/db/models"
+	"github.com/kyrstenkelly/rsvp-api/utils"
 	log "github.com/sirupsen/logrus"
-	"strings"
 )
 
 // AddressesPostgresAccess postgres implementation of a AddressesDAO
@@ -13,11 +13,11 @@ type AddressesPostgresAccess struct {
 
 // AddressesAccess interface for a Cohorts data access object
 type AddressesAccess interface {
-	GetAddresses(tx *pg.Tx) ([]models.Address, error)
-	GetAddress(tx *pg.Tx, id int64) (*models.Address, error)
-	FindOrCreateAddress(tx *pg.Tx, address *models.Address) (*models.Address, error)
-	UpdateAddress(tx *pg.Tx, address *models.Address) (*models.Address, error)
-	DeleteAddress(tx *pg.Tx, id int64) (*models.Address, error)
+	GetAddresses(tx Tx, list ListQuery) ([]models.Address, int, error)
+	GetAddress(tx Tx, id int64) (*models.Address, error)
+	FindOrCreateAddress(tx Tx, address *models.Address) (*models.Address, error)
+	UpdateAddress(tx Tx, address *models.Address) (*models.Address, error)
+	DeleteAddress(tx Tx, id int64, version int64) (*models.Address, error)
 }
 
 // NewAddressesDAO Create a new addresses dao
@@ -25,41 +25,62 @@ func NewAddressesDAO() AddressesAccess {
 	return &AddressesPostgresAccess{}
 }
 
-// CheckForDuplicate checks for an existing address
-func CheckForDuplicate(tx *pg.Tx, address *models.Address) (int64, error) {
+// CheckForDuplicate checks for an existing address in the transaction's organization
+func CheckForDuplicate(tx Tx, address *models.Address) (int64, error) {
+	ptx := pgTx(tx)
 	query :=
 		`SELECT id FROM addresses
 		WHERE line1 = $1 AND line2 = $2 AND city = $3
-			AND state = $4 AND zip = $5`
-	stmt, err := tx.Prepare(query)
+			AND state = $4 AND zip = $5 AND organization_id = $6`
+	stmt, err := ptx.Prepare(query)
 	if err != nil {
 		log.Error(err)
 		return 0, err
 	}
 
 	var addressID int64
-	_, err = stmt.Query(pg.Scan(&addressID), &address.Line1, &address.Line2, &address.City, &address.State, &address.Zip)
+	_, err = stmt.Query(pg.Scan(&addressID), &address.Line1, &address.Line2, &address.City, &address.State, &address.Zip, OrganizationID(tx))
 	if err != nil {
 		return 0, err
 	}
 	return addressID, nil
 }
 
-// GetAddresses gets all addresses
-func (a *AddressesPostgresAccess) GetAddresses(tx *pg.Tx) ([]models.Address, error) {
-	var addresses []models.Address
-	err := tx.Model(&addresses).Select()
+// AddressesList is how lists of addresses can be filtered, searched and sorted
+var AddressesList = ListSpec{
+	Fields: map[string]ListField{
+		"id":    {Column: "address.id", Kind: ListInt, Sort: true},
+		"line1": {Column: "address.line1", Kind: ListString, Sort: true},
+		"line2": {Column: "address.line2", Kind: ListString},
+		"city":  {Column: "address.city", Kind: ListString, Filter: true, Sort: true},
+		"state": {Column: "address.state", Kind: ListString, Filter: true, Sort: true},
+		"zip":   {Column: "address.zip", Kind: ListString, Filter: true, Sort: true},
+	},
+	Search: []string{"line1", "line2", "city"},
+}
+
+// GetAddresses gets a page of addresses, along with how many addresses match the list's filters
+func (a *AddressesPostgresAccess) GetAddresses(tx Tx, list ListQuery) ([]models.Address, int, error) {
+	ptx := pgTx(tx)
+	addresses := []models.Address{}
+	query := ptx.Model(&addresses).
+		Where("address.organization_id = ?", OrganizationID(tx))
+	total, err := applyListQuery(query, AddressesList, list).SelectAndCount()
 	if err != nil {
 		log.Error(err)
-		return nil, err
+		return nil, 0, err
 	}
-	return addresses, nil
+	return addresses, total, nil
 }
 
 // GetAddress gets an address by id
-func (a *AddressesPostgresAccess) GetAddress(tx *pg.Tx, id int64) (*models.Address, error) {
+func (a *AddressesPostgresAccess) GetAddress(tx Tx, id int64) (*models.Address, error) {
+	ptx := pgTx(tx)
 	address := new(models.Address)
-	err := tx.Model(address).Where("address.id = ?", id).Select()
+	err := ptx.Model(address).
+		Where("address.id = ?", id).
+		Where("address.organization_id = ?", OrganizationID(tx)).
+		Select()
 	if err == pg.ErrNoRows {
 		return nil, nil
 	} else if err != nil {
@@ -69,8 +90,13 @@ func (a *AddressesPostgresAccess) GetAddress(tx *pg.Tx, id int64) (*models.Addre
 	return address, nil
 }
 
-// FindOrCreateAddress creates an address
-func (a *AddressesPostgresAccess) FindOrCreateAddress(tx *pg.Tx, address *models.Address) (*models.Address, error) {
+// FindOrCreateAddress finds an identical address in the transaction's organization, returning a copy of it,
+// or creates the given one and returns it
+func (a *AddressesPostgresAccess) FindOrCreateAddress(tx Tx, address *models.Address) (*models.Address, error) {
+	ptx := pgTx(tx)
+	if address == nil {
+		return nil, utils.ArgumentError.Here().WithMessage("An address is required")
+	}
 	existingAddressID, err := CheckForDuplicate(tx, address)
 	if err != nil {
 		return nil, err
@@ -80,66 +106,62 @@ func (a *AddressesPostgresAccess) FindOrCreateAddress(tx *pg.Tx, address *models
 	}
 	query :=
 		`INSERT INTO
-			addresses ("line1", "line2", "city", "state", "zip")
+			addresses ("line1", "line2", "city", "state", "zip", "organization_id")
 		VALUES
-			($1, $2, $3, $4, $5)
+			($1, $2, $3, $4, $5, $6)
 		RETURNING id`
-	stmt, err := tx.Prepare(query)
+	stmt, err := ptx.Prepare(query)
 	if err != nil {
 		log.Error(err)
 		return nil, err
 	}
 
 	var addressID int64
-	_, err = stmt.Query(pg.Scan(&addressID), &address.Line1, &address.Line2, &address.City, &address.State, &address.Zip)
+	address.OrganizationID = OrganizationID(tx)
+	_, err = stmt.Query(pg.Scan(&addressID), &address.Line1, &address.Line2, &address.City, &address.State, &address.Zip, &address.OrganizationID)
 	if err != nil {
 		log.Error(err)
 		return nil, err
 	}
 	address.ID = addressID
+	address.Version = 1
 
 	return address, nil
 }
 
-// UpdateAddress updates an address
-func (a *AddressesPostgresAccess) UpdateAddress(tx *pg.Tx, address *models.Address) (*models.Address, error) {
-	var q []string
-	if address.Line1 != "" {
-		q = append(q, "line1 = ?line1")
-	}
-	if address.Line2 != "" {
-		q = append(q, "line2 = ?line2")
-	}
-	if address.City != "" {
-		q = append(q, "city = ?city")
-	}
-	if address.State != "" {
-		q = append(q, "state = ?state")
-	}
-	if address.Zip != "" {
-		q = append(q, "zip = ?zip")
-	}
-
-	qString := strings.Join(q, ", ")
-	_, updateErr := tx.Model(address).Set(qString).Where("id = ?id").Update()
+// UpdateAddress replaces an address's lines, city, state and zip with the given address's, if its version is the given address's Version
+func (a *AddressesPostgresAccess) UpdateAddress(tx Tx, address *models.Address) (*models.Address, error) {
+	ptx := pgTx(tx)
+	result, updateErr := ptx.Model(address).
+		Set("line1 = ?line1, line2 = ?line2, city = ?city, state = ?state, zip = ?zip").
+		Set("version = version + 1").
+		Where("id = ?id").
+		Where("organization_id = ?", OrganizationID(tx)).
+		Apply(whereVersion(address.Version)).
+		Update()
 	if updateErr != nil {
 		log.Error(updateErr)
 		return nil, updateErr
 	}
+	if err := checkVersion(result, address.Version); err != nil {
+		return nil, err
+	}
 
 	updatedAddress, _ := a.GetAddress(tx, address.ID)
 	return updatedAddress, nil
 }
 
-// DeleteAddress deletes an address
-func (a *AddressesPostgresAccess) DeleteAddress(tx *pg.Tx, id int64) (*models.Address, error) {
-	address := &models.Address{
-		ID: id,
-	}
-	err := tx.Delete(address)
+// DeleteAddress deletes an address, if it is at the given version
+func (a *AddressesPostgresAccess) DeleteAddress(tx Tx, id int64, version int64) (*models.Address, error) {
+	ptx := pgTx(tx)
+	result, err := ptx.Model((*models.Address)(nil)).
+		Where("id = ?", id).
+		Where("organization_id = ?", OrganizationID(tx)).
+		Apply(whereVersion(version)).
+		Delete()
 	if err != nil {
 		log.Error(err)
 		return nil, err
 	}
-	return nil, nil
+	return nil, checkVersion(result, version)
 }
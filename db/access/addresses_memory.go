@@ -0,0 +1,120 @@
+package access
+
+import (
+	"github.com/kyrstenkelly/rsvp-api/db/models"
+	"github.com/kyrstenkelly/rsvp-api/utils"
+)
+
+// AddressesMemoryAccess in-memory implementation of an AddressesDAO
+type AddressesMemoryAccess struct {
+}
+
+// NewAddressesMemoryDAO Create a new in-memory addresses dao
+func NewAddressesMemoryDAO() AddressesAccess {
+	return &AddressesMemoryAccess{}
+}
+
+// GetAddresses gets a page of addresses, along with how many addresses match the list's filters
+func (a *AddressesMemoryAccess) GetAddresses(tx Tx, list ListQuery) ([]models.Address, int, error) {
+	var rows []models.Address
+	for _, address := range memTx(tx).tables.addresses {
+		if address.OrganizationID == OrganizationID(tx) {
+			rows = append(rows, address)
+		}
+	}
+	page, total := listMemoryRows(len(rows), func(i int) map[string]interface{} {
+		return map[string]interface{}{
+			"id":    rows[i].ID,
+			"line1": rows[i].Line1,
+			"line2": rows[i].Line2,
+			"city":  rows[i].City,
+			"state": rows[i].State,
+			"zip":   rows[i].Zip,
+		}
+	}, AddressesList, list)
+
+	addresses := []models.Address{}
+	for _, i := range page {
+		addresses = append(addresses, rows[i])
+	}
+	return addresses, total, nil
+}
+
+// GetAddress gets an address by id
+func (a *AddressesMemoryAccess) GetAddress(tx Tx, id int64) (*models.Address, error) {
+	address, ok := memTx(tx).tables.addresses[id]
+	if !ok || address.OrganizationID != OrganizationID(tx) {
+		return nil, nil
+	}
+	return &address, nil
+}
+
+// FindOrCreateAddress finds an identical address in the transaction's organization, returning a copy of it,
+// or creates the given one and returns it
+func (a *AddressesMemoryAccess) FindOrCreateAddress(tx Tx, address *models.Address) (*models.Address, error) {
+	if address == nil {
+		return nil, utils.ArgumentError.Here().WithMessage("An address is required")
+	}
+	tables := memTx(tx).tables
+	var existingAddressID int64
+	for id, existing := range tables.addresses {
+		if existing.OrganizationID == OrganizationID(tx) && existing.Line1 == address.Line1 && existing.Line2 == address.Line2 &&
+			existing.City == address.City && existing.State == address.State && existing.Zip == address.Zip {
+			if existingAddressID == 0 || id < existingAddressID {
+				existingAddressID = id
+			}
+		}
+	}
+	if existingAddressID > 0 {
+		return a.GetAddress(tx, existingAddressID)
+	}
+
+	address.ID = tables.nextID("addresses")
+	address.OrganizationID = OrganizationID(tx)
+	address.Version = 1
+	tables.addresses[address.ID] = *address
+	return address, nil
+}
+
+// UpdateAddress replaces an address's lines, city, state and zip with the given address's, if its version is the given address's Version
+func (a *AddressesMemoryAccess) UpdateAddress(tx Tx, address *models.Address) (*models.Address, error) {
+	existing, _ := a.GetAddress(tx, address.ID)
+	if existing == nil {
+		return nil, nil
+	}
+	if err := matchVersion(existing.Version, address.Version); err != nil {
+		return nil, err
+	}
+	existing.Line1 = address.Line1
+	existing.Line2 = address.Line2
+	existing.City = address.City
+	existing.State = address.State
+	existing.Zip = address.Zip
+	existing.Version++
+	memTx(tx).tables.addresses[existing.ID] = *existing
+	return existing, nil
+}
+
+// DeleteAddress deletes an address that no event or invitation is at, if it is at the given version
+func (a *AddressesMemoryAccess) DeleteAddress(tx Tx, id int64, version int64) (*models.Address, error) {
+	address, _ := a.GetAddress(tx, id)
+	if address == nil {
+		return nil, matchVersion(0, version)
+	}
+	if err := matchVersion(address.Version, version); err != nil {
+		return nil, err
+	}
+	tables := memTx(tx).tables
+	for _, event := range tables.events {
+		if event.AddressID == id {
+			return nil, foreignKeyError("addresses", id, "events")
+		}
+	}
+	for _, invitation := range tables.invitations {
+		if invitation.AddressID == id {
+			return nil, foreignKeyError("addresses", id, "invitations")
+		}
+	}
+	delete(tables.addresses, id)
+	return nil, nil
+}
@@ -0,0 +1,104 @@
+package access
+
+import (
+	"context"
+	"github.com/kyrstenkelly/rsvp-api/db/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// Audited entities, as named in the audit log
+const (
+	AuditAddress    = "address"
+	AuditCampaign   = "campaign"
+	AuditEvent      = "event"
+	AuditGuest      = "guest"
+	AuditInvitation = "invitation"
+	AuditRSVP       = "rsvp"
+	AuditSeat       = "seat"
+	AuditTable      = "table"
+	AuditConstraint = "seating_constraint"
+	AuditWebhook    = "webhook"
+)
+
+// Audit log actions
+const (
+	AuditCreate  = "create"
+	AuditUpdate  = "update"
+	AuditDelete  = "delete"
+	AuditRestore = "restore"
+)
+
+// SystemActor is recorded for changes made outside of a request, e.g. by the reminder scheduler
+const SystemActor = "system"
+
+type actorKey struct{}
+
+// WithActor returns a copy of ctx that records changes made in it as made by actor: a token's
+// subject, or "rsvp:<code>" for a guest using their RSVP code
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// Actor gets who a transaction's changes are made by, or SystemActor if its context has none
+func Actor(tx Tx) string {
+	actor, _ := tx.Context().Value(actorKey{}).(string)
+	if actor == "" {
+		return SystemActor
+	}
+	return actor
+}
+
+// AuditPostgresAccess postgres implementation of an AuditDAO
+type AuditPostgresAccess struct {
+}
+
+// AuditAccess interface for an audit log data access object. The log is append-only.
+type AuditAccess interface {
+	RecordChange(tx Tx, entry *models.AuditEntry) error
+	GetAuditEntries(tx Tx, list ListQuery) ([]models.AuditEntry, int, error)
+}
+
+// NewAuditDAO Create a new audit dao
+func NewAuditDAO() AuditAccess {
+	return &AuditPostgresAccess{}
+}
+
+// AuditList is how the audit log can be filtered. Entries are sorted oldest first by default.
+var AuditList = ListSpec{
+	Fields: map[string]ListField{
+		"id":        {Column: "audit_entry.id", Kind: ListInt, Sort: true},
+		"entity":    {Column: "audit_entry.entity", Kind: ListString, Filter: true},
+		"entity_id": {Column: "audit_entry.entity_id", Kind: ListInt, Filter: true},
+		"actor":     {Column: "audit_entry.actor", Kind: ListString, Filter: true},
+		"action":    {Column: "audit_entry.action", Kind: ListString, Filter: true},
+	},
+}
+
+// RecordChange appends an entry to the audit log
+func (a *AuditPostgresAccess) RecordChange(tx Tx, entry *models.AuditEntry) error {
+	ptx := pgTx(tx)
+	entry.OrganizationID = OrganizationID(tx)
+	if entry.Changed == nil {
+		entry.Changed = []string{}
+	}
+	_, err := ptx.Model(entry).Returning("*").Insert()
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	return nil
+}
+
+// GetAuditEntries gets a page of the audit log, along with how many entries match the list's filters
+func (a *AuditPostgresAccess) GetAuditEntries(tx Tx, list ListQuery) ([]models.AuditEntry, int, error) {
+	ptx := pgTx(tx)
+	entries := []models.AuditEntry{}
+	query := ptx.Model(&entries).
+		Where("audit_entry.organization_id = ?", OrganizationID(tx))
+	total, err := applyListQuery(query, AuditList, list).SelectAndCount()
+	if err != nil {
+		log.Error(err)
+		return nil, 0, err
+	}
+	return entries, total, nil
+}
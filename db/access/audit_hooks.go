@@ -0,0 +1,577 @@
+package access
+
+import (
+	"encoding/json"
+	"github.com/kyrstenkelly/rsvp-api/db/models"
+	"reflect"
+	"sort"
+)
+
+// auditHook records the writes made through the DAOs it wraps in the audit log. Each wrapped
+// Create*, Update*, Delete* and Restore* method reads the row before and after the write, in the
+// same transaction, so an entry is only recorded if the write commits.
+type auditHook struct {
+	audit AuditAccess
+}
+
+// auditedStore wraps the store's DAOs so that their writes are recorded in its audit log. Rows that a
+// DAO writes through another on the way, like an invitation's new guests and address or an RSVP's plus
+// ones, have no entry of their own but show up in its entry's JSON. FindOrCreateAddress is only recorded
+// when it creates the address. Organizations are deliberately left out: the log belongs to an
+// organization, and one is created, from the command line, before it has any.
+func auditedStore(store *Store) *Store {
+	hook := auditHook{audit: store.Audit}
+	store.Addresses = &auditedAddresses{AddressesAccess: store.Addresses, hook: hook}
+	store.Events = &auditedEvents{EventsAccess: store.Events, hook: hook}
+	store.Guests = &auditedGuests{GuestsAccess: store.Guests, rsvps: store.RSVPs, hook: hook}
+	store.Invitations = &auditedInvitations{InvitationsAccess: store.Invitations, hook: hook}
+	store.ReminderCampaigns = &auditedCampaigns{ReminderCampaignsAccess: store.ReminderCampaigns, hook: hook}
+	store.RSVPs = &auditedRSVPs{RSVPsAccess: store.RSVPs, hook: hook}
+	store.Seating = &auditedSeating{SeatingAccess: store.Seating, hook: hook}
+	store.Webhooks = &auditedWebhooks{WebhooksAccess: store.Webhooks, hook: hook}
+	return store
+}
+
+// record runs a write to one row and records it, with the row as get reads it before and after.
+// id is 0 for creates, and write returns the id of the row it wrote. Nothing is recorded if the
+// row didn't exist either side of the write, or didn't change.
+func (h auditHook) record(tx Tx, entity string, action string, id int64, get func(id int64) (interface{}, error),
+	write func() (int64, error)) error {
+	var before interface{}
+	if id != 0 {
+		row, err := get(id)
+		if err != nil {
+			return err
+		}
+		before = row
+	}
+	writtenID, err := write()
+	if err != nil {
+		return err
+	}
+	if writtenID == 0 {
+		writtenID = id
+	}
+	after, err := get(writtenID)
+	if err != nil {
+		return err
+	}
+
+	entry := &models.AuditEntry{
+		Actor:    Actor(tx),
+		Entity:   entity,
+		EntityID: writtenID,
+		Action:   action,
+	}
+	if entry.Before, err = auditDocument(before); err != nil {
+		return err
+	}
+	if entry.After, err = auditDocument(after); err != nil {
+		return err
+	}
+	if entry.Before == nil && entry.After == nil {
+		return nil
+	}
+	entry.Changed = changedFields(entry.Before, entry.After)
+	if len(entry.Changed) == 0 {
+		return nil
+	}
+	return h.audit.RecordChange(tx, entry)
+}
+
+// auditDocument converts a row to the JSON object the API serves it as, or nil if there's no row
+func auditDocument(row interface{}) (map[string]interface{}, error) {
+	data, err := json.Marshal(row)
+	if err != nil {
+		return nil, err
+	}
+	var document map[string]interface{}
+	if err := json.Unmarshal(data, &document); err != nil {
+		return nil, err
+	}
+	return document, nil
+}
+
+// changedFields lists the top level fields that differ between before and after, in order
+func changedFields(before map[string]interface{}, after map[string]interface{}) []string {
+	changed := []string{}
+	for field, value := range before {
+		if afterValue, ok := after[field]; !ok || !reflect.DeepEqual(value, afterValue) {
+			changed = append(changed, field)
+		}
+	}
+	for field := range after {
+		if _, ok := before[field]; !ok {
+			changed = append(changed, field)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+type auditedAddresses struct {
+	AddressesAccess
+	hook auditHook
+}
+
+func (a *auditedAddresses) get(tx Tx) func(int64) (interface{}, error) {
+	return func(id int64) (interface{}, error) { return a.AddressesAccess.GetAddress(tx, id) }
+}
+
+// FindOrCreateAddress finds or creates an address, recording the change if it creates one
+func (a *auditedAddresses) FindOrCreateAddress(tx Tx, address *models.Address) (found *models.Address, err error) {
+	err = a.hook.record(tx, AuditAddress, AuditCreate, 0, a.get(tx), func() (int64, error) {
+		// An address that was found comes back as a copy rather than the one given
+		if found, err = a.AddressesAccess.FindOrCreateAddress(tx, address); err != nil || found != address {
+			return 0, err
+		}
+		return found.ID, nil
+	})
+	return found, err
+}
+
+// UpdateAddress updates an address, recording the change
+func (a *auditedAddresses) UpdateAddress(tx Tx, address *models.Address) (updated *models.Address, err error) {
+	err = a.hook.record(tx, AuditAddress, AuditUpdate, address.ID, a.get(tx), func() (int64, error) {
+		updated, err = a.AddressesAccess.UpdateAddress(tx, address)
+		return address.ID, err
+	})
+	return updated, err
+}
+
+// DeleteAddress deletes an address, recording the change
+func (a *auditedAddresses) DeleteAddress(tx Tx, id int64, version int64) (deleted *models.Address, err error) {
+	err = a.hook.record(tx, AuditAddress, AuditDelete, id, a.get(tx), func() (int64, error) {
+		deleted, err = a.AddressesAccess.DeleteAddress(tx, id, version)
+		return id, err
+	})
+	return deleted, err
+}
+
+type auditedEvents struct {
+	EventsAccess
+	hook auditHook
+}
+
+func (a *auditedEvents) get(tx Tx) func(int64) (interface{}, error) {
+	return func(id int64) (interface{}, error) { return a.EventsAccess.GetEvent(tx, id) }
+}
+
+// CreateEvent creates an event, recording the change
+func (a *auditedEvents) CreateEvent(tx Tx, event *models.Event) (created *models.Event, err error) {
+	err = a.hook.record(tx, AuditEvent, AuditCreate, 0, a.get(tx), func() (int64, error) {
+		if created, err = a.EventsAccess.CreateEvent(tx, event); err != nil || created == nil {
+			return 0, err
+		}
+		return created.ID, nil
+	})
+	return created, err
+}
+
+// UpdateEvent updates an event, recording the change
+func (a *auditedEvents) UpdateEvent(tx Tx, event *models.Event) (updated *models.Event, err error) {
+	err = a.hook.record(tx, AuditEvent, AuditUpdate, event.ID, a.get(tx), func() (int64, error) {
+		updated, err = a.EventsAccess.UpdateEvent(tx, event)
+		return event.ID, err
+	})
+	return updated, err
+}
+
+// DeleteEvent deletes an event, recording the change
+func (a *auditedEvents) DeleteEvent(tx Tx, id int64, version int64) (deleted *models.Event, err error) {
+	err = a.hook.record(tx, AuditEvent, AuditDelete, id, a.get(tx), func() (int64, error) {
+		deleted, err = a.EventsAccess.DeleteEvent(tx, id, version)
+		return id, err
+	})
+	return deleted, err
+}
+
+// RestoreEvent takes an event out of the trash, recording the change
+func (a *auditedEvents) RestoreEvent(tx Tx, id int64) (restored *models.Event, err error) {
+	err = a.hook.record(tx, AuditEvent, AuditRestore, id, a.get(tx), func() (int64, error) {
+		restored, err = a.EventsAccess.RestoreEvent(tx, id)
+		return id, err
+	})
+	return restored, err
+}
+
+type auditedGuests struct {
+	GuestsAccess
+	// rsvps reads the rsvp a merge moves responses within
+	rsvps RSVPsAccess
+	hook  auditHook
+}
+
+func (a *auditedGuests) get(tx Tx) func(int64) (interface{}, error) {
+	return func(id int64) (interface{}, error) { return a.GuestsAccess.GetGuest(tx, id) }
+}
+
+// CreateGuest creates a guest, recording the change
+func (a *auditedGuests) CreateGuest(tx Tx, guest *models.Guest) (created *models.Guest, err error) {
+	err = a.hook.record(tx, AuditGuest, AuditCreate, 0, a.get(tx), func() (int64, error) {
+		if created, err = a.GuestsAccess.CreateGuest(tx, guest); err != nil || created == nil {
+			return 0, err
+		}
+		return created.ID, nil
+	})
+	return created, err
+}
+
+// UpdateGuest updates a guest, recording the change
+func (a *auditedGuests) UpdateGuest(tx Tx, guest *models.Guest) (updated *models.Guest, err error) {
+	err = a.hook.record(tx, AuditGuest, AuditUpdate, guest.ID, a.get(tx), func() (int64, error) {
+		updated, err = a.GuestsAccess.UpdateGuest(tx, guest)
+		return guest.ID, err
+	})
+	return updated, err
+}
+
+// DeleteGuest deletes a guest, recording the change
+func (a *auditedGuests) DeleteGuest(tx Tx, id int64, version int64) (deleted *models.Guest, err error) {
+	err = a.hook.record(tx, AuditGuest, AuditDelete, id, a.get(tx), func() (int64, error) {
+		deleted, err = a.GuestsAccess.DeleteGuest(tx, id, version)
+		return id, err
+	})
+	return deleted, err
+}
+
+// MergeGuests merges duplicates into a guest, recording the update to the guest and to their invitation's
+// rsvp, whose responses move to the guest, and each duplicate's deletion
+func (a *auditedGuests) MergeGuests(tx Tx, guestID int64, duplicateIDs []int64) (merged *models.Guest, err error) {
+	write := func() error {
+		merged, err = a.GuestsAccess.MergeGuests(tx, guestID, duplicateIDs)
+		return err
+	}
+	for _, duplicateID := range duplicateIDs {
+		duplicateID, merge := duplicateID, write
+		write = func() error {
+			return a.hook.record(tx, AuditGuest, AuditDelete, duplicateID, a.get(tx), func() (int64, error) {
+				return duplicateID, merge()
+			})
+		}
+	}
+
+	guest, err := a.GuestsAccess.GetGuest(tx, guestID)
+	if err != nil {
+		return nil, err
+	}
+	if guest != nil && guest.InvitationID != 0 {
+		rsvp, err := a.rsvps.GetRSVPByInvitation(tx, guest.InvitationID)
+		if err != nil {
+			return nil, err
+		}
+		if rsvp != nil {
+			merge := write
+			getRSVP := func(id int64) (interface{}, error) { return a.rsvps.GetRSVP(tx, id) }
+			write = func() error {
+				return a.hook.record(tx, AuditRSVP, AuditUpdate, rsvp.ID, getRSVP, func() (int64, error) {
+					return rsvp.ID, merge()
+				})
+			}
+		}
+	}
+
+	err = a.hook.record(tx, AuditGuest, AuditUpdate, guestID, a.get(tx), func() (int64, error) {
+		return guestID, write()
+	})
+	return merged, err
+}
+
+type auditedInvitations struct {
+	InvitationsAccess
+	hook auditHook
+}
+
+func (a *auditedInvitations) get(tx Tx) func(int64) (interface{}, error) {
+	return func(id int64) (interface{}, error) { return a.InvitationsAccess.GetInvitation(tx, id) }
+}
+
+// CreateInvitation creates an invitation, recording the change
+func (a *auditedInvitations) CreateInvitation(tx Tx, invitation *models.Invitation) (created *models.Invitation, err error) {
+	err = a.hook.record(tx, AuditInvitation, AuditCreate, 0, a.get(tx), func() (int64, error) {
+		if created, err = a.InvitationsAccess.CreateInvitation(tx, invitation); err != nil || created == nil {
+			return 0, err
+		}
+		return created.ID, nil
+	})
+	return created, err
+}
+
+// UpdateInvitation updates an invitation, recording the change
+func (a *auditedInvitations) UpdateInvitation(tx Tx, invitation *models.Invitation) (updated *models.Invitation, err error) {
+	err = a.hook.record(tx, AuditInvitation, AuditUpdate, invitation.ID, a.get(tx), func() (int64, error) {
+		updated, err = a.InvitationsAccess.UpdateInvitation(tx, invitation)
+		return invitation.ID, err
+	})
+	return updated, err
+}
+
+// DeleteInvitation deletes an invitation, recording the change
+func (a *auditedInvitations) DeleteInvitation(tx Tx, id int64, version int64) (deleted *models.Invitation, err error) {
+	err = a.hook.record(tx, AuditInvitation, AuditDelete, id, a.get(tx), func() (int64, error) {
+		deleted, err = a.InvitationsAccess.DeleteInvitation(tx, id, version)
+		return id, err
+	})
+	return deleted, err
+}
+
+// RestoreInvitation takes an invitation out of the trash, recording the change
+func (a *auditedInvitations) RestoreInvitation(tx Tx, id int64) (restored *models.Invitation, err error) {
+	err = a.hook.record(tx, AuditInvitation, AuditRestore, id, a.get(tx), func() (int64, error) {
+		restored, err = a.InvitationsAccess.RestoreInvitation(tx, id)
+		return id, err
+	})
+	return restored, err
+}
+
+// SetInvitationGuests sets an invitation's guests, recording it as a change to the invitation
+func (a *auditedInvitations) SetInvitationGuests(tx Tx, invitationID int64, guestIDs []int64) error {
+	return a.hook.record(tx, AuditInvitation, AuditUpdate, invitationID, a.get(tx), func() (int64, error) {
+		return invitationID, a.InvitationsAccess.SetInvitationGuests(tx, invitationID, guestIDs)
+	})
+}
+
+type auditedCampaigns struct {
+	ReminderCampaignsAccess
+	hook auditHook
+}
+
+func (a *auditedCampaigns) get(tx Tx) func(int64) (interface{}, error) {
+	return func(id int64) (interface{}, error) { return a.ReminderCampaignsAccess.GetCampaign(tx, id) }
+}
+
+// CreateCampaign creates a reminder campaign, recording the change
+func (a *auditedCampaigns) CreateCampaign(tx Tx, campaign *models.ReminderCampaign) (created *models.ReminderCampaign, err error) {
+	err = a.hook.record(tx, AuditCampaign, AuditCreate, 0, a.get(tx), func() (int64, error) {
+		if created, err = a.ReminderCampaignsAccess.CreateCampaign(tx, campaign); err != nil || created == nil {
+			return 0, err
+		}
+		return created.ID, nil
+	})
+	return created, err
+}
+
+// UpdateCampaign updates a reminder campaign, recording the change
+func (a *auditedCampaigns) UpdateCampaign(tx Tx, campaign *models.ReminderCampaign) (updated *models.ReminderCampaign, err error) {
+	err = a.hook.record(tx, AuditCampaign, AuditUpdate, campaign.ID, a.get(tx), func() (int64, error) {
+		updated, err = a.ReminderCampaignsAccess.UpdateCampaign(tx, campaign)
+		return campaign.ID, err
+	})
+	return updated, err
+}
+
+// SetCampaignPaused pauses or resumes a reminder campaign, recording the change
+func (a *auditedCampaigns) SetCampaignPaused(tx Tx, id int64, paused bool) (updated *models.ReminderCampaign, err error) {
+	err = a.hook.record(tx, AuditCampaign, AuditUpdate, id, a.get(tx), func() (int64, error) {
+		updated, err = a.ReminderCampaignsAccess.SetCampaignPaused(tx, id, paused)
+		return id, err
+	})
+	return updated, err
+}
+
+// DeleteCampaign deletes a reminder campaign, recording the change
+func (a *auditedCampaigns) DeleteCampaign(tx Tx, id int64, version int64) (deleted *models.ReminderCampaign, err error) {
+	err = a.hook.record(tx, AuditCampaign, AuditDelete, id, a.get(tx), func() (int64, error) {
+		deleted, err = a.ReminderCampaignsAccess.DeleteCampaign(tx, id, version)
+		return id, err
+	})
+	return deleted, err
+}
+
+type auditedRSVPs struct {
+	RSVPsAccess
+	hook auditHook
+}
+
+func (a *auditedRSVPs) get(tx Tx) func(int64) (interface{}, error) {
+	return func(id int64) (interface{}, error) { return a.RSVPsAccess.GetRSVP(tx, id) }
+}
+
+// CreateRSVP creates an rsvp, recording the change
+func (a *auditedRSVPs) CreateRSVP(tx Tx, rsvp *models.RSVP, enforceDeadline bool) (created *models.RSVP, err error) {
+	err = a.hook.record(tx, AuditRSVP, AuditCreate, 0, a.get(tx), func() (int64, error) {
+		if created, err = a.RSVPsAccess.CreateRSVP(tx, rsvp, enforceDeadline); err != nil || created == nil {
+			return 0, err
+		}
+		return created.ID, nil
+	})
+	return created, err
+}
+
+// UpdateRSVP updates an rsvp, recording the change
+func (a *auditedRSVPs) UpdateRSVP(tx Tx, rsvp *models.RSVP, enforceDeadline bool) (updated *models.RSVP, err error) {
+	err = a.hook.record(tx, AuditRSVP, AuditUpdate, rsvp.ID, a.get(tx), func() (int64, error) {
+		updated, err = a.RSVPsAccess.UpdateRSVP(tx, rsvp, enforceDeadline)
+		return rsvp.ID, err
+	})
+	return updated, err
+}
+
+// DeleteRSVP deletes an rsvp, recording the change
+func (a *auditedRSVPs) DeleteRSVP(tx Tx, id int64, version int64) (deleted *models.RSVP, err error) {
+	err = a.hook.record(tx, AuditRSVP, AuditDelete, id, a.get(tx), func() (int64, error) {
+		deleted, err = a.RSVPsAccess.DeleteRSVP(tx, id, version)
+		return id, err
+	})
+	return deleted, err
+}
+
+// RestoreRSVP takes an rsvp out of the trash, recording the change
+func (a *auditedRSVPs) RestoreRSVP(tx Tx, id int64) (restored *models.RSVP, err error) {
+	err = a.hook.record(tx, AuditRSVP, AuditRestore, id, a.get(tx), func() (int64, error) {
+		restored, err = a.RSVPsAccess.RestoreRSVP(tx, id)
+		return id, err
+	})
+	return restored, err
+}
+
+type auditedSeating struct {
+	SeatingAccess
+	hook auditHook
+}
+
+func (a *auditedSeating) getTable(tx Tx) func(int64) (interface{}, error) {
+	return func(id int64) (interface{}, error) { return a.SeatingAccess.GetSeatingTable(tx, id) }
+}
+
+func (a *auditedSeating) getConstraint(tx Tx) func(int64) (interface{}, error) {
+	return func(id int64) (interface{}, error) { return a.SeatingAccess.GetSeatingConstraint(tx, id) }
+}
+
+func (a *auditedSeating) getSeat(tx Tx) func(int64) (interface{}, error) {
+	return func(id int64) (interface{}, error) { return a.SeatingAccess.GetSeat(tx, id) }
+}
+
+// CreateSeatingTable creates a table, recording the change
+func (a *auditedSeating) CreateSeatingTable(tx Tx, table *models.SeatingTable) (created *models.SeatingTable, err error) {
+	err = a.hook.record(tx, AuditTable, AuditCreate, 0, a.getTable(tx), func() (int64, error) {
+		if created, err = a.SeatingAccess.CreateSeatingTable(tx, table); err != nil || created == nil {
+			return 0, err
+		}
+		return created.ID, nil
+	})
+	return created, err
+}
+
+// UpdateSeatingTable updates a table, recording the change
+func (a *auditedSeating) UpdateSeatingTable(tx Tx, table *models.SeatingTable) (updated *models.SeatingTable, err error) {
+	err = a.hook.record(tx, AuditTable, AuditUpdate, table.ID, a.getTable(tx), func() (int64, error) {
+		updated, err = a.SeatingAccess.UpdateSeatingTable(tx, table)
+		return table.ID, err
+	})
+	return updated, err
+}
+
+// DeleteSeatingTable deletes a table, recording the change
+func (a *auditedSeating) DeleteSeatingTable(tx Tx, id int64, version int64) (deleted *models.SeatingTable, err error) {
+	err = a.hook.record(tx, AuditTable, AuditDelete, id, a.getTable(tx), func() (int64, error) {
+		deleted, err = a.SeatingAccess.DeleteSeatingTable(tx, id, version)
+		return id, err
+	})
+	return deleted, err
+}
+
+// SetSeats seats and unseats guests, recording each guest whose seat changes under their rsvp guest's id:
+// as a create when they are seated, an update when they move table and a delete when they are unseated
+func (a *auditedSeating) SetSeats(tx Tx, seats []models.Seat) error {
+	for _, seat := range seats {
+		seat := seat
+		current, err := a.SeatingAccess.GetSeat(tx, seat.RSVPGuestID)
+		if err != nil {
+			return err
+		}
+		action := AuditUpdate
+		if current == nil {
+			action = AuditCreate
+		} else if seat.TableID == nil {
+			action = AuditDelete
+		}
+		err = a.hook.record(tx, AuditSeat, action, seat.RSVPGuestID, a.getSeat(tx), func() (int64, error) {
+			return seat.RSVPGuestID, a.SeatingAccess.SetSeats(tx, []models.Seat{seat})
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CreateSeatingConstraint creates a seating constraint, recording the change
+func (a *auditedSeating) CreateSeatingConstraint(tx Tx, constraint *models.SeatingConstraint) (created *models.SeatingConstraint, err error) {
+	err = a.hook.record(tx, AuditConstraint, AuditCreate, 0, a.getConstraint(tx), func() (int64, error) {
+		if created, err = a.SeatingAccess.CreateSeatingConstraint(tx, constraint); err != nil || created == nil {
+			return 0, err
+		}
+		return created.ID, nil
+	})
+	return created, err
+}
+
+// DeleteSeatingConstraint deletes a seating constraint, recording the change
+func (a *auditedSeating) DeleteSeatingConstraint(tx Tx, id int64, version int64) (deleted *models.SeatingConstraint, err error) {
+	err = a.hook.record(tx, AuditConstraint, AuditDelete, id, a.getConstraint(tx), func() (int64, error) {
+		deleted, err = a.SeatingAccess.DeleteSeatingConstraint(tx, id, version)
+		return id, err
+	})
+	return deleted, err
+}
+
+// Webhook secrets are left out of the audit log, and stood in for by one of these
+const (
+	auditSecretRedacted = "[redacted]"
+	auditSecretChanged  = "[redacted, changed]"
+)
+
+type auditedWebhooks struct {
+	WebhooksAccess
+	hook auditHook
+}
+
+// get reads a webhook with its secret redacted. A secret that differs from the one it last read is
+// redacted differently, so that a new secret shows up in the entry's changed fields.
+func (a *auditedWebhooks) get(tx Tx) func(int64) (interface{}, error) {
+	var previous *string
+	return func(id int64) (interface{}, error) {
+		webhook, err := a.WebhooksAccess.GetWebhook(tx, id)
+		if err != nil || webhook == nil {
+			return webhook, err
+		}
+		secret := webhook.Secret
+		if previous != nil && *previous != secret {
+			webhook.Secret = auditSecretChanged
+		} else {
+			webhook.Secret = auditSecretRedacted
+		}
+		previous = &secret
+		return webhook, nil
+	}
+}
+
+// CreateWebhook creates a webhook, recording the change
+func (a *auditedWebhooks) CreateWebhook(tx Tx, webhook *models.Webhook) (created *models.Webhook, err error) {
+	err = a.hook.record(tx, AuditWebhook, AuditCreate, 0, a.get(tx), func() (int64, error) {
+		if created, err = a.WebhooksAccess.CreateWebhook(tx, webhook); err != nil || created == nil {
+			return 0, err
+		}
+		return created.ID, nil
+	})
+	return created, err
+}
+
+// UpdateWebhook updates a webhook, recording the change
+func (a *auditedWebhooks) UpdateWebhook(tx Tx, webhook *models.Webhook) (updated *models.Webhook, err error) {
+	err = a.hook.record(tx, AuditWebhook, AuditUpdate, webhook.ID, a.get(tx), func() (int64, error) {
+		updated, err = a.WebhooksAccess.UpdateWebhook(tx, webhook)
+		return webhook.ID, err
+	})
+	return updated, err
+}
+
+// DeleteWebhook deletes a webhook, recording the change
+func (a *auditedWebhooks) DeleteWebhook(tx Tx, id int64, version int64) (deleted *models.Webhook, err error) {
+	err = a.hook.record(tx, AuditWebhook, AuditDelete, id, a.get(tx), func() (int64, error) {
+		deleted, err = a.WebhooksAccess.DeleteWebhook(tx, id, version)
+		return id, err
+	})
+	return deleted, err
+}
@@ -0,0 +1,52 @@
+package access
+
+import (
+	"github.com/kyrstenkelly/rsvp-api/db/models"
+)
+
+// AuditMemoryAccess in-memory implementation of an AuditDAO
+type AuditMemoryAccess struct {
+}
+
+// NewAuditMemoryDAO Create a new in-memory audit dao
+func NewAuditMemoryDAO() AuditAccess {
+	return &AuditMemoryAccess{}
+}
+
+// RecordChange appends an entry to the audit log
+func (a *AuditMemoryAccess) RecordChange(tx Tx, entry *models.AuditEntry) error {
+	tables := memTx(tx).tables
+	entry.ID = tables.nextID("audit_entries")
+	entry.OrganizationID = OrganizationID(tx)
+	entry.CreatedAt = memTx(tx).now
+	if entry.Changed == nil {
+		entry.Changed = []string{}
+	}
+	tables.auditEntries[entry.ID] = *entry
+	return nil
+}
+
+// GetAuditEntries gets a page of the audit log, along with how many entries match the list's filters
+func (a *AuditMemoryAccess) GetAuditEntries(tx Tx, list ListQuery) ([]models.AuditEntry, int, error) {
+	var rows []models.AuditEntry
+	for _, entry := range memTx(tx).tables.auditEntries {
+		if entry.OrganizationID == OrganizationID(tx) {
+			rows = append(rows, entry)
+		}
+	}
+	page, total := listMemoryRows(len(rows), func(i int) map[string]interface{} {
+		return map[string]interface{}{
+			"id":        rows[i].ID,
+			"entity":    rows[i].Entity,
+			"entity_id": rows[i].EntityID,
+			"actor":     rows[i].Actor,
+			"action":    rows[i].Action,
+		}
+	}, AuditList, list)
+
+	entries := []models.AuditEntry{}
+	for _, i := range page {
+		entries = append(entries, rows[i])
+	}
+	return entries, total, nil
+}
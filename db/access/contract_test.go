@@ -0,0 +1,813 @@
+package access
+
+import (
+	"context"
+	"errors"
+	"github.com/kyrstenkelly/rsvp-api/db/dbtest"
+	"github.com/kyrstenkelly/rsvp-api/db/models"
+	"github.com/kyrstenkelly/rsvp-api/utils"
+	"net/http"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	os.Exit(dbtest.Run(m))
+}
+
+func TestMemoryDAOContract(t *testing.T) {
+	runDAOContract(t, NewMemoryStore())
+}
+
+func TestPostgresDAOContract(t *testing.T) {
+	conn, drop := dbtest.Postgres(t)
+	defer drop()
+	runDAOContract(t, NewPostgresStore(conn))
+}
+
+// contract holds what the DAO contract's cases share: a store, and contexts for two of its organizations
+type contract struct {
+	store    *Store
+	org      context.Context
+	otherOrg context.Context
+}
+
+// runDAOContract checks the behaviour every store's DAOs must share, whatever backs them
+func runDAOContract(t *testing.T, store *Store) {
+	ctx := WithActor(context.Background(), "contract")
+	var organizationIDs []int64
+	for _, slug := range []string{"contract", "contract-other"} {
+		organization := mustRun(t, ctx, store, func(tx Tx) (interface{}, error) {
+			return store.Organizations.CreateOrganization(tx, &models.Organization{Slug: slug, Name: slug})
+		}).(*models.Organization)
+		organizationIDs = append(organizationIDs, organization.ID)
+	}
+	c := &contract{
+		store:    store,
+		org:      WithOrganization(ctx, organizationIDs[0]),
+		otherOrg: WithOrganization(ctx, organizationIDs[1]),
+	}
+
+	t.Run("FindOrCreateAddressDedupes", c.findOrCreateAddressDedupes)
+	t.Run("OrganizationScoping", c.organizationScoping)
+	t.Run("VersionConflicts", c.versionConflicts)
+	t.Run("RollbackOnError", c.rollbackOnError)
+	t.Run("InvitationEmailsUniquePerOrganization", c.invitationEmailsUniquePerOrganization)
+	t.Run("ListedGuestsKeepTheirInvitation", c.listedGuestsKeepTheirInvitation)
+	t.Run("OneRSVPPerInvitation", c.oneRSVPPerInvitation)
+	t.Run("WebhookSecretsRedactedFromAudit", c.webhookSecretsRedactedFromAudit)
+	t.Run("SeatingAudited", c.seatingAudited)
+	t.Run("RSVPLifecycle", c.rsvpLifecycle)
+	t.Run("RSVPDeadlines", c.rsvpDeadlines)
+	t.Run("RSVPGuestsStayOnTheirRSVP", c.rsvpGuestsStayOnTheirRSVP)
+	t.Run("PlusOneLimits", c.plusOneLimits)
+	t.Run("InvitationTrashTakesItsRSVP", c.invitationTrashTakesItsRSVP)
+}
+
+func (c *contract) findOrCreateAddressDedupes(t *testing.T) {
+	findOrCreate := func(ctx context.Context, address *models.Address) int64 {
+		return mustRun(t, ctx, c.store, func(tx Tx) (interface{}, error) {
+			return c.store.Addresses.FindOrCreateAddress(tx, address)
+		}).(*models.Address).ID
+	}
+
+	first := findOrCreate(c.org, contractAddress("1 Dedupe St"))
+	if again := findOrCreate(c.org, contractAddress("1 Dedupe St")); again != first {
+		t.Errorf("The same address was created twice, as %d and %d", first, again)
+	}
+	apartment := contractAddress("1 Dedupe St")
+	apartment.Line2 = "Apt 2"
+	if id := findOrCreate(c.org, apartment); id == first {
+		t.Error("An address with another line2 was matched to the first")
+	}
+	if id := findOrCreate(c.otherOrg, contractAddress("1 Dedupe St")); id == first {
+		t.Error("Another organization's address was matched")
+	}
+
+	entries := mustRun(t, c.org, c.store, func(tx Tx) (interface{}, error) {
+		entries, _, err := c.store.Audit.GetAuditEntries(tx, ListQuery{
+			Filters: map[string]interface{}{"entity": AuditAddress, "entity_id": first},
+		})
+		return entries, err
+	}).([]models.AuditEntry)
+	if len(entries) != 1 || entries[0].Action != AuditCreate {
+		t.Errorf("Expected creating an address to be recorded once, and finding it not at all, got %v", entries)
+	}
+}
+
+func (c *contract) organizationScoping(t *testing.T) {
+	event := c.createEvent(t, c.org, "Scoped")
+	uninvited := c.createEvent(t, c.org, "Uninvited")
+	invitation := c.createInvitation(t, c.org, event.ID, "scoped@example.com")
+	guest := (*invitation.Guests)[0]
+
+	mustRun(t, c.otherOrg, c.store, func(tx Tx) (interface{}, error) {
+		if found, err := c.store.Events.GetEvent(tx, event.ID); err != nil || found != nil {
+			t.Errorf("Got another organization's event: %v, %v", found, err)
+		}
+		if found, err := c.store.Invitations.GetInvitation(tx, invitation.ID); err != nil || found != nil {
+			t.Errorf("Got another organization's invitation: %v, %v", found, err)
+		}
+		if found, err := c.store.Invitations.GetInvitationByCode(tx, invitation.RSVPCode); err != nil || found != nil {
+			t.Errorf("Got another organization's invitation by its code: %v, %v", found, err)
+		}
+		if found, err := c.store.Guests.GetGuest(tx, guest.ID); err != nil || found != nil {
+			t.Errorf("Got another organization's guest: %v, %v", found, err)
+		}
+
+		events, _, err := c.store.Events.GetEvents(tx, ListQuery{})
+		if err != nil {
+			return nil, err
+		}
+		for _, listed := range events {
+			if listed.ID == event.ID || listed.ID == uninvited.ID {
+				t.Errorf("Another organization's event %d was listed", listed.ID)
+			}
+		}
+		invitations, _, err := c.store.Invitations.GetInvitations(tx, ListQuery{})
+		if err != nil {
+			return nil, err
+		}
+		for _, listed := range invitations {
+			if listed.ID == invitation.ID {
+				t.Errorf("Another organization's invitation %d was listed", listed.ID)
+			}
+		}
+		guests, _, err := c.store.Guests.GetGuests(tx, ListQuery{})
+		if err != nil {
+			return nil, err
+		}
+		for _, listed := range guests {
+			if listed.ID == guest.ID {
+				t.Errorf("Another organization's guest %d was listed", listed.ID)
+			}
+		}
+		return nil, nil
+	})
+
+	// Another organization's rows aren't there for it to write: updates change nothing, and so do deletes,
+	// apart from moving to the trash, which is not found
+	writes := map[string]struct {
+		write func(tx Tx) (interface{}, error)
+		want  int
+	}{
+		"UpdateEvent": {func(tx Tx) (interface{}, error) {
+			return c.store.Events.UpdateEvent(tx, &models.Event{ID: event.ID, Name: "Taken", Date: "2030-01-01", Address: contractAddress("1 Taken St")})
+		}, 0},
+		"DeleteEvent": {func(tx Tx) (interface{}, error) {
+			return c.store.Events.DeleteEvent(tx, uninvited.ID, AnyVersion)
+		}, http.StatusNotFound},
+		"UpdateGuest": {func(tx Tx) (interface{}, error) {
+			return c.store.Guests.UpdateGuest(tx, &models.Guest{ID: guest.ID, Name: "Taken Guest"})
+		}, 0},
+		"DeleteGuest": {func(tx Tx) (interface{}, error) {
+			return c.store.Guests.DeleteGuest(tx, guest.ID, AnyVersion)
+		}, 0},
+		"DeleteInvitation": {func(tx Tx) (interface{}, error) {
+			return c.store.Invitations.DeleteInvitation(tx, invitation.ID, AnyVersion)
+		}, http.StatusNotFound},
+	}
+	for name, write := range writes {
+		result, err := Run(c.otherOrg, c.store.Transactor, write.write)
+		code := 0
+		if err != nil {
+			code = utils.StatusCode(err, -1)
+		}
+		if code != write.want {
+			t.Errorf("%s of another organization's row got %d (%v), expected %d", name, code, err, write.want)
+		}
+		if !isNil(result) {
+			t.Errorf("%s of another organization's row returned %v", name, result)
+		}
+	}
+
+	mustRun(t, c.org, c.store, func(tx Tx) (interface{}, error) {
+		if found, err := c.store.Events.GetEvent(tx, event.ID); err != nil || found == nil || found.Name != "Scoped" || found.Version != event.Version {
+			t.Errorf("Another organization changed an event: %v, %v", found, err)
+		}
+		if found, err := c.store.Events.GetEvent(tx, uninvited.ID); err != nil || found == nil {
+			t.Errorf("Another organization deleted an event: %v", err)
+		}
+		if found, err := c.store.Invitations.GetInvitation(tx, invitation.ID); err != nil || found == nil {
+			t.Errorf("Another organization deleted an invitation: %v", err)
+		}
+		if found, err := c.store.Guests.GetGuest(tx, guest.ID); err != nil || found == nil || found.Name != guest.Name {
+			t.Errorf("Another organization changed a guest: %v, %v", found, err)
+		}
+		return nil, nil
+	})
+}
+
+func (c *contract) versionConflicts(t *testing.T) {
+	event := c.createEvent(t, c.org, "Versioned")
+
+	stale := *event
+	stale.Version = event.Version + 1
+	_, err := Run(c.org, c.store.Transactor, func(tx Tx) (interface{}, error) {
+		return c.store.Events.UpdateEvent(tx, &stale)
+	})
+	if code := utils.StatusCode(err, 0); code != http.StatusPreconditionFailed {
+		t.Errorf("Updating another version of an event got %d (%v), expected %d", code, err, http.StatusPreconditionFailed)
+	}
+
+	renamed := *event
+	renamed.Name = "Renamed"
+	updated := mustRun(t, c.org, c.store, func(tx Tx) (interface{}, error) {
+		return c.store.Events.UpdateEvent(tx, &renamed)
+	}).(*models.Event)
+	if updated.Name != "Renamed" || updated.Version != event.Version+1 {
+		t.Errorf("Updating an event at its version gave %q at version %d", updated.Name, updated.Version)
+	}
+
+	_, err = Run(c.org, c.store.Transactor, func(tx Tx) (interface{}, error) {
+		return c.store.Events.DeleteEvent(tx, event.ID, event.Version)
+	})
+	if code := utils.StatusCode(err, 0); code != http.StatusPreconditionFailed {
+		t.Errorf("Deleting an old version of an event got %d (%v), expected %d", code, err, http.StatusPreconditionFailed)
+	}
+
+	mustRun(t, c.org, c.store, func(tx Tx) (interface{}, error) {
+		return c.store.Events.DeleteEvent(tx, updated.ID, updated.Version)
+	})
+	mustRun(t, c.org, c.store, func(tx Tx) (interface{}, error) {
+		if found, err := c.store.Events.GetEvent(tx, event.ID); err != nil || found != nil {
+			t.Errorf("A deleted event was still found: %v, %v", found, err)
+		}
+		return nil, nil
+	})
+}
+
+func (c *contract) rollbackOnError(t *testing.T) {
+	errRollback := errors.New("rolled back")
+	_, err := Run(c.org, c.store.Transactor, func(tx Tx) (interface{}, error) {
+		if _, err := c.store.Events.CreateEvent(tx, &models.Event{Name: "Rolled Back", Date: "2030-01-01", Address: contractAddress("1 Rollback St")}); err != nil {
+			return nil, err
+		}
+		return nil, errRollback
+	})
+	if err != errRollback {
+		t.Fatalf("Expected the unit of work's own error, got %v", err)
+	}
+
+	eventless := &models.Invitation{
+		Name:    "Rolled Back",
+		Email:   "rollback@example.com",
+		Address: contractAddress("2 Rollback St"),
+		Events:  []models.InvitationEvent{{EventID: 1 << 40}},
+		Guests:  &[]models.Guest{{Name: "Rolled Back"}},
+	}
+	_, err = Run(c.org, c.store.Transactor, func(tx Tx) (interface{}, error) {
+		return c.store.Invitations.CreateInvitation(tx, eventless)
+	})
+	if err == nil {
+		t.Fatal("Created an invitation to an event that doesn't exist")
+	}
+
+	mustRun(t, c.org, c.store, func(tx Tx) (interface{}, error) {
+		events, _, err := c.store.Events.GetEvents(tx, ListQuery{})
+		if err != nil {
+			return nil, err
+		}
+		for _, event := range events {
+			if event.Name == "Rolled Back" {
+				t.Errorf("Event %d was kept after its unit of work failed", event.ID)
+			}
+		}
+		addresses, _, err := c.store.Addresses.GetAddresses(tx, ListQuery{})
+		if err != nil {
+			return nil, err
+		}
+		for _, address := range addresses {
+			if address.Line1 == "1 Rollback St" || address.Line1 == "2 Rollback St" {
+				t.Errorf("Address %d was kept after its unit of work failed", address.ID)
+			}
+		}
+		if found, err := c.store.Invitations.GetInvitationByEmail(tx, "rollback@example.com"); err != nil || found != nil {
+			t.Errorf("An invitation was kept after creating it failed: %v, %v", found, err)
+		}
+		guests, _, err := c.store.Guests.GetGuests(tx, ListQuery{})
+		if err != nil {
+			return nil, err
+		}
+		for _, guest := range guests {
+			if guest.Name == "Rolled Back" {
+				t.Errorf("Guest %d was kept after creating their invitation failed", guest.ID)
+			}
+		}
+		return nil, nil
+	})
+}
+
+func (c *contract) invitationEmailsUniquePerOrganization(t *testing.T) {
+	event := c.createEvent(t, c.org, "Unique")
+	c.createInvitation(t, c.org, event.ID, "Unique@Example.com")
+
+	_, err := Run(c.org, c.store.Transactor, func(tx Tx) (interface{}, error) {
+		return c.store.Invitations.CreateInvitation(tx, contractInvitation(event.ID, "unique@example.COM"))
+	})
+	if code := utils.StatusCode(err, 0); code != http.StatusBadRequest {
+		t.Errorf("Inviting an email again in another case got %d (%v), expected %d", code, err, http.StatusBadRequest)
+	}
+
+	otherEvent := c.createEvent(t, c.otherOrg, "Unique")
+	c.createInvitation(t, c.otherOrg, otherEvent.ID, "unique@example.com")
+}
+
+func (c *contract) listedGuestsKeepTheirInvitation(t *testing.T) {
+	event := c.createEvent(t, c.org, "Listed")
+	invitation := c.createInvitation(t, c.org, event.ID, "listed@example.com")
+
+	invitations := mustRun(t, c.org, c.store, func(tx Tx) (interface{}, error) {
+		invitations, _, err := c.store.Invitations.GetInvitations(tx, ListQuery{})
+		return invitations, err
+	}).([]models.Invitation)
+	for _, listed := range invitations {
+		if listed.ID != invitation.ID {
+			continue
+		}
+		if listed.Guests == nil || len(*listed.Guests) != 1 {
+			t.Fatalf("Expected the listed invitation to have one guest, got %v", listed.Guests)
+		}
+		if guest := (*listed.Guests)[0]; guest.InvitationID != invitation.ID {
+			t.Errorf("A listed invitation's guest has invitation_id %d, expected %d", guest.InvitationID, invitation.ID)
+		}
+		return
+	}
+	t.Errorf("Invitation %d wasn't listed", invitation.ID)
+}
+
+func (c *contract) oneRSVPPerInvitation(t *testing.T) {
+	event := c.createEvent(t, c.org, "One RSVP")
+	invitation := c.createInvitation(t, c.org, event.ID, "one-rsvp@example.com")
+	createRSVP := func() (interface{}, error) {
+		return Run(c.org, c.store.Transactor, func(tx Tx) (interface{}, error) {
+			return c.store.RSVPs.CreateRSVP(tx, &models.RSVP{InvitationID: invitation.ID}, false)
+		})
+	}
+
+	first, err := createRSVP()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := createRSVP(); utils.StatusCode(err, 0) != http.StatusBadRequest {
+		t.Errorf("A second rsvp to an invitation got %v, expected a %d", err, http.StatusBadRequest)
+	}
+
+	trashed := first.(*models.RSVP)
+	mustRun(t, c.org, c.store, func(tx Tx) (interface{}, error) {
+		return c.store.RSVPs.DeleteRSVP(tx, trashed.ID, AnyVersion)
+	})
+	if _, err := createRSVP(); err != nil {
+		t.Errorf("Couldn't replace an rsvp in the trash: %v", err)
+	}
+	_, err = Run(c.org, c.store.Transactor, func(tx Tx) (interface{}, error) {
+		return c.store.RSVPs.RestoreRSVP(tx, trashed.ID)
+	})
+	if utils.StatusCode(err, 0) != http.StatusBadRequest {
+		t.Errorf("Restoring a replaced rsvp got %v, expected a %d", err, http.StatusBadRequest)
+	}
+}
+
+func (c *contract) webhookSecretsRedactedFromAudit(t *testing.T) {
+	webhook := mustRun(t, c.org, c.store, func(tx Tx) (interface{}, error) {
+		return c.store.Webhooks.CreateWebhook(tx, &models.Webhook{
+			URL: "https://example.com/hook", Secret: "first-secret", Events: models.WebhookEvents, Active: true,
+		})
+	}).(*models.Webhook)
+	mustRun(t, c.org, c.store, func(tx Tx) (interface{}, error) {
+		webhook.Secret = "second-secret"
+		return c.store.Webhooks.UpdateWebhook(tx, webhook)
+	})
+	mustRun(t, c.org, c.store, func(tx Tx) (interface{}, error) {
+		return c.store.Webhooks.DeleteWebhook(tx, webhook.ID, AnyVersion)
+	})
+
+	entries := mustRun(t, c.org, c.store, func(tx Tx) (interface{}, error) {
+		entries, _, err := c.store.Audit.GetAuditEntries(tx, ListQuery{
+			Filters: map[string]interface{}{"entity": AuditWebhook, "entity_id": webhook.ID},
+		})
+		return entries, err
+	}).([]models.AuditEntry)
+	var actions []string
+	for _, entry := range entries {
+		actions = append(actions, entry.Action)
+		for _, document := range []map[string]interface{}{entry.Before, entry.After} {
+			if secret, ok := document["secret"]; ok && secret != auditSecretRedacted && secret != auditSecretChanged {
+				t.Errorf("The %s entry recorded the secret %v", entry.Action, secret)
+			}
+		}
+	}
+	if len(entries) != 3 {
+		t.Fatalf("Expected a create, update and delete to be recorded, got %v", actions)
+	}
+	if changed := entries[1].Changed; !containsString(changed, "secret") {
+		t.Errorf("Giving a webhook a new secret didn't change its secret, only %v", changed)
+	}
+}
+
+func (c *contract) seatingAudited(t *testing.T) {
+	event := c.createEvent(t, c.org, "Seated")
+	invitation := c.createInvitation(t, c.org, event.ID, "seated@example.com")
+	guest := (*invitation.Guests)[0]
+	rsvp := mustRun(t, c.org, c.store, func(tx Tx) (interface{}, error) {
+		return c.store.RSVPs.CreateRSVP(tx, &models.RSVP{
+			InvitationID: invitation.ID,
+			RSVPGuests:   []models.RSVPGuest{{Guest: &guest, EventID: event.ID, Attending: true}},
+		}, false)
+	}).(*models.RSVP)
+	rsvpGuestID := rsvp.RSVPGuests[0].ID
+
+	var tableIDs []int64
+	for _, name := range []string{"Table 1", "Table 2"} {
+		table := mustRun(t, c.org, c.store, func(tx Tx) (interface{}, error) {
+			return c.store.Seating.CreateSeatingTable(tx, &models.SeatingTable{EventID: event.ID, Name: name, Capacity: 4})
+		}).(*models.SeatingTable)
+		tableIDs = append(tableIDs, table.ID)
+	}
+	for _, tableID := range []*int64{&tableIDs[0], &tableIDs[1], &tableIDs[1], nil} {
+		mustRun(t, c.org, c.store, func(tx Tx) (interface{}, error) {
+			return nil, c.store.Seating.SetSeats(tx, []models.Seat{{RSVPGuestID: rsvpGuestID, TableID: tableID}})
+		})
+	}
+	constraint := mustRun(t, c.org, c.store, func(tx Tx) (interface{}, error) {
+		return c.store.Seating.CreateSeatingConstraint(tx, &models.SeatingConstraint{
+			EventID: event.ID, Kind: models.SeatingApart, InvitationIDs: []int64{invitation.ID},
+		})
+	}).(*models.SeatingConstraint)
+	mustRun(t, c.org, c.store, func(tx Tx) (interface{}, error) {
+		return c.store.Seating.DeleteSeatingConstraint(tx, constraint.ID, AnyVersion)
+	})
+
+	actions := func(entity string, id int64) []string {
+		entries := mustRun(t, c.org, c.store, func(tx Tx) (interface{}, error) {
+			entries, _, err := c.store.Audit.GetAuditEntries(tx, ListQuery{
+				Filters: map[string]interface{}{"entity": entity, "entity_id": id},
+			})
+			return entries, err
+		}).([]models.AuditEntry)
+		actions := []string{}
+		for _, entry := range entries {
+			actions = append(actions, entry.Action)
+		}
+		return actions
+	}
+	expected := []struct {
+		entity  string
+		id      int64
+		actions []string
+	}{
+		{AuditTable, tableIDs[0], []string{AuditCreate}},
+		// Seating the guest at the table they're already at changes nothing
+		{AuditSeat, rsvpGuestID, []string{AuditCreate, AuditUpdate, AuditDelete}},
+		{AuditConstraint, constraint.ID, []string{AuditCreate, AuditDelete}},
+	}
+	for _, e := range expected {
+		if got := actions(e.entity, e.id); !reflect.DeepEqual(got, e.actions) {
+			t.Errorf("Expected %s %d to be recorded as %v, got %v", e.entity, e.id, e.actions, got)
+		}
+	}
+}
+
+func (c *contract) rsvpLifecycle(t *testing.T) {
+	event := c.createEvent(t, c.org, "Lifecycle")
+	invitation := c.createInvitation(t, c.org, event.ID, "lifecycle@example.com")
+	guest := (*invitation.Guests)[0]
+
+	created := c.createRSVP(t, &models.RSVP{
+		InvitationID: invitation.ID,
+		RSVPGuests:   []models.RSVPGuest{{GuestID: guest.ID, EventID: event.ID, Attending: true, FoodChoice: "Fish"}},
+	})
+	if len(created.RSVPGuests) != 1 || created.RSVPGuests[0].ID == 0 || created.RSVPGuests[0].GuestID != guest.ID || created.Late {
+		t.Fatalf("Expected an rsvp on time with one response from guest %d, got %+v", guest.ID, created)
+	}
+	rsvpGuestID := created.RSVPGuests[0].ID
+
+	update := func(version int64) (interface{}, error) {
+		return Run(c.org, c.store.Transactor, func(tx Tx) (interface{}, error) {
+			return c.store.RSVPs.UpdateRSVP(tx, &models.RSVP{
+				ID:         created.ID,
+				Version:    version,
+				RSVPGuests: []models.RSVPGuest{{ID: rsvpGuestID, Attending: false}},
+			}, true)
+		})
+	}
+	result, err := update(created.Version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	updated := result.(*models.RSVP)
+	if updated.Version != created.Version+1 {
+		t.Errorf("Expected updating version %d to make version %d, got %d", created.Version, created.Version+1, updated.Version)
+	}
+	if _, err := update(created.Version); utils.StatusCode(err, 0) != http.StatusPreconditionFailed {
+		t.Errorf("Updating a stale version got %v, expected a %d", err, http.StatusPreconditionFailed)
+	}
+	_, err = Run(c.org, c.store.Transactor, func(tx Tx) (interface{}, error) {
+		return c.store.RSVPGuests.UpdateRSVPGuest(tx, &models.RSVPGuest{ID: rsvpGuestID, Attending: true, FoodChoice: "Steak"})
+	})
+	if utils.StatusCode(err, 0) != http.StatusBadRequest {
+		t.Errorf("Choosing food the event doesn't serve got %v, expected a %d", err, http.StatusBadRequest)
+	}
+	stored := c.getRSVP(t, created.ID)
+	if stored == nil || len(stored.RSVPGuests) != 1 || stored.RSVPGuests[0].Attending || stored.RSVPGuests[0].FoodChoice != "" {
+		t.Fatalf("Expected the response to be declined with no food choice, got %+v", stored)
+	}
+
+	_, err = Run(c.org, c.store.Transactor, func(tx Tx) (interface{}, error) {
+		return c.store.RSVPs.DeleteRSVP(tx, created.ID, created.Version)
+	})
+	if utils.StatusCode(err, 0) != http.StatusPreconditionFailed {
+		t.Errorf("Deleting a stale version got %v, expected a %d", err, http.StatusPreconditionFailed)
+	}
+	mustRun(t, c.org, c.store, func(tx Tx) (interface{}, error) {
+		return c.store.RSVPs.DeleteRSVP(tx, created.ID, updated.Version)
+	})
+	if trashed := c.getRSVP(t, created.ID); trashed != nil {
+		t.Errorf("Got an rsvp in the trash: %+v", trashed)
+	}
+	if _, err := update(AnyVersion); utils.StatusCode(err, 0) != http.StatusNotFound {
+		t.Errorf("Updating an rsvp in the trash got %v, expected a %d", err, http.StatusNotFound)
+	}
+
+	restored := mustRun(t, c.org, c.store, func(tx Tx) (interface{}, error) {
+		return c.store.RSVPs.RestoreRSVP(tx, created.ID)
+	}).(*models.RSVP)
+	if restored == nil || restored.Version <= updated.Version || len(restored.RSVPGuests) != 1 || restored.RSVPGuests[0].ID != rsvpGuestID {
+		t.Errorf("Expected the rsvp back at a new version with its response, got %+v", restored)
+	}
+	again := mustRun(t, c.org, c.store, func(tx Tx) (interface{}, error) {
+		return c.store.RSVPs.RestoreRSVP(tx, created.ID)
+	})
+	if !isNil(again) {
+		t.Errorf("Restoring an rsvp that isn't in the trash got %+v", again)
+	}
+}
+
+func (c *contract) rsvpDeadlines(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+	closed := c.createEventWith(t, &models.Event{Name: "Closed", RSVPDeadline: &past})
+	lenient := c.createEventWith(t, &models.Event{Name: "Lenient", RSVPDeadline: &past, AllowLateRSVPs: true})
+
+	invitation := c.createInvitation(t, c.org, closed.ID, "closed@example.com")
+	guest := (*invitation.Guests)[0]
+	rsvp := &models.RSVP{
+		InvitationID: invitation.ID,
+		RSVPGuests:   []models.RSVPGuest{{GuestID: guest.ID, EventID: closed.ID, Attending: true}},
+	}
+	_, err := Run(c.org, c.store.Transactor, func(tx Tx) (interface{}, error) {
+		return c.store.RSVPs.CreateRSVP(tx, rsvp, true)
+	})
+	if utils.StatusCode(err, 0) != http.StatusForbidden {
+		t.Errorf("Responding after the deadline got %v, expected a %d", err, http.StatusForbidden)
+	}
+	if existing := c.getRSVPByInvitation(t, invitation.ID); existing != nil {
+		t.Errorf("A response refused for its deadline was kept: %+v", existing)
+	}
+
+	late := mustRun(t, c.org, c.store, func(tx Tx) (interface{}, error) {
+		return c.store.RSVPs.CreateRSVP(tx, rsvp, false)
+	}).(*models.RSVP)
+	if !late.Late {
+		t.Error("A response after the deadline wasn't marked late")
+	}
+	_, err = Run(c.org, c.store.Transactor, func(tx Tx) (interface{}, error) {
+		return c.store.RSVPs.UpdateRSVP(tx, &models.RSVP{
+			ID:         late.ID,
+			RSVPGuests: []models.RSVPGuest{{ID: late.RSVPGuests[0].ID, Attending: false}},
+		}, true)
+	})
+	if utils.StatusCode(err, 0) != http.StatusForbidden {
+		t.Errorf("Changing a response after the deadline got %v, expected a %d", err, http.StatusForbidden)
+	}
+
+	lenientInvitation := c.createInvitation(t, c.org, lenient.ID, "lenient@example.com")
+	allowed := c.createRSVP(t, &models.RSVP{InvitationID: lenientInvitation.ID})
+	if !allowed.Late {
+		t.Error("A late response to an event that allows them wasn't marked late")
+	}
+}
+
+func (c *contract) rsvpGuestsStayOnTheirRSVP(t *testing.T) {
+	event := c.createEvent(t, c.org, "Claimed")
+	first := c.createInvitation(t, c.org, event.ID, "claimed-first@example.com")
+	second := c.createInvitation(t, c.org, event.ID, "claimed-second@example.com")
+	firstGuest, secondGuest := (*first.Guests)[0], (*second.Guests)[0]
+	firstRSVP := c.createRSVP(t, &models.RSVP{
+		InvitationID: first.ID,
+		RSVPGuests:   []models.RSVPGuest{{GuestID: firstGuest.ID, EventID: event.ID, Attending: true}},
+	})
+	secondRSVP := c.createRSVP(t, &models.RSVP{InvitationID: second.ID})
+
+	refused := map[string]func(tx Tx) (interface{}, error){
+		"Updating another rsvp's response": func(tx Tx) (interface{}, error) {
+			return c.store.RSVPs.UpdateRSVP(tx, &models.RSVP{
+				ID:         secondRSVP.ID,
+				RSVPGuests: []models.RSVPGuest{{ID: firstRSVP.RSVPGuests[0].ID, Attending: false}},
+			}, false)
+		},
+		"Responding for another invitation's guest": func(tx Tx) (interface{}, error) {
+			return c.store.RSVPGuests.CreateRSVPGuest(tx, secondRSVP.ID, &models.RSVPGuest{GuestID: firstGuest.ID, EventID: event.ID})
+		},
+		"Responding twice for a guest": func(tx Tx) (interface{}, error) {
+			return c.store.RSVPs.UpdateRSVP(tx, &models.RSVP{
+				ID:         firstRSVP.ID,
+				RSVPGuests: []models.RSVPGuest{{GuestID: firstGuest.ID, EventID: event.ID}},
+			}, false)
+		},
+	}
+	for name, write := range refused {
+		if _, err := Run(c.org, c.store.Transactor, write); utils.StatusCode(err, 0) != http.StatusBadRequest {
+			t.Errorf("%s got %v, expected a %d", name, err, http.StatusBadRequest)
+		}
+	}
+
+	if stored := c.getRSVP(t, firstRSVP.ID); len(stored.RSVPGuests) != 1 || !stored.RSVPGuests[0].Attending {
+		t.Errorf("Expected the first rsvp to keep its one attending response, got %+v", stored.RSVPGuests)
+	}
+	if stored := c.getRSVP(t, secondRSVP.ID); len(stored.RSVPGuests) != 0 {
+		t.Errorf("Expected the second rsvp to have no responses, got %+v", stored.RSVPGuests)
+	}
+	c.createRSVPGuest(t, secondRSVP.ID, &models.RSVPGuest{GuestID: secondGuest.ID, EventID: event.ID, Attending: true})
+}
+
+func (c *contract) plusOneLimits(t *testing.T) {
+	event := c.createEvent(t, c.org, "Plus Ones")
+	plusOne := func(name string) models.RSVPGuest {
+		return models.RSVPGuest{IsPlusOne: true, EventID: event.ID, Attending: true, Guest: &models.Guest{FirstName: name, LastName: "Plus"}}
+	}
+
+	invitation := contractInvitation(event.ID, "plus-one@example.com")
+	invitation.PlusOnes = 1
+	invitation = mustRun(t, c.org, c.store, func(tx Tx) (interface{}, error) {
+		return c.store.Invitations.CreateInvitation(tx, invitation)
+	}).(*models.Invitation)
+	rsvp := c.createRSVP(t, &models.RSVP{
+		InvitationID: invitation.ID,
+		RSVPGuests:   []models.RSVPGuest{{GuestID: (*invitation.Guests)[0].ID, EventID: event.ID, Attending: true}, plusOne("First")},
+	})
+	if guest := rsvp.RSVPGuests[1].Guest; guest == nil || !guest.PlusOne || guest.InvitationID != invitation.ID {
+		t.Errorf("Expected a plus one on invitation %d, got %+v", invitation.ID, guest)
+	}
+
+	_, err := Run(c.org, c.store.Transactor, func(tx Tx) (interface{}, error) {
+		return c.store.RSVPs.UpdateRSVP(tx, &models.RSVP{ID: rsvp.ID, RSVPGuests: []models.RSVPGuest{plusOne("Second")}}, false)
+	})
+	if utils.StatusCode(err, 0) != http.StatusBadRequest {
+		t.Errorf("Bringing more plus ones than the invitation allows got %v, expected a %d", err, http.StatusBadRequest)
+	}
+	_, err = Run(c.org, c.store.Transactor, func(tx Tx) (interface{}, error) {
+		second := plusOne("Second")
+		return c.store.RSVPGuests.CreateRSVPGuest(tx, rsvp.ID, &second)
+	})
+	if utils.StatusCode(err, 0) != http.StatusBadRequest {
+		t.Errorf("Adding a response for a plus one past the allowance got %v, expected a %d", err, http.StatusBadRequest)
+	}
+	if stored := c.getRSVP(t, rsvp.ID); len(stored.RSVPGuests) != 2 {
+		t.Errorf("Expected the rsvp to keep its guest and one plus one, got %+v", stored.RSVPGuests)
+	}
+
+	none := c.createInvitation(t, c.org, event.ID, "no-plus-one@example.com")
+	_, err = Run(c.org, c.store.Transactor, func(tx Tx) (interface{}, error) {
+		return c.store.RSVPs.CreateRSVP(tx, &models.RSVP{InvitationID: none.ID, RSVPGuests: []models.RSVPGuest{plusOne("Uninvited")}}, false)
+	})
+	if utils.StatusCode(err, 0) != http.StatusBadRequest {
+		t.Errorf("Bringing a plus one on an invitation without any got %v, expected a %d", err, http.StatusBadRequest)
+	}
+	if existing := c.getRSVPByInvitation(t, none.ID); existing != nil {
+		t.Errorf("A response refused for its plus one was kept: %+v", existing)
+	}
+}
+
+func (c *contract) invitationTrashTakesItsRSVP(t *testing.T) {
+	event := c.createEvent(t, c.org, "Cascade")
+	invitation := c.createInvitation(t, c.org, event.ID, "cascade@example.com")
+	trashedAlone := c.createRSVP(t, &models.RSVP{InvitationID: invitation.ID})
+	mustRun(t, c.org, c.store, func(tx Tx) (interface{}, error) {
+		return c.store.RSVPs.DeleteRSVP(tx, trashedAlone.ID, AnyVersion)
+	})
+	rsvp := c.createRSVP(t, &models.RSVP{
+		InvitationID: invitation.ID,
+		RSVPGuests:   []models.RSVPGuest{{GuestID: (*invitation.Guests)[0].ID, EventID: event.ID, Attending: true}},
+	})
+
+	mustRun(t, c.org, c.store, func(tx Tx) (interface{}, error) {
+		return c.store.Invitations.DeleteInvitation(tx, invitation.ID, AnyVersion)
+	})
+	if trashed := c.getRSVP(t, rsvp.ID); trashed != nil {
+		t.Errorf("The rsvp of an invitation in the trash is still there: %+v", trashed)
+	}
+	_, err := Run(c.org, c.store.Transactor, func(tx Tx) (interface{}, error) {
+		return c.store.RSVPs.RestoreRSVP(tx, rsvp.ID)
+	})
+	if utils.StatusCode(err, 0) != http.StatusBadRequest {
+		t.Errorf("Restoring an rsvp whose invitation is in the trash got %v, expected a %d", err, http.StatusBadRequest)
+	}
+	_, err = Run(c.org, c.store.Transactor, func(tx Tx) (interface{}, error) {
+		return c.store.RSVPs.CreateRSVP(tx, &models.RSVP{InvitationID: invitation.ID}, false)
+	})
+	if utils.StatusCode(err, 0) != http.StatusBadRequest {
+		t.Errorf("Responding to an invitation in the trash got %v, expected a %d", err, http.StatusBadRequest)
+	}
+
+	mustRun(t, c.org, c.store, func(tx Tx) (interface{}, error) {
+		return c.store.Invitations.RestoreInvitation(tx, invitation.ID)
+	})
+	restored := c.getRSVPByInvitation(t, invitation.ID)
+	if restored == nil || restored.ID != rsvp.ID || len(restored.RSVPGuests) != 1 {
+		t.Errorf("Expected rsvp %d back with its invitation and response, got %+v", rsvp.ID, restored)
+	}
+	if alone := c.getRSVP(t, trashedAlone.ID); alone != nil {
+		t.Errorf("An rsvp trashed before its invitation came back with it: %+v", alone)
+	}
+}
+
+func (c *contract) createRSVP(t *testing.T, rsvp *models.RSVP) *models.RSVP {
+	return mustRun(t, c.org, c.store, func(tx Tx) (interface{}, error) {
+		return c.store.RSVPs.CreateRSVP(tx, rsvp, true)
+	}).(*models.RSVP)
+}
+
+func (c *contract) createRSVPGuest(t *testing.T, rsvpID int64, rsvpGuest *models.RSVPGuest) *models.RSVPGuest {
+	return mustRun(t, c.org, c.store, func(tx Tx) (interface{}, error) {
+		return c.store.RSVPGuests.CreateRSVPGuest(tx, rsvpID, rsvpGuest)
+	}).(*models.RSVPGuest)
+}
+
+func (c *contract) getRSVP(t *testing.T, id int64) *models.RSVP {
+	return mustRun(t, c.org, c.store, func(tx Tx) (interface{}, error) {
+		return c.store.RSVPs.GetRSVP(tx, id)
+	}).(*models.RSVP)
+}
+
+func (c *contract) getRSVPByInvitation(t *testing.T, invitationID int64) *models.RSVP {
+	return mustRun(t, c.org, c.store, func(tx Tx) (interface{}, error) {
+		return c.store.RSVPs.GetRSVPByInvitation(tx, invitationID)
+	}).(*models.RSVP)
+}
+
+func (c *contract) createEvent(t *testing.T, ctx context.Context, name string) *models.Event {
+	return mustRun(t, ctx, c.store, func(tx Tx) (interface{}, error) {
+		return c.store.Events.CreateEvent(tx, &models.Event{
+			Name:        name,
+			Date:        "2030-01-01",
+			Address:     contractAddress("1 Event St"),
+			FoodOptions: []string{"Fish", "Pasta"},
+		})
+	}).(*models.Event)
+}
+
+// createEventWith creates an event in the contract's organization, filling in its date and address
+func (c *contract) createEventWith(t *testing.T, event *models.Event) *models.Event {
+	event.Date = "2030-01-01"
+	event.Address = contractAddress("1 Event St")
+	return mustRun(t, c.org, c.store, func(tx Tx) (interface{}, error) {
+		return c.store.Events.CreateEvent(tx, event)
+	}).(*models.Event)
+}
+
+func (c *contract) createInvitation(t *testing.T, ctx context.Context, eventID int64, email string) *models.Invitation {
+	return mustRun(t, ctx, c.store, func(tx Tx) (interface{}, error) {
+		return c.store.Invitations.CreateInvitation(tx, contractInvitation(eventID, email))
+	}).(*models.Invitation)
+}
+
+func contractInvitation(eventID int64, email string) *models.Invitation {
+	return &models.Invitation{
+		Name:    "Contract Household",
+		Email:   email,
+		Address: contractAddress("1 Invitation St"),
+		Events:  []models.InvitationEvent{{EventID: eventID}},
+		Guests:  &[]models.Guest{{FirstName: "Contract", LastName: "Guest"}},
+	}
+}
+
+func contractAddress(line1 string) *models.Address {
+	return &models.Address{Line1: line1, City: "Portland", State: "OR", Zip: "97201"}
+}
+
+// mustRun runs a unit of work, failing the test if it fails
+func mustRun(t *testing.T, ctx context.Context, store *Store, call func(tx Tx) (interface{}, error)) interface{} {
+	t.Helper()
+	result, err := Run(ctx, store.Transactor, call)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+// isNil reports whether a DAO's result is nil, which it returns as a typed nil pointer
+func isNil(result interface{}) bool {
+	switch result := result.(type) {
+	case *models.Event:
+		return result == nil
+	case *models.Guest:
+		return result == nil
+	case *models.Invitation:
+		return result == nil
+	case *models.RSVP:
+		return result == nil
+	}
+	return result == nil
+}
@@ -4,7 +4,6 @@ import (
 	"github.com/go-pg/pg/v9"
 	"github.com/kyrstenkelly/rsvp-api/db/models"
 	log "github.com/sirupsen/logrus"
-	"strings"
 )
 
 // EventsPostgresAccess postgres implementation of a CohortsDAO
@@ -14,11 +13,12 @@ type EventsPostgresAccess struct {
 
 // EventsAccess interface for a Cohorts data access object
 type EventsAccess interface {
-	GetEvents(tx *pg.Tx) ([]models.Event, error)
-	GetEvent(tx *pg.Tx, id int64) (*models.Event, error)
-	CreateEvent(tx *pg.Tx, event *models.Event) (*models.Event, error)
-	UpdateEvent(tx *pg.Tx, event *models.Event) (*models.Event, error)
-	DeleteEvent(tx *pg.Tx, id int64) (*models.Event, error)
+	GetEvents(tx Tx, list ListQuery) ([]models.Event, int, error)
+	GetEvent(tx Tx, id int64) (*models.Event, error)
+	CreateEvent(tx Tx, event *models.Event) (*models.Event, error)
+	UpdateEvent(tx Tx, event *models.Event) (*models.Event, error)
+	DeleteEvent(tx Tx, id int64, version int64) (*models.Event, error)
+	RestoreEvent(tx Tx, id int64) (*models.Event, error)
 }
 
 // NewEventsDAO Create a new events dao
@@ -29,25 +29,42 @@ func NewEventsDAO() EventsAccess {
 	}
 }
 
-// GetEvents gets all events
-func (a *EventsPostgresAccess) GetEvents(tx *pg.Tx) ([]models.Event, error) {
-	var events []models.Event
-	err := tx.Model(&events).
+// EventsList is how lists of events can be searched and sorted
+var EventsList = ListSpec{
+	Fields: map[string]ListField{
+		"id":       {Column: "event.id", Kind: ListInt, Sort: true},
+		"name":     {Column: "event.name", Kind: ListString, Sort: true},
+		"date":     {Column: "event.date", Kind: ListString, Sort: true},
+		"location": {Column: "event.location", Kind: ListString, Sort: true},
+	},
+	Search: []string{"name", "location"},
+}
+
+// GetEvents gets a page of events, along with how many events match the list's filters
+func (a *EventsPostgresAccess) GetEvents(tx Tx, list ListQuery) ([]models.Event, int, error) {
+	ptx := pgTx(tx)
+	events := []models.Event{}
+	query := ptx.Model(&events).
 		Column("event.*", "Address").
-		Select()
+		Where("event.organization_id = ?", OrganizationID(tx)).
+		Where("event.deleted_at IS NULL")
+	total, err := applyListQuery(query, EventsList, list).SelectAndCount()
 	if err != nil {
 		log.Error(err)
-		return nil, err
+		return nil, 0, err
 	}
-	return events, nil
+	return events, total, nil
 }
 
 // GetEvent gets an event by id
-func (a *EventsPostgresAccess) GetEvent(tx *pg.Tx, id int64) (*models.Event, error) {
+func (a *EventsPostgresAccess) GetEvent(tx Tx, id int64) (*models.Event, error) {
+	ptx := pgTx(tx)
 	event := new(models.Event)
-	err := tx.Model(event).
+	err := ptx.Model(event).
 		Column("event.*", "Address").
 		Where("event.id = ?", id).
+		Where("event.organization_id = ?", OrganizationID(tx)).
+		Where("event.deleted_at IS NULL").
 		Select()
 
 	if err == pg.ErrNoRows {
@@ -60,7 +77,8 @@ func (a *EventsPostgresAccess) GetEvent(tx *pg.Tx, id int64) (*models.Event, err
 }
 
 // CreateEvent creates an event
-func (a *EventsPostgresAccess) CreateEvent(tx *pg.Tx, event *models.Event) (*models.Event, error) {
+func (a *EventsPostgresAccess) CreateEvent(tx Tx, event *models.Event) (*models.Event, error) {
+	ptx := pgTx(tx)
 	address, err := a.addressAccess.FindOrCreateAddress(tx, event.Address)
 	if err != nil {
 		log.Error(err)
@@ -70,68 +88,109 @@ func (a *EventsPostgresAccess) CreateEvent(tx *pg.Tx, event *models.Event) (*mod
 
 	query :=
 		`INSERT INTO
-			events ("name", "location", "address_id", "food_options", "date")
+			events ("name", "location", "address_id", "food_options", "date", "rsvp_deadline", "allow_late_rsvps", "organization_id")
 		VALUES
-			($1, $2, $3, $4, $5)
+			($1, $2, $3, $4, $5, $6, $7, $8)
 		RETURNING id`
-	stmt, err := tx.Prepare(query)
+	stmt, err := ptx.Prepare(query)
 	if err != nil {
 		log.Error(err)
 		return nil, err
 	}
 
 	var eventID int64
-	_, err = stmt.Query(pg.Scan(&eventID), &event.Name, &event.Location, &event.AddressID, &event.FoodOptions, &event.Date)
+	event.OrganizationID = OrganizationID(tx)
+	_, err = stmt.Query(pg.Scan(&eventID), &event.Name, &event.Location, &event.AddressID, &event.FoodOptions, &event.Date, event.RSVPDeadline,
+		&event.AllowLateRSVPs, &event.OrganizationID)
 	if err != nil {
 		log.Error(err)
 		return nil, err
 	}
 	event.ID = eventID
+	event.Version = 1
 
 	return event, nil
 }
 
-// UpdateEvent updates an event
-func (a *EventsPostgresAccess) UpdateEvent(tx *pg.Tx, event *models.Event) (*models.Event, error) {
-	var q []string
-	if event.Name != "" {
-		q = append(q, "name = ?name")
-	}
-	if event.Location != "" {
-		q = append(q, "location = ?location")
-	}
-	if event.Address != nil {
-		_, err := a.addressAccess.FindOrCreateAddress(tx, event.Address)
-		if err != nil {
-			log.Error(err)
-			return nil, err
-		}
-		q = append(q, "address = ?address")
-	}
-	if event.FoodOptions != nil {
-		q = append(q, "food_options = ?food_options")
+// UpdateEvent replaces an event's fields with the given event's, if its version is the given event's Version
+func (a *EventsPostgresAccess) UpdateEvent(tx Tx, event *models.Event) (*models.Event, error) {
+	ptx := pgTx(tx)
+	address, err := a.addressAccess.FindOrCreateAddress(tx, event.Address)
+	if err != nil {
+		log.Error(err)
+		return nil, err
 	}
+	event.AddressID = address.ID
 
-	qString := strings.Join(q, ", ")
-	_, updateErr := tx.Model(event).Set(qString).Where("id = ?id").Update()
+	result, updateErr := ptx.Model(event).
+		Set("name = ?name, location = ?location, date = ?date, address_id = ?address_id").
+		Set("food_options = ?food_options, rsvp_deadline = ?rsvp_deadline, allow_late_rsvps = ?allow_late_rsvps").
+		Set("version = version + 1").
+		Where("id = ?id").
+		Where("organization_id = ?", OrganizationID(tx)).
+		Where("deleted_at IS NULL").
+		Apply(whereVersion(event.Version)).
+		Update()
 	if updateErr != nil {
 		log.Error(updateErr)
 		return nil, updateErr
 	}
+	if err := checkVersion(result, event.Version); err != nil {
+		return nil, err
+	}
 
 	updatedEvent, _ := a.GetEvent(tx, event.ID)
 	return updatedEvent, nil
 }
 
-// DeleteEvent deletes an event
-func (a *EventsPostgresAccess) DeleteEvent(tx *pg.Tx, id int64) (*models.Event, error) {
-	event := &models.Event{
-		ID: id,
+// DeleteEvent moves an event to the trash, if it is at the given version and all of the invitations
+// covering it are in the trash already. It fails with a HTTPNotFoundError if there's no such event.
+func (a *EventsPostgresAccess) DeleteEvent(tx Tx, id int64, version int64) (*models.Event, error) {
+	ptx := pgTx(tx)
+	var invited bool
+	_, err := ptx.QueryOne(pg.Scan(&invited),
+		`SELECT EXISTS (
+			SELECT 1 FROM invitation_events ie
+			JOIN invitations i ON i.id = ie.invitation_id
+			WHERE ie.event_id = ? AND i.organization_id = ? AND i.deleted_at IS NULL
+		)`, id, OrganizationID(tx))
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	if invited {
+		return nil, foreignKeyError("events", id, "invitations")
 	}
-	err := tx.Delete(event)
+
+	result, err := ptx.Model((*models.Event)(nil)).
+		Set("deleted_at = now(), version = version + 1").
+		Where("id = ?", id).
+		Where("organization_id = ?", OrganizationID(tx)).
+		Where("deleted_at IS NULL").
+		Apply(whereVersion(version)).
+		Update()
 	if err != nil {
 		log.Error(err)
 		return nil, err
 	}
-	return nil, nil
+	return nil, checkTrashed(tx, (*models.Event)(nil), id, result)
+}
+
+// RestoreEvent takes an event out of the trash, returning nil if it isn't in the trash
+func (a *EventsPostgresAccess) RestoreEvent(tx Tx, id int64) (*models.Event, error) {
+	ptx := pgTx(tx)
+	result, err := ptx.Model((*models.Event)(nil)).
+		Set("deleted_at = NULL, version = version + 1").
+		Where("id = ?", id).
+		Where("organization_id = ?", OrganizationID(tx)).
+		Where("deleted_at IS NOT NULL").
+		Update()
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	if result.RowsAffected() == 0 {
+		return nil, nil
+	}
+	return a.GetEvent(tx, id)
 }
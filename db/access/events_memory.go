@@ -0,0 +1,161 @@
+package access
+
+import (
+	"github.com/kyrstenkelly/rsvp-api/db/models"
+	"github.com/kyrstenkelly/rsvp-api/utils"
+)
+
+// EventsMemoryAccess in-memory implementation of an EventsDAO
+type EventsMemoryAccess struct {
+	addressAccess AddressesAccess
+}
+
+// NewEventsMemoryDAO Create a new in-memory events dao
+func NewEventsMemoryDAO() EventsAccess {
+	return &EventsMemoryAccess{
+		addressAccess: NewAddressesMemoryDAO(),
+	}
+}
+
+// storedEvent copies an event for the tables, leaving out its address
+func storedEvent(event models.Event) models.Event {
+	event.Address = nil
+	event.FoodOptions = copyStrings(event.FoodOptions)
+	if event.RSVPDeadline != nil {
+		deadline := *event.RSVPDeadline
+		event.RSVPDeadline = &deadline
+	}
+	return event
+}
+
+// loadEvent copies an event out of the tables along with its address
+func (a *EventsMemoryAccess) loadEvent(tx Tx, event models.Event) (*models.Event, error) {
+	event = storedEvent(event)
+	if event.AddressID > 0 {
+		address, err := a.addressAccess.GetAddress(tx, event.AddressID)
+		if err != nil {
+			return nil, err
+		}
+		event.Address = address
+	}
+	return &event, nil
+}
+
+// GetEvents gets a page of events, along with how many events match the list's filters
+func (a *EventsMemoryAccess) GetEvents(tx Tx, list ListQuery) ([]models.Event, int, error) {
+	tables := memTx(tx).tables
+	var rows []models.Event
+	for _, event := range tables.events {
+		if event.OrganizationID == OrganizationID(tx) && event.DeletedAt == nil {
+			rows = append(rows, event)
+		}
+	}
+	page, total := listMemoryRows(len(rows), func(i int) map[string]interface{} {
+		return map[string]interface{}{
+			"id":       rows[i].ID,
+			"name":     rows[i].Name,
+			"date":     rows[i].Date,
+			"location": rows[i].Location,
+		}
+	}, EventsList, list)
+
+	events := []models.Event{}
+	for _, i := range page {
+		event, err := a.loadEvent(tx, rows[i])
+		if err != nil {
+			return nil, 0, err
+		}
+		events = append(events, *event)
+	}
+	return events, total, nil
+}
+
+// GetEvent gets an event by id
+func (a *EventsMemoryAccess) GetEvent(tx Tx, id int64) (*models.Event, error) {
+	event, ok := memTx(tx).tables.events[id]
+	if !ok || event.OrganizationID != OrganizationID(tx) || event.DeletedAt != nil {
+		return nil, nil
+	}
+	return a.loadEvent(tx, event)
+}
+
+// CreateEvent creates an event
+func (a *EventsMemoryAccess) CreateEvent(tx Tx, event *models.Event) (*models.Event, error) {
+	address, err := a.addressAccess.FindOrCreateAddress(tx, event.Address)
+	if err != nil {
+		return nil, err
+	}
+	event.AddressID = address.ID
+
+	tables := memTx(tx).tables
+	event.ID = tables.nextID("events")
+	event.OrganizationID = OrganizationID(tx)
+	event.Version = 1
+	tables.events[event.ID] = storedEvent(*event)
+	return event, nil
+}
+
+// UpdateEvent replaces an event's fields with the given event's, if its version is the given event's Version
+func (a *EventsMemoryAccess) UpdateEvent(tx Tx, event *models.Event) (*models.Event, error) {
+	existing, ok := memTx(tx).tables.events[event.ID]
+	if !ok || existing.OrganizationID != OrganizationID(tx) || existing.DeletedAt != nil {
+		return nil, nil
+	}
+	if err := matchVersion(existing.Version, event.Version); err != nil {
+		return nil, err
+	}
+
+	address, err := a.addressAccess.FindOrCreateAddress(tx, event.Address)
+	if err != nil {
+		return nil, err
+	}
+	existing.Name = event.Name
+	existing.Location = event.Location
+	existing.Date = event.Date
+	existing.AddressID = address.ID
+	existing.FoodOptions = event.FoodOptions
+	existing.RSVPDeadline = event.RSVPDeadline
+	existing.AllowLateRSVPs = event.AllowLateRSVPs
+	existing.Version++
+	memTx(tx).tables.events[existing.ID] = storedEvent(existing)
+
+	return a.GetEvent(tx, event.ID)
+}
+
+// DeleteEvent moves an event to the trash, if it is at the given version and all of the invitations
+// covering it are in the trash already. It fails with a HTTPNotFoundError if there's no such event.
+func (a *EventsMemoryAccess) DeleteEvent(tx Tx, id int64, version int64) (*models.Event, error) {
+	tables := memTx(tx).tables
+	event, ok := tables.events[id]
+	if !ok || event.OrganizationID != OrganizationID(tx) || event.DeletedAt != nil {
+		return nil, utils.HTTPNotFoundError.Here()
+	}
+	if err := matchVersion(event.Version, version); err != nil {
+		return nil, err
+	}
+	for invitationID, invitationEvents := range tables.invitationEvents {
+		for _, invitationEvent := range invitationEvents {
+			if invitationEvent.EventID == id && tables.invitations[invitationID].DeletedAt == nil {
+				return nil, foreignKeyError("events", id, "invitations")
+			}
+		}
+	}
+	deletedAt := memTx(tx).now
+	event.DeletedAt = &deletedAt
+	event.Version++
+	tables.events[id] = event
+	return nil, nil
+}
+
+// RestoreEvent takes an event out of the trash, returning nil if it isn't in the trash
+func (a *EventsMemoryAccess) RestoreEvent(tx Tx, id int64) (*models.Event, error) {
+	tables := memTx(tx).tables
+	event, ok := tables.events[id]
+	if !ok || event.OrganizationID != OrganizationID(tx) || event.DeletedAt == nil {
+		return nil, nil
+	}
+	event.DeletedAt = nil
+	event.Version++
+	tables.events[id] = event
+	return a.GetEvent(tx, id)
+}
@@ -0,0 +1,98 @@
+package access
+
+import (
+	"github.com/kyrstenkelly/rsvp-api/db/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// guestExportQuery flattens every guest on an invitation, plus the plus ones added through
+// their RSVP, into one row per event they are invited to with their response from the
+// invitation's latest RSVP
+const guestExportQuery = `
+	SELECT
+		g.id AS guest_id,
+		g.name AS guest_name,
+		i.id AS invitation_id,
+		i.name AS invitation_name,
+		i.email,
+		coalesce(a.line1, '') AS line1,
+		coalesce(a.line2, '') AS line2,
+		coalesce(a.city, '') AS city,
+		coalesce(a.state, '') AS state,
+		coalesce(a.zip, '') AS zip,
+		i.organization_id,
+		e.id AS event_id,
+		e.name AS event_name,
+		CASE
+			WHEN rg.id IS NULL THEN 'no_response'
+			WHEN rg.attending THEN 'attending'
+			ELSE 'declined'
+		END AS status,
+		coalesce(rg.food_choice, '') AS food_choice,
+		people.is_plus_one,
+		people.position
+	FROM (
+		SELECT ig.invitation_id, ig.guest_id, ie.event_id, ig.position, false AS is_plus_one
+		FROM invitation_guests ig
+		JOIN invitation_events ie ON ie.invitation_id = ig.invitation_id
+			AND (cardinality(ie.guest_ids) = 0 OR ig.guest_id = ANY (ie.guest_ids))
+		UNION ALL
+		SELECT r.invitation_id, rg.guest_id, rg.event_id, 2147483647 AS position, true AS is_plus_one
+		FROM rsvp_guests rg
+		JOIN rsvps r ON r.id = rg.rsvp_id
+		WHERE rg.is_plus_one AND r.deleted_at IS NULL
+	) people
+	JOIN invitations i ON i.id = people.invitation_id
+	JOIN guests g ON g.id = people.guest_id
+	JOIN events e ON e.id = people.event_id
+	LEFT JOIN addresses a ON a.id = i.address_id
+	LEFT JOIN LATERAL (
+		SELECT id FROM rsvps WHERE invitation_id = i.id AND deleted_at IS NULL ORDER BY id DESC LIMIT 1
+	) r ON true
+	LEFT JOIN rsvp_guests rg ON rg.rsvp_id = r.id AND rg.guest_id = g.id AND rg.event_id = e.id
+	WHERE i.deleted_at IS NULL AND e.deleted_at IS NULL`
+
+// guestExportColumns are the columns of guestExportQuery that make up a models.GuestExportRow
+var guestExportColumns = []string{
+	"guest_id", "guest_name", "invitation_id", "invitation_name", "email",
+	"line1", "line2", "city", "state", "zip",
+	"event_id", "event_name", "status", "food_choice", "is_plus_one",
+}
+
+// ExportsPostgresAccess postgres implementation of an ExportsDAO
+type ExportsPostgresAccess struct {
+}
+
+// ExportsAccess interface for an exports data access object
+type ExportsAccess interface {
+	ForEachGuestRow(tx Tx, filter models.GuestExportFilter, fn func(*models.GuestExportRow) error) error
+}
+
+// NewExportsDAO Create a new exports dao
+func NewExportsDAO() ExportsAccess {
+	return &ExportsPostgresAccess{}
+}
+
+// ForEachGuestRow streams the flattened guest list to fn one row at a time, ordered by invitation
+func (a *ExportsPostgresAccess) ForEachGuestRow(tx Tx, filter models.GuestExportFilter, fn func(*models.GuestExportRow) error) error {
+	ptx := pgTx(tx)
+	query := ptx.Model().
+		TableExpr("("+guestExportQuery+") AS export").
+		Column(guestExportColumns...).
+		Where("export.organization_id = ?", OrganizationID(tx))
+	if filter.EventID > 0 {
+		query = query.Where("export.event_id = ?", filter.EventID)
+	}
+	if filter.Status != "" {
+		query = query.Where("export.status = ?", filter.Status)
+	}
+
+	err := query.
+		Order("export.event_id", "export.invitation_name", "export.invitation_id", "export.position", "export.guest_name").
+		ForEach(fn)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	return nil
+}
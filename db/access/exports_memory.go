@@ -0,0 +1,150 @@
+package access
+
+import (
+	"github.com/kyrstenkelly/rsvp-api/db/models"
+	"math"
+	"sort"
+)
+
+// ExportsMemoryAccess in-memory implementation of an ExportsDAO
+type ExportsMemoryAccess struct {
+}
+
+// NewExportsMemoryDAO Create a new in-memory exports dao
+func NewExportsMemoryDAO() ExportsAccess {
+	return &ExportsMemoryAccess{}
+}
+
+// memoryExportRow is a row of guestExportQuery along with the columns it is ordered by
+type memoryExportRow struct {
+	models.GuestExportRow
+	position int
+}
+
+// ForEachGuestRow calls fn with the flattened guest list one row at a time, ordered by invitation
+func (a *ExportsMemoryAccess) ForEachGuestRow(tx Tx, filter models.GuestExportFilter, fn func(*models.GuestExportRow) error) error {
+	tables := memTx(tx).tables
+
+	type person struct {
+		invitationID int64
+		guestID      int64
+		eventID      int64
+		position     int
+		isPlusOne    bool
+	}
+	var people []person
+	for invitationID, guestIDs := range tables.invitationGuests {
+		for _, invitationEvent := range tables.invitationEvents[invitationID] {
+			for position, guestID := range guestIDs {
+				if invitationEvent.Invites(guestID) {
+					people = append(people, person{invitationID, guestID, invitationEvent.EventID, position, false})
+				}
+			}
+		}
+	}
+	for _, rsvpGuest := range tables.rsvpGuests {
+		if rsvpGuest.IsPlusOne {
+			if rsvp, ok := tables.rsvps[rsvpGuest.RsvpID]; ok && rsvp.DeletedAt == nil {
+				people = append(people, person{rsvp.InvitationID, rsvpGuest.GuestID, rsvpGuest.EventID, math.MaxInt32, true})
+			}
+		}
+	}
+
+	latestRSVPs := map[int64]int64{}
+	for id, rsvp := range tables.rsvps {
+		if rsvp.DeletedAt == nil && id > latestRSVPs[rsvp.InvitationID] {
+			latestRSVPs[rsvp.InvitationID] = id
+		}
+	}
+
+	var rows []memoryExportRow
+	for _, p := range people {
+		invitation, ok := tables.invitations[p.invitationID]
+		if !ok || invitation.OrganizationID != OrganizationID(tx) || invitation.DeletedAt != nil {
+			continue
+		}
+		guest, ok := tables.guests[p.guestID]
+		if !ok {
+			continue
+		}
+		event, ok := tables.events[p.eventID]
+		if !ok || event.DeletedAt != nil {
+			continue
+		}
+		address := tables.addresses[invitation.AddressID]
+
+		row := memoryExportRow{
+			GuestExportRow: models.GuestExportRow{
+				GuestID:        guest.ID,
+				GuestName:      guest.Name,
+				InvitationID:   invitation.ID,
+				InvitationName: invitation.Name,
+				Email:          invitation.Email,
+				Line1:          address.Line1,
+				Line2:          address.Line2,
+				City:           address.City,
+				State:          address.State,
+				Zip:            address.Zip,
+				EventID:        event.ID,
+				EventName:      event.Name,
+				Status:         models.GuestStatusNoResponse,
+				IsPlusOne:      p.isPlusOne,
+			},
+			position: p.position,
+		}
+		if rsvpGuest := latestRSVPGuest(tables, latestRSVPs[invitation.ID], guest.ID, event.ID); rsvpGuest != nil {
+			row.Status = models.GuestStatusDeclined
+			if rsvpGuest.Attending {
+				row.Status = models.GuestStatusAttending
+			}
+			row.FoodChoice = rsvpGuest.FoodChoice
+		}
+
+		if filter.EventID > 0 && row.EventID != filter.EventID {
+			continue
+		}
+		if filter.Status != "" && row.Status != filter.Status {
+			continue
+		}
+		rows = append(rows, row)
+	}
+
+	sort.Slice(rows, func(i, j int) bool {
+		x, y := rows[i], rows[j]
+		if x.EventID != y.EventID {
+			return x.EventID < y.EventID
+		}
+		if x.InvitationName != y.InvitationName {
+			return x.InvitationName < y.InvitationName
+		}
+		if x.InvitationID != y.InvitationID {
+			return x.InvitationID < y.InvitationID
+		}
+		if x.position != y.position {
+			return x.position < y.position
+		}
+		return x.GuestName < y.GuestName
+	})
+
+	for i := range rows {
+		if err := fn(&rows[i].GuestExportRow); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// latestRSVPGuest gets a guest's response to an event on an rsvp, or nil if they haven't responded to it
+func latestRSVPGuest(tables *memoryTables, rsvpID int64, guestID int64, eventID int64) *models.RSVPGuest {
+	if rsvpID == 0 {
+		return nil
+	}
+	var found *models.RSVPGuest
+	for id, rsvpGuest := range tables.rsvpGuests {
+		if rsvpGuest.RsvpID == rsvpID && rsvpGuest.GuestID == guestID && rsvpGuest.EventID == eventID && (found == nil || id < found.ID) {
+			rsvpGuest := rsvpGuest
+			found = &rsvpGuest
+		}
+	}
+	return found
+}
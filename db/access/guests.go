@@ -1,10 +1,12 @@
 package access
 
 import (
+	"strings"
+
 	"github.com/go-pg/pg/v9"
 	"github.com/kyrstenkelly/rsvp-api/db/models"
+	"github.com/kyrstenkelly/rsvp-api/utils"
 	log "github.com/sirupsen/logrus"
-	"strings"
 )
 
 // GuestsPostgresAccess postgres implementation of a CohortsDAO
@@ -13,13 +15,16 @@ type GuestsPostgresAccess struct {
 
 // GuestsAccess interface for a Cohorts data access object
 type GuestsAccess interface {
-	// TODO: Get guests by invitation ID
-	GetGuests(tx *pg.Tx, ids []int64) ([]models.Guest, error)
-	GetGuest(tx *pg.Tx, id int64) (*models.Guest, error)
-	GetGuestByName(tx *pg.Tx, name string) (*models.Guest, error)
-	FindOrCreateGuest(tx *pg.Tx, guest *models.Guest) (*models.Guest, error)
-	UpdateGuest(tx *pg.Tx, guest *models.Guest) (*models.Guest, error)
-	DeleteGuest(tx *pg.Tx, id int64) (*models.Guest, error)
+	GetGuests(tx Tx, list ListQuery) ([]models.Guest, int, error)
+	GetGuestsByInvitation(tx Tx, invitationID int64) ([]models.Guest, error)
+	GetGuestsByInvitations(tx Tx, invitationIDs []int64) (map[int64][]models.Guest, error)
+	GetGuest(tx Tx, id int64) (*models.Guest, error)
+	GetPlusOnes(tx Tx, invitationID int64) ([]models.Guest, error)
+	GetDuplicateGuests(tx Tx) ([]models.DuplicateGuests, error)
+	CreateGuest(tx Tx, guest *models.Guest) (*models.Guest, error)
+	UpdateGuest(tx Tx, guest *models.Guest) (*models.Guest, error)
+	MergeGuests(tx Tx, guestID int64, duplicateIDs []int64) (*models.Guest, error)
+	DeleteGuest(tx Tx, id int64, version int64) (*models.Guest, error)
 }
 
 // NewGuestsDAO Create a new guests dao
@@ -27,17 +32,48 @@ func NewGuestsDAO() GuestsAccess {
 	return &GuestsPostgresAccess{}
 }
 
-// GetGuests gets all guests
-func (a *GuestsPostgresAccess) GetGuests(tx *pg.Tx, ids []int64) ([]models.Guest, error) {
-	var guests []models.Guest
-	var err error
-	if ids == nil {
-		err = tx.Model(&guests).Select()
-	} else {
-		err = tx.Model(&guests).
-			Where("id in (?)", pg.In(ids)).
-			Select()
+// GuestsList is how lists of guests can be filtered, searched and sorted
+var GuestsList = ListSpec{
+	Fields: map[string]ListField{
+		"id":            {Column: "guest.id", Kind: ListInt, Sort: true},
+		"name":          {Column: "guest.name", Kind: ListString, Sort: true},
+		"last_name":     {Column: "guest.last_name", Kind: ListString, Sort: true},
+		"email":         {Column: "guest.email", Kind: ListString, Filter: true},
+		"invitation_id": {Column: "guest.invitation_id", Kind: ListInt, Filter: true, Sort: true},
+		"plus_one":      {Column: "guest.plus_one", Kind: ListBool, Filter: true},
+		"attending": {
+			Column: "EXISTS (SELECT 1 FROM rsvp_guests AS rg JOIN rsvps AS r ON r.id = rg.rsvp_id WHERE rg.guest_id = guest.id AND rg.attending AND r.deleted_at IS NULL)",
+			Kind:   ListBool,
+			Filter: true,
+		},
+	},
+	Search: []string{"name", "email"},
+}
+
+// GetGuests gets a page of guests, along with how many guests match the list's filters
+func (a *GuestsPostgresAccess) GetGuests(tx Tx, list ListQuery) ([]models.Guest, int, error) {
+	ptx := pgTx(tx)
+	guests := []models.Guest{}
+	query := ptx.Model(&guests).
+		Where("guest.organization_id = ?", OrganizationID(tx))
+	total, err := applyListQuery(query, GuestsList, list).SelectAndCount()
+	if err != nil {
+		log.Error(err)
+		return nil, 0, err
 	}
+	return guests, total, nil
+}
+
+// GetGuestsByInvitation gets the guests on an invitation, in the order they were listed
+func (a *GuestsPostgresAccess) GetGuestsByInvitation(tx Tx, invitationID int64) ([]models.Guest, error) {
+	ptx := pgTx(tx)
+	guests := []models.Guest{}
+	err := ptx.Model(&guests).
+		Join("JOIN invitation_guests AS ig ON ig.guest_id = guest.id").
+		Where("ig.invitation_id = ?", invitationID).
+		Where("guest.organization_id = ?", OrganizationID(tx)).
+		Order("ig.position").
+		Select()
 	if err != nil {
 		log.Error(err)
 		return nil, err
@@ -45,23 +81,44 @@ func (a *GuestsPostgresAccess) GetGuests(tx *pg.Tx, ids []int64) ([]models.Guest
 	return guests, nil
 }
 
-// GetGuest gets a guest by id
-func (a *GuestsPostgresAccess) GetGuest(tx *pg.Tx, id int64) (*models.Guest, error) {
-	guest := new(models.Guest)
-	err := tx.Model(guest).Where("guest.id = ?", id).Select()
-	if err == pg.ErrNoRows {
-		return nil, nil
-	} else if err != nil {
+// GetGuestsByInvitations gets the guests for several invitations in one query, keyed by invitation ID
+func (a *GuestsPostgresAccess) GetGuestsByInvitations(tx Tx, invitationIDs []int64) (map[int64][]models.Guest, error) {
+	ptx := pgTx(tx)
+	guestsByInvitation := map[int64][]models.Guest{}
+	if len(invitationIDs) == 0 {
+		return guestsByInvitation, nil
+	}
+
+	// The join's invitation_id is aliased so it doesn't collide with the guest's own
+	var rows []struct {
+		ListInvitationID int64
+		models.Guest
+	}
+	_, err := ptx.Query(&rows,
+		`SELECT ig.invitation_id AS list_invitation_id, g.*
+		FROM invitation_guests ig
+		JOIN guests g ON g.id = ig.guest_id
+		WHERE ig.invitation_id IN (?) AND g.organization_id = ?
+		ORDER BY ig.invitation_id, ig.position`, pg.In(invitationIDs), OrganizationID(tx))
+	if err != nil {
 		log.Error(err)
 		return nil, err
 	}
-	return guest, nil
+
+	for _, row := range rows {
+		guestsByInvitation[row.ListInvitationID] = append(guestsByInvitation[row.ListInvitationID], row.Guest)
+	}
+	return guestsByInvitation, nil
 }
 
-// GetGuestByName gets a guest by name
-func (a *GuestsPostgresAccess) GetGuestByName(tx *pg.Tx, name string) (*models.Guest, error) {
+// GetGuest gets a guest by id
+func (a *GuestsPostgresAccess) GetGuest(tx Tx, id int64) (*models.Guest, error) {
+	ptx := pgTx(tx)
 	guest := new(models.Guest)
-	err := tx.Model(guest).Where("guest.name = ?", name).First()
+	err := ptx.Model(guest).
+		Where("guest.id = ?", id).
+		Where("guest.organization_id = ?", OrganizationID(tx)).
+		Select()
 	if err == pg.ErrNoRows {
 		return nil, nil
 	} else if err != nil {
@@ -71,65 +128,276 @@ func (a *GuestsPostgresAccess) GetGuestByName(tx *pg.Tx, name string) (*models.G
 	return guest, nil
 }
 
-// FindOrCreateGuest finds or creates an guest
-func (a *GuestsPostgresAccess) FindOrCreateGuest(tx *pg.Tx, guest *models.Guest) (*models.Guest, error) {
-	existingGuest, err := a.GetGuestByName(tx, guest.Name)
+// GetPlusOnes gets the plus ones an invitation has brought, in the order they first responded
+func (a *GuestsPostgresAccess) GetPlusOnes(tx Tx, invitationID int64) ([]models.Guest, error) {
+	ptx := pgTx(tx)
+	guests := []models.Guest{}
+	err := ptx.Model(&guests).
+		Where("guest.invitation_id = ?", invitationID).
+		Where("guest.plus_one").
+		Where("guest.organization_id = ?", OrganizationID(tx)).
+		Order("guest.id").
+		Select()
 	if err != nil {
+		log.Error(err)
 		return nil, err
 	}
-	if existingGuest != nil {
-		return existingGuest, nil
-	}
-	query :=
-		`INSERT INTO
-			guests ("name")
-		VALUES
-			($1)
-		RETURNING id`
-	stmt, err := tx.Prepare(query)
+	return guests, nil
+}
+
+// GetDuplicateGuests gets the guests that share their name with another guest on the same invitation
+func (a *GuestsPostgresAccess) GetDuplicateGuests(tx Tx) ([]models.DuplicateGuests, error) {
+	ptx := pgTx(tx)
+	guests := []models.Guest{}
+	err := ptx.Model(&guests).
+		Where("guest.organization_id = ?", OrganizationID(tx)).
+		Where(`(guest.invitation_id, lower(guest.name)) IN (
+			SELECT invitation_id, lower(name) FROM guests
+			WHERE organization_id = ? AND invitation_id IS NOT NULL
+			GROUP BY 1, 2
+			HAVING count(*) > 1)`, OrganizationID(tx)).
+		OrderExpr("guest.invitation_id, lower(guest.name), guest.id").
+		Select()
 	if err != nil {
 		log.Error(err)
 		return nil, err
 	}
+	return groupDuplicateGuests(guests), nil
+}
 
-	var guestID int64
-	_, err = stmt.Query(pg.Scan(&guestID), &guest.Name)
+// CreateGuest creates a guest
+func (a *GuestsPostgresAccess) CreateGuest(tx Tx, guest *models.Guest) (*models.Guest, error) {
+	ptx := pgTx(tx)
+	if err := checkGuest(guest); err != nil {
+		return nil, err
+	}
+	guest.ID = 0
+	guest.Version = 0
+	guest.OrganizationID = OrganizationID(tx)
+	_, err := ptx.Model(guest).Returning("*").Insert()
 	if err != nil {
 		log.Error(err)
 		return nil, err
 	}
-	guest.ID = guestID
-
 	return guest, nil
 }
 
-// UpdateGuest updates an guest
-func (a *GuestsPostgresAccess) UpdateGuest(tx *pg.Tx, guest *models.Guest) (*models.Guest, error) {
-	var q []string
-	if guest.Name != "" {
-		q = append(q, "name = ?name")
+// UpdateGuest replaces a guest's names, email and phone, if its version is the given guest's Version
+func (a *GuestsPostgresAccess) UpdateGuest(tx Tx, guest *models.Guest) (*models.Guest, error) {
+	ptx := pgTx(tx)
+	if err := checkGuest(guest); err != nil {
+		return nil, err
 	}
-
-	qString := strings.Join(q, ", ")
-	_, updateErr := tx.Model(guest).Set(qString).Where("id = ?id").Update()
+	result, updateErr := ptx.Model(guest).
+		Set("name = ?name, first_name = ?first_name, last_name = ?last_name, email = ?email, phone = ?phone").
+		Set("version = version + 1").
+		Where("id = ?id").
+		Where("organization_id = ?", OrganizationID(tx)).
+		Apply(whereVersion(guest.Version)).
+		Update()
 	if updateErr != nil {
 		log.Error(updateErr)
 		return nil, updateErr
 	}
+	if err := checkVersion(result, guest.Version); err != nil {
+		return nil, err
+	}
 
 	updatedGuest, _ := a.GetGuest(tx, guest.ID)
 	return updatedGuest, nil
 }
 
-// DeleteGuest deletes an guest
-func (a *GuestsPostgresAccess) DeleteGuest(tx *pg.Tx, id int64) (*models.Guest, error) {
-	guest := &models.Guest{
-		ID: id,
+// MergeGuests merges duplicates of a guest on the same invitation into them. The duplicates' responses become
+// the guest's, apart from those to events the guest has already responded to, and the events and seating
+// constraints naming a duplicate name the guest instead. Then the duplicates are deleted. The guest and the
+// rsvps whose responses changed get new versions.
+func (a *GuestsPostgresAccess) MergeGuests(tx Tx, guestID int64, duplicateIDs []int64) (*models.Guest, error) {
+	ptx := pgTx(tx)
+	guest, err := checkMergeGuests(tx, a, guestID, duplicateIDs)
+	if err != nil {
+		return nil, err
 	}
-	err := tx.Delete(guest)
+
+	var rsvpIDs []int64
+	_, err = ptx.Query(&rsvpIDs, `SELECT DISTINCT rsvp_id FROM rsvp_guests WHERE guest_id IN (?)`, pg.In(duplicateIDs))
 	if err != nil {
 		log.Error(err)
 		return nil, err
 	}
-	return nil, nil
+
+	// Only one response to each event is kept: the guest's own, or else the earliest duplicate's
+	_, err = ptx.Exec(
+		`DELETE FROM rsvp_guests d
+		WHERE d.guest_id IN (?0)
+			AND EXISTS (
+				SELECT 1 FROM rsvp_guests rg
+				WHERE rg.rsvp_id = d.rsvp_id AND rg.event_id = d.event_id AND rg.id <> d.id
+					AND (rg.guest_id = ?1 OR (rg.guest_id IN (?0) AND rg.id < d.id))
+			)`, pg.In(duplicateIDs), guestID)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	_, err = ptx.Exec(`UPDATE rsvp_guests SET guest_id = ?1, is_plus_one = ?2 WHERE guest_id IN (?0)`,
+		pg.In(duplicateIDs), guestID, guest.PlusOne)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	for _, table := range []string{"invitation_events", "seating_constraints"} {
+		_, err = ptx.Exec(
+			`UPDATE ?0 t
+			SET guest_ids = ARRAY(
+				SELECT id FROM (
+					SELECT CASE WHEN u.guest_id IN (?1) THEN ?2 ELSE u.guest_id END AS id, min(u.n) AS n
+					FROM unnest(t.guest_ids) WITH ORDINALITY AS u(guest_id, n)
+					GROUP BY 1
+				) ids
+				ORDER BY n
+			)
+			WHERE t.guest_ids && ?3`, pg.Ident(table), pg.In(duplicateIDs), guestID, pg.Array(duplicateIDs))
+		if err != nil {
+			log.Error(err)
+			return nil, err
+		}
+	}
+
+	if len(rsvpIDs) > 0 {
+		_, err = ptx.Exec(
+			`UPDATE rsvps r
+			SET rsvp_guest_ids = (
+				SELECT coalesce(jsonb_agg(e.id ORDER BY e.n), '[]'::jsonb)
+				FROM jsonb_array_elements(r.rsvp_guest_ids) WITH ORDINALITY AS e(id, n)
+				WHERE EXISTS (SELECT 1 FROM rsvp_guests rg WHERE rg.id = (e.id #>> '{}')::bigint)
+			), version = version + 1
+			WHERE r.id IN (?)`, pg.In(rsvpIDs))
+		if err != nil {
+			log.Error(err)
+			return nil, err
+		}
+	}
+
+	// Deleting the duplicates cascades to their invitation_guests rows
+	_, err = ptx.Exec(`DELETE FROM guests WHERE id IN (?) AND organization_id = ?`, pg.In(duplicateIDs), OrganizationID(tx))
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	_, err = ptx.Exec(`UPDATE guests SET version = version + 1 WHERE id = ?`, guestID)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	return a.GetGuest(tx, guestID)
+}
+
+// DeleteGuest deletes a guest, if it is at the given version
+func (a *GuestsPostgresAccess) DeleteGuest(tx Tx, id int64, version int64) (*models.Guest, error) {
+	ptx := pgTx(tx)
+	result, err := ptx.Model((*models.Guest)(nil)).
+		Where("id = ?", id).
+		Where("organization_id = ?", OrganizationID(tx)).
+		Apply(whereVersion(version)).
+		Delete()
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	return nil, checkVersion(result, version)
+}
+
+// errGuestName is returned for a guest without a name
+var errGuestName = utils.ArgumentError.WithMessage("A guest needs a first or last name")
+
+// checkGuest tidies a guest's names, email and phone, checking they have a name and that any email and
+// phone look like one
+func checkGuest(guest *models.Guest) error {
+	if guest == nil {
+		return utils.ArgumentError.Here().WithMessage("A guest is required")
+	}
+	guest.SetNames()
+	if guest.Name == "" {
+		return errGuestName.Here()
+	}
+	guest.Email = strings.TrimSpace(guest.Email)
+	if guest.Email != "" && !strings.Contains(guest.Email, "@") {
+		return utils.ArgumentError.Here().WithMessagef("Email %q is not valid", guest.Email)
+	}
+	guest.Phone = strings.TrimSpace(guest.Phone)
+	digits := 0
+	for _, r := range guest.Phone {
+		switch {
+		case r >= '0' && r <= '9':
+			digits++
+		case !strings.ContainsRune("+-(). ", r):
+			return utils.ArgumentError.Here().WithMessagef("Phone %q is not valid", guest.Phone)
+		}
+	}
+	if guest.Phone != "" && digits < 7 {
+		return utils.ArgumentError.Here().WithMessagef("Phone %q is not valid", guest.Phone)
+	}
+	return nil
+}
+
+// groupDuplicateGuests groups guests sorted by invitation and name into those sharing both
+func groupDuplicateGuests(guests []models.Guest) []models.DuplicateGuests {
+	groups := []models.DuplicateGuests{}
+	for _, guest := range guests {
+		last := len(groups) - 1
+		if last >= 0 && groups[last].InvitationID == guest.InvitationID && strings.EqualFold(groups[last].Name, guest.Name) {
+			groups[last].Guests = append(groups[last].Guests, guest)
+			continue
+		}
+		groups = append(groups, models.DuplicateGuests{
+			InvitationID: guest.InvitationID,
+			Name:         guest.Name,
+			Guests:       []models.Guest{guest},
+		})
+	}
+
+	duplicates := []models.DuplicateGuests{}
+	for _, group := range groups {
+		if group.InvitationID != 0 && len(group.Guests) > 1 {
+			duplicates = append(duplicates, group)
+		}
+	}
+	return duplicates
+}
+
+// checkMergeGuests gets the guest duplicates are being merged into, checking each duplicate is another guest
+// on the same invitation. A plus one can be merged into one of the invitation's guests, but not the other way.
+func checkMergeGuests(tx Tx, guestAccess GuestsAccess, guestID int64, duplicateIDs []int64) (*models.Guest, error) {
+	if len(duplicateIDs) == 0 {
+		return nil, utils.ArgumentError.Here().WithMessage("Give the guest_ids of the duplicates to merge")
+	}
+	guest, err := guestAccess.GetGuest(tx, guestID)
+	if err != nil {
+		return nil, err
+	}
+	if guest == nil {
+		return nil, utils.HTTPNotFoundError.Here()
+	}
+
+	seen := map[int64]bool{guestID: true}
+	for _, duplicateID := range duplicateIDs {
+		if seen[duplicateID] {
+			return nil, utils.ArgumentError.Here().WithMessagef("Guest %d is listed more than once", duplicateID)
+		}
+		seen[duplicateID] = true
+		duplicate, err := guestAccess.GetGuest(tx, duplicateID)
+		if err != nil {
+			return nil, err
+		}
+		if duplicate == nil {
+			return nil, utils.ArgumentError.Here().WithMessagef("Guest %d does not exist", duplicateID)
+		}
+		if duplicate.InvitationID == 0 || duplicate.InvitationID != guest.InvitationID {
+			return nil, utils.ArgumentError.Here().WithMessagef("Guest %d is not on the same invitation as guest %d", duplicateID, guestID)
+		}
+		if guest.PlusOne && !duplicate.PlusOne {
+			return nil, utils.ArgumentError.Here().WithMessagef("Guest %d is a plus one, merge them into guest %d instead", guestID, duplicateID)
+		}
+	}
+	return guest, nil
 }
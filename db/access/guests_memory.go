@@ -0,0 +1,292 @@
+package access
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/kyrstenkelly/rsvp-api/db/models"
+	"github.com/kyrstenkelly/rsvp-api/utils"
+)
+
+// GuestsMemoryAccess in-memory implementation of a GuestsDAO
+type GuestsMemoryAccess struct {
+}
+
+// NewGuestsMemoryDAO Create a new in-memory guests dao
+func NewGuestsMemoryDAO() GuestsAccess {
+	return &GuestsMemoryAccess{}
+}
+
+// GetGuests gets a page of guests, along with how many guests match the list's filters
+func (a *GuestsMemoryAccess) GetGuests(tx Tx, list ListQuery) ([]models.Guest, int, error) {
+	tables := memTx(tx).tables
+	var rows []models.Guest
+	for _, guest := range tables.guests {
+		if guest.OrganizationID == OrganizationID(tx) {
+			rows = append(rows, guest)
+		}
+	}
+	attending := map[int64]bool{}
+	for _, rsvpGuest := range tables.rsvpGuests {
+		if tables.rsvps[rsvpGuest.RsvpID].DeletedAt != nil {
+			continue
+		}
+		attending[rsvpGuest.GuestID] = attending[rsvpGuest.GuestID] || rsvpGuest.Attending
+	}
+	page, total := listMemoryRows(len(rows), func(i int) map[string]interface{} {
+		return map[string]interface{}{
+			"id":            rows[i].ID,
+			"name":          rows[i].Name,
+			"last_name":     rows[i].LastName,
+			"email":         rows[i].Email,
+			"invitation_id": rows[i].InvitationID,
+			"plus_one":      rows[i].PlusOne,
+			"attending":     attending[rows[i].ID],
+		}
+	}, GuestsList, list)
+
+	guests := []models.Guest{}
+	for _, i := range page {
+		guests = append(guests, rows[i])
+	}
+	return guests, total, nil
+}
+
+// GetGuestsByInvitation gets the guests on an invitation, in the order they were listed
+func (a *GuestsMemoryAccess) GetGuestsByInvitation(tx Tx, invitationID int64) ([]models.Guest, error) {
+	guests := []models.Guest{}
+	tables := memTx(tx).tables
+	for _, id := range tables.invitationGuests[invitationID] {
+		guest, ok := tables.guests[id]
+		if ok && guest.OrganizationID == OrganizationID(tx) {
+			guests = append(guests, guest)
+		}
+	}
+	return guests, nil
+}
+
+// GetGuestsByInvitations gets the guests for several invitations, keyed by invitation ID
+func (a *GuestsMemoryAccess) GetGuestsByInvitations(tx Tx, invitationIDs []int64) (map[int64][]models.Guest, error) {
+	guestsByInvitation := map[int64][]models.Guest{}
+	for _, invitationID := range invitationIDs {
+		guests, err := a.GetGuestsByInvitation(tx, invitationID)
+		if err != nil {
+			return nil, err
+		}
+		if len(guests) > 0 {
+			guestsByInvitation[invitationID] = guests
+		}
+	}
+	return guestsByInvitation, nil
+}
+
+// GetGuest gets a guest by id
+func (a *GuestsMemoryAccess) GetGuest(tx Tx, id int64) (*models.Guest, error) {
+	guest, ok := memTx(tx).tables.guests[id]
+	if !ok || guest.OrganizationID != OrganizationID(tx) {
+		return nil, nil
+	}
+	return &guest, nil
+}
+
+// GetPlusOnes gets the plus ones an invitation has brought, in the order they first responded
+func (a *GuestsMemoryAccess) GetPlusOnes(tx Tx, invitationID int64) ([]models.Guest, error) {
+	guests := []models.Guest{}
+	for _, guest := range memTx(tx).tables.guests {
+		if guest.OrganizationID == OrganizationID(tx) && guest.InvitationID == invitationID && guest.PlusOne {
+			guests = append(guests, guest)
+		}
+	}
+	sort.Slice(guests, func(i, j int) bool { return guests[i].ID < guests[j].ID })
+	return guests, nil
+}
+
+// GetDuplicateGuests gets the guests that share their name with another guest on the same invitation
+func (a *GuestsMemoryAccess) GetDuplicateGuests(tx Tx) ([]models.DuplicateGuests, error) {
+	guests := []models.Guest{}
+	for _, guest := range memTx(tx).tables.guests {
+		if guest.OrganizationID == OrganizationID(tx) && guest.InvitationID != 0 {
+			guests = append(guests, guest)
+		}
+	}
+	sort.Slice(guests, func(i, j int) bool {
+		if guests[i].InvitationID != guests[j].InvitationID {
+			return guests[i].InvitationID < guests[j].InvitationID
+		}
+		if name, other := strings.ToLower(guests[i].Name), strings.ToLower(guests[j].Name); name != other {
+			return name < other
+		}
+		return guests[i].ID < guests[j].ID
+	})
+	return groupDuplicateGuests(guests), nil
+}
+
+// CreateGuest creates a guest
+func (a *GuestsMemoryAccess) CreateGuest(tx Tx, guest *models.Guest) (*models.Guest, error) {
+	if err := checkGuest(guest); err != nil {
+		return nil, err
+	}
+	tables := memTx(tx).tables
+	if _, ok := tables.invitations[guest.InvitationID]; guest.InvitationID != 0 && !ok {
+		return nil, utils.ArgumentError.Here().WithMessagef("Invitation %d does not exist", guest.InvitationID)
+	}
+	guest.ID = tables.nextID("guests")
+	guest.OrganizationID = OrganizationID(tx)
+	guest.Version = 1
+	tables.guests[guest.ID] = *guest
+	return guest, nil
+}
+
+// UpdateGuest replaces a guest's names, email and phone, if its version is the given guest's Version
+func (a *GuestsMemoryAccess) UpdateGuest(tx Tx, guest *models.Guest) (*models.Guest, error) {
+	if err := checkGuest(guest); err != nil {
+		return nil, err
+	}
+	existing, _ := a.GetGuest(tx, guest.ID)
+	if existing == nil {
+		return nil, nil
+	}
+	if err := matchVersion(existing.Version, guest.Version); err != nil {
+		return nil, err
+	}
+	existing.Name = guest.Name
+	existing.FirstName = guest.FirstName
+	existing.LastName = guest.LastName
+	existing.Email = guest.Email
+	existing.Phone = guest.Phone
+	existing.Version++
+	memTx(tx).tables.guests[existing.ID] = *existing
+	return existing, nil
+}
+
+// MergeGuests merges duplicates of a guest on the same invitation into them. The duplicates' responses become
+// the guest's, apart from those to events the guest has already responded to, and the events and seating
+// constraints naming a duplicate name the guest instead. Then the duplicates are deleted.
+func (a *GuestsMemoryAccess) MergeGuests(tx Tx, guestID int64, duplicateIDs []int64) (*models.Guest, error) {
+	guest, err := checkMergeGuests(tx, a, guestID, duplicateIDs)
+	if err != nil {
+		return nil, err
+	}
+	tables := memTx(tx).tables
+	duplicates := map[int64]bool{}
+	for _, duplicateID := range duplicateIDs {
+		duplicates[duplicateID] = true
+	}
+
+	// Only one response to each event is kept: the guest's own, or else the earliest duplicate's
+	type response struct{ rsvpID, eventID int64 }
+	responded := map[response]bool{}
+	var rsvpGuestIDs []int64
+	rsvpIDs := map[int64]bool{}
+	for id, rsvpGuest := range tables.rsvpGuests {
+		if rsvpGuest.GuestID == guestID {
+			responded[response{rsvpGuest.RsvpID, rsvpGuest.EventID}] = true
+		} else if duplicates[rsvpGuest.GuestID] {
+			rsvpGuestIDs = append(rsvpGuestIDs, id)
+			rsvpIDs[rsvpGuest.RsvpID] = true
+		}
+	}
+	sortInt64s(rsvpGuestIDs)
+	for _, id := range rsvpGuestIDs {
+		rsvpGuest := tables.rsvpGuests[id]
+		key := response{rsvpGuest.RsvpID, rsvpGuest.EventID}
+		if responded[key] {
+			delete(tables.rsvpGuests, id)
+			delete(tables.seats, id)
+			continue
+		}
+		responded[key] = true
+		rsvpGuest.GuestID = guestID
+		rsvpGuest.IsPlusOne = guest.PlusOne
+		tables.rsvpGuests[id] = rsvpGuest
+	}
+	for rsvpID := range rsvpIDs {
+		rsvp := tables.rsvps[rsvpID]
+		var remaining []int64
+		for _, id := range rsvp.RSVPGuestIds {
+			if _, ok := tables.rsvpGuests[id]; ok {
+				remaining = append(remaining, id)
+			}
+		}
+		rsvp.RSVPGuestIds = remaining
+		rsvp.Version++
+		tables.rsvps[rsvpID] = rsvp
+	}
+
+	for invitationID, invitationEvents := range tables.invitationEvents {
+		var merged []models.InvitationEvent
+		for _, invitationEvent := range invitationEvents {
+			invitationEvent.GuestIDs = mergeGuestIDs(invitationEvent.GuestIDs, duplicates, guestID)
+			merged = append(merged, invitationEvent)
+		}
+		tables.invitationEvents[invitationID] = merged
+	}
+	for id, constraint := range tables.seatingConstraints {
+		constraint.GuestIDs = mergeGuestIDs(constraint.GuestIDs, duplicates, guestID)
+		tables.seatingConstraints[id] = constraint
+	}
+	for invitationID, guestIDs := range tables.invitationGuests {
+		var remaining []int64
+		for _, id := range guestIDs {
+			if !duplicates[id] {
+				remaining = append(remaining, id)
+			}
+		}
+		if len(remaining) == 0 {
+			delete(tables.invitationGuests, invitationID)
+		} else if len(remaining) != len(guestIDs) {
+			tables.invitationGuests[invitationID] = remaining
+		}
+	}
+	for _, duplicateID := range duplicateIDs {
+		delete(tables.guests, duplicateID)
+	}
+	guest.Version++
+	tables.guests[guestID] = *guest
+	return a.GetGuest(tx, guestID)
+}
+
+// mergeGuestIDs copies guest ids with the duplicates replaced by the guest they are merged into, keeping
+// only the first of any repeats
+func mergeGuestIDs(guestIDs []int64, duplicates map[int64]bool, guestID int64) []int64 {
+	merged := []int64{}
+	for _, id := range guestIDs {
+		if duplicates[id] {
+			id = guestID
+		}
+		if !containsInt64(merged, id) {
+			merged = append(merged, id)
+		}
+	}
+	return merged
+}
+
+// DeleteGuest deletes a guest who hasn't responded to an RSVP, taking them off their invitations, if they are at the given version
+func (a *GuestsMemoryAccess) DeleteGuest(tx Tx, id int64, version int64) (*models.Guest, error) {
+	guest, _ := a.GetGuest(tx, id)
+	if guest == nil {
+		return nil, matchVersion(0, version)
+	}
+	if err := matchVersion(guest.Version, version); err != nil {
+		return nil, err
+	}
+	tables := memTx(tx).tables
+	for _, rsvpGuest := range tables.rsvpGuests {
+		if rsvpGuest.GuestID == id {
+			return nil, foreignKeyError("guests", id, "rsvp_guests")
+		}
+	}
+	for invitationID, guestIDs := range tables.invitationGuests {
+		var remaining []int64
+		for _, guestID := range guestIDs {
+			if guestID != id {
+				remaining = append(remaining, guestID)
+			}
+		}
+		if len(remaining) != len(guestIDs) {
+			tables.invitationGuests[invitationID] = remaining
+		}
+	}
+	delete(tables.guests, id)
+	return nil, nil
+}
@@ -1,88 +1,257 @@
 package access
 
 import (
+	"crypto/rand"
 	"github.com/go-pg/pg/v9"
 	"github.com/kyrstenkelly/rsvp-api/db/models"
+	"github.com/kyrstenkelly/rsvp-api/utils"
 	log "github.com/sirupsen/logrus"
 	"strings"
+	"time"
 )
 
 // InvitationsPostgresAccess postgres implementation of a CohortsDAO
 type InvitationsPostgresAccess struct {
 	addressAccess AddressesAccess
 	guestAccess   GuestsAccess
+	eventAccess   EventsAccess
 }
 
 // InvitationsAccess interface for a Cohorts data access object
 type InvitationsAccess interface {
-	GetInvitations(tx *pg.Tx) ([]models.Invitation, error)
-	GetInvitation(tx *pg.Tx, id int64) (*models.Invitation, error)
-	CreateInvitation(tx *pg.Tx, invitation *models.Invitation) (*models.Invitation, error)
-	UpdateInvitation(tx *pg.Tx, invitation *models.Invitation) (*models.Invitation, error)
-	DeleteInvitation(tx *pg.Tx, id int64) (*models.Invitation, error)
+	GetInvitations(tx Tx, list ListQuery) ([]models.Invitation, int, error)
+	GetInvitation(tx Tx, id int64) (*models.Invitation, error)
+	GetInvitationByCode(tx Tx, code string) (*models.Invitation, error)
+	GetOrganizationIDByCode(tx Tx, code string) (int64, error)
+	GetInvitationByEmail(tx Tx, email string) (*models.Invitation, error)
+	CreateInvitation(tx Tx, invitation *models.Invitation) (*models.Invitation, error)
+	UpdateInvitation(tx Tx, invitation *models.Invitation) (*models.Invitation, error)
+	DeleteInvitation(tx Tx, id int64, version int64) (*models.Invitation, error)
+	RestoreInvitation(tx Tx, id int64) (*models.Invitation, error)
+	SetInvitationGuests(tx Tx, invitationID int64, guestIDs []int64) error
+	SetInvitationEvents(tx Tx, invitationID int64, events []models.InvitationEvent) error
 }
 
+// rsvpCodeAlphabet leaves out characters that are easily confused when read off a card (0/O, 1/I)
+const rsvpCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// rsvpCodeLength gives 50 bits of randomness with a 32 character alphabet
+const rsvpCodeLength = 10
+
 // NewInvitationsDAO Create a new invitations dao
 func NewInvitationsDAO() InvitationsAccess {
 	addressesDAO := NewAddressesDAO()
 	guestsDAO := NewGuestsDAO()
+	eventsDAO := NewEventsDAO()
 	return &InvitationsPostgresAccess{
 		addressAccess: addressesDAO,
 		guestAccess:   guestsDAO,
+		eventAccess:   eventsDAO,
 	}
 }
 
-// GetInvitations gets all invitations
-func (a *InvitationsPostgresAccess) GetInvitations(tx *pg.Tx) ([]models.Invitation, error) {
-	var invitations []models.Invitation
-	err := tx.Model(&invitations).
-		Column("invitation.*", "Address", "Event").
-		Select()
+// InvitationsList is how lists of invitations can be filtered, searched and sorted
+var InvitationsList = ListSpec{
+	Fields: map[string]ListField{
+		"id":    {Column: "invitation.id", Kind: ListInt, Sort: true},
+		"name":  {Column: "invitation.name", Kind: ListString, Sort: true},
+		"email": {Column: "invitation.email", Kind: ListString, Filter: true, Sort: true},
+		"event_id": {
+			Column: "ARRAY(SELECT ie.event_id FROM invitation_events AS ie WHERE ie.invitation_id = invitation.id)",
+			Kind:   ListInt,
+			Filter: true,
+			Any:    true,
+		},
+		"plus_one":  {Column: "invitation.plus_ones > 0", Kind: ListBool, Filter: true},
+		"plus_ones": {Column: "invitation.plus_ones", Kind: ListInt, Filter: true, Sort: true},
+		"responded": {
+			Column: "EXISTS (SELECT 1 FROM rsvps AS r WHERE r.invitation_id = invitation.id AND r.deleted_at IS NULL)",
+			Kind:   ListBool,
+			Filter: true,
+		},
+	},
+	Search: []string{"name", "email"},
+}
 
-	var invitationsWithGuests []models.Invitation
-	for _, invitation := range invitations {
-		guests, err := a.guestAccess.GetGuests(tx, invitation.GuestIds)
-		if err != nil {
-			log.Error(err)
-			return nil, err
-		}
-		invitation.Guests = &guests
-		invitationsWithGuests = append(invitationsWithGuests, invitation)
+// GetInvitations gets a page of invitations, along with how many invitations match the list's filters
+func (a *InvitationsPostgresAccess) GetInvitations(tx Tx, list ListQuery) ([]models.Invitation, int, error) {
+	ptx := pgTx(tx)
+	invitations := []models.Invitation{}
+	query := ptx.Model(&invitations).
+		Column("invitation.*", "Address").
+		Where("invitation.organization_id = ?", OrganizationID(tx)).
+		Where("invitation.deleted_at IS NULL")
+	total, err := applyListQuery(query, InvitationsList, list).SelectAndCount()
+	if err != nil {
+		log.Error(err)
+		return nil, 0, err
 	}
 
+	var invitationIDs []int64
+	for _, invitation := range invitations {
+		invitationIDs = append(invitationIDs, invitation.ID)
+	}
+	guestsByInvitation, err := a.guestAccess.GetGuestsByInvitations(tx, invitationIDs)
 	if err != nil {
 		log.Error(err)
-		return nil, err
+		return nil, 0, err
 	}
-	return invitationsWithGuests, nil
+	eventsByInvitation, err := a.getInvitationEvents(tx, invitationIDs)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	for i := range invitations {
+		guests := guestsByInvitation[invitations[i].ID]
+		if guests == nil {
+			guests = []models.Guest{}
+		}
+		invitations[i].Guests = &guests
+		invitations[i].Events = eventsByInvitation[invitations[i].ID]
+	}
+	return invitations, total, nil
 }
 
 // GetInvitation gets a invitation by id
-func (a *InvitationsPostgresAccess) GetInvitation(tx *pg.Tx, id int64) (*models.Invitation, error) {
+func (a *InvitationsPostgresAccess) GetInvitation(tx Tx, id int64) (*models.Invitation, error) {
+	ptx := pgTx(tx)
 	invitation := new(models.Invitation)
-	err := tx.Model(invitation).
+	err := ptx.Model(invitation).
 		Column("invitation.*", "Address").
 		Where("invitation.id = ?", id).
+		Where("invitation.organization_id = ?", OrganizationID(tx)).
+		Where("invitation.deleted_at IS NULL").
 		Select()
+	if err == pg.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		log.Error(err)
+		return nil, err
+	}
 
-	var guests []models.Guest
-	for _, guestID := range invitation.GuestIds {
-		guest, _ := a.guestAccess.GetGuest(tx, guestID)
-		guests = append(guests, *guest)
+	guests, err := a.guestAccess.GetGuestsByInvitation(tx, id)
+	if err != nil {
+		log.Error(err)
+		return nil, err
 	}
 	invitation.Guests = &guests
 
+	eventsByInvitation, err := a.getInvitationEvents(tx, []int64{id})
+	if err != nil {
+		return nil, err
+	}
+	invitation.Events = eventsByInvitation[id]
+
+	return invitation, nil
+}
+
+// getInvitationEvents gets the events several invitations cover, in the order they were listed,
+// keyed by invitation ID. Each comes with its event, unless the event is in the trash.
+func (a *InvitationsPostgresAccess) getInvitationEvents(tx Tx, invitationIDs []int64) (map[int64][]models.InvitationEvent, error) {
+	ptx := pgTx(tx)
+	eventsByInvitation := map[int64][]models.InvitationEvent{}
+	for _, invitationID := range invitationIDs {
+		eventsByInvitation[invitationID] = []models.InvitationEvent{}
+	}
+	if len(invitationIDs) == 0 {
+		return eventsByInvitation, nil
+	}
+
+	var invitationEvents []models.InvitationEvent
+	err := ptx.Model(&invitationEvents).
+		Where("invitation_id IN (?)", pg.In(invitationIDs)).
+		Order("invitation_id", "position").
+		Select()
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	events := map[int64]*models.Event{}
+	for _, invitationEvent := range invitationEvents {
+		event, ok := events[invitationEvent.EventID]
+		if !ok {
+			event, err = a.eventAccess.GetEvent(tx, invitationEvent.EventID)
+			if err != nil {
+				return nil, err
+			}
+			events[invitationEvent.EventID] = event
+		}
+		invitationEvent.Event = event
+		eventsByInvitation[invitationEvent.InvitationID] = append(eventsByInvitation[invitationEvent.InvitationID], invitationEvent)
+	}
+	return eventsByInvitation, nil
+}
+
+// GetInvitationByCode gets an invitation by its RSVP code
+func (a *InvitationsPostgresAccess) GetInvitationByCode(tx Tx, code string) (*models.Invitation, error) {
+	ptx := pgTx(tx)
+	code = NormalizeRSVPCode(code)
+	if code == "" {
+		return nil, nil
+	}
+
+	var invitationID int64
+	_, err := ptx.QueryOne(pg.Scan(&invitationID), `SELECT id FROM invitations WHERE rsvp_code = ? AND organization_id = ? AND deleted_at IS NULL`,
+		code, OrganizationID(tx))
 	if err == pg.ErrNoRows {
 		return nil, nil
 	} else if err != nil {
 		log.Error(err)
 		return nil, err
 	}
-	return invitation, nil
+	return a.GetInvitation(tx, invitationID)
+}
+
+// GetOrganizationIDByCode gets the organization of the invitation with an RSVP code, or 0 if there
+// is none. Guests have no token, so this is how their requests find their organization. Codes are
+// unique across organizations, so this is the one lookup that isn't scoped to one.
+func (a *InvitationsPostgresAccess) GetOrganizationIDByCode(tx Tx, code string) (int64, error) {
+	ptx := pgTx(tx)
+	code = NormalizeRSVPCode(code)
+	if code == "" {
+		return 0, nil
+	}
+
+	var organizationID int64
+	_, err := ptx.QueryOne(pg.Scan(&organizationID), `SELECT organization_id FROM invitations WHERE rsvp_code = ? AND deleted_at IS NULL`, code)
+	if err == pg.ErrNoRows {
+		return 0, nil
+	} else if err != nil {
+		log.Error(err)
+		return 0, err
+	}
+	return organizationID, nil
+}
+
+// GetInvitationByEmail gets an invitation by its email, ignoring case
+func (a *InvitationsPostgresAccess) GetInvitationByEmail(tx Tx, email string) (*models.Invitation, error) {
+	ptx := pgTx(tx)
+	var invitationID int64
+	_, err := ptx.QueryOne(pg.Scan(&invitationID), `SELECT id FROM invitations WHERE lower(email) = lower(?) AND organization_id = ? AND deleted_at IS NULL`,
+		email, OrganizationID(tx))
+	if err == pg.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	return a.GetInvitation(tx, invitationID)
 }
 
 // CreateInvitation creates an invitation
-func (a *InvitationsPostgresAccess) CreateInvitation(tx *pg.Tx, invitation *models.Invitation) (*models.Invitation, error) {
+func (a *InvitationsPostgresAccess) CreateInvitation(tx Tx, invitation *models.Invitation) (*models.Invitation, error) {
+	ptx := pgTx(tx)
+	if len(invitation.Events) == 0 {
+		return nil, errNoInvitationEvents.Here()
+	}
+	if invitation.PlusOnes < 0 {
+		return nil, errNegativePlusOnes.Here()
+	}
+	if err := a.checkUniqueEmail(tx, invitation); err != nil {
+		return nil, err
+	}
+
 	// Create and append address to invitation
 	address, err := a.addressAccess.FindOrCreateAddress(tx, invitation.Address)
 	if err != nil {
@@ -91,8 +260,7 @@ func (a *InvitationsPostgresAccess) CreateInvitation(tx *pg.Tx, invitation *mode
 	}
 	invitation.AddressID = address.ID
 
-	// Find and append guest IDs to the invitation
-	invitation.GuestIds, err = a.BuildGuestIDs(tx, invitation.Guests)
+	invitation.RSVPCode, err = generateRSVPCode()
 	if err != nil {
 		log.Error(err)
 		return nil, err
@@ -100,78 +268,172 @@ func (a *InvitationsPostgresAccess) CreateInvitation(tx *pg.Tx, invitation *mode
 
 	query :=
 		`INSERT INTO
-			invitations ("event_id", "name", "email", "plus_one", "guest_ids", "address_id")
+			invitations ("name", "email", "plus_ones", "rsvp_code", "address_id", "organization_id")
 		VALUES
 			($1, $2, $3, $4, $5, $6)
 		RETURNING id`
-	stmt, err := tx.Prepare(query)
+	stmt, err := ptx.Prepare(query)
 	if err != nil {
 		log.Error(err)
 		return nil, err
 	}
 
 	var invitationID int64
-	_, err = stmt.Query(pg.Scan(&invitationID), &invitation.EventID, &invitation.Name, &invitation.Email, &invitation.PlusOne, pg.Array(&invitation.GuestIds), &invitation.AddressID)
+	invitation.OrganizationID = OrganizationID(tx)
+	_, err = stmt.Query(pg.Scan(&invitationID), &invitation.Name, &invitation.Email, &invitation.PlusOnes, &invitation.RSVPCode,
+		&invitation.AddressID, &invitation.OrganizationID)
 	if err != nil {
 		log.Error(err)
 		return nil, err
 	}
 	invitation.ID = invitationID
+	invitation.Version = 1
 
-	return invitation, nil
+	// Create the new guests before linking them to the invitation
+	guestIDs, err := a.BuildGuestIDs(tx, invitationID, invitation.Guests)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	err = a.SetInvitationGuests(tx, invitationID, guestIDs)
+	if err != nil {
+		return nil, err
+	}
+	err = a.SetInvitationEvents(tx, invitationID, invitation.Events)
+	if err != nil {
+		return nil, err
+	}
+
+	return a.GetInvitation(tx, invitationID)
 }
 
-// UpdateInvitation updates an invitation
-func (a *InvitationsPostgresAccess) UpdateInvitation(tx *pg.Tx, invitation *models.Invitation) (*models.Invitation, error) {
-	var q []string
-	if invitation.Name != "" {
-		q = append(q, "name = ?name")
+// UpdateInvitation replaces an invitation's name, email, plus ones allowed, address, guests and events with the given
+// invitation's. Its RSVP code never changes. Nothing changes unless its version is the given invitation's Version.
+func (a *InvitationsPostgresAccess) UpdateInvitation(tx Tx, invitation *models.Invitation) (*models.Invitation, error) {
+	ptx := pgTx(tx)
+	existing, err := a.GetInvitation(tx, invitation.ID)
+	if err != nil {
+		return nil, err
 	}
-	if invitation.Email != "" {
-		q = append(q, "email = ?email")
+	if existing == nil {
+		return nil, nil
 	}
-	if invitation.PlusOne {
-		q = append(q, "plus_one = ?plus_one")
+	if err := a.checkPlusOnes(tx, invitation); err != nil {
+		return nil, err
 	}
-	if invitation.Address != nil {
-		_, err := a.addressAccess.FindOrCreateAddress(tx, invitation.Address)
-		if err != nil {
-			log.Error(err)
-			return nil, err
-		}
-		q = append(q, "address = ?address")
+	if err := a.checkUniqueEmail(tx, invitation); err != nil {
+		return nil, err
 	}
-	if invitation.Guests != nil {
-		guestIds, err := a.BuildGuestIDs(tx, invitation.Guests)
-		invitation.GuestIds = guestIds
-		if err != nil {
-			log.Error(err)
-			return nil, err
-		}
-		q = append(q, "guest_ids = ?guest_ids")
+
+	address, err := a.addressAccess.FindOrCreateAddress(tx, invitation.Address)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	invitation.AddressID = address.ID
+
+	guestIDs, err := a.BuildGuestIDs(tx, invitation.ID, invitation.Guests)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	err = a.SetInvitationGuests(tx, invitation.ID, guestIDs)
+	if err != nil {
+		return nil, err
+	}
+	err = a.SetInvitationEvents(tx, invitation.ID, invitation.Events)
+	if err != nil {
+		return nil, err
 	}
 
-	qString := strings.Join(q, ", ")
-	_, updateErr := tx.Model(invitation).Set(qString).Where("id = ?id").Update()
+	result, updateErr := ptx.Model(invitation).
+		Set("name = ?name, email = ?email, plus_ones = ?plus_ones, address_id = ?address_id").
+		Set("version = version + 1").
+		Where("id = ?id").
+		Where("organization_id = ?", OrganizationID(tx)).
+		Where("deleted_at IS NULL").
+		Apply(whereVersion(invitation.Version)).
+		Update()
 	if updateErr != nil {
 		log.Error(updateErr)
 		return nil, updateErr
 	}
+	if err := checkVersion(result, invitation.Version); err != nil {
+		return nil, err
+	}
 
 	updatedInvitation, _ := a.GetInvitation(tx, invitation.ID)
 	return updatedInvitation, nil
 }
 
-// DeleteInvitation deletes an invitation and the associated guests
-func (a *InvitationsPostgresAccess) DeleteInvitation(tx *pg.Tx, id int64) (*models.Invitation, error) {
-	invitation, err := a.GetInvitation(tx, id)
-	if invitation == nil {
-		return nil, nil
+// checkUniqueEmail rejects an email another of the organization's invitations has, ignoring case, before the
+// unique index would. Invitations in the trash keep their email until they are purged.
+func (a *InvitationsPostgresAccess) checkUniqueEmail(tx Tx, invitation *models.Invitation) error {
+	var count int
+	_, err := pgTx(tx).QueryOne(pg.Scan(&count),
+		`SELECT count(*) FROM invitations WHERE lower(email) = lower(?) AND organization_id = ? AND id <> ?`,
+		invitation.Email, OrganizationID(tx), invitation.ID)
+	if err != nil {
+		log.Error(err)
+		return err
 	}
-	for _, guestID := range invitation.GuestIds {
-		a.guestAccess.DeleteGuest(tx, guestID)
+	if count > 0 {
+		return uniqueError("invitations", "email", invitation.Email)
 	}
-	err = tx.Delete(invitation)
+	return nil
+}
+
+// checkPlusOnes checks an invitation's new plus one allowance still covers the plus ones who have
+// responded to each of its events. The invitation is locked first, as responses do before counting.
+func (a *InvitationsPostgresAccess) checkPlusOnes(tx Tx, invitation *models.Invitation) error {
+	if invitation.PlusOnes < 0 {
+		return errNegativePlusOnes.Here()
+	}
+	ptx := pgTx(tx)
+	if _, err := ptx.Exec(`SELECT 1 FROM invitations WHERE id = ? FOR UPDATE`, invitation.ID); err != nil {
+		log.Error(err)
+		return err
+	}
+	var responded int
+	_, err := ptx.QueryOne(pg.Scan(&responded),
+		`SELECT coalesce(max(n), 0) FROM (
+			SELECT count(*) AS n
+			FROM rsvp_guests rg
+			JOIN rsvps r ON r.id = rg.rsvp_id
+			WHERE r.invitation_id = ? AND rg.is_plus_one
+			GROUP BY rg.event_id
+		) plus_ones`, invitation.ID)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	return plusOnesRespondedError(invitation, responded)
+}
+
+// DeleteInvitation moves an invitation and its RSVP to the trash, if it is at the given version.
+// Its guests are kept until it is purged. It fails with a HTTPNotFoundError if there's no such invitation.
+func (a *InvitationsPostgresAccess) DeleteInvitation(tx Tx, id int64, version int64) (*models.Invitation, error) {
+	ptx := pgTx(tx)
+	result, err := ptx.Model((*models.Invitation)(nil)).
+		Set("deleted_at = now(), version = version + 1").
+		Where("id = ?", id).
+		Where("organization_id = ?", OrganizationID(tx)).
+		Where("deleted_at IS NULL").
+		Apply(whereVersion(version)).
+		Update()
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	if err := checkTrashed(tx, (*models.Invitation)(nil), id, result); err != nil {
+		return nil, err
+	}
+	// now() is the same throughout the transaction, which is how RestoreInvitation finds the RSVP again
+	_, err = ptx.Model((*models.RSVP)(nil)).
+		Set("deleted_at = now(), version = version + 1").
+		Where("invitation_id = ?", id).
+		Where("deleted_at IS NULL").
+		Update()
 	if err != nil {
 		log.Error(err)
 		return nil, err
@@ -179,17 +441,289 @@ func (a *InvitationsPostgresAccess) DeleteInvitation(tx *pg.Tx, id int64) (*mode
 	return nil, nil
 }
 
-// BuildGuestIDs takes a list of guests and returns a list of their IDs
-// After either finding or creating them
-func (a *InvitationsPostgresAccess) BuildGuestIDs(tx *pg.Tx, guests *[]models.Guest) ([]int64, error) {
+// RestoreInvitation takes an invitation out of the trash, along with the RSVP that was deleted with it.
+// It returns nil if the invitation isn't in the trash, and fails if any of its events are.
+func (a *InvitationsPostgresAccess) RestoreInvitation(tx Tx, id int64) (*models.Invitation, error) {
+	ptx := pgTx(tx)
+	var deletedAt time.Time
+	var eventDeleted bool
+	_, err := ptx.QueryOne(pg.Scan(&deletedAt, &eventDeleted),
+		`SELECT i.deleted_at, EXISTS (
+			SELECT 1 FROM invitation_events ie
+			JOIN events e ON e.id = ie.event_id
+			WHERE ie.invitation_id = i.id AND e.deleted_at IS NOT NULL
+		)
+		FROM invitations i
+		WHERE i.id = ? AND i.organization_id = ? AND i.deleted_at IS NOT NULL`, id, OrganizationID(tx))
+	if err == pg.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	if eventDeleted {
+		return nil, errInvitationEventDeleted.Here()
+	}
+
+	_, err = ptx.Model((*models.Invitation)(nil)).
+		Set("deleted_at = NULL, version = version + 1").
+		Where("id = ?", id).
+		Update()
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	_, err = ptx.Model((*models.RSVP)(nil)).
+		Set("deleted_at = NULL, version = version + 1").
+		Where("invitation_id = ?", id).
+		Where("deleted_at = ?", deletedAt).
+		Update()
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	return a.GetInvitation(tx, id)
+}
+
+// SetInvitationGuests replaces the guests on an invitation, keeping them in the given order. Each guest must
+// already be on the invitation or on none, and guests taken off it are left on none.
+func (a *InvitationsPostgresAccess) SetInvitationGuests(tx Tx, invitationID int64, guestIDs []int64) error {
+	ptx := pgTx(tx)
+	guests := []models.Guest{}
+	if len(guestIDs) > 0 {
+		err := ptx.Model(&guests).
+			Where("guest.id IN (?)", pg.In(guestIDs)).
+			Where("guest.organization_id = ?", OrganizationID(tx)).
+			Select()
+		if err != nil {
+			log.Error(err)
+			return err
+		}
+	}
+	if err := checkInvitationGuests(invitationID, guestIDs, guests); err != nil {
+		return err
+	}
+
+	_, err := ptx.Model((*models.InvitationGuest)(nil)).
+		Where("invitation_id = ?", invitationID).
+		Delete()
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	_, err = ptx.Exec(`UPDATE guests SET invitation_id = NULL WHERE invitation_id = ?0 AND NOT plus_one AND id <> ALL (?1)`,
+		invitationID, pg.Array(storedGuestIDs(guestIDs)))
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	if len(guestIDs) == 0 {
+		return nil
+	}
+
+	var links []models.InvitationGuest
+	for position, guestID := range guestIDs {
+		links = append(links, models.InvitationGuest{
+			InvitationID: invitationID,
+			GuestID:      guestID,
+			Position:     position,
+		})
+	}
+	_, err = ptx.Model(&links).Insert()
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	_, err = ptx.Exec(`UPDATE guests SET invitation_id = ? WHERE id IN (?)`, invitationID, pg.In(guestIDs))
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	return nil
+}
+
+// SetInvitationEvents replaces the events an invitation covers, keeping them in the given order. Each
+// event must exist and can only invite guests on the invitation, so set its guests first. Responses to
+// events the invitation no longer covers are deleted.
+func (a *InvitationsPostgresAccess) SetInvitationEvents(tx Tx, invitationID int64, events []models.InvitationEvent) error {
+	ptx := pgTx(tx)
 	var guestIDs []int64
-	for _, guest := range *guests {
-		newGuest, err := a.guestAccess.FindOrCreateGuest(tx, &guest)
+	_, err := ptx.Query(&guestIDs, `SELECT guest_id FROM invitation_guests WHERE invitation_id = ?`, invitationID)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	if err := checkInvitationEvents(events, guestIDs); err != nil {
+		return err
+	}
+
+	var eventIDs []int64
+	for _, event := range events {
+		var eventExists bool
+		_, err := ptx.QueryOne(pg.Scan(&eventExists), `SELECT EXISTS (SELECT 1 FROM events WHERE id = ? AND organization_id = ? AND deleted_at IS NULL)`,
+			event.EventID, OrganizationID(tx))
 		if err != nil {
 			log.Error(err)
-			return nil, err
+			return err
+		}
+		if !eventExists {
+			return utils.ArgumentError.Here().WithMessagef("Event %d does not exist", event.EventID)
 		}
-		guestIDs = append(guestIDs, newGuest.ID)
+		eventIDs = append(eventIDs, event.EventID)
+	}
+
+	_, err = ptx.Exec(
+		`DELETE FROM rsvp_guests rg
+		USING rsvps r
+		WHERE r.id = rg.rsvp_id AND r.invitation_id = ? AND NOT (rg.event_id = ANY (?))`, invitationID, pg.Array(eventIDs))
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	_, err = ptx.Model((*models.InvitationEvent)(nil)).
+		Where("invitation_id = ?", invitationID).
+		Delete()
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+
+	var links []models.InvitationEvent
+	for position, event := range events {
+		links = append(links, models.InvitationEvent{
+			InvitationID: invitationID,
+			EventID:      event.EventID,
+			Position:     position,
+			GuestIDs:     storedGuestIDs(event.GuestIDs),
+		})
+	}
+	_, err = ptx.Model(&links).Insert()
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	return nil
+}
+
+// errNoInvitationEvents is returned for an invitation that doesn't cover any events
+var errNoInvitationEvents = utils.ArgumentError.WithMessage("An invitation must cover at least one event")
+
+// errInvitationEventDeleted is returned when restoring an invitation to an event in the trash
+var errInvitationEventDeleted = utils.ArgumentError.WithMessage("One of the invitation's events is in the trash, restore it first")
+
+// errNegativePlusOnes is returned for an invitation allowing fewer than no plus ones
+var errNegativePlusOnes = utils.ArgumentError.WithMessage("plus_ones can't be negative")
+
+// plusOnesRespondedError is returned when more plus ones have responded to one of an invitation's events
+// than it would allow
+func plusOnesRespondedError(invitation *models.Invitation, responded int) error {
+	if responded <= invitation.PlusOnes {
+		return nil
+	}
+	return utils.ArgumentError.Here().WithMessagef("%d plus ones have already responded to one of the invitation's events, "+
+		"so it can't allow only %d", responded, invitation.PlusOnes)
+}
+
+// checkInvitationEvents checks an invitation covers at least one event, each only once, and that
+// each invites only guests who are on the invitation
+func checkInvitationEvents(events []models.InvitationEvent, guestIDs []int64) error {
+	if len(events) == 0 {
+		return errNoInvitationEvents.Here()
+	}
+	invited := map[int64]bool{}
+	for _, guestID := range guestIDs {
+		invited[guestID] = true
+	}
+	seen := map[int64]bool{}
+	for _, event := range events {
+		if seen[event.EventID] {
+			return utils.ArgumentError.Here().WithMessagef("Event %d is listed more than once", event.EventID)
+		}
+		seen[event.EventID] = true
+		for _, guestID := range event.GuestIDs {
+			if !invited[guestID] {
+				return utils.ArgumentError.Here().WithMessagef("Guest %d invited to event %d is not on the invitation", guestID, event.EventID)
+			}
+		}
+	}
+	return nil
+}
+
+// checkInvitationGuests checks the guests being put on an invitation are each listed once, and are already
+// on it or on none. Plus ones can't be listed, they belong to the invitation as they are.
+func checkInvitationGuests(invitationID int64, guestIDs []int64, guests []models.Guest) error {
+	byID := map[int64]models.Guest{}
+	for _, guest := range guests {
+		byID[guest.ID] = guest
+	}
+	seen := map[int64]bool{}
+	for _, guestID := range guestIDs {
+		guest, ok := byID[guestID]
+		switch {
+		case !ok:
+			return utils.ArgumentError.Here().WithMessagef("Guest %d does not exist", guestID)
+		case seen[guestID]:
+			return utils.ArgumentError.Here().WithMessagef("Guest %d is listed more than once", guestID)
+		case guest.PlusOne:
+			return utils.ArgumentError.Here().WithMessagef("Guest %d is a plus one", guestID)
+		case guest.InvitationID != 0 && guest.InvitationID != invitationID:
+			return utils.ArgumentError.Here().WithMessagef("Guest %d is on another invitation", guestID)
+		}
+		seen[guestID] = true
+	}
+	return nil
+}
+
+// storedGuestIDs copies an event's guest ids, storing none as an empty array rather than null
+func storedGuestIDs(guestIDs []int64) []int64 {
+	if len(guestIDs) == 0 {
+		return []int64{}
+	}
+	return copyInt64s(guestIDs)
+}
+
+// BuildGuestIDs takes a list of an invitation's guests and returns a list of their IDs, after creating
+// the new guests, which have no ID
+func (a *InvitationsPostgresAccess) BuildGuestIDs(tx Tx, invitationID int64, guests *[]models.Guest) ([]int64, error) {
+	var guestIDs []int64
+	if guests == nil {
+		return guestIDs, nil
+	}
+	for _, guest := range *guests {
+		if guest.ID == 0 {
+			guest.InvitationID = invitationID
+			guest.PlusOne = false
+			newGuest, err := a.guestAccess.CreateGuest(tx, &guest)
+			if err != nil {
+				log.Error(err)
+				return nil, err
+			}
+			guest.ID = newGuest.ID
+		}
+		guestIDs = append(guestIDs, guest.ID)
 	}
 	return guestIDs, nil
 }
+
+// NormalizeRSVPCode uppercases a code and strips the spaces and dashes guests tend to type
+func NormalizeRSVPCode(code string) string {
+	return strings.Map(func(r rune) rune {
+		if r == ' ' || r == '-' {
+			return -1
+		}
+		return r
+	}, strings.ToUpper(code))
+}
+
+// generateRSVPCode builds a random, unguessable code for the guest-facing RSVP flow
+func generateRSVPCode() (string, error) {
+	buf := make([]byte, rsvpCodeLength)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	code := make([]byte, rsvpCodeLength)
+	for i, b := range buf {
+		code[i] = rsvpCodeAlphabet[int(b)%len(rsvpCodeAlphabet)]
+	}
+	return string(code), nil
+}
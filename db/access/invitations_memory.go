@@ -0,0 +1,437 @@
+package access
+
+import (
+	"github.com/kyrstenkelly/rsvp-api/db/models"
+	"github.com/kyrstenkelly/rsvp-api/utils"
+	"strings"
+)
+
+// InvitationsMemoryAccess in-memory implementation of an InvitationsDAO
+type InvitationsMemoryAccess struct {
+	addressAccess AddressesAccess
+	guestAccess   GuestsAccess
+}
+
+// NewInvitationsMemoryDAO Create a new in-memory invitations dao
+func NewInvitationsMemoryDAO() InvitationsAccess {
+	return &InvitationsMemoryAccess{
+		addressAccess: NewAddressesMemoryDAO(),
+		guestAccess:   NewGuestsMemoryDAO(),
+	}
+}
+
+// storedInvitation copies an invitation for the tables, leaving out its relations
+func storedInvitation(invitation models.Invitation) models.Invitation {
+	invitation.Address = nil
+	invitation.Guests = nil
+	invitation.Events = nil
+	return invitation
+}
+
+// loadInvitation copies an invitation out of the tables along with its address, guests and events
+func (a *InvitationsMemoryAccess) loadInvitation(tx Tx, invitation models.Invitation) (*models.Invitation, error) {
+	tables := memTx(tx).tables
+	if invitation.AddressID > 0 {
+		address, err := a.addressAccess.GetAddress(tx, invitation.AddressID)
+		if err != nil {
+			return nil, err
+		}
+		invitation.Address = address
+	}
+	guests, err := a.guestAccess.GetGuestsByInvitation(tx, invitation.ID)
+	if err != nil {
+		return nil, err
+	}
+	invitation.Guests = &guests
+
+	invitation.Events = []models.InvitationEvent{}
+	for _, invitationEvent := range tables.invitationEvents[invitation.ID] {
+		invitationEvent.GuestIDs = storedGuestIDs(invitationEvent.GuestIDs)
+		if event, ok := tables.events[invitationEvent.EventID]; ok && event.DeletedAt == nil {
+			event = storedEvent(event)
+			invitationEvent.Event = &event
+		}
+		invitation.Events = append(invitation.Events, invitationEvent)
+	}
+	return &invitation, nil
+}
+
+// findInvitation gets the id of the first invitation in the transaction's organization, and not in the trash, that matches
+func (a *InvitationsMemoryAccess) findInvitation(tx Tx, matches func(models.Invitation) bool) int64 {
+	var invitationID int64
+	for id, invitation := range memTx(tx).tables.invitations {
+		if invitation.OrganizationID == OrganizationID(tx) && invitation.DeletedAt == nil && matches(invitation) &&
+			(invitationID == 0 || id < invitationID) {
+			invitationID = id
+		}
+	}
+	return invitationID
+}
+
+// GetInvitations gets a page of invitations, along with how many invitations match the list's filters
+func (a *InvitationsMemoryAccess) GetInvitations(tx Tx, list ListQuery) ([]models.Invitation, int, error) {
+	tables := memTx(tx).tables
+	var rows []models.Invitation
+	for _, invitation := range tables.invitations {
+		if invitation.OrganizationID == OrganizationID(tx) && invitation.DeletedAt == nil {
+			rows = append(rows, invitation)
+		}
+	}
+	responded := map[int64]bool{}
+	for _, rsvp := range tables.rsvps {
+		if rsvp.DeletedAt == nil {
+			responded[rsvp.InvitationID] = true
+		}
+	}
+	page, total := listMemoryRows(len(rows), func(i int) map[string]interface{} {
+		return map[string]interface{}{
+			"id":        rows[i].ID,
+			"name":      rows[i].Name,
+			"email":     rows[i].Email,
+			"event_id":  invitationEventIDs(tables, rows[i].ID),
+			"plus_one":  rows[i].PlusOnes > 0,
+			"plus_ones": rows[i].PlusOnes,
+			"responded": responded[rows[i].ID],
+		}
+	}, InvitationsList, list)
+
+	invitations := []models.Invitation{}
+	for _, i := range page {
+		invitation, err := a.loadInvitation(tx, rows[i])
+		if err != nil {
+			return nil, 0, err
+		}
+		invitations = append(invitations, *invitation)
+	}
+	return invitations, total, nil
+}
+
+// GetInvitation gets an invitation by id
+func (a *InvitationsMemoryAccess) GetInvitation(tx Tx, id int64) (*models.Invitation, error) {
+	invitation, ok := memTx(tx).tables.invitations[id]
+	if !ok || invitation.OrganizationID != OrganizationID(tx) || invitation.DeletedAt != nil {
+		return nil, nil
+	}
+	return a.loadInvitation(tx, invitation)
+}
+
+// GetInvitationByCode gets an invitation by its RSVP code
+func (a *InvitationsMemoryAccess) GetInvitationByCode(tx Tx, code string) (*models.Invitation, error) {
+	code = NormalizeRSVPCode(code)
+	if code == "" {
+		return nil, nil
+	}
+	invitationID := a.findInvitation(tx, func(invitation models.Invitation) bool {
+		return invitation.RSVPCode == code
+	})
+	if invitationID == 0 {
+		return nil, nil
+	}
+	return a.GetInvitation(tx, invitationID)
+}
+
+// GetOrganizationIDByCode gets the organization of the invitation with an RSVP code, or 0 if there is none
+func (a *InvitationsMemoryAccess) GetOrganizationIDByCode(tx Tx, code string) (int64, error) {
+	code = NormalizeRSVPCode(code)
+	if code == "" {
+		return 0, nil
+	}
+	for _, invitation := range memTx(tx).tables.invitations {
+		if invitation.RSVPCode == code && invitation.DeletedAt == nil {
+			return invitation.OrganizationID, nil
+		}
+	}
+	return 0, nil
+}
+
+// GetInvitationByEmail gets an invitation by its email, ignoring case
+func (a *InvitationsMemoryAccess) GetInvitationByEmail(tx Tx, email string) (*models.Invitation, error) {
+	invitationID := a.findInvitation(tx, func(invitation models.Invitation) bool {
+		return strings.EqualFold(invitation.Email, email)
+	})
+	if invitationID == 0 {
+		return nil, nil
+	}
+	return a.GetInvitation(tx, invitationID)
+}
+
+// checkUnique enforces the unique RSVP code and the email, unique within an organization ignoring case,
+// that postgres does
+func (a *InvitationsMemoryAccess) checkUnique(tx Tx, invitation *models.Invitation) error {
+	for id, existing := range memTx(tx).tables.invitations {
+		if id == invitation.ID {
+			continue
+		}
+		if existing.OrganizationID == OrganizationID(tx) && strings.EqualFold(existing.Email, invitation.Email) {
+			return uniqueError("invitations", "email", invitation.Email)
+		}
+		if existing.RSVPCode == invitation.RSVPCode {
+			return uniqueError("invitations", "rsvp_code", invitation.RSVPCode)
+		}
+	}
+	return nil
+}
+
+// CreateInvitation creates an invitation
+func (a *InvitationsMemoryAccess) CreateInvitation(tx Tx, invitation *models.Invitation) (*models.Invitation, error) {
+	tables := memTx(tx).tables
+	if len(invitation.Events) == 0 {
+		return nil, errNoInvitationEvents.Here()
+	}
+	if invitation.PlusOnes < 0 {
+		return nil, errNegativePlusOnes.Here()
+	}
+
+	address, err := a.addressAccess.FindOrCreateAddress(tx, invitation.Address)
+	if err != nil {
+		return nil, err
+	}
+	invitation.AddressID = address.ID
+
+	invitation.RSVPCode, err = generateRSVPCode()
+	if err != nil {
+		return nil, err
+	}
+	invitation.ID = 0
+	if err := a.checkUnique(tx, invitation); err != nil {
+		return nil, err
+	}
+
+	invitation.ID = tables.nextID("invitations")
+	invitation.OrganizationID = OrganizationID(tx)
+	invitation.Version = 1
+	tables.invitations[invitation.ID] = storedInvitation(*invitation)
+
+	guestIDs, err := a.BuildGuestIDs(tx, invitation.ID, invitation.Guests)
+	if err != nil {
+		return nil, err
+	}
+	err = a.SetInvitationGuests(tx, invitation.ID, guestIDs)
+	if err != nil {
+		return nil, err
+	}
+	err = a.SetInvitationEvents(tx, invitation.ID, invitation.Events)
+	if err != nil {
+		return nil, err
+	}
+	return a.GetInvitation(tx, invitation.ID)
+}
+
+// UpdateInvitation replaces an invitation's name, email, plus ones allowed, address, guests and events with the given
+// invitation's. Its RSVP code never changes. Nothing changes unless its version is the given invitation's Version.
+func (a *InvitationsMemoryAccess) UpdateInvitation(tx Tx, invitation *models.Invitation) (*models.Invitation, error) {
+	tables := memTx(tx).tables
+	existing, ok := tables.invitations[invitation.ID]
+	if !ok || existing.OrganizationID != OrganizationID(tx) || existing.DeletedAt != nil {
+		return nil, nil
+	}
+	if err := matchVersion(existing.Version, invitation.Version); err != nil {
+		return nil, err
+	}
+	if err := a.checkPlusOnes(tx, invitation); err != nil {
+		return nil, err
+	}
+
+	address, err := a.addressAccess.FindOrCreateAddress(tx, invitation.Address)
+	if err != nil {
+		return nil, err
+	}
+	guestIDs, err := a.BuildGuestIDs(tx, invitation.ID, invitation.Guests)
+	if err != nil {
+		return nil, err
+	}
+	err = a.SetInvitationGuests(tx, invitation.ID, guestIDs)
+	if err != nil {
+		return nil, err
+	}
+	err = a.SetInvitationEvents(tx, invitation.ID, invitation.Events)
+	if err != nil {
+		return nil, err
+	}
+
+	existing.Name = invitation.Name
+	existing.Email = invitation.Email
+	existing.PlusOnes = invitation.PlusOnes
+	existing.AddressID = address.ID
+	existing.Version++
+	if err := a.checkUnique(tx, &existing); err != nil {
+		return nil, err
+	}
+	tables.invitations[existing.ID] = storedInvitation(existing)
+
+	return a.GetInvitation(tx, invitation.ID)
+}
+
+// checkPlusOnes checks an invitation's new plus one allowance still covers the plus ones who have
+// responded to each of its events
+func (a *InvitationsMemoryAccess) checkPlusOnes(tx Tx, invitation *models.Invitation) error {
+	if invitation.PlusOnes < 0 {
+		return errNegativePlusOnes.Here()
+	}
+	tables := memTx(tx).tables
+	perEvent := map[int64]int{}
+	responded := 0
+	for _, rsvpGuest := range tables.rsvpGuests {
+		if rsvpGuest.IsPlusOne && tables.rsvps[rsvpGuest.RsvpID].InvitationID == invitation.ID {
+			perEvent[rsvpGuest.EventID]++
+			if perEvent[rsvpGuest.EventID] > responded {
+				responded = perEvent[rsvpGuest.EventID]
+			}
+		}
+	}
+	return plusOnesRespondedError(invitation, responded)
+}
+
+// DeleteInvitation moves an invitation and its RSVP to the trash, if it is at the given version.
+// Its guests are kept until it is purged. It fails with a HTTPNotFoundError if there's no such invitation.
+func (a *InvitationsMemoryAccess) DeleteInvitation(tx Tx, id int64, version int64) (*models.Invitation, error) {
+	tables := memTx(tx).tables
+	invitation, ok := tables.invitations[id]
+	if !ok || invitation.OrganizationID != OrganizationID(tx) || invitation.DeletedAt != nil {
+		return nil, utils.HTTPNotFoundError.Here()
+	}
+	if err := matchVersion(invitation.Version, version); err != nil {
+		return nil, err
+	}
+
+	deletedAt := memTx(tx).now
+	invitation.DeletedAt = &deletedAt
+	invitation.Version++
+	tables.invitations[id] = invitation
+	for rsvpID, rsvp := range tables.rsvps {
+		if rsvp.InvitationID == id && rsvp.DeletedAt == nil {
+			rsvp.DeletedAt = &deletedAt
+			rsvp.Version++
+			tables.rsvps[rsvpID] = rsvp
+		}
+	}
+	return nil, nil
+}
+
+// RestoreInvitation takes an invitation out of the trash, along with the RSVP that was deleted with it.
+// It returns nil if the invitation isn't in the trash, and fails if any of its events are.
+func (a *InvitationsMemoryAccess) RestoreInvitation(tx Tx, id int64) (*models.Invitation, error) {
+	tables := memTx(tx).tables
+	invitation, ok := tables.invitations[id]
+	if !ok || invitation.OrganizationID != OrganizationID(tx) || invitation.DeletedAt == nil {
+		return nil, nil
+	}
+	for _, invitationEvent := range tables.invitationEvents[id] {
+		if tables.events[invitationEvent.EventID].DeletedAt != nil {
+			return nil, errInvitationEventDeleted.Here()
+		}
+	}
+
+	deletedAt := *invitation.DeletedAt
+	invitation.DeletedAt = nil
+	invitation.Version++
+	tables.invitations[id] = invitation
+	for rsvpID, rsvp := range tables.rsvps {
+		if rsvp.InvitationID == id && rsvp.DeletedAt != nil && rsvp.DeletedAt.Equal(deletedAt) {
+			rsvp.DeletedAt = nil
+			rsvp.Version++
+			tables.rsvps[rsvpID] = rsvp
+		}
+	}
+	return a.GetInvitation(tx, id)
+}
+
+// SetInvitationGuests replaces the guests on an invitation, keeping them in the given order. Each guest must
+// already be on the invitation or on none, and guests taken off it are left on none.
+func (a *InvitationsMemoryAccess) SetInvitationGuests(tx Tx, invitationID int64, guestIDs []int64) error {
+	tables := memTx(tx).tables
+	if _, ok := tables.invitations[invitationID]; !ok {
+		return utils.ArgumentError.Here().WithMessagef("Invitation %d does not exist", invitationID)
+	}
+	var guests []models.Guest
+	for _, guestID := range guestIDs {
+		if guest, ok := tables.guests[guestID]; ok && guest.OrganizationID == OrganizationID(tx) {
+			guests = append(guests, guest)
+		}
+	}
+	if err := checkInvitationGuests(invitationID, guestIDs, guests); err != nil {
+		return err
+	}
+
+	for id, guest := range tables.guests {
+		onInvitation := containsInt64(guestIDs, id)
+		if onInvitation && guest.InvitationID != invitationID {
+			guest.InvitationID = invitationID
+			tables.guests[id] = guest
+		} else if !onInvitation && guest.InvitationID == invitationID && !guest.PlusOne {
+			guest.InvitationID = 0
+			tables.guests[id] = guest
+		}
+	}
+	if len(guestIDs) == 0 {
+		delete(tables.invitationGuests, invitationID)
+		return nil
+	}
+	tables.invitationGuests[invitationID] = copyInt64s(guestIDs)
+	return nil
+}
+
+// SetInvitationEvents replaces the events an invitation covers, keeping them in the given order. Each
+// event must exist and can only invite guests on the invitation, so set its guests first. Responses to
+// events the invitation no longer covers are deleted.
+func (a *InvitationsMemoryAccess) SetInvitationEvents(tx Tx, invitationID int64, events []models.InvitationEvent) error {
+	tables := memTx(tx).tables
+	if err := checkInvitationEvents(events, tables.invitationGuests[invitationID]); err != nil {
+		return err
+	}
+
+	covered := map[int64]bool{}
+	var links []models.InvitationEvent
+	for position, invitationEvent := range events {
+		event, ok := tables.events[invitationEvent.EventID]
+		if !ok || event.OrganizationID != OrganizationID(tx) || event.DeletedAt != nil {
+			return utils.ArgumentError.Here().WithMessagef("Event %d does not exist", invitationEvent.EventID)
+		}
+		covered[invitationEvent.EventID] = true
+		links = append(links, models.InvitationEvent{
+			InvitationID: invitationID,
+			EventID:      invitationEvent.EventID,
+			Position:     position,
+			GuestIDs:     storedGuestIDs(invitationEvent.GuestIDs),
+		})
+	}
+
+	for id, rsvpGuest := range tables.rsvpGuests {
+		if rsvp, ok := tables.rsvps[rsvpGuest.RsvpID]; ok && rsvp.InvitationID == invitationID && !covered[rsvpGuest.EventID] {
+			delete(tables.rsvpGuests, id)
+			delete(tables.seats, id)
+		}
+	}
+	tables.invitationEvents[invitationID] = links
+	return nil
+}
+
+// invitationEventIDs gets the ids of the events an invitation covers
+func invitationEventIDs(tables *memoryTables, invitationID int64) []int64 {
+	eventIDs := []int64{}
+	for _, invitationEvent := range tables.invitationEvents[invitationID] {
+		eventIDs = append(eventIDs, invitationEvent.EventID)
+	}
+	return eventIDs
+}
+
+// BuildGuestIDs creates an invitation's new guests, which have no ID, and returns the IDs of all of them
+func (a *InvitationsMemoryAccess) BuildGuestIDs(tx Tx, invitationID int64, guests *[]models.Guest) ([]int64, error) {
+	var guestIDs []int64
+	if guests == nil {
+		return guestIDs, nil
+	}
+	for _, guest := range *guests {
+		if guest.ID == 0 {
+			guest.InvitationID = invitationID
+			guest.PlusOne = false
+			newGuest, err := a.guestAccess.CreateGuest(tx, &guest)
+			if err != nil {
+				return nil, err
+			}
+			guest.ID = newGuest.ID
+		}
+		guestIDs = append(guestIDs, guest.ID)
+	}
+	return guestIDs, nil
+}
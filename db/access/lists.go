@@ -0,0 +1,260 @@
+package access
+
+import (
+	"fmt"
+	"github.com/go-pg/pg/v9/orm"
+	"github.com/kyrstenkelly/rsvp-api/utils"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// List paging defaults
+const (
+	DefaultListLimit = 100
+	MaxListLimit     = 500
+)
+
+// ListFieldKind is the type of a list field's values
+type ListFieldKind int
+
+// List field kinds
+const (
+	ListInt ListFieldKind = iota
+	ListBool
+	ListString
+)
+
+// ListField is a field a list can be filtered or sorted by
+type ListField struct {
+	// Column is the sql expression for the field's value
+	Column string
+	Kind   ListFieldKind
+	// Filter allows ?name=value, and Sort allows ?sort=name or ?sort=-name
+	Filter bool
+	Sort   bool
+	// Any marks a field whose Column is an array, which ?name=value filters to rows whose array holds value
+	Any bool
+}
+
+// ListSpec describes how a list can be filtered, searched and sorted. Every spec has an "id" field,
+// which breaks ties when sorting so pages don't overlap.
+type ListSpec struct {
+	Fields map[string]ListField
+	// Search names the string fields that ?q= matches, case insensitively
+	Search []string
+}
+
+// ListQuery narrows, orders and pages a list. The zero value lists every row in id order.
+type ListQuery struct {
+	Filters map[string]interface{}
+	Search  string
+	Sort    string
+	Desc    bool
+	Limit   int
+	Offset  int
+}
+
+// ParseListQuery reads the limit, offset, sort, q and filter parameters of a list request,
+// rejecting any the spec doesn't allow
+func ParseListQuery(spec ListSpec, values url.Values) (ListQuery, error) {
+	query := ListQuery{
+		Filters: map[string]interface{}{},
+		Limit:   DefaultListLimit,
+	}
+	for name, params := range values {
+		value := params[0]
+		switch name {
+		case "limit":
+			limit, err := strconv.Atoi(value)
+			if err != nil || limit < 1 || limit > MaxListLimit {
+				return query, utils.ArgumentError.Here().WithMessagef("limit must be between 1 and %d", MaxListLimit)
+			}
+			query.Limit = limit
+		case "offset":
+			offset, err := strconv.Atoi(value)
+			if err != nil || offset < 0 {
+				return query, utils.ArgumentError.Here().WithMessage("offset must be a positive number")
+			}
+			query.Offset = offset
+		case "sort":
+			field := strings.TrimPrefix(value, "-")
+			if !spec.Fields[field].Sort {
+				return query, utils.ArgumentError.Here().WithMessagef("Cannot sort by %q", field)
+			}
+			query.Sort = field
+			query.Desc = strings.HasPrefix(value, "-")
+		case "q":
+			if len(spec.Search) == 0 {
+				return query, utils.ArgumentError.Here().WithMessage("This list cannot be searched")
+			}
+			query.Search = value
+		default:
+			field, ok := spec.Fields[name]
+			if !ok || !field.Filter {
+				return query, utils.ArgumentError.Here().WithMessagef("Unknown parameter %q", name)
+			}
+			filter, err := parseListValue(field.Kind, value)
+			if err != nil {
+				return query, utils.ArgumentError.Here().WithMessagef("Invalid %s: %s", name, err)
+			}
+			query.Filters[name] = filter
+		}
+	}
+	return query, nil
+}
+
+func parseListValue(kind ListFieldKind, value string) (interface{}, error) {
+	switch kind {
+	case ListInt:
+		i, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a number", value)
+		}
+		return i, nil
+	case ListBool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not true or false", value)
+		}
+		return b, nil
+	}
+	return value, nil
+}
+
+// sortField gets the field a list is sorted by
+func (list ListQuery) sortField() string {
+	if list.Sort == "" {
+		return "id"
+	}
+	return list.Sort
+}
+
+// applyListQuery adds a list's filters, search, order and page to a query. Use SelectAndCount
+// to get the total before paging.
+func applyListQuery(query *orm.Query, spec ListSpec, list ListQuery) *orm.Query {
+	for name, value := range list.Filters {
+		if spec.Fields[name].Any {
+			query = query.Where("? = ANY ("+spec.Fields[name].Column+")", value)
+			continue
+		}
+		query = query.Where("("+spec.Fields[name].Column+") = ?", value)
+	}
+	if list.Search != "" {
+		pattern := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(list.Search) + "%"
+		query = query.WhereGroup(func(q *orm.Query) (*orm.Query, error) {
+			for _, name := range spec.Search {
+				q = q.WhereOr(spec.Fields[name].Column+" ILIKE ?", pattern)
+			}
+			return q, nil
+		})
+	}
+
+	direction := " ASC"
+	if list.Desc {
+		direction = " DESC"
+	}
+	query = query.OrderExpr(spec.Fields[list.sortField()].Column + direction)
+	if list.sortField() != "id" {
+		query = query.OrderExpr(spec.Fields["id"].Column + direction)
+	}
+	if list.Limit > 0 {
+		query = query.Limit(list.Limit)
+	}
+	return query.Offset(list.Offset)
+}
+
+// listMemoryRows does what applyListQuery does in sql for rows held in memory. values gets the
+// fields of the i-th of n rows by name. It returns the indexes of the rows on the page, in order,
+// along with the total number of rows that matched.
+func listMemoryRows(n int, values func(i int) map[string]interface{}, spec ListSpec, list ListQuery) ([]int, int) {
+	var matched []int
+	rows := make([]map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		rows[i] = values(i)
+		if memoryRowMatches(rows[i], spec, list) {
+			matched = append(matched, i)
+		}
+	}
+
+	field := list.sortField()
+	sort.SliceStable(matched, func(i, j int) bool {
+		x, y := rows[matched[i]], rows[matched[j]]
+		if c := compareListValues(x[field], y[field]); c != 0 {
+			return (c < 0) != list.Desc
+		}
+		return (compareListValues(x["id"], y["id"]) < 0) != list.Desc
+	})
+
+	total := len(matched)
+	if list.Offset >= total {
+		return []int{}, total
+	}
+	matched = matched[list.Offset:]
+	if list.Limit > 0 && len(matched) > list.Limit {
+		matched = matched[:list.Limit]
+	}
+	return matched, total
+}
+
+func memoryRowMatches(row map[string]interface{}, spec ListSpec, list ListQuery) bool {
+	for name, value := range list.Filters {
+		if spec.Fields[name].Any {
+			if !listValuesContain(row[name], value) {
+				return false
+			}
+			continue
+		}
+		if row[name] != value {
+			return false
+		}
+	}
+	if list.Search == "" {
+		return true
+	}
+	search := strings.ToLower(list.Search)
+	for _, name := range spec.Search {
+		if value, ok := row[name].(string); ok && strings.Contains(strings.ToLower(value), search) {
+			return true
+		}
+	}
+	return false
+}
+
+// listValuesContain reports whether the []int64 or []string values of an Any field hold value
+func listValuesContain(values interface{}, value interface{}) bool {
+	switch values := values.(type) {
+	case []int64:
+		for _, v := range values {
+			if v == value {
+				return true
+			}
+		}
+	case []string:
+		return containsString(values, value.(string))
+	}
+	return false
+}
+
+func compareListValues(x interface{}, y interface{}) int {
+	switch x := x.(type) {
+	case int64:
+		y, _ := y.(int64)
+		if x < y {
+			return -1
+		} else if x > y {
+			return 1
+		}
+	case string:
+		return strings.Compare(x, y.(string))
+	case bool:
+		y, _ := y.(bool)
+		if !x && y {
+			return -1
+		} else if x && !y {
+			return 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,228 @@
+package access
+
+import (
+	"context"
+	"github.com/kyrstenkelly/rsvp-api/db/models"
+	"github.com/kyrstenkelly/rsvp-api/utils"
+	"sort"
+	"sync"
+	"time"
+)
+
+// memoryTables holds the rows of a MemoryTransactor. Rows are stored without their relations
+// (an event's Address, an invitation's Guests and so on), which the memory DAOs fill in when
+// they are read, just as the postgres DAOs join them. Slices on stored rows are never modified
+// in place, so copying the maps is enough to snapshot the tables.
+type memoryTables struct {
+	sequences          map[string]int64
+	organizations      map[int64]models.Organization
+	addresses          map[int64]models.Address
+	events             map[int64]models.Event
+	guests             map[int64]models.Guest
+	invitations        map[int64]models.Invitation
+	invitationGuests   map[int64][]int64
+	invitationEvents   map[int64][]models.InvitationEvent
+	rsvps              map[int64]models.RSVP
+	rsvpGuests         map[int64]models.RSVPGuest
+	notifications      map[int64]models.Notification
+	campaigns          map[int64]models.ReminderCampaign
+	auditEntries       map[int64]models.AuditEntry
+	webhooks           map[int64]models.Webhook
+	webhookDeliveries  map[int64]models.WebhookDelivery
+	seatingTables      map[int64]models.SeatingTable
+	seats              map[int64]int64
+	seatingConstraints map[int64]models.SeatingConstraint
+}
+
+func newMemoryTables() *memoryTables {
+	return &memoryTables{
+		sequences:          map[string]int64{},
+		organizations:      map[int64]models.Organization{},
+		addresses:          map[int64]models.Address{},
+		events:             map[int64]models.Event{},
+		guests:             map[int64]models.Guest{},
+		invitations:        map[int64]models.Invitation{},
+		invitationGuests:   map[int64][]int64{},
+		invitationEvents:   map[int64][]models.InvitationEvent{},
+		rsvps:              map[int64]models.RSVP{},
+		rsvpGuests:         map[int64]models.RSVPGuest{},
+		notifications:      map[int64]models.Notification{},
+		campaigns:          map[int64]models.ReminderCampaign{},
+		auditEntries:       map[int64]models.AuditEntry{},
+		webhooks:           map[int64]models.Webhook{},
+		webhookDeliveries:  map[int64]models.WebhookDelivery{},
+		seatingTables:      map[int64]models.SeatingTable{},
+		seats:              map[int64]int64{},
+		seatingConstraints: map[int64]models.SeatingConstraint{},
+	}
+}
+
+// clone copies the tables so a transaction can change them without touching the committed rows
+func (t *memoryTables) clone() *memoryTables {
+	c := newMemoryTables()
+	for k, v := range t.sequences {
+		c.sequences[k] = v
+	}
+	for k, v := range t.organizations {
+		c.organizations[k] = v
+	}
+	for k, v := range t.addresses {
+		c.addresses[k] = v
+	}
+	for k, v := range t.events {
+		c.events[k] = v
+	}
+	for k, v := range t.guests {
+		c.guests[k] = v
+	}
+	for k, v := range t.invitations {
+		c.invitations[k] = v
+	}
+	for k, v := range t.invitationGuests {
+		c.invitationGuests[k] = v
+	}
+	for k, v := range t.invitationEvents {
+		c.invitationEvents[k] = v
+	}
+	for k, v := range t.rsvps {
+		c.rsvps[k] = v
+	}
+	for k, v := range t.rsvpGuests {
+		c.rsvpGuests[k] = v
+	}
+	for k, v := range t.notifications {
+		c.notifications[k] = v
+	}
+	for k, v := range t.campaigns {
+		c.campaigns[k] = v
+	}
+	for k, v := range t.auditEntries {
+		c.auditEntries[k] = v
+	}
+	for k, v := range t.webhooks {
+		c.webhooks[k] = v
+	}
+	for k, v := range t.webhookDeliveries {
+		c.webhookDeliveries[k] = v
+	}
+	for k, v := range t.seatingTables {
+		c.seatingTables[k] = v
+	}
+	for k, v := range t.seats {
+		c.seats[k] = v
+	}
+	for k, v := range t.seatingConstraints {
+		c.seatingConstraints[k] = v
+	}
+	return c
+}
+
+// nextID works like a bigserial column, starting each table at 1
+func (t *memoryTables) nextID(table string) int64 {
+	t.sequences[table]++
+	return t.sequences[table]
+}
+
+// memoryTx is a Tx backed by a working copy of a MemoryTransactor's tables
+type memoryTx struct {
+	ctx    context.Context
+	tables *memoryTables
+	now    time.Time
+}
+
+// Context gets the context the transaction was begun with
+func (tx *memoryTx) Context() context.Context {
+	return tx.ctx
+}
+
+// memTx gets the memory transaction behind a Tx. The memory DAOs may only be used with a
+// MemoryTransactor.
+func memTx(tx Tx) *memoryTx {
+	return tx.(*memoryTx)
+}
+
+// MemoryTransactor runs units of work against tables held in memory, for tests and running the
+// API without postgres. Transactions run one at a time on a copy of the tables, which replaces
+// them when the transaction commits, so they are serializable and roll back cleanly.
+type MemoryTransactor struct {
+	mu     sync.Mutex
+	tables *memoryTables
+}
+
+// NewMemoryTransactor creates a transactor with empty tables, apart from the default organization
+// that the migrations create
+func NewMemoryTransactor() *MemoryTransactor {
+	tables := newMemoryTables()
+	id := tables.nextID("organizations")
+	tables.organizations[id] = models.Organization{
+		ID:        id,
+		Slug:      "default",
+		Name:      "Default",
+		CreatedAt: time.Now(),
+	}
+	return &MemoryTransactor{tables: tables}
+}
+
+// RunInTransaction runs fn on a copy of the tables, committing the copy if fn returns nil and
+// ctx hasn't been cancelled in the meantime
+func (t *MemoryTransactor) RunInTransaction(ctx context.Context, fn func(tx Tx) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	tx := &memoryTx{ctx: ctx, tables: t.tables.clone(), now: time.Now()}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	t.tables = tx.tables
+	return nil
+}
+
+// foreignKeyError is returned in place of postgres' foreign key violations
+func foreignKeyError(table string, id int64, referencedBy string) error {
+	return utils.ArgumentError.Here().WithMessagef("%s %d is still referenced from %s", table, id, referencedBy)
+}
+
+// uniqueError is returned in place of postgres' unique violations
+func uniqueError(table string, column string, value interface{}) error {
+	return utils.ArgumentError.Here().WithMessagef("%s with %s %v already exists", table, column, value)
+}
+
+func copyStrings(values []string) []string {
+	if values == nil {
+		return nil
+	}
+	return append([]string{}, values...)
+}
+
+func copyInts(values []int) []int {
+	if values == nil {
+		return nil
+	}
+	return append([]int{}, values...)
+}
+
+func copyInt64s(values []int64) []int64 {
+	if values == nil {
+		return nil
+	}
+	return append([]int64{}, values...)
+}
+
+func sortInt64s(values []int64) {
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+}
+
+func containsInt64(values []int64, value int64) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,122 @@
+package access
+
+import (
+	"github.com/go-pg/pg/v9"
+	"github.com/kyrstenkelly/rsvp-api/db/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// claimableNotification matches notifications that may be (re)sent: ones that failed, and ones
+// whose sender has held them for so long it has probably died mid-send. ClaimNotification
+// repeats it with qualified columns, as ON CONFLICT needs.
+const claimableNotification = `status = 'failed'
+	OR (status = 'sending' AND claimed_at < now() - interval '10 minutes')`
+
+// NotificationsPostgresAccess postgres implementation of a NotificationsDAO
+type NotificationsPostgresAccess struct {
+}
+
+// NotificationsAccess interface for a notifications data access object
+type NotificationsAccess interface {
+	GetNotifications(tx Tx, invitationID int64, kind string) ([]models.Notification, error)
+	ClaimNotification(tx Tx, notification *models.Notification) (*models.Notification, error)
+	ClaimRetryableNotifications(tx Tx, limit int) ([]models.Notification, error)
+	MarkNotificationSent(tx Tx, id int64) error
+	MarkNotificationFailed(tx Tx, id int64, sendErr string) error
+}
+
+// NewNotificationsDAO Create a new notifications dao
+func NewNotificationsDAO() NotificationsAccess {
+	return &NotificationsPostgresAccess{}
+}
+
+// GetNotifications gets the notifications sent, newest first. Filter by invitation and kind
+// by passing a non-zero invitationID or non-empty kind.
+func (a *NotificationsPostgresAccess) GetNotifications(tx Tx, invitationID int64, kind string) ([]models.Notification, error) {
+	ptx := pgTx(tx)
+	notifications := []models.Notification{}
+	query := ptx.Model(&notifications).Where("notification.organization_id = ?", OrganizationID(tx))
+	if invitationID > 0 {
+		query = query.Where("notification.invitation_id = ?", invitationID)
+	}
+	if kind != "" {
+		query = query.Where("notification.kind = ?", kind)
+	}
+	err := query.Order("notification.id DESC").Select()
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	return notifications, nil
+}
+
+// ClaimNotification records a notification that is about to be sent. If a notification with the same
+// idempotency key has already been sent, or is being sent by someone else, it returns nil and the
+// caller must not send it.
+func (a *NotificationsPostgresAccess) ClaimNotification(tx Tx, notification *models.Notification) (*models.Notification, error) {
+	ptx := pgTx(tx)
+	claimed := new(models.Notification)
+	_, err := ptx.QueryOne(claimed,
+		`INSERT INTO notifications
+			(kind, idempotency_key, invitation_id, recipients, subject, text_body, html_body, organization_id)
+		VALUES
+			(?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (idempotency_key) DO UPDATE
+		SET status = 'sending', attempts = notifications.attempts + 1, claimed_at = now(), error = NULL
+		WHERE notifications.status = 'failed'
+			OR (notifications.status = 'sending' AND notifications.claimed_at < now() - interval '10 minutes')
+		RETURNING *`,
+		notification.Kind, notification.IdempotencyKey, notification.InvitationID, pg.Array(notification.Recipients),
+		notification.Subject, notification.TextBody, notification.HTMLBody, OrganizationID(tx))
+	if err == pg.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	return claimed, nil
+}
+
+// ClaimRetryableNotifications claims up to limit notifications that need to be sent again
+func (a *NotificationsPostgresAccess) ClaimRetryableNotifications(tx Tx, limit int) ([]models.Notification, error) {
+	ptx := pgTx(tx)
+	notifications := []models.Notification{}
+	_, err := ptx.Query(&notifications,
+		`UPDATE notifications
+		SET status = 'sending', attempts = attempts + 1, claimed_at = now(), error = NULL
+		WHERE id IN (
+			SELECT id FROM notifications
+			WHERE organization_id = ? AND (`+claimableNotification+`)
+			ORDER BY id
+			LIMIT ?
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING *`, OrganizationID(tx), limit)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	return notifications, nil
+}
+
+// MarkNotificationSent records that a notification was sent
+func (a *NotificationsPostgresAccess) MarkNotificationSent(tx Tx, id int64) error {
+	ptx := pgTx(tx)
+	_, err := ptx.Exec(`UPDATE notifications SET status = 'sent', sent_at = now() WHERE id = ? AND organization_id = ?`,
+		id, OrganizationID(tx))
+	if err != nil {
+		log.Error(err)
+	}
+	return err
+}
+
+// MarkNotificationFailed records that sending a notification failed, so it can be retried
+func (a *NotificationsPostgresAccess) MarkNotificationFailed(tx Tx, id int64, sendErr string) error {
+	ptx := pgTx(tx)
+	_, err := ptx.Exec(`UPDATE notifications SET status = 'failed', error = ? WHERE id = ? AND organization_id = ?`,
+		sendErr, id, OrganizationID(tx))
+	if err != nil {
+		log.Error(err)
+	}
+	return err
+}
@@ -0,0 +1,151 @@
+package access
+
+import (
+	"github.com/kyrstenkelly/rsvp-api/db/models"
+	"time"
+)
+
+// NotificationsMemoryAccess in-memory implementation of a NotificationsDAO
+type NotificationsMemoryAccess struct {
+}
+
+// NewNotificationsMemoryDAO Create a new in-memory notifications dao
+func NewNotificationsMemoryDAO() NotificationsAccess {
+	return &NotificationsMemoryAccess{}
+}
+
+// storedNotification copies a notification for the tables
+func storedNotification(notification models.Notification) models.Notification {
+	notification.Recipients = copyStrings(notification.Recipients)
+	if notification.InvitationID != nil {
+		invitationID := *notification.InvitationID
+		notification.InvitationID = &invitationID
+	}
+	if notification.SentAt != nil {
+		sentAt := *notification.SentAt
+		notification.SentAt = &sentAt
+	}
+	return notification
+}
+
+// claimable matches the same notifications as claimableNotification
+func claimable(notification models.Notification, now time.Time) bool {
+	return notification.Status == models.NotificationFailed ||
+		(notification.Status == models.NotificationSending && notification.ClaimedAt.Before(now.Add(-10*time.Minute)))
+}
+
+// GetNotifications gets the notifications sent, newest first. Filter by invitation and kind
+// by passing a non-zero invitationID or non-empty kind.
+func (a *NotificationsMemoryAccess) GetNotifications(tx Tx, invitationID int64, kind string) ([]models.Notification, error) {
+	tables := memTx(tx).tables
+	var ids []int64
+	for id, notification := range tables.notifications {
+		if notification.OrganizationID != OrganizationID(tx) {
+			continue
+		}
+		if invitationID > 0 && (notification.InvitationID == nil || *notification.InvitationID != invitationID) {
+			continue
+		}
+		if kind != "" && notification.Kind != kind {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sortInt64s(ids)
+
+	notifications := []models.Notification{}
+	for i := len(ids) - 1; i >= 0; i-- {
+		notifications = append(notifications, storedNotification(tables.notifications[ids[i]]))
+	}
+	return notifications, nil
+}
+
+// ClaimNotification records a notification that is about to be sent. If a notification with the same
+// idempotency key has already been sent, or is being sent by someone else, it returns nil and the
+// caller must not send it.
+func (a *NotificationsMemoryAccess) ClaimNotification(tx Tx, notification *models.Notification) (*models.Notification, error) {
+	tables := memTx(tx).tables
+	now := memTx(tx).now
+	for id, existing := range tables.notifications {
+		if existing.IdempotencyKey != notification.IdempotencyKey {
+			continue
+		}
+		if !claimable(existing, now) {
+			return nil, nil
+		}
+		existing.Status = models.NotificationSending
+		existing.Attempts++
+		existing.ClaimedAt = now
+		existing.Error = ""
+		tables.notifications[id] = existing
+		claimed := storedNotification(existing)
+		return &claimed, nil
+	}
+
+	claimed := storedNotification(*notification)
+	claimed.ID = tables.nextID("notifications")
+	claimed.OrganizationID = OrganizationID(tx)
+	claimed.Status = models.NotificationSending
+	claimed.Attempts = 1
+	claimed.Error = ""
+	claimed.CreatedAt = now
+	claimed.ClaimedAt = now
+	claimed.SentAt = nil
+	tables.notifications[claimed.ID] = claimed
+	return &claimed, nil
+}
+
+// ClaimRetryableNotifications claims up to limit notifications that need to be sent again
+func (a *NotificationsMemoryAccess) ClaimRetryableNotifications(tx Tx, limit int) ([]models.Notification, error) {
+	tables := memTx(tx).tables
+	now := memTx(tx).now
+	var ids []int64
+	for id, notification := range tables.notifications {
+		if notification.OrganizationID == OrganizationID(tx) && claimable(notification, now) {
+			ids = append(ids, id)
+		}
+	}
+	sortInt64s(ids)
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+
+	notifications := []models.Notification{}
+	for _, id := range ids {
+		notification := tables.notifications[id]
+		notification.Status = models.NotificationSending
+		notification.Attempts++
+		notification.ClaimedAt = now
+		notification.Error = ""
+		tables.notifications[id] = notification
+		notifications = append(notifications, storedNotification(notification))
+	}
+	return notifications, nil
+}
+
+// MarkNotificationSent records that a notification was sent
+func (a *NotificationsMemoryAccess) MarkNotificationSent(tx Tx, id int64) error {
+	tables := memTx(tx).tables
+	notification, ok := tables.notifications[id]
+	if !ok || notification.OrganizationID != OrganizationID(tx) {
+		return nil
+	}
+	sentAt := memTx(tx).now
+	notification.Status = models.NotificationSent
+	notification.SentAt = &sentAt
+	tables.notifications[id] = notification
+	return nil
+}
+
+// MarkNotificationFailed records that sending a notification failed, so it can be retried
+func (a *NotificationsMemoryAccess) MarkNotificationFailed(tx Tx, id int64, sendErr string) error {
+	tables := memTx(tx).tables
+	notification, ok := tables.notifications[id]
+	if !ok || notification.OrganizationID != OrganizationID(tx) {
+		return nil
+	}
+	notification.Status = models.NotificationFailed
+	notification.Error = sendErr
+	tables.notifications[id] = notification
+	return nil
+}
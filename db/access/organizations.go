@@ -0,0 +1,91 @@
+package access
+
+import (
+	"context"
+	"github.com/go-pg/pg/v9"
+	"github.com/kyrstenkelly/rsvp-api/db/models"
+	log "github.com/sirupsen/logrus"
+)
+
+type organizationKey struct{}
+
+// WithOrganization returns a copy of ctx scoped to an organization. Every DAO only reads and
+// writes the rows of the organization its transaction was begun with.
+func WithOrganization(ctx context.Context, organizationID int64) context.Context {
+	return context.WithValue(ctx, organizationKey{}, organizationID)
+}
+
+// ContextOrganizationID gets the organization ctx is scoped to, or 0 if it isn't
+func ContextOrganizationID(ctx context.Context) int64 {
+	organizationID, _ := ctx.Value(organizationKey{}).(int64)
+	return organizationID
+}
+
+// OrganizationID gets the organization a transaction is scoped to. It is 0 for transactions
+// that weren't begun with WithOrganization, which matches no rows.
+func OrganizationID(tx Tx) int64 {
+	return ContextOrganizationID(tx.Context())
+}
+
+// BackgroundContext returns a context for work that outlives the request ctx came from,
+// scoped to the same organization
+func BackgroundContext(ctx context.Context) context.Context {
+	return WithOrganization(context.Background(), ContextOrganizationID(ctx))
+}
+
+// OrganizationsPostgresAccess postgres implementation of an OrganizationsDAO
+type OrganizationsPostgresAccess struct {
+}
+
+// OrganizationsAccess interface for an organizations data access object. Unlike the other
+// DAOs it isn't scoped to an organization, since it is how requests find theirs.
+type OrganizationsAccess interface {
+	GetOrganizations(tx Tx) ([]models.Organization, error)
+	GetOrganizationBySlug(tx Tx, slug string) (*models.Organization, error)
+	CreateOrganization(tx Tx, organization *models.Organization) (*models.Organization, error)
+}
+
+// NewOrganizationsDAO Create a new organizations dao
+func NewOrganizationsDAO() OrganizationsAccess {
+	return &OrganizationsPostgresAccess{}
+}
+
+// GetOrganizations gets all organizations
+func (a *OrganizationsPostgresAccess) GetOrganizations(tx Tx) ([]models.Organization, error) {
+	ptx := pgTx(tx)
+	organizations := []models.Organization{}
+	err := ptx.Model(&organizations).Order("organization.id").Select()
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	return organizations, nil
+}
+
+// GetOrganizationBySlug gets an organization by its slug
+func (a *OrganizationsPostgresAccess) GetOrganizationBySlug(tx Tx, slug string) (*models.Organization, error) {
+	ptx := pgTx(tx)
+	organization := new(models.Organization)
+	err := ptx.Model(organization).
+		Where("organization.slug = ?", slug).
+		Select()
+
+	if err == pg.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	return organization, nil
+}
+
+// CreateOrganization creates an organization
+func (a *OrganizationsPostgresAccess) CreateOrganization(tx Tx, organization *models.Organization) (*models.Organization, error) {
+	ptx := pgTx(tx)
+	_, err := ptx.Model(organization).Returning("*").Insert()
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	return organization, nil
+}
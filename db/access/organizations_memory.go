@@ -0,0 +1,53 @@
+package access
+
+import (
+	"github.com/kyrstenkelly/rsvp-api/db/models"
+)
+
+// OrganizationsMemoryAccess in-memory implementation of an OrganizationsDAO
+type OrganizationsMemoryAccess struct {
+}
+
+// NewOrganizationsMemoryDAO Create a new in-memory organizations dao
+func NewOrganizationsMemoryDAO() OrganizationsAccess {
+	return &OrganizationsMemoryAccess{}
+}
+
+// GetOrganizations gets all organizations
+func (a *OrganizationsMemoryAccess) GetOrganizations(tx Tx) ([]models.Organization, error) {
+	tables := memTx(tx).tables
+	var ids []int64
+	for id := range tables.organizations {
+		ids = append(ids, id)
+	}
+	sortInt64s(ids)
+
+	organizations := []models.Organization{}
+	for _, id := range ids {
+		organizations = append(organizations, tables.organizations[id])
+	}
+	return organizations, nil
+}
+
+// GetOrganizationBySlug gets an organization by its slug
+func (a *OrganizationsMemoryAccess) GetOrganizationBySlug(tx Tx, slug string) (*models.Organization, error) {
+	for _, organization := range memTx(tx).tables.organizations {
+		if organization.Slug == slug {
+			return &organization, nil
+		}
+	}
+	return nil, nil
+}
+
+// CreateOrganization creates an organization
+func (a *OrganizationsMemoryAccess) CreateOrganization(tx Tx, organization *models.Organization) (*models.Organization, error) {
+	existing, _ := a.GetOrganizationBySlug(tx, organization.Slug)
+	if existing != nil {
+		return nil, uniqueError("organizations", "slug", organization.Slug)
+	}
+	tables := memTx(tx).tables
+	organization.ID = tables.nextID("organizations")
+	organization.CreatedAt = memTx(tx).now
+	tables.organizations[organization.ID] = *organization
+	return organization, nil
+}
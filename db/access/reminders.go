@@ -0,0 +1,184 @@
+package access
+
+import (
+	"github.com/go-pg/pg/v9"
+	"github.com/kyrstenkelly/rsvp-api/db/models"
+	log "github.com/sirupsen/logrus"
+	"strings"
+)
+
+// ReminderCampaignsPostgresAccess postgres implementation of a ReminderCampaignsDAO
+type ReminderCampaignsPostgresAccess struct {
+}
+
+// ReminderCampaignsAccess interface for a reminder campaigns data access object
+type ReminderCampaignsAccess interface {
+	GetCampaigns(tx Tx) ([]models.ReminderCampaign, error)
+	GetActiveCampaigns(tx Tx) ([]models.ReminderCampaign, error)
+	GetCampaign(tx Tx, id int64) (*models.ReminderCampaign, error)
+	CreateCampaign(tx Tx, campaign *models.ReminderCampaign) (*models.ReminderCampaign, error)
+	UpdateCampaign(tx Tx, campaign *models.ReminderCampaign) (*models.ReminderCampaign, error)
+	SetCampaignPaused(tx Tx, id int64, paused bool) (*models.ReminderCampaign, error)
+	DeleteCampaign(tx Tx, id int64, version int64) (*models.ReminderCampaign, error)
+	GetReminderRecipients(tx Tx, campaign *models.ReminderCampaign, offsetDays int) ([]models.ReminderRecipient, error)
+}
+
+// NewReminderCampaignsDAO Create a new reminder campaigns dao
+func NewReminderCampaignsDAO() ReminderCampaignsAccess {
+	return &ReminderCampaignsPostgresAccess{}
+}
+
+// GetCampaigns gets all reminder campaigns
+func (a *ReminderCampaignsPostgresAccess) GetCampaigns(tx Tx) ([]models.ReminderCampaign, error) {
+	ptx := pgTx(tx)
+	campaigns := []models.ReminderCampaign{}
+	err := ptx.Model(&campaigns).
+		Where("reminder_campaign.organization_id = ?", OrganizationID(tx)).
+		Order("reminder_campaign.id").
+		Select()
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	return campaigns, nil
+}
+
+// GetActiveCampaigns gets the campaigns that aren't paused, and whose event isn't in the trash, in every
+// organization, for the scheduler.
+// Work on each campaign must then be scoped to its organization.
+func (a *ReminderCampaignsPostgresAccess) GetActiveCampaigns(tx Tx) ([]models.ReminderCampaign, error) {
+	ptx := pgTx(tx)
+	campaigns := []models.ReminderCampaign{}
+	err := ptx.Model(&campaigns).
+		Where("NOT reminder_campaign.paused").
+		Where("EXISTS (SELECT 1 FROM events AS e WHERE e.id = reminder_campaign.event_id AND e.deleted_at IS NULL)").
+		Order("reminder_campaign.id").
+		Select()
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	return campaigns, nil
+}
+
+// GetCampaign gets a reminder campaign by id
+func (a *ReminderCampaignsPostgresAccess) GetCampaign(tx Tx, id int64) (*models.ReminderCampaign, error) {
+	ptx := pgTx(tx)
+	campaign := new(models.ReminderCampaign)
+	err := ptx.Model(campaign).
+		Where("reminder_campaign.id = ?", id).
+		Where("reminder_campaign.organization_id = ?", OrganizationID(tx)).
+		Select()
+
+	if err == pg.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	return campaign, nil
+}
+
+// CreateCampaign creates a reminder campaign
+func (a *ReminderCampaignsPostgresAccess) CreateCampaign(tx Tx, campaign *models.ReminderCampaign) (*models.ReminderCampaign, error) {
+	ptx := pgTx(tx)
+	campaign.OrganizationID = OrganizationID(tx)
+	campaign.Version = 1
+	_, err := ptx.Model(campaign).Returning("*").Insert()
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	return campaign, nil
+}
+
+// UpdateCampaign updates the name and offsets of a reminder campaign, if its version is the given campaign's Version
+func (a *ReminderCampaignsPostgresAccess) UpdateCampaign(tx Tx, campaign *models.ReminderCampaign) (*models.ReminderCampaign, error) {
+	ptx := pgTx(tx)
+	q := []string{"version = version + 1"}
+	if campaign.Name != "" {
+		q = append(q, "name = ?name")
+	}
+	if campaign.OffsetDays != nil {
+		q = append(q, "offset_days = ?offset_days")
+	}
+
+	result, err := ptx.Model(campaign).Set(strings.Join(q, ", ")).
+		Where("id = ?id").
+		Where("organization_id = ?", OrganizationID(tx)).
+		Apply(whereVersion(campaign.Version)).
+		Update()
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	if err := checkVersion(result, campaign.Version); err != nil {
+		return nil, err
+	}
+	return a.GetCampaign(tx, campaign.ID)
+}
+
+// SetCampaignPaused pauses or resumes a reminder campaign
+func (a *ReminderCampaignsPostgresAccess) SetCampaignPaused(tx Tx, id int64, paused bool) (*models.ReminderCampaign, error) {
+	ptx := pgTx(tx)
+	_, err := ptx.Exec(`UPDATE reminder_campaigns SET paused = ?, version = version + 1 WHERE id = ? AND organization_id = ?`,
+		paused, id, OrganizationID(tx))
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	return a.GetCampaign(tx, id)
+}
+
+// DeleteCampaign deletes a reminder campaign, if it is at the given version. Reminders it has sent stay in notifications.
+func (a *ReminderCampaignsPostgresAccess) DeleteCampaign(tx Tx, id int64, version int64) (*models.ReminderCampaign, error) {
+	ptx := pgTx(tx)
+	result, err := ptx.Model((*models.ReminderCampaign)(nil)).
+		Where("id = ?", id).
+		Where("organization_id = ?", OrganizationID(tx)).
+		Apply(whereVersion(version)).
+		Delete()
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	return nil, checkVersion(result, version)
+}
+
+// GetReminderRecipients gets the invitations to the campaign's event that have an email, haven't
+// responded to that event and haven't been sent the campaign's reminder for offsetDays. The key
+// matches models.ReminderKey.
+func (a *ReminderCampaignsPostgresAccess) GetReminderRecipients(tx Tx, campaign *models.ReminderCampaign, offsetDays int) ([]models.ReminderRecipient, error) {
+	ptx := pgTx(tx)
+	recipients := []models.ReminderRecipient{}
+	_, err := ptx.Query(&recipients,
+		`SELECT
+			i.id AS invitation_id,
+			i.name,
+			i.email,
+			count(n.id) AS reminders_sent,
+			max(n.sent_at) AS last_reminded_at
+		FROM invitations i
+		LEFT JOIN notifications n
+			ON n.invitation_id = i.id AND n.kind = ?0 AND n.status = 'sent'
+		WHERE i.organization_id = ?2 AND i.deleted_at IS NULL
+			AND EXISTS (SELECT 1 FROM invitation_events ie WHERE ie.invitation_id = i.id AND ie.event_id = ?1)
+			AND coalesce(i.email, '') <> ''
+			AND NOT EXISTS (
+				SELECT 1 FROM rsvps r
+				JOIN rsvp_guests rg ON rg.rsvp_id = r.id
+				WHERE r.invitation_id = i.id AND r.deleted_at IS NULL AND rg.event_id = ?1
+			)
+			AND NOT EXISTS (
+				SELECT 1 FROM notifications s
+				WHERE s.idempotency_key = format('%s:%s:%s:%s', ?0, ?3, i.id, ?4)
+			)
+		GROUP BY i.id
+		ORDER BY i.id`,
+		models.NotificationReminder, campaign.EventID, OrganizationID(tx), campaign.ID, offsetDays)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	return recipients, nil
+}
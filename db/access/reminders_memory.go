@@ -0,0 +1,174 @@
+package access
+
+import (
+	"github.com/kyrstenkelly/rsvp-api/db/models"
+	"github.com/kyrstenkelly/rsvp-api/utils"
+)
+
+// ReminderCampaignsMemoryAccess in-memory implementation of a ReminderCampaignsDAO
+type ReminderCampaignsMemoryAccess struct {
+}
+
+// NewReminderCampaignsMemoryDAO Create a new in-memory reminder campaigns dao
+func NewReminderCampaignsMemoryDAO() ReminderCampaignsAccess {
+	return &ReminderCampaignsMemoryAccess{}
+}
+
+// storedCampaign copies a campaign for the tables
+func storedCampaign(campaign models.ReminderCampaign) models.ReminderCampaign {
+	campaign.OffsetDays = copyInts(campaign.OffsetDays)
+	return campaign
+}
+
+// getCampaigns gets the campaigns that match, ordered by id
+func (a *ReminderCampaignsMemoryAccess) getCampaigns(tx Tx, matches func(models.ReminderCampaign) bool) []models.ReminderCampaign {
+	tables := memTx(tx).tables
+	var ids []int64
+	for id, campaign := range tables.campaigns {
+		if matches(campaign) {
+			ids = append(ids, id)
+		}
+	}
+	sortInt64s(ids)
+
+	campaigns := []models.ReminderCampaign{}
+	for _, id := range ids {
+		campaigns = append(campaigns, storedCampaign(tables.campaigns[id]))
+	}
+	return campaigns
+}
+
+// GetCampaigns gets all reminder campaigns
+func (a *ReminderCampaignsMemoryAccess) GetCampaigns(tx Tx) ([]models.ReminderCampaign, error) {
+	return a.getCampaigns(tx, func(campaign models.ReminderCampaign) bool {
+		return campaign.OrganizationID == OrganizationID(tx)
+	}), nil
+}
+
+// GetActiveCampaigns gets the campaigns that aren't paused, and whose event isn't in the trash, in every
+// organization, for the scheduler
+func (a *ReminderCampaignsMemoryAccess) GetActiveCampaigns(tx Tx) ([]models.ReminderCampaign, error) {
+	events := memTx(tx).tables.events
+	return a.getCampaigns(tx, func(campaign models.ReminderCampaign) bool {
+		event, ok := events[campaign.EventID]
+		return !campaign.Paused && ok && event.DeletedAt == nil
+	}), nil
+}
+
+// GetCampaign gets a reminder campaign by id
+func (a *ReminderCampaignsMemoryAccess) GetCampaign(tx Tx, id int64) (*models.ReminderCampaign, error) {
+	campaign, ok := memTx(tx).tables.campaigns[id]
+	if !ok || campaign.OrganizationID != OrganizationID(tx) {
+		return nil, nil
+	}
+	campaign = storedCampaign(campaign)
+	return &campaign, nil
+}
+
+// CreateCampaign creates a reminder campaign
+func (a *ReminderCampaignsMemoryAccess) CreateCampaign(tx Tx, campaign *models.ReminderCampaign) (*models.ReminderCampaign, error) {
+	tables := memTx(tx).tables
+	if _, ok := tables.events[campaign.EventID]; !ok {
+		return nil, utils.ArgumentError.Here().WithMessagef("Event %d does not exist", campaign.EventID)
+	}
+	campaign.ID = tables.nextID("reminder_campaigns")
+	campaign.OrganizationID = OrganizationID(tx)
+	campaign.Version = 1
+	campaign.CreatedAt = memTx(tx).now
+	tables.campaigns[campaign.ID] = storedCampaign(*campaign)
+	return campaign, nil
+}
+
+// UpdateCampaign updates the name and offsets of a reminder campaign, if its version is the given campaign's Version
+func (a *ReminderCampaignsMemoryAccess) UpdateCampaign(tx Tx, campaign *models.ReminderCampaign) (*models.ReminderCampaign, error) {
+	existing, _ := a.GetCampaign(tx, campaign.ID)
+	if existing == nil {
+		return nil, nil
+	}
+	if err := matchVersion(existing.Version, campaign.Version); err != nil {
+		return nil, err
+	}
+	existing.Version++
+	if campaign.Name != "" {
+		existing.Name = campaign.Name
+	}
+	if campaign.OffsetDays != nil {
+		existing.OffsetDays = campaign.OffsetDays
+	}
+	memTx(tx).tables.campaigns[existing.ID] = storedCampaign(*existing)
+	return a.GetCampaign(tx, campaign.ID)
+}
+
+// SetCampaignPaused pauses or resumes a reminder campaign
+func (a *ReminderCampaignsMemoryAccess) SetCampaignPaused(tx Tx, id int64, paused bool) (*models.ReminderCampaign, error) {
+	existing, _ := a.GetCampaign(tx, id)
+	if existing == nil {
+		return nil, nil
+	}
+	existing.Paused = paused
+	existing.Version++
+	memTx(tx).tables.campaigns[id] = storedCampaign(*existing)
+	return a.GetCampaign(tx, id)
+}
+
+// DeleteCampaign deletes a reminder campaign, if it is at the given version. Reminders it has sent stay in notifications.
+func (a *ReminderCampaignsMemoryAccess) DeleteCampaign(tx Tx, id int64, version int64) (*models.ReminderCampaign, error) {
+	existing, _ := a.GetCampaign(tx, id)
+	if existing == nil {
+		return nil, matchVersion(0, version)
+	}
+	if err := matchVersion(existing.Version, version); err != nil {
+		return nil, err
+	}
+	delete(memTx(tx).tables.campaigns, id)
+	return nil, nil
+}
+
+// GetReminderRecipients gets the invitations to the campaign's event that have an email, haven't
+// responded to that event and haven't been sent the campaign's reminder for offsetDays
+func (a *ReminderCampaignsMemoryAccess) GetReminderRecipients(tx Tx, campaign *models.ReminderCampaign, offsetDays int) ([]models.ReminderRecipient, error) {
+	tables := memTx(tx).tables
+	responded := map[int64]bool{}
+	for _, rsvpGuest := range tables.rsvpGuests {
+		if rsvp, ok := tables.rsvps[rsvpGuest.RsvpID]; ok && rsvp.DeletedAt == nil && rsvpGuest.EventID == campaign.EventID {
+			responded[rsvp.InvitationID] = true
+		}
+	}
+	keys := map[string]bool{}
+	for _, notification := range tables.notifications {
+		keys[notification.IdempotencyKey] = true
+	}
+
+	var ids []int64
+	for id, invitation := range tables.invitations {
+		if listValuesContain(invitationEventIDs(tables, id), campaign.EventID) && invitation.OrganizationID == OrganizationID(tx) &&
+			invitation.DeletedAt == nil && invitation.Email != "" && !responded[id] &&
+			!keys[models.ReminderKey(campaign.ID, id, offsetDays)] {
+			ids = append(ids, id)
+		}
+	}
+	sortInt64s(ids)
+
+	recipients := []models.ReminderRecipient{}
+	for _, id := range ids {
+		invitation := tables.invitations[id]
+		recipient := models.ReminderRecipient{
+			InvitationID: id,
+			Name:         invitation.Name,
+			Email:        invitation.Email,
+		}
+		for _, notification := range tables.notifications {
+			if notification.InvitationID == nil || *notification.InvitationID != id ||
+				notification.Kind != models.NotificationReminder || notification.Status != models.NotificationSent {
+				continue
+			}
+			recipient.RemindersSent++
+			if notification.SentAt != nil && (recipient.LastRemindedAt == nil || notification.SentAt.After(*recipient.LastRemindedAt)) {
+				sentAt := *notification.SentAt
+				recipient.LastRemindedAt = &sentAt
+			}
+		}
+		recipients = append(recipients, recipient)
+	}
+	return recipients, nil
+}
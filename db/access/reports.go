@@ -0,0 +1,187 @@
+package access
+
+import (
+	"github.com/kyrstenkelly/rsvp-api/db/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// ReportsPostgresAccess postgres implementation of a ReportsDAO
+type ReportsPostgresAccess struct {
+	eventAccess EventsAccess
+}
+
+// ReportsAccess interface for a reports data access object
+type ReportsAccess interface {
+	GetEventReport(tx Tx, eventID int64) (*models.EventReport, error)
+}
+
+// NewReportsDAO Create a new reports dao
+func NewReportsDAO() ReportsAccess {
+	eventsDAO := NewEventsDAO()
+	return &ReportsPostgresAccess{
+		eventAccess: eventsDAO,
+	}
+}
+
+// GetEventReport gets the headcount and meal totals for an event. Everything is counted through
+// the event, so the report is scoped to the event's organization. Guests are only counted as invited
+// to the event if their invitation invites them to it.
+func (a *ReportsPostgresAccess) GetEventReport(tx Tx, eventID int64) (*models.EventReport, error) {
+	ptx := pgTx(tx)
+	event, err := a.eventAccess.GetEvent(tx, eventID)
+	if err != nil {
+		return nil, err
+	}
+	if event == nil {
+		return nil, nil
+	}
+
+	report := &models.EventReport{
+		EventID:           event.ID,
+		EventName:         event.Name,
+		FoodCounts:        []models.FoodCount{},
+		MissingFoodChoice: []models.MissingFoodChoice{},
+		Allergens:         []models.DietaryCount{},
+		Diets:             []models.DietaryCount{},
+	}
+
+	query :=
+		`SELECT
+			(SELECT count(*)
+				FROM invitation_events ie
+				JOIN invitations i ON i.id = ie.invitation_id
+				WHERE ie.event_id = ?0 AND i.deleted_at IS NULL) AS invitations,
+			count(DISTINCT r.id) AS invitations_responded,
+			(SELECT coalesce(sum(CASE
+					WHEN cardinality(ie.guest_ids) > 0 THEN cardinality(ie.guest_ids)
+					ELSE (SELECT count(*) FROM invitation_guests ig WHERE ig.invitation_id = i.id)
+				END), 0)
+				FROM invitation_events ie
+				JOIN invitations i ON i.id = ie.invitation_id
+				WHERE ie.event_id = ?0 AND i.deleted_at IS NULL) AS invited,
+			count(rg.id) FILTER (WHERE NOT coalesce(rg.is_plus_one, false)) AS responded,
+			count(rg.id) FILTER (WHERE rg.attending) AS attending,
+			count(rg.id) FILTER (WHERE NOT rg.attending AND NOT coalesce(rg.is_plus_one, false)) AS declined,
+			count(rg.id) FILTER (WHERE rg.attending AND coalesce(rg.is_plus_one, false)) AS plus_ones
+		FROM rsvp_guests rg
+		JOIN rsvps r ON r.id = rg.rsvp_id
+		JOIN invitations i ON i.id = r.invitation_id
+		WHERE rg.event_id = ?0 AND i.deleted_at IS NULL AND r.deleted_at IS NULL`
+	_, err = ptx.QueryOne(report, query, eventID)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	report.NoResponse = report.Invited - report.Responded
+	if report.NoResponse < 0 {
+		report.NoResponse = 0
+	}
+
+	var dietary []dietaryRow
+	_, err = ptx.Query(&dietary,
+		`SELECT d.kind, d.name, g.id AS guest_id, g.name AS guest_name, i.name AS invitation_name,
+			CASE WHEN d.kind = 'allergen' THEN rg.allergy_severity ELSE '' END AS allergy_severity,
+			rg.dietary_notes
+		FROM rsvp_guests rg
+		JOIN rsvps r ON r.id = rg.rsvp_id
+		JOIN invitations i ON i.id = r.invitation_id
+		JOIN guests g ON g.id = rg.guest_id
+		CROSS JOIN LATERAL (
+			SELECT 'allergen' AS kind, unnest(rg.allergens) AS name
+			UNION ALL
+			SELECT 'diet' AS kind, unnest(rg.diets) AS name
+		) d
+		WHERE rg.event_id = ? AND i.deleted_at IS NULL AND r.deleted_at IS NULL AND rg.attending
+		ORDER BY i.name, g.name`, eventID)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	report.Allergens, report.Diets = dietaryCounts(dietary)
+
+	if len(event.FoodOptions) == 0 {
+		return report, nil
+	}
+
+	var choices []models.FoodCount
+	_, err = ptx.Query(&choices,
+		`SELECT rg.food_choice, count(*) AS count
+		FROM rsvp_guests rg
+		JOIN rsvps r ON r.id = rg.rsvp_id
+		JOIN invitations i ON i.id = r.invitation_id
+		WHERE rg.event_id = ? AND i.deleted_at IS NULL AND r.deleted_at IS NULL AND rg.attending AND coalesce(rg.food_choice, '') <> ''
+		GROUP BY rg.food_choice`, eventID)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	counts := map[string]int{}
+	for _, choice := range choices {
+		counts[choice.FoodChoice] = choice.Count
+	}
+	// Every option is listed, in the event's order, even if nobody picked it
+	for _, option := range event.FoodOptions {
+		report.FoodCounts = append(report.FoodCounts, models.FoodCount{
+			FoodChoice: option,
+			Count:      counts[option],
+		})
+	}
+
+	_, err = ptx.Query(&report.MissingFoodChoice,
+		`SELECT
+			g.id AS guest_id,
+			g.name AS guest_name,
+			i.id AS invitation_id,
+			i.name AS invitation_name,
+			coalesce(rg.is_plus_one, false) AS is_plus_one
+		FROM rsvp_guests rg
+		JOIN rsvps r ON r.id = rg.rsvp_id
+		JOIN invitations i ON i.id = r.invitation_id
+		JOIN guests g ON g.id = rg.guest_id
+		WHERE rg.event_id = ? AND i.deleted_at IS NULL AND r.deleted_at IS NULL AND rg.attending AND coalesce(rg.food_choice, '') = ''
+		ORDER BY i.name, g.name`, eventID)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	return report, nil
+}
+
+// dietaryRow is an attending guest with one allergen or diet
+type dietaryRow struct {
+	Kind            string
+	Name            string
+	GuestID         int64
+	GuestName       string
+	InvitationName  string
+	AllergySeverity string
+	DietaryNotes    string
+}
+
+// dietaryCounts groups attending guests by allergen and diet, listing each that anyone has in the
+// order guests pick them from, with the guests in the order of the rows
+func dietaryCounts(rows []dietaryRow) ([]models.DietaryCount, []models.DietaryCount) {
+	guests := map[string][]models.DietaryGuest{}
+	for _, row := range rows {
+		key := row.Kind + ":" + row.Name
+		guests[key] = append(guests[key], models.DietaryGuest{
+			GuestID:         row.GuestID,
+			GuestName:       row.GuestName,
+			InvitationName:  row.InvitationName,
+			AllergySeverity: row.AllergySeverity,
+			DietaryNotes:    row.DietaryNotes,
+		})
+	}
+
+	counts := func(kind string, names []string) []models.DietaryCount {
+		result := []models.DietaryCount{}
+		for _, name := range names {
+			if named := guests[kind+":"+name]; len(named) > 0 {
+				result = append(result, models.DietaryCount{Name: name, Count: len(named), Guests: named})
+			}
+		}
+		return result
+	}
+	return counts("allergen", models.Allergens), counts("diet", models.Diets)
+}
@@ -0,0 +1,139 @@
+package access
+
+import (
+	"github.com/kyrstenkelly/rsvp-api/db/models"
+	"sort"
+)
+
+// ReportsMemoryAccess in-memory implementation of a ReportsDAO
+type ReportsMemoryAccess struct {
+	eventAccess EventsAccess
+}
+
+// NewReportsMemoryDAO Create a new in-memory reports dao
+func NewReportsMemoryDAO() ReportsAccess {
+	return &ReportsMemoryAccess{
+		eventAccess: NewEventsMemoryDAO(),
+	}
+}
+
+// GetEventReport gets the headcount and meal totals for an event
+func (a *ReportsMemoryAccess) GetEventReport(tx Tx, eventID int64) (*models.EventReport, error) {
+	event, err := a.eventAccess.GetEvent(tx, eventID)
+	if err != nil {
+		return nil, err
+	}
+	if event == nil {
+		return nil, nil
+	}
+
+	report := &models.EventReport{
+		EventID:           event.ID,
+		EventName:         event.Name,
+		FoodCounts:        []models.FoodCount{},
+		MissingFoodChoice: []models.MissingFoodChoice{},
+	}
+	var dietary []dietaryRow
+
+	tables := memTx(tx).tables
+	for id, invitationEvents := range tables.invitationEvents {
+		if tables.invitations[id].DeletedAt != nil {
+			continue
+		}
+		for _, invitationEvent := range invitationEvents {
+			if invitationEvent.EventID != eventID {
+				continue
+			}
+			report.Invitations++
+			if len(invitationEvent.GuestIDs) > 0 {
+				report.Invited += len(invitationEvent.GuestIDs)
+			} else {
+				report.Invited += len(tables.invitationGuests[id])
+			}
+		}
+	}
+
+	counts := map[string]int{}
+	for id, rsvp := range tables.rsvps {
+		invitation, ok := tables.invitations[rsvp.InvitationID]
+		if !ok || invitation.DeletedAt != nil || rsvp.DeletedAt != nil {
+			continue
+		}
+
+		responded := false
+		for _, rsvpGuest := range tables.rsvpGuests {
+			if rsvpGuest.RsvpID != id || rsvpGuest.EventID != eventID {
+				continue
+			}
+			responded = true
+			if !rsvpGuest.IsPlusOne {
+				report.Responded++
+			}
+			if !rsvpGuest.Attending {
+				if !rsvpGuest.IsPlusOne {
+					report.Declined++
+				}
+				continue
+			}
+			report.Attending++
+			if rsvpGuest.IsPlusOne {
+				report.PlusOnes++
+			}
+			guest := tables.guests[rsvpGuest.GuestID]
+			for _, allergen := range rsvpGuest.Allergens {
+				dietary = append(dietary, dietaryRow{"allergen", allergen, guest.ID, guest.Name, invitation.Name,
+					rsvpGuest.AllergySeverity, rsvpGuest.DietaryNotes})
+			}
+			for _, diet := range rsvpGuest.Diets {
+				dietary = append(dietary, dietaryRow{"diet", diet, guest.ID, guest.Name, invitation.Name, "", rsvpGuest.DietaryNotes})
+			}
+
+			if rsvpGuest.FoodChoice != "" {
+				counts[rsvpGuest.FoodChoice]++
+			} else if guest, ok := tables.guests[rsvpGuest.GuestID]; ok {
+				report.MissingFoodChoice = append(report.MissingFoodChoice, models.MissingFoodChoice{
+					GuestID:        guest.ID,
+					GuestName:      guest.Name,
+					InvitationID:   invitation.ID,
+					InvitationName: invitation.Name,
+					IsPlusOne:      rsvpGuest.IsPlusOne,
+				})
+			}
+		}
+		if responded {
+			report.InvitationsResponded++
+		}
+	}
+	report.NoResponse = report.Invited - report.Responded
+	if report.NoResponse < 0 {
+		report.NoResponse = 0
+	}
+	sort.SliceStable(dietary, func(i, j int) bool {
+		if dietary[i].InvitationName != dietary[j].InvitationName {
+			return dietary[i].InvitationName < dietary[j].InvitationName
+		}
+		return dietary[i].GuestName < dietary[j].GuestName
+	})
+	report.Allergens, report.Diets = dietaryCounts(dietary)
+
+	if len(event.FoodOptions) == 0 {
+		report.MissingFoodChoice = []models.MissingFoodChoice{}
+		return report, nil
+	}
+
+	// Every option is listed, in the event's order, even if nobody picked it
+	for _, option := range event.FoodOptions {
+		report.FoodCounts = append(report.FoodCounts, models.FoodCount{
+			FoodChoice: option,
+			Count:      counts[option],
+		})
+	}
+	sort.Slice(report.MissingFoodChoice, func(i, j int) bool {
+		x, y := report.MissingFoodChoice[i], report.MissingFoodChoice[j]
+		if x.InvitationName != y.InvitationName {
+			return x.InvitationName < y.InvitationName
+		}
+		return x.GuestName < y.GuestName
+	})
+	return report, nil
+}
@@ -6,38 +6,44 @@ import (
 
 	"github.com/go-pg/pg/v9"
 	"github.com/kyrstenkelly/rsvp-api/db/models"
+	"github.com/kyrstenkelly/rsvp-api/utils"
 	log "github.com/sirupsen/logrus"
 )
 
 // RSVPGuestsPostgresAccess postgres implementation of a RSVPGuestDAO
 type RSVPGuestsPostgresAccess struct {
 	guestAccess GuestsAccess
+	eventAccess EventsAccess
 }
 
 // RSVPGuestsAccess interface for a Cohorts data access object
 type RSVPGuestsAccess interface {
-	GetRSVPGuests(tx *pg.Tx, invitationID int64) ([]models.RSVPGuest, error)
-	GetRSVPGuest(tx *pg.Tx, id int64) (*models.RSVPGuest, error)
-	CreateRSVPGuest(tx *pg.Tx, rsvpID int64, rsvpGuest *models.RSVPGuest) (*models.RSVPGuest, error)
-	UpdateRSVPGuest(tx *pg.Tx, rsvpGuest *models.RSVPGuest) (*models.RSVPGuest, error)
-	DeleteRSVPGuest(tx *pg.Tx, id int64) (*models.RSVPGuest, error)
+	GetRSVPGuests(tx Tx, invitationID int64) ([]models.RSVPGuest, error)
+	GetRSVPGuest(tx Tx, id int64) (*models.RSVPGuest, error)
+	CreateRSVPGuest(tx Tx, rsvpID int64, rsvpGuest *models.RSVPGuest) (*models.RSVPGuest, error)
+	UpdateRSVPGuest(tx Tx, rsvpGuest *models.RSVPGuest) (*models.RSVPGuest, error)
+	DeleteRSVPGuest(tx Tx, id int64) (*models.RSVPGuest, error)
 }
 
 // NewRSVPGuestsDAO Create a new rsvpguests dao
 func NewRSVPGuestsDAO() RSVPGuestsAccess {
 	guestsDAO := NewGuestsDAO()
+	eventsDAO := NewEventsDAO()
 	return &RSVPGuestsPostgresAccess{
 		guestAccess: guestsDAO,
+		eventAccess: eventsDAO,
 	}
 }
 
 // GetRSVPGuests gets all rsvps
-func (a *RSVPGuestsPostgresAccess) GetRSVPGuests(tx *pg.Tx, rsvpID int64) ([]models.RSVPGuest, error) {
+func (a *RSVPGuestsPostgresAccess) GetRSVPGuests(tx Tx, rsvpID int64) ([]models.RSVPGuest, error) {
+	ptx := pgTx(tx)
 	var rsvpGuests []models.RSVPGuest
 	// TODO: Use invitation ID to get guests
-	err := tx.Model(&rsvpGuests).
+	err := ptx.Model(&rsvpGuests).
 		Column("rsvp_guest.*", "Guest").
 		Where("rsvp_guest.rsvp_id = ?", rsvpID).
+		Where("rsvp_guest.organization_id = ?", OrganizationID(tx)).
 		Select()
 	if err != nil {
 		log.Error(err)
@@ -47,9 +53,13 @@ func (a *RSVPGuestsPostgresAccess) GetRSVPGuests(tx *pg.Tx, rsvpID int64) ([]mod
 }
 
 // GetRSVPGuest gets an rsvpGuest by id
-func (a *RSVPGuestsPostgresAccess) GetRSVPGuest(tx *pg.Tx, id int64) (*models.RSVPGuest, error) {
+func (a *RSVPGuestsPostgresAccess) GetRSVPGuest(tx Tx, id int64) (*models.RSVPGuest, error) {
+	ptx := pgTx(tx)
 	rsvpGuest := new(models.RSVPGuest)
-	err := tx.Model(rsvpGuest).Where("rsvp_guest.id = ?", id).Select()
+	err := ptx.Model(rsvpGuest).
+		Where("rsvp_guest.id = ?", id).
+		Where("rsvp_guest.organization_id = ?", OrganizationID(tx)).
+		Select()
 	if err == pg.ErrNoRows {
 		return nil, nil
 	} else if err != nil {
@@ -59,40 +69,43 @@ func (a *RSVPGuestsPostgresAccess) GetRSVPGuest(tx *pg.Tx, id int64) (*models.RS
 	return rsvpGuest, nil
 }
 
-// CreateRSVPGuest creates an rsvpGuest
-func (a *RSVPGuestsPostgresAccess) CreateRSVPGuest(tx *pg.Tx, rsvpID int64, rsvpGuest *models.RSVPGuest) (*models.RSVPGuest, error) {
-	var guest *models.Guest
-	var err error
-	// If it's a plus one, create a new guest. Otherwise verify that the guest is in the DB.
-	if rsvpGuest.IsPlusOne {
-		guest, err = a.guestAccess.FindOrCreateGuest(tx, rsvpGuest.Guest)
-	} else {
-		guest, err = a.guestAccess.GetGuestByName(tx, rsvpGuest.Guest.Name)
-	}
+// CreateRSVPGuest creates an rsvpGuest for a guest on the rsvp's invitation, or a new plus one, rejecting
+// food choices that aren't one of its event's options and plus ones beyond the invitation's allowance
+func (a *RSVPGuestsPostgresAccess) CreateRSVPGuest(tx Tx, rsvpID int64, rsvpGuest *models.RSVPGuest) (*models.RSVPGuest, error) {
+	ptx := pgTx(tx)
+	event, err := a.eventAccess.GetEvent(tx, rsvpGuest.EventID)
 	if err != nil {
-		log.Error(err)
 		return nil, err
 	}
-	if guest == nil {
-		err := errors.New("Cannot create RSVP for a guest does not exist")
+	if err := checkRSVPGuest(event, rsvpGuest); err != nil {
+		return nil, err
+	}
+
+	guest, err := a.invitationGuest(tx, rsvpID, event, rsvpGuest)
+	if err != nil {
+		log.Error(err)
 		return nil, err
 	}
 	rsvpGuest.GuestID = guest.ID
+	rsvpGuest.Guest = guest
 
 	query :=
 		`INSERT INTO
-			rsvp_guests ("rsvp_id", "guest_id", "attending", "food_choice", "is_plus_one")
+			rsvp_guests ("rsvp_id", "guest_id", "event_id", "attending", "food_choice", "is_plus_one",
+				"allergens", "diets", "allergy_severity", "dietary_notes", "organization_id")
 		VALUES
-			($1, $2, $3, $4, $5)
+			($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
 		RETURNING id`
-	stmt, err := tx.Prepare(query)
+	stmt, err := ptx.Prepare(query)
 	if err != nil {
 		log.Error(err)
 		return nil, err
 	}
 
 	var rsvpGuestID int64
-	_, err = stmt.Query(pg.Scan(&rsvpGuestID), &rsvpID, &rsvpGuest.GuestID, &rsvpGuest.Attending, &rsvpGuest.FoodChoice, &rsvpGuest.IsPlusOne)
+	rsvpGuest.OrganizationID = OrganizationID(tx)
+	_, err = stmt.Query(pg.Scan(&rsvpGuestID), &rsvpID, &rsvpGuest.GuestID, &rsvpGuest.EventID, &rsvpGuest.Attending, &rsvpGuest.FoodChoice, &rsvpGuest.IsPlusOne,
+		pg.Array(rsvpGuest.Allergens), pg.Array(rsvpGuest.Diets), &rsvpGuest.AllergySeverity, &rsvpGuest.DietaryNotes, &rsvpGuest.OrganizationID)
 	if err != nil {
 		log.Error(err)
 		return nil, err
@@ -102,26 +115,91 @@ func (a *RSVPGuestsPostgresAccess) CreateRSVPGuest(tx *pg.Tx, rsvpID int64, rsvp
 	return rsvpGuest, nil
 }
 
-// UpdateRSVPGuest updates an rsvpGuest
-func (a *RSVPGuestsPostgresAccess) UpdateRSVPGuest(tx *pg.Tx, rsvpGuest *models.RSVPGuest) (*models.RSVPGuest, error) {
-	log.Debug("top of UpdateRSVPGuest")
-	var q []string
-	q = append(q, "attending = ?attending")
-	q = append(q, "is_plus_one = ?is_plus_one")
-	if rsvpGuest.FoodChoice != "" {
-		q = append(q, "food_choice = ?food_choice")
+// invitationGuest gets the guest on the rsvp's invitation an rsvpGuest is for, making sure a plus one fits in
+// the invitation's allowance and that the guest hasn't already responded to the event. The invitation is
+// locked until the transaction ends, so concurrent responses can't both take its last plus one.
+func (a *RSVPGuestsPostgresAccess) invitationGuest(tx Tx, rsvpID int64, event *models.Event, rsvpGuest *models.RSVPGuest) (*models.Guest, error) {
+	ptx := pgTx(tx)
+	var invitationID int64
+	var allowed, plusOnes int
+	_, err := ptx.QueryOne(pg.Scan(&invitationID, &allowed),
+		`SELECT i.id, i.plus_ones
+		FROM rsvps r
+		JOIN invitations i ON i.id = r.invitation_id
+		WHERE r.id = ? AND r.organization_id = ?
+		FOR UPDATE OF i`, rsvpID, OrganizationID(tx))
+	if err == pg.ErrNoRows {
+		return nil, errors.New("Cannot create a guest for an RSVP that does not exist")
+	} else if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	// Counted once the lock is held, so this sees the plus ones of any response that held it first
+	_, err = ptx.QueryOne(pg.Scan(&plusOnes),
+		`SELECT count(*) FROM rsvp_guests WHERE rsvp_id = ? AND event_id = ? AND is_plus_one`, rsvpID, event.ID)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	guest, err := resolveRSVPGuest(tx, a.guestAccess, invitationID, rsvpGuest, func() error {
+		return checkPlusOneAllowance(event, allowed, plusOnes)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var responded bool
+	_, err = ptx.QueryOne(pg.Scan(&responded),
+		`SELECT EXISTS (SELECT 1 FROM rsvp_guests WHERE rsvp_id = ? AND event_id = ? AND guest_id = ?)`, rsvpID, event.ID, guest.ID)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	if responded {
+		return nil, utils.ArgumentError.Here().WithMessagef("%s has already responded to %s", guest.Name, event.Name)
+	}
+	return guest, nil
+}
+
+// UpdateRSVPGuest replaces whether a guest is attending, what they will eat and their dietary needs.
+// Whether they are a plus one is settled when they first respond.
+func (a *RSVPGuestsPostgresAccess) UpdateRSVPGuest(tx Tx, rsvpGuest *models.RSVPGuest) (*models.RSVPGuest, error) {
+	ptx := pgTx(tx)
+	existing, err := a.GetRSVPGuest(tx, rsvpGuest.ID)
+	if err != nil {
+		return nil, err
+	}
+	if existing == nil {
+		return nil, errors.New("Cannot update an RSVP guest that does not exist")
+	}
+	rsvpGuest.EventID = existing.EventID
+	rsvpGuest.IsPlusOne = existing.IsPlusOne
+	event, err := a.eventAccess.GetEvent(tx, rsvpGuest.EventID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkRSVPGuest(event, rsvpGuest); err != nil {
+		return nil, err
 	}
 
-	log.Debug("updating rsvp guest: ")
-	log.Debug(rsvpGuest)
-	qString := strings.Join(q, ", ")
-	_, updateErr := tx.Model(rsvpGuest).Set(qString).Where("id = ?id").Update()
+	_, updateErr := ptx.Model(rsvpGuest).
+		Set("attending = ?attending, food_choice = ?food_choice").
+		Set("allergens = ?allergens, diets = ?diets, allergy_severity = ?allergy_severity, dietary_notes = ?dietary_notes").
+		Where("id = ?id").
+		Where("organization_id = ?", OrganizationID(tx)).
+		Update()
 	if updateErr != nil {
 		log.Error(updateErr)
 		return nil, updateErr
 	}
 
 	updatedRSVPGuest, err := a.GetRSVPGuest(tx, rsvpGuest.ID)
+	if err != nil {
+		return nil, err
+	}
+	if updatedRSVPGuest == nil {
+		return nil, errors.New("Cannot update an RSVP guest that does not exist")
+	}
 	updatedRSVPGuest.Guest, err = a.guestAccess.GetGuest(tx, updatedRSVPGuest.GuestID)
 	if err != nil {
 		log.Error(updateErr)
@@ -130,12 +208,115 @@ func (a *RSVPGuestsPostgresAccess) UpdateRSVPGuest(tx *pg.Tx, rsvpGuest *models.
 	return updatedRSVPGuest, nil
 }
 
-// DeleteRSVPGuest deletes an rsvpGuest
-func (a *RSVPGuestsPostgresAccess) DeleteRSVPGuest(tx *pg.Tx, id int64) (*models.RSVPGuest, error) {
-	rsvpGuest := &models.RSVPGuest{
-		ID: id,
+// checkRSVPGuest checks a response's food choice is one of its event's options and its dietary needs are
+// ones guests can pick, tidying the allergens and diets into lower case without repeats
+func checkRSVPGuest(event *models.Event, rsvpGuest *models.RSVPGuest) error {
+	if event == nil {
+		return utils.ArgumentError.Here().WithMessagef("Event %d does not exist", rsvpGuest.EventID)
+	}
+	if rsvpGuest.FoodChoice != "" && !containsString(event.FoodOptions, rsvpGuest.FoodChoice) {
+		return utils.ArgumentError.Here().WithMessagef("Unknown food choice %q for %s, expected one of %v",
+			rsvpGuest.FoodChoice, event.Name, event.FoodOptions)
+	}
+
+	var err error
+	rsvpGuest.Allergens, err = dietaryValues("allergen", rsvpGuest.Allergens, models.Allergens)
+	if err != nil {
+		return err
+	}
+	rsvpGuest.Diets, err = dietaryValues("diet", rsvpGuest.Diets, models.Diets)
+	if err != nil {
+		return err
+	}
+
+	rsvpGuest.AllergySeverity = strings.ToLower(strings.TrimSpace(rsvpGuest.AllergySeverity))
+	if rsvpGuest.AllergySeverity != "" && !containsString(models.AllergySeverities, rsvpGuest.AllergySeverity) {
+		return utils.ArgumentError.Here().WithMessagef("Unknown allergy severity %q, expected one of %v",
+			rsvpGuest.AllergySeverity, models.AllergySeverities)
+	}
+	if rsvpGuest.AllergySeverity != "" && len(rsvpGuest.Allergens) == 0 {
+		return utils.ArgumentError.Here().WithMessage("An allergy severity needs at least one allergen")
+	}
+	rsvpGuest.DietaryNotes = strings.TrimSpace(rsvpGuest.DietaryNotes)
+	return nil
+}
+
+// checkPlusOneAllowance checks an invitation allowing some plus ones has room for one more to respond
+// to an event, when the given number already have
+func checkPlusOneAllowance(event *models.Event, allowed int, responded int) error {
+	if allowed == 0 {
+		return utils.ArgumentError.Here().WithMessage("Invitation does not include a plus one")
 	}
-	err := tx.Delete(rsvpGuest)
+	if responded >= allowed {
+		return utils.ArgumentError.Here().WithMessagef("Invitation only includes %d plus ones to %s", allowed, event.Name)
+	}
+	return nil
+}
+
+// errRSVPGuestID is returned for a response that doesn't say which of the invitation's guests it is for
+var errRSVPGuestID = utils.ArgumentError.WithMessage("Each response must give the id of the invitation's guest it is for, or be for a new plus one")
+
+// resolveRSVPGuest finds the guest an rsvpGuest is for by id, among its invitation's guests and plus ones,
+// or adds the new plus one it names. Plus ones must fit in the invitation's allowance, which checkRoom checks.
+func resolveRSVPGuest(tx Tx, guestAccess GuestsAccess, invitationID int64, rsvpGuest *models.RSVPGuest, checkRoom func() error) (*models.Guest, error) {
+	guestID := rsvpGuest.GuestID
+	if guestID == 0 && rsvpGuest.Guest != nil {
+		guestID = rsvpGuest.Guest.ID
+	}
+	if guestID == 0 {
+		if !rsvpGuest.IsPlusOne {
+			return nil, errRSVPGuestID.Here()
+		}
+		if err := checkRoom(); err != nil {
+			return nil, err
+		}
+		plusOne := &models.Guest{}
+		if rsvpGuest.Guest != nil {
+			*plusOne = *rsvpGuest.Guest
+		}
+		plusOne.InvitationID = invitationID
+		plusOne.PlusOne = true
+		return guestAccess.CreateGuest(tx, plusOne)
+	}
+
+	guest, err := guestAccess.GetGuest(tx, guestID)
+	if err != nil {
+		return nil, err
+	}
+	if guest == nil || guest.InvitationID != invitationID {
+		return nil, utils.ArgumentError.Here().WithMessagef("Guest %d is not on this invitation", guestID)
+	}
+	rsvpGuest.IsPlusOne = guest.PlusOne
+	if guest.PlusOne {
+		if err := checkRoom(); err != nil {
+			return nil, err
+		}
+	}
+	return guest, nil
+}
+
+// dietaryValues lower cases allergens or diets and drops repeats, checking each is one of the options
+func dietaryValues(kind string, values []string, options []string) ([]string, error) {
+	cleaned := []string{}
+	for _, value := range values {
+		value = strings.ToLower(strings.TrimSpace(value))
+		if !containsString(options, value) {
+			return nil, utils.ArgumentError.Here().WithMessagef("Unknown %s %q, expected one of %v", kind, value, options)
+		}
+		if !containsString(cleaned, value) {
+			cleaned = append(cleaned, value)
+		}
+	}
+	return cleaned, nil
+}
+
+// DeleteRSVPGuest deletes an rsvpGuest
+func (a *RSVPGuestsPostgresAccess) DeleteRSVPGuest(tx Tx, id int64) (*models.RSVPGuest, error) {
+	ptx := pgTx(tx)
+	_, err := ptx.Model((*models.RSVPGuest)(nil)).
+		Where("id = ?", id).
+		Where("organization_id = ?", OrganizationID(tx)).
+		Delete()
 	if err != nil {
 		log.Error(err)
 		return nil, err
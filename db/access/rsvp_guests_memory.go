@@ -0,0 +1,159 @@
+package access
+
+import (
+	"errors"
+	"github.com/kyrstenkelly/rsvp-api/db/models"
+	"github.com/kyrstenkelly/rsvp-api/utils"
+)
+
+// RSVPGuestsMemoryAccess in-memory implementation of a RSVPGuestDAO
+type RSVPGuestsMemoryAccess struct {
+	guestAccess GuestsAccess
+	eventAccess EventsAccess
+}
+
+// NewRSVPGuestsMemoryDAO Create a new in-memory rsvpguests dao
+func NewRSVPGuestsMemoryDAO() RSVPGuestsAccess {
+	return &RSVPGuestsMemoryAccess{
+		guestAccess: NewGuestsMemoryDAO(),
+		eventAccess: NewEventsMemoryDAO(),
+	}
+}
+
+// storedRSVPGuest copies an rsvpGuest for the tables, leaving out its relations
+func storedRSVPGuest(rsvpGuest models.RSVPGuest) models.RSVPGuest {
+	rsvpGuest.RSVP = nil
+	rsvpGuest.Guest = nil
+	rsvpGuest.Allergens = append([]string{}, rsvpGuest.Allergens...)
+	rsvpGuest.Diets = append([]string{}, rsvpGuest.Diets...)
+	return rsvpGuest
+}
+
+// GetRSVPGuests gets the guests on an rsvp
+func (a *RSVPGuestsMemoryAccess) GetRSVPGuests(tx Tx, rsvpID int64) ([]models.RSVPGuest, error) {
+	tables := memTx(tx).tables
+	var ids []int64
+	for id, rsvpGuest := range tables.rsvpGuests {
+		if rsvpGuest.RsvpID == rsvpID && rsvpGuest.OrganizationID == OrganizationID(tx) {
+			ids = append(ids, id)
+		}
+	}
+	sortInt64s(ids)
+
+	rsvpGuests := []models.RSVPGuest{}
+	for _, id := range ids {
+		rsvpGuest := storedRSVPGuest(tables.rsvpGuests[id])
+		if guest, ok := tables.guests[rsvpGuest.GuestID]; ok {
+			rsvpGuest.Guest = &guest
+		}
+		rsvpGuests = append(rsvpGuests, rsvpGuest)
+	}
+	return rsvpGuests, nil
+}
+
+// GetRSVPGuest gets an rsvpGuest by id
+func (a *RSVPGuestsMemoryAccess) GetRSVPGuest(tx Tx, id int64) (*models.RSVPGuest, error) {
+	rsvpGuest, ok := memTx(tx).tables.rsvpGuests[id]
+	if !ok || rsvpGuest.OrganizationID != OrganizationID(tx) {
+		return nil, nil
+	}
+	rsvpGuest = storedRSVPGuest(rsvpGuest)
+	return &rsvpGuest, nil
+}
+
+// CreateRSVPGuest creates an rsvpGuest for a guest on the rsvp's invitation, or a new plus one, rejecting
+// food choices that aren't one of its event's options and plus ones beyond the invitation's allowance
+func (a *RSVPGuestsMemoryAccess) CreateRSVPGuest(tx Tx, rsvpID int64, rsvpGuest *models.RSVPGuest) (*models.RSVPGuest, error) {
+	event, err := a.eventAccess.GetEvent(tx, rsvpGuest.EventID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkRSVPGuest(event, rsvpGuest); err != nil {
+		return nil, err
+	}
+
+	tables := memTx(tx).tables
+	if _, ok := tables.rsvps[rsvpID]; !ok {
+		return nil, errors.New("Cannot create a guest for an RSVP that does not exist")
+	}
+
+	guest, err := a.invitationGuest(tx, rsvpID, event, rsvpGuest)
+	if err != nil {
+		return nil, err
+	}
+	rsvpGuest.GuestID = guest.ID
+	rsvpGuest.Guest = guest
+
+	rsvpGuest.ID = tables.nextID("rsvp_guests")
+	rsvpGuest.RsvpID = rsvpID
+	rsvpGuest.OrganizationID = OrganizationID(tx)
+	tables.rsvpGuests[rsvpGuest.ID] = storedRSVPGuest(*rsvpGuest)
+	return rsvpGuest, nil
+}
+
+// invitationGuest gets the guest on the rsvp's invitation an rsvpGuest is for, making sure a plus one fits in
+// the invitation's allowance and that the guest hasn't already responded to the event
+func (a *RSVPGuestsMemoryAccess) invitationGuest(tx Tx, rsvpID int64, event *models.Event, rsvpGuest *models.RSVPGuest) (*models.Guest, error) {
+	tables := memTx(tx).tables
+	invitation := tables.invitations[tables.rsvps[rsvpID].InvitationID]
+	plusOnes := 0
+	for _, stored := range tables.rsvpGuests {
+		if stored.RsvpID == rsvpID && stored.EventID == event.ID && stored.IsPlusOne {
+			plusOnes++
+		}
+	}
+	guest, err := resolveRSVPGuest(tx, a.guestAccess, invitation.ID, rsvpGuest, func() error {
+		return checkPlusOneAllowance(event, invitation.PlusOnes, plusOnes)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, stored := range tables.rsvpGuests {
+		if stored.RsvpID == rsvpID && stored.EventID == event.ID && stored.GuestID == guest.ID {
+			return nil, utils.ArgumentError.Here().WithMessagef("%s has already responded to %s", guest.Name, event.Name)
+		}
+	}
+	return guest, nil
+}
+
+// UpdateRSVPGuest replaces whether a guest is attending, what they will eat and their dietary needs.
+// Whether they are a plus one is settled when they first respond.
+func (a *RSVPGuestsMemoryAccess) UpdateRSVPGuest(tx Tx, rsvpGuest *models.RSVPGuest) (*models.RSVPGuest, error) {
+	existing, _ := a.GetRSVPGuest(tx, rsvpGuest.ID)
+	if existing == nil {
+		return nil, errors.New("Cannot update an RSVP guest that does not exist")
+	}
+	rsvpGuest.EventID = existing.EventID
+	event, err := a.eventAccess.GetEvent(tx, rsvpGuest.EventID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkRSVPGuest(event, rsvpGuest); err != nil {
+		return nil, err
+	}
+
+	existing.Attending = rsvpGuest.Attending
+	existing.FoodChoice = rsvpGuest.FoodChoice
+	existing.Allergens = rsvpGuest.Allergens
+	existing.Diets = rsvpGuest.Diets
+	existing.AllergySeverity = rsvpGuest.AllergySeverity
+	existing.DietaryNotes = rsvpGuest.DietaryNotes
+	memTx(tx).tables.rsvpGuests[existing.ID] = storedRSVPGuest(*existing)
+
+	existing.Guest, err = a.guestAccess.GetGuest(tx, existing.GuestID)
+	if err != nil {
+		return nil, err
+	}
+	return existing, nil
+}
+
+// DeleteRSVPGuest deletes an rsvpGuest
+func (a *RSVPGuestsMemoryAccess) DeleteRSVPGuest(tx Tx, id int64) (*models.RSVPGuest, error) {
+	rsvpGuest, _ := a.GetRSVPGuest(tx, id)
+	if rsvpGuest != nil {
+		delete(memTx(tx).tables.rsvpGuests, id)
+		delete(memTx(tx).tables.seats, id)
+	}
+	return nil, nil
+}
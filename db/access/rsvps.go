@@ -3,6 +3,7 @@ package access
 import (
 	"github.com/go-pg/pg/v9"
 	"github.com/kyrstenkelly/rsvp-api/db/models"
+	"github.com/kyrstenkelly/rsvp-api/utils"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -13,11 +14,13 @@ type RSVPsPostgresAccess struct {
 
 // RSVPsAccess interface for a Cohorts data access object
 type RSVPsAccess interface {
-	GetRSVPs(tx *pg.Tx) ([]models.RSVP, error)
-	GetRSVP(tx *pg.Tx, id int64) (*models.RSVP, error)
-	CreateRSVP(tx *pg.Tx, rsvp *models.RSVP) (*models.RSVP, error)
-	UpdateRSVP(tx *pg.Tx, rsvp *models.RSVP) (*models.RSVP, error)
-	DeleteRSVP(tx *pg.Tx, id int64) (*models.RSVP, error)
+	GetRSVPs(tx Tx, list ListQuery) ([]models.RSVP, int, error)
+	GetRSVP(tx Tx, id int64) (*models.RSVP, error)
+	GetRSVPByInvitation(tx Tx, invitationID int64) (*models.RSVP, error)
+	CreateRSVP(tx Tx, rsvp *models.RSVP, enforceDeadline bool) (*models.RSVP, error)
+	UpdateRSVP(tx Tx, rsvp *models.RSVP, enforceDeadline bool) (*models.RSVP, error)
+	DeleteRSVP(tx Tx, id int64, version int64) (*models.RSVP, error)
+	RestoreRSVP(tx Tx, id int64) (*models.RSVP, error)
 }
 
 // NewRSVPsDAO Create a new rsvps dao
@@ -28,36 +31,74 @@ func NewRSVPsDAO() RSVPsAccess {
 	}
 }
 
-// GetRSVPs gets all rsvps
-func (a *RSVPsPostgresAccess) GetRSVPs(tx *pg.Tx) ([]models.RSVP, error) {
+// RSVPsList is how lists of rsvps can be filtered, searched and sorted
+var RSVPsList = ListSpec{
+	Fields: map[string]ListField{
+		"id":            {Column: "rsvp.id", Kind: ListInt, Sort: true},
+		"invitation_id": {Column: "rsvp.invitation_id", Kind: ListInt, Filter: true, Sort: true},
+		"event_id": {
+			Column: "ARRAY(SELECT ie.event_id FROM invitation_events AS ie WHERE ie.invitation_id = rsvp.invitation_id)",
+			Kind:   ListInt,
+			Filter: true,
+			Any:    true,
+		},
+		"name": {
+			Column: "(SELECT i.name FROM invitations AS i WHERE i.id = rsvp.invitation_id)",
+			Kind:   ListString,
+			Sort:   true,
+		},
+		"late": {Column: "rsvp.late", Kind: ListBool, Filter: true},
+		"attending": {
+			Column: "EXISTS (SELECT 1 FROM rsvp_guests AS rg WHERE rg.rsvp_id = rsvp.id AND rg.attending)",
+			Kind:   ListBool,
+			Filter: true,
+		},
+	},
+	Search: []string{"name"},
+}
+
+// GetRSVPs gets a page of rsvps, along with how many rsvps match the list's filters
+func (a *RSVPsPostgresAccess) GetRSVPs(tx Tx, list ListQuery) ([]models.RSVP, int, error) {
+	ptx := pgTx(tx)
 	var rsvps []models.RSVP
-	err := tx.Model(&rsvps).Select()
+	query := ptx.Model(&rsvps).
+		Where("rsvp.organization_id = ?", OrganizationID(tx)).
+		Where("rsvp.deleted_at IS NULL")
+	total, err := applyListQuery(query, RSVPsList, list).SelectAndCount()
+	if err != nil {
+		log.Error(err)
+		return nil, 0, err
+	}
 
-	var rsvpsWithGuests []models.RSVP
+	rsvpsWithGuests := []models.RSVP{}
 	for _, rsvp := range rsvps {
 		rsvpGuests, err := a.rsvpGuestAccess.GetRSVPGuests(tx, rsvp.ID)
 		if err != nil {
 			log.Error(err)
-			return nil, err
+			return nil, 0, err
 		}
 		rsvp.RSVPGuests = rsvpGuests
 		rsvpsWithGuests = append(rsvpsWithGuests, rsvp)
 	}
-
-	if err != nil {
-		log.Error(err)
-		return nil, err
-	}
-	return rsvpsWithGuests, nil
+	return rsvpsWithGuests, total, nil
 }
 
 // GetRSVP gets an rsvp by id
-func (a *RSVPsPostgresAccess) GetRSVP(tx *pg.Tx, id int64) (*models.RSVP, error) {
+func (a *RSVPsPostgresAccess) GetRSVP(tx Tx, id int64) (*models.RSVP, error) {
+	ptx := pgTx(tx)
 	rsvp := new(models.RSVP)
 
-	err := tx.Model(rsvp).
+	err := ptx.Model(rsvp).
 		Where("rsvp.id = ?", id).
+		Where("rsvp.organization_id = ?", OrganizationID(tx)).
+		Where("rsvp.deleted_at IS NULL").
 		Select()
+	if err == pg.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		log.Error(err)
+		return nil, err
+	}
 
 	rsvpGuests, err := a.rsvpGuestAccess.GetRSVPGuests(tx, rsvp.ID)
 	if err != nil {
@@ -66,38 +107,172 @@ func (a *RSVPsPostgresAccess) GetRSVP(tx *pg.Tx, id int64) (*models.RSVP, error)
 	}
 	rsvp.RSVPGuests = rsvpGuests
 
+	return rsvp, nil
+}
+
+// GetRSVPByInvitation gets the rsvp for an invitation, if one has been submitted
+func (a *RSVPsPostgresAccess) GetRSVPByInvitation(tx Tx, invitationID int64) (*models.RSVP, error) {
+	ptx := pgTx(tx)
+	rsvp := new(models.RSVP)
+	err := ptx.Model(rsvp).
+		Where("rsvp.invitation_id = ?", invitationID).
+		Where("rsvp.organization_id = ?", OrganizationID(tx)).
+		Where("rsvp.deleted_at IS NULL").
+		First()
 	if err == pg.ErrNoRows {
 		return nil, nil
 	} else if err != nil {
 		log.Error(err)
 		return nil, err
 	}
+
+	rsvp.RSVPGuests, err = a.rsvpGuestAccess.GetRSVPGuests(tx, rsvp.ID)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
 	return rsvp, nil
 }
 
-// CreateRSVP creates an rsvp
-func (a *RSVPsPostgresAccess) CreateRSVP(tx *pg.Tx, rsvp *models.RSVP) (*models.RSVP, error) {
+// CheckDeadline sets the event each of the rsvp's guests is responding to, and reports whether the
+// response is past any of those events' RSVP deadlines. When enforcing, late responses are rejected
+// unless each event they are late for allows them.
+func (a *RSVPsPostgresAccess) CheckDeadline(tx Tx, rsvp *models.RSVP, enforceDeadline bool) (bool, error) {
+	ptx := pgTx(tx)
+	var invitationExists bool
+	_, err := ptx.QueryOne(pg.Scan(&invitationExists),
+		`SELECT EXISTS (SELECT 1 FROM invitations WHERE id = ? AND organization_id = ? AND deleted_at IS NULL)`,
+		rsvp.InvitationID, OrganizationID(tx))
+	if err != nil {
+		log.Error(err)
+		return false, err
+	}
+	if !invitationExists {
+		return false, utils.ArgumentError.Here().WithMessage("Invitation does not exist")
+	}
+
+	invitation := &models.Invitation{ID: rsvp.InvitationID}
+	err = ptx.Model(&invitation.Events).
+		Where("invitation_id = ?", rsvp.InvitationID).
+		Order("position").
+		Select()
+	if err != nil {
+		log.Error(err)
+		return false, err
+	}
+	eventIDs, err := resolveRSVPEvents(invitation, rsvp)
+	if err != nil {
+		return false, err
+	}
+
+	var pastDeadline, allowLate bool
+	_, err = ptx.QueryOne(pg.Scan(&pastDeadline, &allowLate),
+		`SELECT coalesce(bool_or(past), false), coalesce(bool_and(allow_late_rsvps OR NOT past), true)
+		FROM (
+			SELECT rsvp_deadline IS NOT NULL AND rsvp_deadline < now() AS past, allow_late_rsvps
+			FROM events
+			WHERE id = ANY (?)
+		) e`, pg.Array(eventIDs))
+	if err != nil {
+		log.Error(err)
+		return false, err
+	}
+
+	if pastDeadline && enforceDeadline && !allowLate {
+		return false, utils.RSVPDeadlinePassedError.Here()
+	}
+	return pastDeadline, nil
+}
+
+// resolveRSVPEvents sets the event each of an rsvp's guests is responding to, which they can leave out
+// when the invitation covers only one, and returns the ids of the events responded to. An rsvp without
+// any responses counts as responding to every event on the invitation.
+func resolveRSVPEvents(invitation *models.Invitation, rsvp *models.RSVP) ([]int64, error) {
+	if len(rsvp.RSVPGuests) == 0 {
+		return invitation.EventIDs(), nil
+	}
+	eventIDs := []int64{}
+	seen := map[int64]bool{}
+	for i := range rsvp.RSVPGuests {
+		rsvpGuest := &rsvp.RSVPGuests[i]
+		invitationEvent := invitation.ResponseEvent(rsvpGuest.EventID)
+		if invitationEvent == nil && rsvpGuest.EventID == 0 {
+			return nil, utils.ArgumentError.Here().WithMessage("The invitation covers several events, each response must give its event_id")
+		}
+		if invitationEvent == nil {
+			return nil, utils.ArgumentError.Here().WithMessagef("Event %d is not on this invitation", rsvpGuest.EventID)
+		}
+		rsvpGuest.EventID = invitationEvent.EventID
+		if !seen[rsvpGuest.EventID] {
+			seen[rsvpGuest.EventID] = true
+			eventIDs = append(eventIDs, rsvpGuest.EventID)
+		}
+	}
+	return eventIDs, nil
+}
+
+// claimRSVPGuests makes sure the rsvp guests being updated belong to the rsvp, and keeps the event
+// each was a response to. New responses have no id.
+func claimRSVPGuests(tx Tx, rsvpGuestAccess RSVPGuestsAccess, rsvp *models.RSVP) error {
+	for i := range rsvp.RSVPGuests {
+		rsvpGuest := &rsvp.RSVPGuests[i]
+		if rsvpGuest.ID == 0 {
+			continue
+		}
+		stored, err := rsvpGuestAccess.GetRSVPGuest(tx, rsvpGuest.ID)
+		if err != nil {
+			return err
+		}
+		if stored == nil || stored.RsvpID != rsvp.ID {
+			return utils.ArgumentError.Here().WithMessagef("RSVP guest %d does not belong to this RSVP", rsvpGuest.ID)
+		}
+		rsvpGuest.EventID = stored.EventID
+	}
+	return nil
+}
+
+// rsvpExistsError is returned for a second rsvp to an invitation, which may only have one out of the trash
+func rsvpExistsError(invitationID int64) error {
+	return utils.ArgumentError.Here().WithMessagef("Invitation %d already has an RSVP", invitationID)
+}
+
+// CreateRSVP creates an rsvp, failing if its invitation already has one
+func (a *RSVPsPostgresAccess) CreateRSVP(tx Tx, rsvp *models.RSVP, enforceDeadline bool) (*models.RSVP, error) {
+	ptx := pgTx(tx)
+	late, err := a.CheckDeadline(tx, rsvp, enforceDeadline)
+	if err != nil {
+		return nil, err
+	}
+	rsvp.Late = late
+
+	// Nothing is inserted, and no id returned, if the invitation already has an rsvp
 	query :=
-		`INSERT INTO rsvps ("invitation_id") VALUES ($1)
+		`INSERT INTO rsvps ("invitation_id", "late", "organization_id") VALUES ($1, $2, $3)
+		ON CONFLICT (invitation_id) WHERE deleted_at IS NULL DO NOTHING
 		RETURNING id`
-	stmt, err := tx.Prepare(query)
+	stmt, err := ptx.Prepare(query)
 	if err != nil {
 		log.Error(err)
 		return nil, err
 	}
 
 	var rsvpID int64
-	_, err = stmt.Query(pg.Scan(&rsvpID), &rsvp.InvitationID)
+	rsvp.OrganizationID = OrganizationID(tx)
+	_, err = stmt.Query(pg.Scan(&rsvpID), &rsvp.InvitationID, &rsvp.Late, &rsvp.OrganizationID)
 	if err != nil {
 		log.Error(err)
 		return nil, err
 	}
+	if rsvpID == 0 {
+		return nil, rsvpExistsError(rsvp.InvitationID)
+	}
 	rsvp.ID = rsvpID
+	rsvp.Version = 1
 
 	// Create and append RSVPGuests to the RSVP
 	var rsvpGuestIDs []int64
-	for _, rsvpGuest := range rsvp.RSVPGuests {
-		newRSVPGuest, err := a.rsvpGuestAccess.CreateRSVPGuest(tx, rsvpID, &rsvpGuest)
+	for i := range rsvp.RSVPGuests {
+		newRSVPGuest, err := a.rsvpGuestAccess.CreateRSVPGuest(tx, rsvpID, &rsvp.RSVPGuests[i])
 		if err != nil {
 			log.Error(err)
 			return nil, err
@@ -105,7 +280,7 @@ func (a *RSVPsPostgresAccess) CreateRSVP(tx *pg.Tx, rsvp *models.RSVP) (*models.
 		rsvpGuestIDs = append(rsvpGuestIDs, newRSVPGuest.ID)
 	}
 	rsvp.RSVPGuestIds = rsvpGuestIDs
-	_, updateErr := tx.Model(rsvp).Set("rsvp_guest_ids = ?rsvp_guest_ids").Where("id = ?id").Update()
+	_, updateErr := ptx.Model(rsvp).Set("rsvp_guest_ids = ?rsvp_guest_ids").Where("id = ?id").Update()
 	if updateErr != nil {
 		log.Error(updateErr)
 		return nil, updateErr
@@ -114,8 +289,40 @@ func (a *RSVPsPostgresAccess) CreateRSVP(tx *pg.Tx, rsvp *models.RSVP) (*models.
 	return rsvp, nil
 }
 
-// UpdateRSVP updates an rsvp
-func (a *RSVPsPostgresAccess) UpdateRSVP(tx *pg.Tx, rsvp *models.RSVP) (*models.RSVP, error) {
+// UpdateRSVP updates an rsvp, if its version is the given rsvp's Version. Its guests without an id are
+// new responses, such as to an event added to the invitation since.
+func (a *RSVPsPostgresAccess) UpdateRSVP(tx Tx, rsvp *models.RSVP, enforceDeadline bool) (*models.RSVP, error) {
+	ptx := pgTx(tx)
+	_, err := ptx.QueryOne(pg.Scan(&rsvp.InvitationID), `SELECT invitation_id FROM rsvps WHERE id = ? AND organization_id = ? AND deleted_at IS NULL`, rsvp.ID, OrganizationID(tx))
+	if err == pg.ErrNoRows {
+		return nil, utils.HTTPNotFoundError.Here()
+	} else if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	if err := claimRSVPGuests(tx, a.rsvpGuestAccess, rsvp); err != nil {
+		return nil, err
+	}
+	rsvp.Late, err = a.CheckDeadline(tx, rsvp, enforceDeadline)
+	if err != nil {
+		return nil, err
+	}
+	version := rsvp.Version
+	result, err := ptx.Model(rsvp).
+		Set("late = ?late, version = version + 1").
+		Where("id = ?id").
+		Where("deleted_at IS NULL").
+		Apply(whereVersion(version)).
+		Returning("version").
+		Update()
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	if err := checkVersion(result, version); err != nil {
+		return nil, err
+	}
+
 	var updatedRSVPGuests []models.RSVPGuest
 	log.Debug("about to update rsvp guests: ")
 	log.Debug(rsvp.RSVPGuests)
@@ -124,6 +331,23 @@ func (a *RSVPsPostgresAccess) UpdateRSVP(tx *pg.Tx, rsvp *models.RSVP) (*models.
 			"foodChoice": rsvpGuest.FoodChoice,
 			"guest":      rsvpGuest.Guest,
 		}).Info("about to update rsvp guest")
+		if rsvpGuest.ID == 0 {
+			created, err := a.rsvpGuestAccess.CreateRSVPGuest(tx, rsvp.ID, &rsvpGuest)
+			if err != nil {
+				return nil, err
+			}
+			// rsvp_guest_ids is a jsonb array, which is null until the rsvp has guests
+			_, err = ptx.Exec(
+				`UPDATE rsvps
+				SET rsvp_guest_ids = CASE WHEN jsonb_typeof(rsvp_guest_ids) = 'array' THEN rsvp_guest_ids ELSE '[]' END || jsonb_build_array(?)
+				WHERE id = ?`, created.ID, rsvp.ID)
+			if err != nil {
+				log.Error(err)
+				return nil, err
+			}
+			updatedRSVPGuests = append(updatedRSVPGuests, *created)
+			continue
+		}
 		updated, err := a.rsvpGuestAccess.UpdateRSVPGuest(tx, &rsvpGuest)
 		if err != nil {
 			log.Error(err)
@@ -135,17 +359,56 @@ func (a *RSVPsPostgresAccess) UpdateRSVP(tx *pg.Tx, rsvp *models.RSVP) (*models.
 	return rsvp, nil
 }
 
-// DeleteRSVP deletes an rsvp
-func (a *RSVPsPostgresAccess) DeleteRSVP(tx *pg.Tx, id int64) (*models.RSVP, error) {
-	// First delete the RSVP guests, then the RSVP
-	rsvp, err := a.GetRSVP(tx, id)
-	for _, rsvpGuestID := range rsvp.RSVPGuestIds {
-		a.rsvpGuestAccess.DeleteRSVPGuest(tx, rsvpGuestID)
+// DeleteRSVP moves an rsvp to the trash, if it is at the given version. Its rsvp guests are kept until it is purged.
+// It fails with a HTTPNotFoundError if there's no such rsvp.
+func (a *RSVPsPostgresAccess) DeleteRSVP(tx Tx, id int64, version int64) (*models.RSVP, error) {
+	ptx := pgTx(tx)
+	result, err := ptx.Model((*models.RSVP)(nil)).
+		Set("deleted_at = now(), version = version + 1").
+		Where("id = ?", id).
+		Where("organization_id = ?", OrganizationID(tx)).
+		Where("deleted_at IS NULL").
+		Apply(whereVersion(version)).
+		Update()
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	return nil, checkTrashed(tx, (*models.RSVP)(nil), id, result)
+}
+
+// RestoreRSVP takes an rsvp out of the trash, returning nil if it isn't in the trash. It fails if its
+// invitation is in the trash, or has had another rsvp submitted since.
+func (a *RSVPsPostgresAccess) RestoreRSVP(tx Tx, id int64) (*models.RSVP, error) {
+	ptx := pgTx(tx)
+	var invitationID int64
+	var invitationDeleted, replaced bool
+	_, err := ptx.QueryOne(pg.Scan(&invitationID, &invitationDeleted, &replaced),
+		`SELECT r.invitation_id, i.deleted_at IS NOT NULL,
+			EXISTS (SELECT 1 FROM rsvps o WHERE o.invitation_id = r.invitation_id AND o.deleted_at IS NULL)
+		FROM rsvps r
+		JOIN invitations i ON i.id = r.invitation_id
+		WHERE r.id = ? AND r.organization_id = ? AND r.deleted_at IS NOT NULL`, id, OrganizationID(tx))
+	if err == pg.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		log.Error(err)
+		return nil, err
 	}
-	err = tx.Delete(rsvp)
+	if invitationDeleted {
+		return nil, utils.ArgumentError.Here().WithMessage("The RSVP's invitation is in the trash, restore it instead")
+	}
+	if replaced {
+		return nil, rsvpExistsError(invitationID)
+	}
+
+	_, err = ptx.Model((*models.RSVP)(nil)).
+		Set("deleted_at = NULL, version = version + 1").
+		Where("id = ?", id).
+		Update()
 	if err != nil {
 		log.Error(err)
 		return nil, err
 	}
-	return nil, nil
+	return a.GetRSVP(tx, id)
 }
@@ -0,0 +1,247 @@
+package access
+
+import (
+	"github.com/kyrstenkelly/rsvp-api/db/models"
+	"github.com/kyrstenkelly/rsvp-api/utils"
+)
+
+// RSVPsMemoryAccess in-memory implementation of an RSVPsDAO
+type RSVPsMemoryAccess struct {
+	rsvpGuestAccess RSVPGuestsAccess
+}
+
+// NewRSVPsMemoryDAO Create a new in-memory rsvps dao
+func NewRSVPsMemoryDAO() RSVPsAccess {
+	return &RSVPsMemoryAccess{
+		rsvpGuestAccess: NewRSVPGuestsMemoryDAO(),
+	}
+}
+
+// storedRSVP copies an rsvp for the tables, leaving out its guests
+func storedRSVP(rsvp models.RSVP) models.RSVP {
+	rsvp.RSVPGuestIds = copyInt64s(rsvp.RSVPGuestIds)
+	rsvp.RSVPGuests = nil
+	return rsvp
+}
+
+// loadRSVP copies an rsvp out of the tables along with its guests
+func (a *RSVPsMemoryAccess) loadRSVP(tx Tx, rsvp models.RSVP) (*models.RSVP, error) {
+	rsvp = storedRSVP(rsvp)
+	rsvpGuests, err := a.rsvpGuestAccess.GetRSVPGuests(tx, rsvp.ID)
+	if err != nil {
+		return nil, err
+	}
+	rsvp.RSVPGuests = rsvpGuests
+	return &rsvp, nil
+}
+
+// GetRSVPs gets a page of rsvps, along with how many rsvps match the list's filters
+func (a *RSVPsMemoryAccess) GetRSVPs(tx Tx, list ListQuery) ([]models.RSVP, int, error) {
+	tables := memTx(tx).tables
+	var rows []models.RSVP
+	for _, rsvp := range tables.rsvps {
+		if rsvp.OrganizationID == OrganizationID(tx) && rsvp.DeletedAt == nil {
+			rows = append(rows, rsvp)
+		}
+	}
+	attending := map[int64]bool{}
+	for _, rsvpGuest := range tables.rsvpGuests {
+		attending[rsvpGuest.RsvpID] = attending[rsvpGuest.RsvpID] || rsvpGuest.Attending
+	}
+	page, total := listMemoryRows(len(rows), func(i int) map[string]interface{} {
+		invitation := tables.invitations[rows[i].InvitationID]
+		return map[string]interface{}{
+			"id":            rows[i].ID,
+			"invitation_id": rows[i].InvitationID,
+			"event_id":      invitationEventIDs(tables, invitation.ID),
+			"name":          invitation.Name,
+			"late":          rows[i].Late,
+			"attending":     attending[rows[i].ID],
+		}
+	}, RSVPsList, list)
+
+	rsvps := []models.RSVP{}
+	for _, i := range page {
+		rsvp, err := a.loadRSVP(tx, rows[i])
+		if err != nil {
+			return nil, 0, err
+		}
+		rsvps = append(rsvps, *rsvp)
+	}
+	return rsvps, total, nil
+}
+
+// GetRSVP gets an rsvp by id
+func (a *RSVPsMemoryAccess) GetRSVP(tx Tx, id int64) (*models.RSVP, error) {
+	rsvp, ok := memTx(tx).tables.rsvps[id]
+	if !ok || rsvp.OrganizationID != OrganizationID(tx) || rsvp.DeletedAt != nil {
+		return nil, nil
+	}
+	return a.loadRSVP(tx, rsvp)
+}
+
+// GetRSVPByInvitation gets the rsvp for an invitation, if one has been submitted
+func (a *RSVPsMemoryAccess) GetRSVPByInvitation(tx Tx, invitationID int64) (*models.RSVP, error) {
+	var rsvpID int64
+	for id, rsvp := range memTx(tx).tables.rsvps {
+		if rsvp.InvitationID == invitationID && rsvp.OrganizationID == OrganizationID(tx) && rsvp.DeletedAt == nil &&
+			(rsvpID == 0 || id < rsvpID) {
+			rsvpID = id
+		}
+	}
+	if rsvpID == 0 {
+		return nil, nil
+	}
+	return a.GetRSVP(tx, rsvpID)
+}
+
+// CheckDeadline sets the event each of the rsvp's guests is responding to, and reports whether the
+// response is past any of those events' RSVP deadlines. When enforcing, late responses are rejected
+// unless each event they are late for allows them.
+func (a *RSVPsMemoryAccess) CheckDeadline(tx Tx, rsvp *models.RSVP, enforceDeadline bool) (bool, error) {
+	tables := memTx(tx).tables
+	invitation, ok := tables.invitations[rsvp.InvitationID]
+	if !ok || invitation.OrganizationID != OrganizationID(tx) || invitation.DeletedAt != nil {
+		return false, utils.ArgumentError.Here().WithMessage("Invitation does not exist")
+	}
+	invitation.Events = tables.invitationEvents[invitation.ID]
+	eventIDs, err := resolveRSVPEvents(&invitation, rsvp)
+	if err != nil {
+		return false, err
+	}
+
+	pastDeadline, allowLate := false, true
+	for _, eventID := range eventIDs {
+		event := tables.events[eventID]
+		if event.RSVPDeadline != nil && event.RSVPDeadline.Before(memTx(tx).now) {
+			pastDeadline = true
+			allowLate = allowLate && event.AllowLateRSVPs
+		}
+	}
+
+	if pastDeadline && enforceDeadline && !allowLate {
+		return false, utils.RSVPDeadlinePassedError.Here()
+	}
+	return pastDeadline, nil
+}
+
+// CreateRSVP creates an rsvp, failing if its invitation already has one
+func (a *RSVPsMemoryAccess) CreateRSVP(tx Tx, rsvp *models.RSVP, enforceDeadline bool) (*models.RSVP, error) {
+	late, err := a.CheckDeadline(tx, rsvp, enforceDeadline)
+	if err != nil {
+		return nil, err
+	}
+	rsvp.Late = late
+
+	tables := memTx(tx).tables
+	for _, other := range tables.rsvps {
+		if other.InvitationID == rsvp.InvitationID && other.DeletedAt == nil {
+			return nil, rsvpExistsError(rsvp.InvitationID)
+		}
+	}
+	rsvp.ID = tables.nextID("rsvps")
+	rsvp.OrganizationID = OrganizationID(tx)
+	rsvp.Version = 1
+	tables.rsvps[rsvp.ID] = storedRSVP(*rsvp)
+
+	var rsvpGuestIDs []int64
+	for i := range rsvp.RSVPGuests {
+		newRSVPGuest, err := a.rsvpGuestAccess.CreateRSVPGuest(tx, rsvp.ID, &rsvp.RSVPGuests[i])
+		if err != nil {
+			return nil, err
+		}
+		rsvpGuestIDs = append(rsvpGuestIDs, newRSVPGuest.ID)
+	}
+	rsvp.RSVPGuestIds = rsvpGuestIDs
+	tables.rsvps[rsvp.ID] = storedRSVP(*rsvp)
+
+	return rsvp, nil
+}
+
+// UpdateRSVP updates an rsvp, if its version is the given rsvp's Version. Its guests without an id are
+// new responses, such as to an event added to the invitation since.
+func (a *RSVPsMemoryAccess) UpdateRSVP(tx Tx, rsvp *models.RSVP, enforceDeadline bool) (*models.RSVP, error) {
+	tables := memTx(tx).tables
+	existing, ok := tables.rsvps[rsvp.ID]
+	if !ok || existing.OrganizationID != OrganizationID(tx) || existing.DeletedAt != nil {
+		return nil, utils.HTTPNotFoundError.Here()
+	}
+	if err := matchVersion(existing.Version, rsvp.Version); err != nil {
+		return nil, err
+	}
+	rsvp.InvitationID = existing.InvitationID
+
+	if err := claimRSVPGuests(tx, a.rsvpGuestAccess, rsvp); err != nil {
+		return nil, err
+	}
+	var err error
+	rsvp.Late, err = a.CheckDeadline(tx, rsvp, enforceDeadline)
+	if err != nil {
+		return nil, err
+	}
+	existing.Late = rsvp.Late
+	existing.Version++
+	rsvp.Version = existing.Version
+
+	var updatedRSVPGuests []models.RSVPGuest
+	for _, rsvpGuest := range rsvp.RSVPGuests {
+		if rsvpGuest.ID == 0 {
+			created, err := a.rsvpGuestAccess.CreateRSVPGuest(tx, rsvp.ID, &rsvpGuest)
+			if err != nil {
+				return nil, err
+			}
+			existing.RSVPGuestIds = append(existing.RSVPGuestIds, created.ID)
+			updatedRSVPGuests = append(updatedRSVPGuests, *created)
+			continue
+		}
+		updated, err := a.rsvpGuestAccess.UpdateRSVPGuest(tx, &rsvpGuest)
+		if err != nil {
+			return nil, err
+		}
+		updatedRSVPGuests = append(updatedRSVPGuests, *updated)
+	}
+	tables.rsvps[existing.ID] = storedRSVP(existing)
+	rsvp.RSVPGuests = updatedRSVPGuests
+	return rsvp, nil
+}
+
+// DeleteRSVP moves an rsvp to the trash, if it is at the given version. Its rsvp guests are kept until it is purged.
+// It fails with a HTTPNotFoundError if there's no such rsvp.
+func (a *RSVPsMemoryAccess) DeleteRSVP(tx Tx, id int64, version int64) (*models.RSVP, error) {
+	tables := memTx(tx).tables
+	rsvp, ok := tables.rsvps[id]
+	if !ok || rsvp.OrganizationID != OrganizationID(tx) || rsvp.DeletedAt != nil {
+		return nil, utils.HTTPNotFoundError.Here()
+	}
+	if err := matchVersion(rsvp.Version, version); err != nil {
+		return nil, err
+	}
+	deletedAt := memTx(tx).now
+	rsvp.DeletedAt = &deletedAt
+	rsvp.Version++
+	tables.rsvps[id] = rsvp
+	return nil, nil
+}
+
+// RestoreRSVP takes an rsvp out of the trash, returning nil if it isn't in the trash. It fails if its
+// invitation is in the trash, or has had another rsvp submitted since.
+func (a *RSVPsMemoryAccess) RestoreRSVP(tx Tx, id int64) (*models.RSVP, error) {
+	tables := memTx(tx).tables
+	rsvp, ok := tables.rsvps[id]
+	if !ok || rsvp.OrganizationID != OrganizationID(tx) || rsvp.DeletedAt == nil {
+		return nil, nil
+	}
+	if tables.invitations[rsvp.InvitationID].DeletedAt != nil {
+		return nil, utils.ArgumentError.Here().WithMessage("The RSVP's invitation is in the trash, restore it instead")
+	}
+	for _, other := range tables.rsvps {
+		if other.InvitationID == rsvp.InvitationID && other.DeletedAt == nil {
+			return nil, rsvpExistsError(rsvp.InvitationID)
+		}
+	}
+
+	rsvp.DeletedAt = nil
+	rsvp.Version++
+	tables.rsvps[id] = rsvp
+	return a.GetRSVP(tx, id)
+}
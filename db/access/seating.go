@@ -0,0 +1,244 @@
+package access
+
+import (
+	"github.com/go-pg/pg/v9"
+	"github.com/kyrstenkelly/rsvp-api/db/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// SeatingPostgresAccess postgres implementation of a SeatingDAO
+type SeatingPostgresAccess struct {
+}
+
+// SeatingAccess interface for a seating data access object, covering an event's tables, who is
+// seated at them and the constraints on who sits with whom
+type SeatingAccess interface {
+	GetSeatingTables(tx Tx, eventID int64) ([]models.SeatingTable, error)
+	GetSeatingTable(tx Tx, id int64) (*models.SeatingTable, error)
+	CreateSeatingTable(tx Tx, table *models.SeatingTable) (*models.SeatingTable, error)
+	UpdateSeatingTable(tx Tx, table *models.SeatingTable) (*models.SeatingTable, error)
+	DeleteSeatingTable(tx Tx, id int64, version int64) (*models.SeatingTable, error)
+	GetSeatingGuests(tx Tx, eventID int64) ([]models.SeatingGuest, error)
+	GetSeat(tx Tx, rsvpGuestID int64) (*models.Seat, error)
+	SetSeats(tx Tx, seats []models.Seat) error
+	GetSeatingConstraints(tx Tx, eventID int64) ([]models.SeatingConstraint, error)
+	GetSeatingConstraint(tx Tx, id int64) (*models.SeatingConstraint, error)
+	CreateSeatingConstraint(tx Tx, constraint *models.SeatingConstraint) (*models.SeatingConstraint, error)
+	DeleteSeatingConstraint(tx Tx, id int64, version int64) (*models.SeatingConstraint, error)
+}
+
+// NewSeatingDAO Create a new seating dao
+func NewSeatingDAO() SeatingAccess {
+	return &SeatingPostgresAccess{}
+}
+
+// GetSeatingTables gets an event's tables
+func (a *SeatingPostgresAccess) GetSeatingTables(tx Tx, eventID int64) ([]models.SeatingTable, error) {
+	ptx := pgTx(tx)
+	tables := []models.SeatingTable{}
+	err := ptx.Model(&tables).
+		Where("seating_table.organization_id = ?", OrganizationID(tx)).
+		Where("seating_table.event_id = ?", eventID).
+		Order("seating_table.id").
+		Select()
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	return tables, nil
+}
+
+// GetSeatingTable gets a table by id
+func (a *SeatingPostgresAccess) GetSeatingTable(tx Tx, id int64) (*models.SeatingTable, error) {
+	ptx := pgTx(tx)
+	table := new(models.SeatingTable)
+	err := ptx.Model(table).
+		Where("seating_table.id = ?", id).
+		Where("seating_table.organization_id = ?", OrganizationID(tx)).
+		Select()
+
+	if err == pg.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	return table, nil
+}
+
+// CreateSeatingTable creates a table
+func (a *SeatingPostgresAccess) CreateSeatingTable(tx Tx, table *models.SeatingTable) (*models.SeatingTable, error) {
+	ptx := pgTx(tx)
+	table.OrganizationID = OrganizationID(tx)
+	table.Version = 1
+	_, err := ptx.Model(table).Returning("*").Insert()
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	return table, nil
+}
+
+// UpdateSeatingTable replaces a table's name and capacity, if its version is the given table's Version
+func (a *SeatingPostgresAccess) UpdateSeatingTable(tx Tx, table *models.SeatingTable) (*models.SeatingTable, error) {
+	ptx := pgTx(tx)
+	result, err := ptx.Model(table).
+		Set("name = ?name, capacity = ?capacity, version = version + 1").
+		Where("id = ?id").
+		Where("organization_id = ?", OrganizationID(tx)).
+		Apply(whereVersion(table.Version)).
+		Update()
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	if err := checkVersion(result, table.Version); err != nil {
+		return nil, err
+	}
+	return a.GetSeatingTable(tx, table.ID)
+}
+
+// DeleteSeatingTable deletes a table, if it is at the given version. The guests seated at it are unseated.
+func (a *SeatingPostgresAccess) DeleteSeatingTable(tx Tx, id int64, version int64) (*models.SeatingTable, error) {
+	ptx := pgTx(tx)
+	result, err := ptx.Model((*models.SeatingTable)(nil)).
+		Where("id = ?", id).
+		Where("organization_id = ?", OrganizationID(tx)).
+		Apply(whereVersion(version)).
+		Delete()
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	return nil, checkVersion(result, version)
+}
+
+// GetSeatingGuests gets the guests attending an event, from the accepted responses to it, with the
+// tables they are seated at. They are ordered by invitation, so households
+// are listed together.
+func (a *SeatingPostgresAccess) GetSeatingGuests(tx Tx, eventID int64) ([]models.SeatingGuest, error) {
+	ptx := pgTx(tx)
+	guests := []models.SeatingGuest{}
+	_, err := ptx.Query(&guests,
+		`SELECT rg.id AS rsvp_guest_id, g.id AS guest_id, g.name, i.id AS invitation_id, i.name AS invitation_name,
+			coalesce(rg.is_plus_one, false) AS is_plus_one, coalesce(rg.food_choice, '') AS food_choice, s.table_id
+		FROM rsvp_guests rg
+		JOIN rsvps r ON r.id = rg.rsvp_id
+		JOIN invitations i ON i.id = r.invitation_id
+		JOIN guests g ON g.id = rg.guest_id
+		LEFT JOIN seats s ON s.rsvp_guest_id = rg.id
+		WHERE rg.organization_id = ? AND rg.event_id = ? AND rg.attending
+			AND r.deleted_at IS NULL AND i.deleted_at IS NULL
+		ORDER BY i.name, i.id, rg.id`, OrganizationID(tx), eventID)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	return guests, nil
+}
+
+// GetSeat gets the seat of an rsvp guest, or nil if they aren't seated
+func (a *SeatingPostgresAccess) GetSeat(tx Tx, rsvpGuestID int64) (*models.Seat, error) {
+	ptx := pgTx(tx)
+	var tableID int64
+	_, err := ptx.QueryOne(pg.Scan(&tableID),
+		`SELECT table_id FROM seats WHERE rsvp_guest_id = ? AND organization_id = ?`, rsvpGuestID, OrganizationID(tx))
+	if err == pg.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	return &models.Seat{RSVPGuestID: rsvpGuestID, TableID: &tableID}, nil
+}
+
+// SetSeats seats each guest at their seat's table, or unseats them if it has none. Callers check
+// the guests and tables belong to the same event, and that the tables have room.
+func (a *SeatingPostgresAccess) SetSeats(tx Tx, seats []models.Seat) error {
+	ptx := pgTx(tx)
+	for _, seat := range seats {
+		var err error
+		if seat.TableID == nil {
+			_, err = ptx.Exec(`DELETE FROM seats WHERE rsvp_guest_id = ? AND organization_id = ?`,
+				seat.RSVPGuestID, OrganizationID(tx))
+		} else {
+			_, err = ptx.Exec(
+				`INSERT INTO seats (rsvp_guest_id, organization_id, table_id) VALUES (?, ?, ?)
+				ON CONFLICT (rsvp_guest_id) DO UPDATE SET table_id = EXCLUDED.table_id`,
+				seat.RSVPGuestID, OrganizationID(tx), *seat.TableID)
+		}
+		if err != nil {
+			log.Error(err)
+			return err
+		}
+	}
+	return nil
+}
+
+// GetSeatingConstraints gets an event's seating constraints
+func (a *SeatingPostgresAccess) GetSeatingConstraints(tx Tx, eventID int64) ([]models.SeatingConstraint, error) {
+	ptx := pgTx(tx)
+	constraints := []models.SeatingConstraint{}
+	err := ptx.Model(&constraints).
+		Where("seating_constraint.organization_id = ?", OrganizationID(tx)).
+		Where("seating_constraint.event_id = ?", eventID).
+		Order("seating_constraint.id").
+		Select()
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	return constraints, nil
+}
+
+// GetSeatingConstraint gets a seating constraint by id
+func (a *SeatingPostgresAccess) GetSeatingConstraint(tx Tx, id int64) (*models.SeatingConstraint, error) {
+	ptx := pgTx(tx)
+	constraint := new(models.SeatingConstraint)
+	err := ptx.Model(constraint).
+		Where("seating_constraint.id = ?", id).
+		Where("seating_constraint.organization_id = ?", OrganizationID(tx)).
+		Select()
+
+	if err == pg.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	return constraint, nil
+}
+
+// CreateSeatingConstraint creates a seating constraint
+func (a *SeatingPostgresAccess) CreateSeatingConstraint(tx Tx, constraint *models.SeatingConstraint) (*models.SeatingConstraint, error) {
+	ptx := pgTx(tx)
+	constraint.OrganizationID = OrganizationID(tx)
+	constraint.Version = 1
+	if constraint.GuestIDs == nil {
+		constraint.GuestIDs = []int64{}
+	}
+	if constraint.InvitationIDs == nil {
+		constraint.InvitationIDs = []int64{}
+	}
+	_, err := ptx.Model(constraint).Returning("*").Insert()
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	return constraint, nil
+}
+
+// DeleteSeatingConstraint deletes a seating constraint, if it is at the given version
+func (a *SeatingPostgresAccess) DeleteSeatingConstraint(tx Tx, id int64, version int64) (*models.SeatingConstraint, error) {
+	ptx := pgTx(tx)
+	result, err := ptx.Model((*models.SeatingConstraint)(nil)).
+		Where("id = ?", id).
+		Where("organization_id = ?", OrganizationID(tx)).
+		Apply(whereVersion(version)).
+		Delete()
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	return nil, checkVersion(result, version)
+}
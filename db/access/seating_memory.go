@@ -0,0 +1,239 @@
+package access
+
+import (
+	"github.com/kyrstenkelly/rsvp-api/db/models"
+	"sort"
+)
+
+// SeatingMemoryAccess in-memory implementation of a SeatingDAO
+type SeatingMemoryAccess struct {
+}
+
+// NewSeatingMemoryDAO Create a new in-memory seating dao
+func NewSeatingMemoryDAO() SeatingAccess {
+	return &SeatingMemoryAccess{}
+}
+
+// storedSeatingConstraint copies a seating constraint for the tables
+func storedSeatingConstraint(constraint models.SeatingConstraint) models.SeatingConstraint {
+	constraint.GuestIDs = copyInt64s(constraint.GuestIDs)
+	constraint.InvitationIDs = copyInt64s(constraint.InvitationIDs)
+	if constraint.GuestIDs == nil {
+		constraint.GuestIDs = []int64{}
+	}
+	if constraint.InvitationIDs == nil {
+		constraint.InvitationIDs = []int64{}
+	}
+	return constraint
+}
+
+// GetSeatingTables gets an event's tables
+func (a *SeatingMemoryAccess) GetSeatingTables(tx Tx, eventID int64) ([]models.SeatingTable, error) {
+	tables := memTx(tx).tables
+	var ids []int64
+	for id, table := range tables.seatingTables {
+		if table.OrganizationID == OrganizationID(tx) && table.EventID == eventID {
+			ids = append(ids, id)
+		}
+	}
+	sortInt64s(ids)
+
+	seatingTables := []models.SeatingTable{}
+	for _, id := range ids {
+		seatingTables = append(seatingTables, tables.seatingTables[id])
+	}
+	return seatingTables, nil
+}
+
+// GetSeatingTable gets a table by id
+func (a *SeatingMemoryAccess) GetSeatingTable(tx Tx, id int64) (*models.SeatingTable, error) {
+	table, ok := memTx(tx).tables.seatingTables[id]
+	if !ok || table.OrganizationID != OrganizationID(tx) {
+		return nil, nil
+	}
+	return &table, nil
+}
+
+// CreateSeatingTable creates a table
+func (a *SeatingMemoryAccess) CreateSeatingTable(tx Tx, table *models.SeatingTable) (*models.SeatingTable, error) {
+	tables := memTx(tx).tables
+	if event, ok := tables.events[table.EventID]; !ok || event.OrganizationID != OrganizationID(tx) {
+		return nil, foreignKeyError("events", table.EventID, "seating_tables")
+	}
+	table.ID = tables.nextID("seating_tables")
+	table.OrganizationID = OrganizationID(tx)
+	table.Version = 1
+	table.CreatedAt = memTx(tx).now
+	tables.seatingTables[table.ID] = *table
+	return table, nil
+}
+
+// UpdateSeatingTable replaces a table's name and capacity, if its version is the given table's Version
+func (a *SeatingMemoryAccess) UpdateSeatingTable(tx Tx, table *models.SeatingTable) (*models.SeatingTable, error) {
+	existing, _ := a.GetSeatingTable(tx, table.ID)
+	if existing == nil {
+		return nil, nil
+	}
+	if err := matchVersion(existing.Version, table.Version); err != nil {
+		return nil, err
+	}
+	existing.Version++
+	existing.Name = table.Name
+	existing.Capacity = table.Capacity
+	memTx(tx).tables.seatingTables[existing.ID] = *existing
+	return a.GetSeatingTable(tx, table.ID)
+}
+
+// DeleteSeatingTable deletes a table, if it is at the given version. The guests seated at it are unseated.
+func (a *SeatingMemoryAccess) DeleteSeatingTable(tx Tx, id int64, version int64) (*models.SeatingTable, error) {
+	tables := memTx(tx).tables
+	existing, _ := a.GetSeatingTable(tx, id)
+	if existing == nil {
+		return nil, matchVersion(0, version)
+	}
+	if err := matchVersion(existing.Version, version); err != nil {
+		return nil, err
+	}
+	deleteSeatingTable(tables, id)
+	return nil, nil
+}
+
+// deleteSeatingTable deletes a table along with its seats, as the foreign keys cascade in postgres
+func deleteSeatingTable(tables *memoryTables, id int64) {
+	for rsvpGuestID, tableID := range tables.seats {
+		if tableID == id {
+			delete(tables.seats, rsvpGuestID)
+		}
+	}
+	delete(tables.seatingTables, id)
+}
+
+// GetSeatingGuests gets the guests attending an event, from the accepted responses to it, with the
+// tables they are seated at. They are ordered by invitation, so households
+// are listed together.
+func (a *SeatingMemoryAccess) GetSeatingGuests(tx Tx, eventID int64) ([]models.SeatingGuest, error) {
+	tables := memTx(tx).tables
+	guests := []models.SeatingGuest{}
+	for id, rsvpGuest := range tables.rsvpGuests {
+		if rsvpGuest.OrganizationID != OrganizationID(tx) || !rsvpGuest.Attending {
+			continue
+		}
+		rsvp, ok := tables.rsvps[rsvpGuest.RsvpID]
+		if !ok || rsvp.DeletedAt != nil {
+			continue
+		}
+		invitation, ok := tables.invitations[rsvp.InvitationID]
+		if !ok || rsvpGuest.EventID != eventID || invitation.DeletedAt != nil {
+			continue
+		}
+		guest := models.SeatingGuest{
+			RSVPGuestID:    id,
+			GuestID:        rsvpGuest.GuestID,
+			Name:           tables.guests[rsvpGuest.GuestID].Name,
+			InvitationID:   invitation.ID,
+			InvitationName: invitation.Name,
+			IsPlusOne:      rsvpGuest.IsPlusOne,
+			FoodChoice:     rsvpGuest.FoodChoice,
+		}
+		if tableID, ok := tables.seats[id]; ok {
+			guest.TableID = &tableID
+		}
+		guests = append(guests, guest)
+	}
+	sort.Slice(guests, func(i, j int) bool {
+		if guests[i].InvitationName != guests[j].InvitationName {
+			return guests[i].InvitationName < guests[j].InvitationName
+		}
+		if guests[i].InvitationID != guests[j].InvitationID {
+			return guests[i].InvitationID < guests[j].InvitationID
+		}
+		return guests[i].RSVPGuestID < guests[j].RSVPGuestID
+	})
+	return guests, nil
+}
+
+// GetSeat gets the seat of an rsvp guest, or nil if they aren't seated
+func (a *SeatingMemoryAccess) GetSeat(tx Tx, rsvpGuestID int64) (*models.Seat, error) {
+	tables := memTx(tx).tables
+	tableID, ok := tables.seats[rsvpGuestID]
+	if !ok || tables.rsvpGuests[rsvpGuestID].OrganizationID != OrganizationID(tx) {
+		return nil, nil
+	}
+	return &models.Seat{RSVPGuestID: rsvpGuestID, TableID: &tableID}, nil
+}
+
+// SetSeats seats each guest at their seat's table, or unseats them if it has none. Callers check
+// the guests and tables belong to the same event, and that the tables have room.
+func (a *SeatingMemoryAccess) SetSeats(tx Tx, seats []models.Seat) error {
+	tables := memTx(tx).tables
+	for _, seat := range seats {
+		if seat.TableID == nil {
+			delete(tables.seats, seat.RSVPGuestID)
+			continue
+		}
+		if _, ok := tables.rsvpGuests[seat.RSVPGuestID]; !ok {
+			return foreignKeyError("rsvp_guests", seat.RSVPGuestID, "seats")
+		}
+		if _, ok := tables.seatingTables[*seat.TableID]; !ok {
+			return foreignKeyError("seating_tables", *seat.TableID, "seats")
+		}
+		tables.seats[seat.RSVPGuestID] = *seat.TableID
+	}
+	return nil
+}
+
+// GetSeatingConstraints gets an event's seating constraints
+func (a *SeatingMemoryAccess) GetSeatingConstraints(tx Tx, eventID int64) ([]models.SeatingConstraint, error) {
+	tables := memTx(tx).tables
+	var ids []int64
+	for id, constraint := range tables.seatingConstraints {
+		if constraint.OrganizationID == OrganizationID(tx) && constraint.EventID == eventID {
+			ids = append(ids, id)
+		}
+	}
+	sortInt64s(ids)
+
+	constraints := []models.SeatingConstraint{}
+	for _, id := range ids {
+		constraints = append(constraints, storedSeatingConstraint(tables.seatingConstraints[id]))
+	}
+	return constraints, nil
+}
+
+// GetSeatingConstraint gets a seating constraint by id
+func (a *SeatingMemoryAccess) GetSeatingConstraint(tx Tx, id int64) (*models.SeatingConstraint, error) {
+	constraint, ok := memTx(tx).tables.seatingConstraints[id]
+	if !ok || constraint.OrganizationID != OrganizationID(tx) {
+		return nil, nil
+	}
+	constraint = storedSeatingConstraint(constraint)
+	return &constraint, nil
+}
+
+// CreateSeatingConstraint creates a seating constraint
+func (a *SeatingMemoryAccess) CreateSeatingConstraint(tx Tx, constraint *models.SeatingConstraint) (*models.SeatingConstraint, error) {
+	tables := memTx(tx).tables
+	if event, ok := tables.events[constraint.EventID]; !ok || event.OrganizationID != OrganizationID(tx) {
+		return nil, foreignKeyError("events", constraint.EventID, "seating_constraints")
+	}
+	*constraint = storedSeatingConstraint(*constraint)
+	constraint.ID = tables.nextID("seating_constraints")
+	constraint.OrganizationID = OrganizationID(tx)
+	constraint.Version = 1
+	constraint.CreatedAt = memTx(tx).now
+	tables.seatingConstraints[constraint.ID] = storedSeatingConstraint(*constraint)
+	return constraint, nil
+}
+
+// DeleteSeatingConstraint deletes a seating constraint, if it is at the given version
+func (a *SeatingMemoryAccess) DeleteSeatingConstraint(tx Tx, id int64, version int64) (*models.SeatingConstraint, error) {
+	existing, _ := a.GetSeatingConstraint(tx, id)
+	if existing == nil {
+		return nil, matchVersion(0, version)
+	}
+	if err := matchVersion(existing.Version, version); err != nil {
+		return nil, err
+	}
+	delete(memTx(tx).tables.seatingConstraints, id)
+	return nil, nil
+}
@@ -0,0 +1,71 @@
+package access
+
+import (
+	"github.com/go-pg/pg/v9"
+)
+
+// Store is a transactor along with a DAO for each table, all backed by the same storage. Writes
+// made through its DAOs are recorded in its audit log, and RSVP and invitation writes queue its
+// webhook deliveries.
+type Store struct {
+	Transactor        Transactor
+	Addresses         AddressesAccess
+	Audit             AuditAccess
+	Events            EventsAccess
+	Exports           ExportsAccess
+	Guests            GuestsAccess
+	Invitations       InvitationsAccess
+	Notifications     NotificationsAccess
+	Organizations     OrganizationsAccess
+	ReminderCampaigns ReminderCampaignsAccess
+	Reports           ReportsAccess
+	RSVPGuests        RSVPGuestsAccess
+	RSVPs             RSVPsAccess
+	Seating           SeatingAccess
+	Trash             TrashAccess
+	Webhooks          WebhooksAccess
+}
+
+// NewPostgresStore creates a store backed by the given database
+func NewPostgresStore(db *pg.DB) *Store {
+	return auditedStore(webhookStore(&Store{
+		Transactor:        NewPostgresTransactor(db),
+		Addresses:         NewAddressesDAO(),
+		Audit:             NewAuditDAO(),
+		Events:            NewEventsDAO(),
+		Exports:           NewExportsDAO(),
+		Guests:            NewGuestsDAO(),
+		Invitations:       NewInvitationsDAO(),
+		Notifications:     NewNotificationsDAO(),
+		Organizations:     NewOrganizationsDAO(),
+		ReminderCampaigns: NewReminderCampaignsDAO(),
+		Reports:           NewReportsDAO(),
+		RSVPGuests:        NewRSVPGuestsDAO(),
+		RSVPs:             NewRSVPsDAO(),
+		Seating:           NewSeatingDAO(),
+		Trash:             NewTrashDAO(),
+		Webhooks:          NewWebhooksDAO(),
+	}))
+}
+
+// NewMemoryStore creates a store that keeps everything in memory, starting with just the default organization
+func NewMemoryStore() *Store {
+	return auditedStore(webhookStore(&Store{
+		Transactor:        NewMemoryTransactor(),
+		Addresses:         NewAddressesMemoryDAO(),
+		Audit:             NewAuditMemoryDAO(),
+		Events:            NewEventsMemoryDAO(),
+		Exports:           NewExportsMemoryDAO(),
+		Guests:            NewGuestsMemoryDAO(),
+		Invitations:       NewInvitationsMemoryDAO(),
+		Notifications:     NewNotificationsMemoryDAO(),
+		Organizations:     NewOrganizationsMemoryDAO(),
+		ReminderCampaigns: NewReminderCampaignsMemoryDAO(),
+		Reports:           NewReportsMemoryDAO(),
+		RSVPGuests:        NewRSVPGuestsMemoryDAO(),
+		RSVPs:             NewRSVPsMemoryDAO(),
+		Seating:           NewSeatingMemoryDAO(),
+		Trash:             NewTrashMemoryDAO(),
+		Webhooks:          NewWebhooksMemoryDAO(),
+	}))
+}
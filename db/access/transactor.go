@@ -0,0 +1,70 @@
+package access
+
+import (
+	"context"
+	"github.com/go-pg/pg/v9"
+	log "github.com/sirupsen/logrus"
+)
+
+// Tx is a unit of work. Every DAO call takes one, and everything done with the same Tx
+// is committed or rolled back together. Its context carries the request's cancellation,
+// deadline and organization.
+type Tx interface {
+	Context() context.Context
+}
+
+// Transactor runs units of work. Handlers are given one rather than reaching for a connection.
+type Transactor interface {
+	// RunInTransaction calls fn with a new Tx begun with ctx. The Tx is committed if fn
+	// returns nil and rolled back otherwise.
+	RunInTransaction(ctx context.Context, fn func(tx Tx) error) error
+}
+
+// Run runs a DAO call in a unit of work, returning its result
+func Run(ctx context.Context, transactor Transactor, call func(tx Tx) (interface{}, error)) (interface{}, error) {
+	var result interface{}
+	err := transactor.RunInTransaction(ctx, func(tx Tx) (err error) {
+		result, err = call(tx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// postgresTx is a Tx backed by a postgres transaction
+type postgresTx struct {
+	*pg.Tx
+}
+
+// pgTx gets the postgres transaction behind a Tx. The postgres DAOs may only be used with a
+// PostgresTransactor.
+func pgTx(tx Tx) *pg.Tx {
+	return tx.(*postgresTx).Tx
+}
+
+// PostgresTransactor runs units of work as postgres transactions
+type PostgresTransactor struct {
+	db *pg.DB
+}
+
+// NewPostgresTransactor creates a transactor for the given database
+func NewPostgresTransactor(db *pg.DB) *PostgresTransactor {
+	return &PostgresTransactor{db: db}
+}
+
+// RunInTransaction runs fn in a postgres transaction whose queries are cancelled with ctx
+func (t *PostgresTransactor) RunInTransaction(ctx context.Context, fn func(tx Tx) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	tx, err := t.db.WithContext(ctx).Begin()
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	return tx.RunInTransaction(func(tx *pg.Tx) error {
+		return fn(&postgresTx{tx})
+	})
+}
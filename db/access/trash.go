@@ -0,0 +1,91 @@
+package access
+
+import (
+	"github.com/kyrstenkelly/rsvp-api/db/models"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+// TrashPostgresAccess postgres implementation of a TrashDAO
+type TrashPostgresAccess struct {
+}
+
+// TrashAccess interface for the trash, where deleted events, invitations and RSVPs are kept until
+// they are purged. They are restored through their own DAOs.
+type TrashAccess interface {
+	GetTrash(tx Tx) ([]models.TrashItem, error)
+	PurgeTrash(tx Tx, deletedBefore time.Time) (*models.PurgeResult, error)
+}
+
+// NewTrashDAO Create a new trash dao
+func NewTrashDAO() TrashAccess {
+	return &TrashPostgresAccess{}
+}
+
+// GetTrash gets everything in the trash, most recently deleted first
+func (a *TrashPostgresAccess) GetTrash(tx Tx) ([]models.TrashItem, error) {
+	ptx := pgTx(tx)
+	items := []models.TrashItem{}
+	_, err := ptx.Query(&items,
+		`SELECT 'event' AS entity, id, name, version, deleted_at
+		FROM events
+		WHERE organization_id = ?0 AND deleted_at IS NOT NULL
+		UNION ALL
+		SELECT 'invitation', id, name, version, deleted_at
+		FROM invitations
+		WHERE organization_id = ?0 AND deleted_at IS NOT NULL
+		UNION ALL
+		SELECT 'rsvp', r.id, i.name, r.version, r.deleted_at
+		FROM rsvps r
+		JOIN invitations i ON i.id = r.invitation_id
+		WHERE r.organization_id = ?0 AND r.deleted_at IS NOT NULL AND i.deleted_at IS NULL
+		ORDER BY deleted_at DESC, entity, id`, OrganizationID(tx))
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	return items, nil
+}
+
+// PurgeTrash removes everything that was deleted before deletedBefore for good. RSVPs go with
+// their rsvp guests, and invitations with their RSVPs and guests, plus ones included. Events are
+// only purged once their invitations have been.
+func (a *TrashPostgresAccess) PurgeTrash(tx Tx, deletedBefore time.Time) (*models.PurgeResult, error) {
+	ptx := pgTx(tx)
+	organizationID := OrganizationID(tx)
+	purged := &models.PurgeResult{}
+
+	const purgedRSVPs = `SELECT r.id FROM rsvps r JOIN invitations i ON i.id = r.invitation_id
+		WHERE r.organization_id = ?0 AND (r.deleted_at < ?1 OR i.deleted_at < ?1)`
+	_, err := ptx.Exec(`DELETE FROM rsvp_guests WHERE rsvp_id IN (`+purgedRSVPs+`)`, organizationID, deletedBefore)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	result, err := ptx.Exec(`DELETE FROM rsvps WHERE id IN (`+purgedRSVPs+`)`, organizationID, deletedBefore)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	purged.RSVPs = result.RowsAffected()
+
+	// Deleting the invitations cascades to their guests, plus ones included
+	result, err = ptx.Exec(`DELETE FROM invitations WHERE organization_id = ?0 AND deleted_at < ?1`, organizationID, deletedBefore)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	purged.Invitations = result.RowsAffected()
+
+	// Deleting an event cascades to its reminder campaigns and seating
+	result, err = ptx.Exec(
+		`DELETE FROM events e
+		WHERE e.organization_id = ?0 AND e.deleted_at < ?1
+			AND NOT EXISTS (SELECT 1 FROM invitation_events ie WHERE ie.event_id = e.id)`, organizationID, deletedBefore)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	purged.Events = result.RowsAffected()
+	return purged, nil
+}
@@ -0,0 +1,129 @@
+package access
+
+import (
+	"github.com/kyrstenkelly/rsvp-api/db/models"
+	"sort"
+	"time"
+)
+
+// TrashMemoryAccess in-memory implementation of a TrashDAO
+type TrashMemoryAccess struct {
+}
+
+// NewTrashMemoryDAO Create a new in-memory trash dao
+func NewTrashMemoryDAO() TrashAccess {
+	return &TrashMemoryAccess{}
+}
+
+// GetTrash gets everything in the trash, most recently deleted first
+func (a *TrashMemoryAccess) GetTrash(tx Tx) ([]models.TrashItem, error) {
+	tables := memTx(tx).tables
+	items := []models.TrashItem{}
+	for _, event := range tables.events {
+		if event.OrganizationID == OrganizationID(tx) && event.DeletedAt != nil {
+			items = append(items, models.TrashItem{Entity: AuditEvent, ID: event.ID, Name: event.Name,
+				Version: event.Version, DeletedAt: *event.DeletedAt})
+		}
+	}
+	for _, invitation := range tables.invitations {
+		if invitation.OrganizationID == OrganizationID(tx) && invitation.DeletedAt != nil {
+			items = append(items, models.TrashItem{Entity: AuditInvitation, ID: invitation.ID, Name: invitation.Name,
+				Version: invitation.Version, DeletedAt: *invitation.DeletedAt})
+		}
+	}
+	for _, rsvp := range tables.rsvps {
+		invitation := tables.invitations[rsvp.InvitationID]
+		if rsvp.OrganizationID == OrganizationID(tx) && rsvp.DeletedAt != nil && invitation.DeletedAt == nil {
+			items = append(items, models.TrashItem{Entity: AuditRSVP, ID: rsvp.ID, Name: invitation.Name,
+				Version: rsvp.Version, DeletedAt: *rsvp.DeletedAt})
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if !items[i].DeletedAt.Equal(items[j].DeletedAt) {
+			return items[i].DeletedAt.After(items[j].DeletedAt)
+		}
+		if items[i].Entity != items[j].Entity {
+			return items[i].Entity < items[j].Entity
+		}
+		return items[i].ID < items[j].ID
+	})
+	return items, nil
+}
+
+// PurgeTrash removes everything that was deleted before deletedBefore for good. RSVPs go with
+// their rsvp guests, and invitations with their RSVPs and guests, plus ones included. Events are
+// only purged once their invitations have been.
+func (a *TrashMemoryAccess) PurgeTrash(tx Tx, deletedBefore time.Time) (*models.PurgeResult, error) {
+	tables := memTx(tx).tables
+	purged := &models.PurgeResult{}
+	expired := func(deletedAt *time.Time) bool {
+		return deletedAt != nil && deletedAt.Before(deletedBefore)
+	}
+
+	for id, rsvp := range tables.rsvps {
+		if rsvp.OrganizationID != OrganizationID(tx) ||
+			!(expired(rsvp.DeletedAt) || expired(tables.invitations[rsvp.InvitationID].DeletedAt)) {
+			continue
+		}
+		for rsvpGuestID, rsvpGuest := range tables.rsvpGuests {
+			if rsvpGuest.RsvpID == id {
+				delete(tables.rsvpGuests, rsvpGuestID)
+				delete(tables.seats, rsvpGuestID)
+			}
+		}
+		delete(tables.rsvps, id)
+		purged.RSVPs++
+	}
+
+	for id, invitation := range tables.invitations {
+		if invitation.OrganizationID != OrganizationID(tx) || !expired(invitation.DeletedAt) {
+			continue
+		}
+		delete(tables.invitationGuests, id)
+		delete(tables.invitationEvents, id)
+		delete(tables.invitations, id)
+		for notificationID, notification := range tables.notifications {
+			if notification.InvitationID != nil && *notification.InvitationID == id {
+				notification.InvitationID = nil
+				tables.notifications[notificationID] = notification
+			}
+		}
+		purged.Invitations++
+	}
+	// Guests go with their invitation
+	for id, guest := range tables.guests {
+		if _, ok := tables.invitations[guest.InvitationID]; guest.InvitationID != 0 && !ok {
+			delete(tables.guests, id)
+		}
+	}
+
+	invited := map[int64]bool{}
+	for _, invitationEvents := range tables.invitationEvents {
+		for _, invitationEvent := range invitationEvents {
+			invited[invitationEvent.EventID] = true
+		}
+	}
+	for id, event := range tables.events {
+		if event.OrganizationID != OrganizationID(tx) || !expired(event.DeletedAt) || invited[id] {
+			continue
+		}
+		for campaignID, campaign := range tables.campaigns {
+			if campaign.EventID == id {
+				delete(tables.campaigns, campaignID)
+			}
+		}
+		for tableID, table := range tables.seatingTables {
+			if table.EventID == id {
+				deleteSeatingTable(tables, tableID)
+			}
+		}
+		for constraintID, constraint := range tables.seatingConstraints {
+			if constraint.EventID == id {
+				delete(tables.seatingConstraints, constraintID)
+			}
+		}
+		delete(tables.events, id)
+		purged.Events++
+	}
+	return purged, nil
+}
@@ -0,0 +1,58 @@
+package access
+
+import (
+	"github.com/go-pg/pg/v9/orm"
+	"github.com/kyrstenkelly/rsvp-api/utils"
+	log "github.com/sirupsen/logrus"
+)
+
+// AnyVersion matches every version of a row, for writes that aren't conditional
+const AnyVersion int64 = 0
+
+// whereVersion limits an update or delete to the given version of a row, unless it is AnyVersion
+func whereVersion(version int64) func(*orm.Query) (*orm.Query, error) {
+	return func(q *orm.Query) (*orm.Query, error) {
+		if version == AnyVersion {
+			return q, nil
+		}
+		return q.Where("version = ?", version), nil
+	}
+}
+
+// checkVersion returns a HTTPPreconditionFailedError if a conditional update or delete didn't match a row
+func checkVersion(result orm.Result, version int64) error {
+	if version != AnyVersion && result.RowsAffected() == 0 {
+		return utils.HTTPPreconditionFailedError.Here()
+	}
+	return nil
+}
+
+// matchVersion returns a HTTPPreconditionFailedError if a row's current version isn't the given version,
+// doing what whereVersion and checkVersion do in sql for rows already in hand
+func matchVersion(current int64, version int64) error {
+	if version != AnyVersion && current != version {
+		return utils.HTTPPreconditionFailedError.Here()
+	}
+	return nil
+}
+
+// checkTrashed returns an error if moving a row to the trash matched no row: a HTTPNotFoundError if the
+// organization has no such row out of the trash, or a HTTPPreconditionFailedError if it is at another version
+func checkTrashed(tx Tx, model interface{}, id int64, result orm.Result) error {
+	if result.RowsAffected() > 0 {
+		return nil
+	}
+	exists, err := pgTx(tx).Model(model).
+		Where("id = ?", id).
+		Where("organization_id = ?", OrganizationID(tx)).
+		Where("deleted_at IS NULL").
+		Exists()
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	if exists {
+		return utils.HTTPPreconditionFailedError.Here()
+	}
+	return utils.HTTPNotFoundError.Here()
+}
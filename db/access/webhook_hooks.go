@@ -0,0 +1,105 @@
+package access
+
+import (
+	"github.com/kyrstenkelly/rsvp-api/db/models"
+)
+
+// webhookHook queues webhook deliveries for the RSVP and invitation writes made through the DAOs
+// it wraps. Deliveries are queued in the write's transaction, so they go out if and only if the
+// write commits. The data is the row as the API serves it, after the write, or before it for deletes.
+type webhookHook struct {
+	webhooks WebhooksAccess
+}
+
+// webhookStore wraps the store's DAOs so that their writes queue webhook deliveries
+func webhookStore(store *Store) *Store {
+	hook := webhookHook{webhooks: store.Webhooks}
+	store.Invitations = &webhookInvitations{InvitationsAccess: store.Invitations, rsvps: store.RSVPs, hook: hook}
+	store.RSVPs = &webhookRSVPs{RSVPsAccess: store.RSVPs, hook: hook}
+	return store
+}
+
+// queue queues an event for a row, unless there is no row
+func (h webhookHook) queue(tx Tx, event string, row interface{}) error {
+	data, err := auditDocument(row)
+	if err != nil || data == nil {
+		return err
+	}
+	return h.webhooks.QueueWebhookEvent(tx, event, data)
+}
+
+type webhookInvitations struct {
+	InvitationsAccess
+	rsvps RSVPsAccess
+	hook  webhookHook
+}
+
+// CreateInvitation creates an invitation, queueing invitation.created
+func (a *webhookInvitations) CreateInvitation(tx Tx, invitation *models.Invitation) (*models.Invitation, error) {
+	created, err := a.InvitationsAccess.CreateInvitation(tx, invitation)
+	if err != nil || created == nil {
+		return created, err
+	}
+	row, err := a.InvitationsAccess.GetInvitation(tx, created.ID)
+	if err != nil {
+		return nil, err
+	}
+	return created, a.hook.queue(tx, models.WebhookInvitationCreated, row)
+}
+
+// DeleteInvitation deletes an invitation, queueing rsvp.deleted for the RSVP that goes with it
+func (a *webhookInvitations) DeleteInvitation(tx Tx, id int64, version int64) (*models.Invitation, error) {
+	rsvp, err := a.rsvps.GetRSVPByInvitation(tx, id)
+	if err != nil {
+		return nil, err
+	}
+	deleted, err := a.InvitationsAccess.DeleteInvitation(tx, id, version)
+	if err != nil {
+		return nil, err
+	}
+	return deleted, a.hook.queue(tx, models.WebhookRSVPDeleted, rsvp)
+}
+
+type webhookRSVPs struct {
+	RSVPsAccess
+	hook webhookHook
+}
+
+// CreateRSVP creates an rsvp, queueing rsvp.created
+func (a *webhookRSVPs) CreateRSVP(tx Tx, rsvp *models.RSVP, enforceDeadline bool) (*models.RSVP, error) {
+	created, err := a.RSVPsAccess.CreateRSVP(tx, rsvp, enforceDeadline)
+	if err != nil || created == nil {
+		return created, err
+	}
+	row, err := a.RSVPsAccess.GetRSVP(tx, created.ID)
+	if err != nil {
+		return nil, err
+	}
+	return created, a.hook.queue(tx, models.WebhookRSVPCreated, row)
+}
+
+// UpdateRSVP updates an rsvp, queueing rsvp.updated
+func (a *webhookRSVPs) UpdateRSVP(tx Tx, rsvp *models.RSVP, enforceDeadline bool) (*models.RSVP, error) {
+	updated, err := a.RSVPsAccess.UpdateRSVP(tx, rsvp, enforceDeadline)
+	if err != nil || updated == nil {
+		return updated, err
+	}
+	row, err := a.RSVPsAccess.GetRSVP(tx, rsvp.ID)
+	if err != nil {
+		return nil, err
+	}
+	return updated, a.hook.queue(tx, models.WebhookRSVPUpdated, row)
+}
+
+// DeleteRSVP deletes an rsvp, queueing rsvp.deleted
+func (a *webhookRSVPs) DeleteRSVP(tx Tx, id int64, version int64) (*models.RSVP, error) {
+	row, err := a.RSVPsAccess.GetRSVP(tx, id)
+	if err != nil {
+		return nil, err
+	}
+	deleted, err := a.RSVPsAccess.DeleteRSVP(tx, id, version)
+	if err != nil {
+		return nil, err
+	}
+	return deleted, a.hook.queue(tx, models.WebhookRSVPDeleted, row)
+}
@@ -0,0 +1,285 @@
+package access
+
+import (
+	"encoding/json"
+	"github.com/go-pg/pg/v9"
+	"github.com/kyrstenkelly/rsvp-api/db/models"
+	"github.com/kyrstenkelly/rsvp-api/utils"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+// claimableDelivery matches the deliveries of active webhooks that are due to be sent: pending ones
+// whose next attempt has come, and ones whose sender has held them for so long it has probably
+// died mid-send
+const claimableDelivery = `w.active AND (
+		(d.status = 'pending' AND d.next_attempt_at <= now())
+		OR (d.status = 'delivering' AND d.claimed_at < now() - interval '10 minutes'))`
+
+// WebhooksPostgresAccess postgres implementation of a WebhooksDAO
+type WebhooksPostgresAccess struct {
+}
+
+// WebhooksAccess interface for a webhooks data access object, covering the webhooks and the
+// outbox of deliveries queued for them
+type WebhooksAccess interface {
+	GetWebhooks(tx Tx) ([]models.Webhook, error)
+	GetWebhook(tx Tx, id int64) (*models.Webhook, error)
+	CreateWebhook(tx Tx, webhook *models.Webhook) (*models.Webhook, error)
+	UpdateWebhook(tx Tx, webhook *models.Webhook) (*models.Webhook, error)
+	DeleteWebhook(tx Tx, id int64, version int64) (*models.Webhook, error)
+	QueueWebhookEvent(tx Tx, event string, data map[string]interface{}) error
+	GetWebhookDeliveries(tx Tx, list ListQuery) ([]models.WebhookDelivery, int, error)
+	GetWebhookDelivery(tx Tx, id int64) (*models.WebhookDelivery, error)
+	ClaimWebhookDeliveries(tx Tx, limit int) ([]models.WebhookDelivery, error)
+	MarkWebhookDelivered(tx Tx, id int64, responseStatus int) error
+	MarkWebhookFailed(tx Tx, id int64, responseStatus *int, deliveryErr string, nextAttemptAt *time.Time) error
+	ReplayWebhookDelivery(tx Tx, id int64) (*models.WebhookDelivery, error)
+	ReplayDeadWebhookDeliveries(tx Tx, webhookID int64) (int, error)
+}
+
+// NewWebhooksDAO Create a new webhooks dao
+func NewWebhooksDAO() WebhooksAccess {
+	return &WebhooksPostgresAccess{}
+}
+
+// WebhookDeliveriesList is how webhook deliveries can be filtered and sorted
+var WebhookDeliveriesList = ListSpec{
+	Fields: map[string]ListField{
+		"id":         {Column: "webhook_delivery.id", Kind: ListInt, Sort: true},
+		"webhook_id": {Column: "webhook_delivery.webhook_id", Kind: ListInt, Filter: true},
+		"event":      {Column: "webhook_delivery.event", Kind: ListString, Filter: true},
+		"status":     {Column: "webhook_delivery.status", Kind: ListString, Filter: true},
+	},
+}
+
+// GetWebhooks gets all webhooks
+func (a *WebhooksPostgresAccess) GetWebhooks(tx Tx) ([]models.Webhook, error) {
+	ptx := pgTx(tx)
+	webhooks := []models.Webhook{}
+	err := ptx.Model(&webhooks).
+		Where("webhook.organization_id = ?", OrganizationID(tx)).
+		Order("webhook.id").
+		Select()
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	return webhooks, nil
+}
+
+// GetWebhook gets a webhook by id
+func (a *WebhooksPostgresAccess) GetWebhook(tx Tx, id int64) (*models.Webhook, error) {
+	ptx := pgTx(tx)
+	webhook := new(models.Webhook)
+	err := ptx.Model(webhook).
+		Where("webhook.id = ?", id).
+		Where("webhook.organization_id = ?", OrganizationID(tx)).
+		Select()
+
+	if err == pg.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	return webhook, nil
+}
+
+// CreateWebhook creates a webhook
+func (a *WebhooksPostgresAccess) CreateWebhook(tx Tx, webhook *models.Webhook) (*models.Webhook, error) {
+	ptx := pgTx(tx)
+	webhook.OrganizationID = OrganizationID(tx)
+	webhook.Version = 1
+	_, err := ptx.Model(webhook).Returning("*").Insert()
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	return webhook, nil
+}
+
+// UpdateWebhook replaces a webhook's url, secret, events and active flag, if its version is the
+// given webhook's Version
+func (a *WebhooksPostgresAccess) UpdateWebhook(tx Tx, webhook *models.Webhook) (*models.Webhook, error) {
+	ptx := pgTx(tx)
+	result, err := ptx.Model(webhook).
+		Set("url = ?url, secret = ?secret, events = ?events, active = ?active, version = version + 1").
+		Where("id = ?id").
+		Where("organization_id = ?", OrganizationID(tx)).
+		Apply(whereVersion(webhook.Version)).
+		Update()
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	if err := checkVersion(result, webhook.Version); err != nil {
+		return nil, err
+	}
+	return a.GetWebhook(tx, webhook.ID)
+}
+
+// DeleteWebhook deletes a webhook along with its deliveries, if it is at the given version
+func (a *WebhooksPostgresAccess) DeleteWebhook(tx Tx, id int64, version int64) (*models.Webhook, error) {
+	ptx := pgTx(tx)
+	result, err := ptx.Model((*models.Webhook)(nil)).
+		Where("id = ?", id).
+		Where("organization_id = ?", OrganizationID(tx)).
+		Apply(whereVersion(version)).
+		Delete()
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	return nil, checkVersion(result, version)
+}
+
+// QueueWebhookEvent queues a delivery of an event for every active webhook that subscribes to it.
+// It must be called in the transaction that made the change the event describes.
+func (a *WebhooksPostgresAccess) QueueWebhookEvent(tx Tx, event string, data map[string]interface{}) error {
+	ptx := pgTx(tx)
+	document, err := json.Marshal(data)
+	if err != nil {
+		return utils.JSONMarshalingError.Here()
+	}
+	_, err = ptx.Exec(
+		`INSERT INTO webhook_deliveries (organization_id, webhook_id, event, data)
+		SELECT organization_id, id, ?0, ?1::jsonb
+		FROM webhooks
+		WHERE organization_id = ?2 AND active AND ?0 = ANY(events)`, event, string(document), OrganizationID(tx))
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	return nil
+}
+
+// GetWebhookDeliveries gets a page of webhook deliveries, along with how many match the list's filters
+func (a *WebhooksPostgresAccess) GetWebhookDeliveries(tx Tx, list ListQuery) ([]models.WebhookDelivery, int, error) {
+	ptx := pgTx(tx)
+	deliveries := []models.WebhookDelivery{}
+	query := ptx.Model(&deliveries).
+		Where("webhook_delivery.organization_id = ?", OrganizationID(tx))
+	total, err := applyListQuery(query, WebhookDeliveriesList, list).SelectAndCount()
+	if err != nil {
+		log.Error(err)
+		return nil, 0, err
+	}
+	return deliveries, total, nil
+}
+
+// GetWebhookDelivery gets a webhook delivery by id
+func (a *WebhooksPostgresAccess) GetWebhookDelivery(tx Tx, id int64) (*models.WebhookDelivery, error) {
+	ptx := pgTx(tx)
+	delivery := new(models.WebhookDelivery)
+	err := ptx.Model(delivery).
+		Where("webhook_delivery.id = ?", id).
+		Where("webhook_delivery.organization_id = ?", OrganizationID(tx)).
+		Select()
+
+	if err == pg.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	return delivery, nil
+}
+
+// ClaimWebhookDeliveries claims up to limit deliveries that are due to be sent, counting an attempt for each
+func (a *WebhooksPostgresAccess) ClaimWebhookDeliveries(tx Tx, limit int) ([]models.WebhookDelivery, error) {
+	ptx := pgTx(tx)
+	deliveries := []models.WebhookDelivery{}
+	_, err := ptx.Query(&deliveries,
+		`UPDATE webhook_deliveries
+		SET status = 'delivering', attempts = attempts + 1, claimed_at = now()
+		WHERE id IN (
+			SELECT d.id FROM webhook_deliveries d
+			JOIN webhooks w ON w.id = d.webhook_id
+			WHERE d.organization_id = ? AND `+claimableDelivery+`
+			ORDER BY d.id
+			LIMIT ?
+			FOR UPDATE OF d SKIP LOCKED
+		)
+		RETURNING *`, OrganizationID(tx), limit)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// MarkWebhookDelivered records that a webhook accepted a delivery
+func (a *WebhooksPostgresAccess) MarkWebhookDelivered(tx Tx, id int64, responseStatus int) error {
+	ptx := pgTx(tx)
+	_, err := ptx.Exec(
+		`UPDATE webhook_deliveries
+		SET status = 'delivered', delivered_at = now(), response_status = ?, error = NULL, claimed_at = NULL
+		WHERE id = ? AND organization_id = ?`, responseStatus, id, OrganizationID(tx))
+	if err != nil {
+		log.Error(err)
+	}
+	return err
+}
+
+// MarkWebhookFailed records that a delivery failed. It is retried at nextAttemptAt, or is dead if that is nil.
+// responseStatus is nil if the webhook didn't respond.
+func (a *WebhooksPostgresAccess) MarkWebhookFailed(tx Tx, id int64, responseStatus *int, deliveryErr string,
+	nextAttemptAt *time.Time) error {
+	ptx := pgTx(tx)
+	var err error
+	if nextAttemptAt == nil {
+		_, err = ptx.Exec(
+			`UPDATE webhook_deliveries
+			SET status = 'dead', response_status = ?, error = ?, claimed_at = NULL
+			WHERE id = ? AND organization_id = ?`, responseStatus, deliveryErr, id, OrganizationID(tx))
+	} else {
+		_, err = ptx.Exec(
+			`UPDATE webhook_deliveries
+			SET status = 'pending', next_attempt_at = ?, response_status = ?, error = ?, claimed_at = NULL
+			WHERE id = ? AND organization_id = ?`, *nextAttemptAt, responseStatus, deliveryErr, id, OrganizationID(tx))
+	}
+	if err != nil {
+		log.Error(err)
+	}
+	return err
+}
+
+// ReplayWebhookDelivery queues a delivery to be sent again straight away, with a fresh set of attempts,
+// whether it was delivered or is dead. It returns nil if there's no such delivery.
+func (a *WebhooksPostgresAccess) ReplayWebhookDelivery(tx Tx, id int64) (*models.WebhookDelivery, error) {
+	delivery, err := a.GetWebhookDelivery(tx, id)
+	if err != nil || delivery == nil {
+		return nil, err
+	}
+	if delivery.Status == models.WebhookDelivering {
+		return nil, utils.ArgumentError.Here().WithMessagef("Delivery %d is being delivered", id)
+	}
+
+	ptx := pgTx(tx)
+	_, err = ptx.Exec(
+		`UPDATE webhook_deliveries
+		SET status = 'pending', attempts = 0, next_attempt_at = now(), response_status = NULL, error = NULL,
+			claimed_at = NULL, delivered_at = NULL
+		WHERE id = ? AND organization_id = ?`, id, OrganizationID(tx))
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	return a.GetWebhookDelivery(tx, id)
+}
+
+// ReplayDeadWebhookDeliveries queues every dead delivery of a webhook to be sent again straight away,
+// returning how many were queued
+func (a *WebhooksPostgresAccess) ReplayDeadWebhookDeliveries(tx Tx, webhookID int64) (int, error) {
+	ptx := pgTx(tx)
+	result, err := ptx.Exec(
+		`UPDATE webhook_deliveries
+		SET status = 'pending', attempts = 0, next_attempt_at = now(), response_status = NULL, error = NULL
+		WHERE webhook_id = ? AND organization_id = ? AND status = 'dead'`, webhookID, OrganizationID(tx))
+	if err != nil {
+		log.Error(err)
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
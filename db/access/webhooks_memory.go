@@ -0,0 +1,304 @@
+package access
+
+import (
+	"github.com/kyrstenkelly/rsvp-api/db/models"
+	"github.com/kyrstenkelly/rsvp-api/utils"
+	"time"
+)
+
+// WebhooksMemoryAccess in-memory implementation of a WebhooksDAO
+type WebhooksMemoryAccess struct {
+}
+
+// NewWebhooksMemoryDAO Create a new in-memory webhooks dao
+func NewWebhooksMemoryDAO() WebhooksAccess {
+	return &WebhooksMemoryAccess{}
+}
+
+// storedWebhook copies a webhook for the tables
+func storedWebhook(webhook models.Webhook) models.Webhook {
+	webhook.Events = copyStrings(webhook.Events)
+	return webhook
+}
+
+// storedDelivery copies a webhook delivery for the tables. Data is never modified in place, so it is shared.
+func storedDelivery(delivery models.WebhookDelivery) models.WebhookDelivery {
+	if delivery.ClaimedAt != nil {
+		claimedAt := *delivery.ClaimedAt
+		delivery.ClaimedAt = &claimedAt
+	}
+	if delivery.ResponseStatus != nil {
+		responseStatus := *delivery.ResponseStatus
+		delivery.ResponseStatus = &responseStatus
+	}
+	if delivery.DeliveredAt != nil {
+		deliveredAt := *delivery.DeliveredAt
+		delivery.DeliveredAt = &deliveredAt
+	}
+	return delivery
+}
+
+// claimableWebhookDelivery matches the same deliveries as claimableDelivery
+func claimableWebhookDelivery(delivery models.WebhookDelivery, webhook models.Webhook, now time.Time) bool {
+	return webhook.Active &&
+		((delivery.Status == models.WebhookPending && !delivery.NextAttemptAt.After(now)) ||
+			(delivery.Status == models.WebhookDelivering && delivery.ClaimedAt != nil &&
+				delivery.ClaimedAt.Before(now.Add(-10*time.Minute))))
+}
+
+// GetWebhooks gets all webhooks
+func (a *WebhooksMemoryAccess) GetWebhooks(tx Tx) ([]models.Webhook, error) {
+	tables := memTx(tx).tables
+	var ids []int64
+	for id, webhook := range tables.webhooks {
+		if webhook.OrganizationID == OrganizationID(tx) {
+			ids = append(ids, id)
+		}
+	}
+	sortInt64s(ids)
+
+	webhooks := []models.Webhook{}
+	for _, id := range ids {
+		webhooks = append(webhooks, storedWebhook(tables.webhooks[id]))
+	}
+	return webhooks, nil
+}
+
+// GetWebhook gets a webhook by id
+func (a *WebhooksMemoryAccess) GetWebhook(tx Tx, id int64) (*models.Webhook, error) {
+	webhook, ok := memTx(tx).tables.webhooks[id]
+	if !ok || webhook.OrganizationID != OrganizationID(tx) {
+		return nil, nil
+	}
+	webhook = storedWebhook(webhook)
+	return &webhook, nil
+}
+
+// CreateWebhook creates a webhook
+func (a *WebhooksMemoryAccess) CreateWebhook(tx Tx, webhook *models.Webhook) (*models.Webhook, error) {
+	tables := memTx(tx).tables
+	webhook.ID = tables.nextID("webhooks")
+	webhook.OrganizationID = OrganizationID(tx)
+	webhook.Version = 1
+	webhook.CreatedAt = memTx(tx).now
+	tables.webhooks[webhook.ID] = storedWebhook(*webhook)
+	return webhook, nil
+}
+
+// UpdateWebhook replaces a webhook's url, secret, events and active flag, if its version is the
+// given webhook's Version
+func (a *WebhooksMemoryAccess) UpdateWebhook(tx Tx, webhook *models.Webhook) (*models.Webhook, error) {
+	existing, _ := a.GetWebhook(tx, webhook.ID)
+	if existing == nil {
+		return nil, nil
+	}
+	if err := matchVersion(existing.Version, webhook.Version); err != nil {
+		return nil, err
+	}
+	existing.Version++
+	existing.URL = webhook.URL
+	existing.Secret = webhook.Secret
+	existing.Events = webhook.Events
+	existing.Active = webhook.Active
+	memTx(tx).tables.webhooks[existing.ID] = storedWebhook(*existing)
+	return a.GetWebhook(tx, webhook.ID)
+}
+
+// DeleteWebhook deletes a webhook along with its deliveries, if it is at the given version
+func (a *WebhooksMemoryAccess) DeleteWebhook(tx Tx, id int64, version int64) (*models.Webhook, error) {
+	tables := memTx(tx).tables
+	existing, _ := a.GetWebhook(tx, id)
+	if existing == nil {
+		return nil, matchVersion(0, version)
+	}
+	if err := matchVersion(existing.Version, version); err != nil {
+		return nil, err
+	}
+	for deliveryID, delivery := range tables.webhookDeliveries {
+		if delivery.WebhookID == id {
+			delete(tables.webhookDeliveries, deliveryID)
+		}
+	}
+	delete(tables.webhooks, id)
+	return nil, nil
+}
+
+// QueueWebhookEvent queues a delivery of an event for every active webhook that subscribes to it.
+// It must be called in the transaction that made the change the event describes.
+func (a *WebhooksMemoryAccess) QueueWebhookEvent(tx Tx, event string, data map[string]interface{}) error {
+	tables := memTx(tx).tables
+	webhooks, err := a.GetWebhooks(tx)
+	if err != nil {
+		return err
+	}
+	for _, webhook := range webhooks {
+		if !webhook.Active || !containsString(webhook.Events, event) {
+			continue
+		}
+		id := tables.nextID("webhook_deliveries")
+		tables.webhookDeliveries[id] = models.WebhookDelivery{
+			ID:             id,
+			OrganizationID: webhook.OrganizationID,
+			WebhookID:      webhook.ID,
+			Event:          event,
+			Data:           data,
+			Status:         models.WebhookPending,
+			NextAttemptAt:  memTx(tx).now,
+			CreatedAt:      memTx(tx).now,
+		}
+	}
+	return nil
+}
+
+// GetWebhookDeliveries gets a page of webhook deliveries, along with how many match the list's filters
+func (a *WebhooksMemoryAccess) GetWebhookDeliveries(tx Tx, list ListQuery) ([]models.WebhookDelivery, int, error) {
+	var rows []models.WebhookDelivery
+	for _, delivery := range memTx(tx).tables.webhookDeliveries {
+		if delivery.OrganizationID == OrganizationID(tx) {
+			rows = append(rows, delivery)
+		}
+	}
+	page, total := listMemoryRows(len(rows), func(i int) map[string]interface{} {
+		return map[string]interface{}{
+			"id":         rows[i].ID,
+			"webhook_id": rows[i].WebhookID,
+			"event":      rows[i].Event,
+			"status":     rows[i].Status,
+		}
+	}, WebhookDeliveriesList, list)
+
+	deliveries := []models.WebhookDelivery{}
+	for _, i := range page {
+		deliveries = append(deliveries, storedDelivery(rows[i]))
+	}
+	return deliveries, total, nil
+}
+
+// GetWebhookDelivery gets a webhook delivery by id
+func (a *WebhooksMemoryAccess) GetWebhookDelivery(tx Tx, id int64) (*models.WebhookDelivery, error) {
+	delivery, ok := memTx(tx).tables.webhookDeliveries[id]
+	if !ok || delivery.OrganizationID != OrganizationID(tx) {
+		return nil, nil
+	}
+	delivery = storedDelivery(delivery)
+	return &delivery, nil
+}
+
+// ClaimWebhookDeliveries claims up to limit deliveries that are due to be sent, counting an attempt for each
+func (a *WebhooksMemoryAccess) ClaimWebhookDeliveries(tx Tx, limit int) ([]models.WebhookDelivery, error) {
+	tables := memTx(tx).tables
+	now := memTx(tx).now
+	var ids []int64
+	for id, delivery := range tables.webhookDeliveries {
+		if delivery.OrganizationID == OrganizationID(tx) &&
+			claimableWebhookDelivery(delivery, tables.webhooks[delivery.WebhookID], now) {
+			ids = append(ids, id)
+		}
+	}
+	sortInt64s(ids)
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+
+	deliveries := []models.WebhookDelivery{}
+	for _, id := range ids {
+		delivery := tables.webhookDeliveries[id]
+		claimedAt := now
+		delivery.Status = models.WebhookDelivering
+		delivery.Attempts++
+		delivery.ClaimedAt = &claimedAt
+		tables.webhookDeliveries[id] = delivery
+		deliveries = append(deliveries, storedDelivery(delivery))
+	}
+	return deliveries, nil
+}
+
+// MarkWebhookDelivered records that a webhook accepted a delivery
+func (a *WebhooksMemoryAccess) MarkWebhookDelivered(tx Tx, id int64, responseStatus int) error {
+	tables := memTx(tx).tables
+	delivery, ok := tables.webhookDeliveries[id]
+	if !ok || delivery.OrganizationID != OrganizationID(tx) {
+		return nil
+	}
+	deliveredAt := memTx(tx).now
+	delivery.Status = models.WebhookDelivered
+	delivery.DeliveredAt = &deliveredAt
+	delivery.ResponseStatus = &responseStatus
+	delivery.Error = ""
+	delivery.ClaimedAt = nil
+	tables.webhookDeliveries[id] = delivery
+	return nil
+}
+
+// MarkWebhookFailed records that a delivery failed. It is retried at nextAttemptAt, or is dead if that is nil.
+// responseStatus is nil if the webhook didn't respond.
+func (a *WebhooksMemoryAccess) MarkWebhookFailed(tx Tx, id int64, responseStatus *int, deliveryErr string,
+	nextAttemptAt *time.Time) error {
+	tables := memTx(tx).tables
+	delivery, ok := tables.webhookDeliveries[id]
+	if !ok || delivery.OrganizationID != OrganizationID(tx) {
+		return nil
+	}
+	if nextAttemptAt == nil {
+		delivery.Status = models.WebhookDead
+	} else {
+		delivery.Status = models.WebhookPending
+		delivery.NextAttemptAt = *nextAttemptAt
+	}
+	delivery.ResponseStatus = responseStatus
+	delivery.Error = deliveryErr
+	delivery.ClaimedAt = nil
+	tables.webhookDeliveries[id] = storedDelivery(delivery)
+	return nil
+}
+
+// ReplayWebhookDelivery queues a delivery to be sent again straight away, with a fresh set of attempts,
+// whether it was delivered or is dead. It returns nil if there's no such delivery.
+func (a *WebhooksMemoryAccess) ReplayWebhookDelivery(tx Tx, id int64) (*models.WebhookDelivery, error) {
+	tables := memTx(tx).tables
+	delivery, ok := tables.webhookDeliveries[id]
+	if !ok || delivery.OrganizationID != OrganizationID(tx) {
+		return nil, nil
+	}
+	if delivery.Status == models.WebhookDelivering {
+		return nil, utils.ArgumentError.Here().WithMessagef("Delivery %d is being delivered", id)
+	}
+	tables.webhookDeliveries[id] = replayedDelivery(delivery, memTx(tx).now)
+	return a.GetWebhookDelivery(tx, id)
+}
+
+// ReplayDeadWebhookDeliveries queues every dead delivery of a webhook to be sent again straight away,
+// returning how many were queued
+func (a *WebhooksMemoryAccess) ReplayDeadWebhookDeliveries(tx Tx, webhookID int64) (int, error) {
+	tables := memTx(tx).tables
+	replayed := 0
+	for id, delivery := range tables.webhookDeliveries {
+		if delivery.OrganizationID == OrganizationID(tx) && delivery.WebhookID == webhookID &&
+			delivery.Status == models.WebhookDead {
+			tables.webhookDeliveries[id] = replayedDelivery(delivery, memTx(tx).now)
+			replayed++
+		}
+	}
+	return replayed, nil
+}
+
+// replayedDelivery resets a delivery to be sent again at now
+func replayedDelivery(delivery models.WebhookDelivery, now time.Time) models.WebhookDelivery {
+	delivery.Status = models.WebhookPending
+	delivery.Attempts = 0
+	delivery.NextAttemptAt = now
+	delivery.ResponseStatus = nil
+	delivery.Error = ""
+	delivery.ClaimedAt = nil
+	delivery.DeliveredAt = nil
+	return delivery
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
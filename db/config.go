@@ -12,6 +12,8 @@ type Config struct {
 	Database   string `envconfig:"db_name"`
 	Password   string `envconfig:"db_pass"`
 	SSLEnabled string `envconfig:"db_ssl_enabled" default:"disable"`
+
+	MigrationsDir string `envconfig:"db_migrations_dir"`
 }
 
 // ConnectionString gets the connection string from the environment variables
@@ -1,11 +1,8 @@
 package db
 
 import (
-	"github.com/go-pg/migrations/v7"
 	"github.com/go-pg/pg/v9"
-	"github.com/go-pg/pg/v9/orm"
 	"github.com/kelseyhightower/envconfig"
-	"github.com/kyrstenkelly/rsvp-api/db/models"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -18,23 +15,24 @@ func GetConfig() (*Config, error) {
 	if err := envconfig.Process("db", &config); err != nil {
 		return nil, err
 	}
+	if config.MigrationsDir == "" {
+		config.MigrationsDir = defaultMigrationsDir()
+	}
 
 	return &config, nil
 }
 
-func createSchema(db *pg.DB) error {
-	for _, model := range models.Models {
-		err := db.CreateTable(model, &orm.CreateTableOptions{
-			FKConstraints: true,
-		})
-		if err != nil {
-			return err
-		}
+func connect(config *Config) (*pg.DB, error) {
+	options, err := pg.ParseURL(config.ConnectionString())
+	if err != nil {
+		log.Error("Unable to parse connection string")
+		return nil, err
 	}
-	return nil
+
+	return pg.Connect(options), nil
 }
 
-// InitDb initializes the database
+// InitDb initializes the database, failing if the schema is behind the latest migration
 func InitDb() error {
 	log.Info("InitDb: Started")
 
@@ -44,31 +42,15 @@ func InitDb() error {
 		return err
 	}
 
-	options, err := pg.ParseURL(config.ConnectionString())
+	conn, err = connect(config)
 	if err != nil {
-		log.Error("Unable to parse connection string")
 		return err
 	}
 
-	conn = pg.Connect(options)
-
-	var oldVersion, newVersion int64
-	err = createSchema(conn)
-	oldVersion, newVersion, err = migrations.Run(conn)
+	err = checkSchemaVersion(conn, config)
 	if err != nil {
-		log.Error("Unable to run database migrations")
 		return err
 	}
-	if newVersion != oldVersion {
-		log.WithFields(log.Fields{
-			"oldVersion": oldVersion,
-			"newVersion": newVersion,
-		}).Debug("Migrated to new version")
-	} else {
-		log.WithFields(log.Fields{
-			"version": oldVersion,
-		}).Debug("Database version")
-	}
 
 	log.Info("InitDb: Complete")
 
@@ -0,0 +1,200 @@
+// Package dbtest gives tests their own migrated postgres database
+package dbtest
+
+import (
+	"fmt"
+	"github.com/go-pg/pg/v9"
+	"github.com/kyrstenkelly/rsvp-api/db"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+)
+
+// DatabaseURLEnv names the variable holding the URL of a postgres server tests may create databases on.
+// Without it, tests start a throwaway server with the initdb and pg_ctl on the PATH.
+const DatabaseURLEnv = "TEST_DATABASE_URL"
+
+var (
+	serverOnce sync.Once
+	serverURL  *url.URL
+	serverErr  error
+	stopServer func()
+
+	databasesMu sync.Mutex
+	databases   int
+)
+
+// Run runs a package's tests and stops the throwaway server they started, if any. Call it from TestMain.
+func Run(m *testing.M) int {
+	code := m.Run()
+	if stopServer != nil {
+		stopServer()
+	}
+	return code
+}
+
+// Postgres creates a database migrated up to the latest version and connects to it, skipping the test
+// when there is no postgres to test against. The returned func closes the connection and drops the database.
+func Postgres(t testing.TB) (*pg.DB, func()) {
+	serverOnce.Do(func() {
+		serverURL, stopServer, serverErr = findServer()
+	})
+	if serverErr != nil {
+		t.Skipf("No postgres to test against: %v", serverErr)
+	}
+
+	databasesMu.Lock()
+	databases++
+	name := fmt.Sprintf("rsvp_test_%d_%d", os.Getpid(), databases)
+	databasesMu.Unlock()
+
+	drop := func() {
+		admin, err := connect(serverURL)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer admin.Close()
+		if _, err := admin.Exec(`DROP DATABASE IF EXISTS ?`, pg.Ident(name)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	admin, err := connect(serverURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = admin.Exec(`CREATE DATABASE ?`, pg.Ident(name))
+	admin.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	databaseURL := *serverURL
+	databaseURL.Path = "/" + name
+	if err := migrate(&databaseURL); err != nil {
+		drop()
+		t.Fatal(err)
+	}
+	conn, err := connect(&databaseURL)
+	if err != nil {
+		drop()
+		t.Fatal(err)
+	}
+	return conn, func() {
+		conn.Close()
+		drop()
+	}
+}
+
+func connect(u *url.URL) (*pg.DB, error) {
+	options, err := pg.ParseURL(u.String())
+	if err != nil {
+		return nil, err
+	}
+	return pg.Connect(options), nil
+}
+
+// migrate runs db.Migrate against the database at u, which reads its configuration from the environment
+func migrate(u *url.URL) error {
+	_, source, _, _ := runtime.Caller(0)
+	password, _ := u.User.Password()
+	sslMode := u.Query().Get("sslmode")
+	if sslMode == "" {
+		sslMode = "disable"
+	}
+	env := map[string]string{
+		"DB_USER":           u.User.Username(),
+		"DB_PASS":           password,
+		"DB_HOST":           u.Host,
+		"DB_NAME":           u.Path[1:],
+		"DB_SSL_ENABLED":    sslMode,
+		"DB_MIGRATIONS_DIR": filepath.Join(filepath.Dir(source), "..", "migrations"),
+	}
+	for key, value := range env {
+		if previous, ok := os.LookupEnv(key); ok {
+			defer os.Setenv(key, previous)
+		} else {
+			defer os.Unsetenv(key)
+		}
+		os.Setenv(key, value)
+	}
+	return db.Migrate("up")
+}
+
+func findServer() (*url.URL, func(), error) {
+	if raw := os.Getenv(DatabaseURLEnv); raw != "" {
+		u, err := url.Parse(raw)
+		return u, nil, err
+	}
+	return startServer()
+}
+
+// startServer initializes a cluster in a temporary directory and starts it on a free local port
+func startServer() (*url.URL, func(), error) {
+	initdb, pgCtl := findBinary("initdb"), findBinary("pg_ctl")
+	if initdb == "" || pgCtl == "" {
+		return nil, nil, fmt.Errorf("set %s or put initdb and pg_ctl on the PATH", DatabaseURLEnv)
+	}
+
+	dir, err := ioutil.TempDir("", "rsvp-postgres")
+	if err != nil {
+		return nil, nil, err
+	}
+	data := filepath.Join(dir, "data")
+	if out, err := exec.Command(initdb, "-D", data, "-U", "postgres", "-A", "trust", "-E", "UTF8").CombinedOutput(); err != nil {
+		os.RemoveAll(dir)
+		return nil, nil, fmt.Errorf("initdb: %v: %s", err, out)
+	}
+
+	port, err := freePort()
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, nil, err
+	}
+	options := fmt.Sprintf("-p %d -k %s -c listen_addresses=127.0.0.1 -c fsync=off", port, dir)
+	if out, err := exec.Command(pgCtl, "start", "-w", "-D", data, "-l", filepath.Join(dir, "postgres.log"), "-o", options).CombinedOutput(); err != nil {
+		os.RemoveAll(dir)
+		return nil, nil, fmt.Errorf("pg_ctl start: %v: %s", err, out)
+	}
+
+	stop := func() {
+		exec.Command(pgCtl, "stop", "-m", "immediate", "-D", data).Run()
+		os.RemoveAll(dir)
+	}
+	u := &url.URL{
+		Scheme:   "postgres",
+		User:     url.User("postgres"),
+		Host:     fmt.Sprintf("127.0.0.1:%d", port),
+		Path:     "/postgres",
+		RawQuery: "sslmode=disable",
+	}
+	return u, stop, nil
+}
+
+// findBinary looks for a postgres program on the PATH, then where Debian installs each version
+func findBinary(name string) string {
+	if path, err := exec.LookPath(name); err == nil {
+		return path
+	}
+	matches, _ := filepath.Glob(filepath.Join("/usr/lib/postgresql/*/bin", name))
+	if len(matches) == 0 {
+		return ""
+	}
+	return matches[len(matches)-1]
+}
+
+func freePort() (int, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port, nil
+}
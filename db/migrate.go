@@ -0,0 +1,225 @@
+package db
+
+import (
+	"fmt"
+	"github.com/go-pg/migrations/v7"
+	"github.com/go-pg/pg/v9"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var migrationNameRE = regexp.MustCompile(`[^a-z0-9]+`)
+
+// migrationsTable is where go-pg/migrations records the applied versions
+const migrationsTable = "gopg_migrations"
+
+// defaultMigrationsDir finds db/migrations next to the binary, so `rsvp-api migrate` works from any
+// directory, falling back to the working directory for `go run` from the repo root
+func defaultMigrationsDir() string {
+	dir := filepath.Join("db", "migrations")
+	exe, err := os.Executable()
+	if err != nil {
+		return dir
+	}
+	exe, err = filepath.EvalSymlinks(exe)
+	if err != nil {
+		return dir
+	}
+	nextToBinary := filepath.Join(filepath.Dir(exe), dir)
+	if info, err := os.Stat(nextToBinary); err == nil && info.IsDir() {
+		return nextToBinary
+	}
+	return dir
+}
+
+// migrationsCollection loads the SQL migrations from the configured directory
+func migrationsCollection(config *Config) (*migrations.Collection, error) {
+	collection := migrations.NewCollection().DisableSQLAutodiscover(true).SetTableName(migrationsTable)
+	err := collection.DiscoverSQLMigrations(config.MigrationsDir)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"dir": config.MigrationsDir,
+		}).Error("Unable to load database migrations")
+		return nil, err
+	}
+	return collection, nil
+}
+
+// latestVersion returns the highest version in the collection, or 0 if there are none
+func latestVersion(collection *migrations.Collection) int64 {
+	all := collection.Migrations()
+	if len(all) == 0 {
+		return 0
+	}
+	return all[len(all)-1].Version
+}
+
+// schemaVersion returns the version the database has been migrated to, creating the
+// migrations table if this is a fresh database
+func schemaVersion(db *pg.DB, collection *migrations.Collection) (int64, error) {
+	_, _, err := collection.Run(db, "init")
+	if err != nil {
+		return 0, err
+	}
+	return collection.Version(db)
+}
+
+// appliedVersion returns the version the database has been migrated to without writing to it,
+// or 0 if it has never been migrated
+func appliedVersion(db *pg.DB, collection *migrations.Collection) (int64, error) {
+	var exists bool
+	_, err := db.QueryOne(pg.Scan(&exists), `SELECT to_regclass(?) IS NOT NULL`, migrationsTable)
+	if err != nil || !exists {
+		return 0, err
+	}
+	return collection.Version(db)
+}
+
+// checkSchemaVersion returns an error if the database is behind the latest migration
+func checkSchemaVersion(db *pg.DB, config *Config) error {
+	collection, err := migrationsCollection(config)
+	if err != nil {
+		return err
+	}
+
+	version, err := appliedVersion(db, collection)
+	if err != nil {
+		log.Error("Unable to read the database schema version")
+		return err
+	}
+
+	latest := latestVersion(collection)
+	if version < latest {
+		return fmt.Errorf("database schema is at version %d but the latest migration is %d; run `rsvp-api migrate up`", version, latest)
+	}
+
+	log.WithFields(log.Fields{
+		"version": version,
+	}).Debug("Database version")
+	return nil
+}
+
+// Migrate runs a migration command against the configured database. `up` applies pending
+// migrations, `down` reverts the latest one, `status` lists them and `create` adds empty scripts.
+func Migrate(args ...string) error {
+	config, err := GetConfig()
+	if err != nil {
+		log.Error("Unable to configure the database")
+		return err
+	}
+
+	collection, err := migrationsCollection(config)
+	if err != nil {
+		return err
+	}
+
+	cmd := "up"
+	if len(args) > 0 {
+		cmd = args[0]
+	}
+
+	if cmd == "create" {
+		if len(args) < 2 {
+			return fmt.Errorf("usage: rsvp-api migrate create <description>")
+		}
+		return createMigration(config.MigrationsDir, latestVersion(collection)+1, strings.Join(args[1:], "_"))
+	}
+
+	db, err := connect(config)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	version, err := schemaVersion(db, collection)
+	if err != nil {
+		log.Error("Unable to read the database schema version")
+		return err
+	}
+
+	switch cmd {
+	case "up", "down":
+		oldVersion, newVersion, err := collection.Run(db, args...)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"command": cmd,
+			}).Error("Unable to run database migrations")
+			return err
+		}
+		log.WithFields(log.Fields{
+			"oldVersion": oldVersion,
+			"newVersion": newVersion,
+		}).Info("Migrated database")
+		return nil
+	case "status":
+		return printStatus(config.MigrationsDir, collection, version)
+	default:
+		return fmt.Errorf("unknown migrate command %q, expected up, down, status or create", cmd)
+	}
+}
+
+// printStatus prints every migration and whether it has been applied
+func printStatus(dir string, collection *migrations.Collection, version int64) error {
+	names, err := migrationNames(dir)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Database is at version %d of %d\n", version, latestVersion(collection))
+	for _, migration := range collection.Migrations() {
+		state := "pending"
+		if migration.Version <= version {
+			state = "applied"
+		}
+		fmt.Printf("  %-8s %s\n", state, names[migration.Version])
+	}
+	return nil
+}
+
+// migrationNames maps each migration version to the name of its up script
+func migrationNames(dir string) (map[int64]string, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	names := map[int64]string{}
+	for _, f := range files {
+		name := f.Name()
+		if !strings.HasSuffix(name, ".up.sql") {
+			continue
+		}
+		idx := strings.IndexByte(name, '_')
+		if idx == -1 {
+			continue
+		}
+		version, err := strconv.ParseInt(name[:idx], 10, 64)
+		if err != nil {
+			continue
+		}
+		names[version] = strings.TrimSuffix(strings.TrimSuffix(name, ".up.sql"), ".tx")
+	}
+	return names, nil
+}
+
+// createMigration writes empty up and down scripts for a new migration
+func createMigration(dir string, version int64, description string) error {
+	description = migrationNameRE.ReplaceAllString(strings.ToLower(description), "_")
+	base := filepath.Join(dir, fmt.Sprintf("%d_%s", version, description))
+
+	for _, direction := range []string{"up", "down"} {
+		filename := fmt.Sprintf("%s.tx.%s.sql", base, direction)
+		contents := fmt.Sprintf("-- Migration %d (%s): %s\n", version, direction, description)
+		err := ioutil.WriteFile(filename, []byte(contents), 0644)
+		if err != nil {
+			return err
+		}
+		fmt.Println("Created", filename)
+	}
+	return nil
+}
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS seating_constraints;
+DROP TABLE IF EXISTS seats;
+DROP TABLE IF EXISTS seating_tables;
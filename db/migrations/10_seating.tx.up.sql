@@ -0,0 +1,39 @@
+-- Tables at an event, the table each attending rsvp_guests row is seated at, and
+-- constraints keeping guests or whole invitations together or apart. A constraint's
+-- guest and invitation ids aren't foreign keys; ids that no longer attend are ignored.
+
+CREATE TABLE seating_tables (
+	id bigserial NOT NULL,
+	organization_id bigint NOT NULL REFERENCES organizations (id) ON DELETE CASCADE,
+	version bigint NOT NULL DEFAULT 1,
+	event_id bigint NOT NULL REFERENCES events (id) ON DELETE CASCADE,
+	name text NOT NULL,
+	capacity integer NOT NULL CHECK (capacity > 0),
+	created_at timestamptz NOT NULL DEFAULT now(),
+	PRIMARY KEY (id)
+);
+
+CREATE INDEX seating_tables_event_id_idx ON seating_tables (event_id);
+
+CREATE TABLE seats (
+	rsvp_guest_id bigint NOT NULL REFERENCES rsvp_guests (id) ON DELETE CASCADE,
+	organization_id bigint NOT NULL REFERENCES organizations (id) ON DELETE CASCADE,
+	table_id bigint NOT NULL REFERENCES seating_tables (id) ON DELETE CASCADE,
+	PRIMARY KEY (rsvp_guest_id)
+);
+
+CREATE INDEX seats_table_id_idx ON seats (table_id);
+
+CREATE TABLE seating_constraints (
+	id bigserial NOT NULL,
+	organization_id bigint NOT NULL REFERENCES organizations (id) ON DELETE CASCADE,
+	version bigint NOT NULL DEFAULT 1,
+	event_id bigint NOT NULL REFERENCES events (id) ON DELETE CASCADE,
+	kind text NOT NULL CHECK (kind IN ('together', 'apart')),
+	guest_ids bigint[] NOT NULL,
+	invitation_ids bigint[] NOT NULL,
+	created_at timestamptz NOT NULL DEFAULT now(),
+	PRIMARY KEY (id)
+);
+
+CREATE INDEX seating_constraints_event_id_idx ON seating_constraints (event_id);
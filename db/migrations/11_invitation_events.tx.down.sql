@@ -0,0 +1,18 @@
+-- Invitations go back to their first event, and responses to any other event are lost.
+-- Invitations that cover no event are left without one, so event_id stays nullable.
+
+ALTER TABLE invitations ADD COLUMN event_id bigint REFERENCES events (id);
+
+UPDATE invitations i
+SET event_id = ie.event_id
+FROM invitation_events ie
+WHERE ie.invitation_id = i.id
+	AND ie.position = (SELECT min(position) FROM invitation_events WHERE invitation_id = i.id);
+
+DELETE FROM rsvp_guests rg
+USING rsvps r, invitations i
+WHERE r.id = rg.rsvp_id AND i.id = r.invitation_id AND rg.event_id IS DISTINCT FROM i.event_id;
+
+ALTER TABLE rsvp_guests DROP COLUMN event_id;
+
+DROP TABLE invitation_events;
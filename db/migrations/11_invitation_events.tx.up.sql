@@ -0,0 +1,29 @@
+-- An invitation can cover several events, each with all or some of its guests, and
+-- each rsvp_guests row is one guest's response to one of those events. Existing
+-- invitations cover just the event they had, and their responses are to it.
+
+CREATE TABLE invitation_events (
+	invitation_id bigint NOT NULL REFERENCES invitations (id) ON DELETE CASCADE,
+	event_id bigint NOT NULL REFERENCES events (id),
+	position integer NOT NULL,
+	guest_ids bigint[] NOT NULL DEFAULT '{}',
+	PRIMARY KEY (invitation_id, event_id)
+);
+
+CREATE INDEX invitation_events_event_id_idx ON invitation_events (event_id);
+
+INSERT INTO invitation_events (invitation_id, event_id, position)
+SELECT id, event_id, 0 FROM invitations;
+
+ALTER TABLE rsvp_guests ADD COLUMN event_id bigint REFERENCES events (id);
+
+UPDATE rsvp_guests rg
+SET event_id = i.event_id
+FROM rsvps r
+JOIN invitations i ON i.id = r.invitation_id
+WHERE r.id = rg.rsvp_id;
+
+ALTER TABLE rsvp_guests ALTER COLUMN event_id SET NOT NULL;
+CREATE INDEX rsvp_guests_event_id_idx ON rsvp_guests (event_id);
+
+ALTER TABLE invitations DROP COLUMN event_id;
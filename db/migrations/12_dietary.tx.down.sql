@@ -0,0 +1,5 @@
+ALTER TABLE rsvp_guests
+	DROP COLUMN IF EXISTS dietary_notes,
+	DROP COLUMN IF EXISTS allergy_severity,
+	DROP COLUMN IF EXISTS diets,
+	DROP COLUMN IF EXISTS allergens;
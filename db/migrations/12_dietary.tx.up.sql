@@ -0,0 +1,9 @@
+-- Structured dietary data on each response: the allergens a guest has and how severe
+-- they are, the diets they follow, and free-text notes for the caterer.
+
+ALTER TABLE rsvp_guests
+	ADD COLUMN allergens text[] NOT NULL DEFAULT '{}',
+	ADD COLUMN diets text[] NOT NULL DEFAULT '{}',
+	ADD COLUMN allergy_severity text NOT NULL DEFAULT ''
+		CHECK (allergy_severity IN ('', 'mild', 'moderate', 'severe')),
+	ADD COLUMN dietary_notes text NOT NULL DEFAULT '';
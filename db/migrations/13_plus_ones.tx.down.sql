@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS guests_plus_one_of_idx;
+ALTER TABLE guests DROP COLUMN IF EXISTS plus_one_of;
+
+ALTER TABLE invitations ADD COLUMN plus_one boolean;
+UPDATE invitations SET plus_one = plus_ones > 0;
+ALTER TABLE invitations DROP COLUMN IF EXISTS plus_ones;
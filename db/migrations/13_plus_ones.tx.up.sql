@@ -0,0 +1,36 @@
+-- Invitations allow a number of plus ones instead of just one, and each plus one is a guest
+-- belonging to the invitation that brought them, so they are never matched to other guests by name.
+
+ALTER TABLE invitations ADD COLUMN plus_ones integer NOT NULL DEFAULT 0 CHECK (plus_ones >= 0);
+UPDATE invitations SET plus_ones = 1 WHERE plus_one;
+ALTER TABLE invitations DROP COLUMN plus_one;
+
+ALTER TABLE guests ADD COLUMN plus_one_of bigint REFERENCES invitations (id) ON DELETE CASCADE;
+CREATE INDEX guests_plus_one_of_idx ON guests (plus_one_of);
+
+-- Guests who have only ever responded as a plus one belong to the invitation they responded on
+UPDATE guests g
+SET plus_one_of = (
+	SELECT r.invitation_id FROM rsvp_guests rg JOIN rsvps r ON r.id = rg.rsvp_id
+	WHERE rg.guest_id = g.id
+	ORDER BY rg.id
+	LIMIT 1
+)
+WHERE EXISTS (SELECT 1 FROM rsvp_guests rg WHERE rg.guest_id = g.id AND rg.is_plus_one)
+	AND NOT EXISTS (SELECT 1 FROM rsvp_guests rg WHERE rg.guest_id = g.id AND NOT rg.is_plus_one)
+	AND NOT EXISTS (SELECT 1 FROM invitation_guests ig WHERE ig.guest_id = g.id);
+
+-- Invitations that already have more plus ones responding to an event than they now allow keep them
+UPDATE invitations i
+SET plus_ones = greatest(i.plus_ones, counts.plus_ones)
+FROM (
+	SELECT r.invitation_id, max(per_event.n) AS plus_ones
+	FROM rsvps r
+	JOIN LATERAL (
+		SELECT count(*) AS n FROM rsvp_guests rg
+		WHERE rg.rsvp_id = r.id AND rg.is_plus_one
+		GROUP BY rg.event_id
+	) per_event ON true
+	GROUP BY r.invitation_id
+) counts
+WHERE counts.invitation_id = i.id;
@@ -0,0 +1,15 @@
+DROP INDEX IF EXISTS guests_invitation_id_idx;
+DROP INDEX IF EXISTS invitation_guests_guest_id_key;
+
+ALTER TABLE guests ADD COLUMN plus_one_of bigint REFERENCES invitations (id) ON DELETE CASCADE;
+UPDATE guests SET plus_one_of = invitation_id WHERE plus_one;
+CREATE INDEX guests_plus_one_of_idx ON guests (plus_one_of);
+
+ALTER TABLE guests
+	ALTER COLUMN name DROP NOT NULL,
+	DROP COLUMN IF EXISTS phone,
+	DROP COLUMN IF EXISTS email,
+	DROP COLUMN IF EXISTS last_name,
+	DROP COLUMN IF EXISTS first_name,
+	DROP COLUMN IF EXISTS plus_one,
+	DROP COLUMN IF EXISTS invitation_id;
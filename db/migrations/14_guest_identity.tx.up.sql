@@ -0,0 +1,61 @@
+-- Guests are identified by id within their invitation rather than matched by name. Each guest belongs
+-- to one invitation, has a first and last name, and can have an email and phone number. Plus ones
+-- belong to their invitation the same way, flagged with plus_one.
+
+ALTER TABLE guests
+	ADD COLUMN invitation_id bigint REFERENCES invitations (id) ON DELETE CASCADE,
+	ADD COLUMN plus_one boolean NOT NULL DEFAULT false,
+	ADD COLUMN first_name text NOT NULL DEFAULT '',
+	ADD COLUMN last_name text NOT NULL DEFAULT '',
+	ADD COLUMN email text NOT NULL DEFAULT '',
+	ADD COLUMN phone text NOT NULL DEFAULT '';
+
+UPDATE guests SET name = '' WHERE name IS NULL;
+ALTER TABLE guests ALTER COLUMN name SET NOT NULL;
+UPDATE guests
+SET name = btrim(name),
+	first_name = split_part(btrim(name), ' ', 1),
+	last_name = btrim(substr(btrim(name), length(split_part(btrim(name), ' ', 1)) + 1));
+
+UPDATE guests SET invitation_id = plus_one_of, plus_one = true WHERE plus_one_of IS NOT NULL;
+DROP INDEX IF EXISTS guests_plus_one_of_idx;
+ALTER TABLE guests DROP COLUMN plus_one_of;
+
+UPDATE guests g
+SET invitation_id = (SELECT min(ig.invitation_id) FROM invitation_guests ig WHERE ig.guest_id = g.id)
+WHERE NOT g.plus_one;
+
+-- Matching by name made one guest of everyone with the same name. Each other invitation such a
+-- guest is on gets its own copy, which takes over that invitation's responses for them.
+CREATE TEMPORARY TABLE guest_copies ON COMMIT DROP AS
+SELECT ig.invitation_id, ig.guest_id AS old_id, nextval('guests_id_seq') AS new_id
+FROM invitation_guests ig
+JOIN guests g ON g.id = ig.guest_id
+WHERE ig.invitation_id <> g.invitation_id;
+
+INSERT INTO guests (id, organization_id, invitation_id, name, first_name, last_name)
+SELECT c.new_id, g.organization_id, c.invitation_id, g.name, g.first_name, g.last_name
+FROM guest_copies c
+JOIN guests g ON g.id = c.old_id;
+
+UPDATE invitation_guests ig
+SET guest_id = c.new_id
+FROM guest_copies c
+WHERE ig.invitation_id = c.invitation_id AND ig.guest_id = c.old_id;
+
+UPDATE rsvp_guests rg
+SET guest_id = c.new_id
+FROM rsvps r, guest_copies c
+WHERE r.id = rg.rsvp_id AND r.invitation_id = c.invitation_id AND rg.guest_id = c.old_id;
+
+UPDATE invitation_events ie
+SET guest_ids = ARRAY(
+	SELECT coalesce(c.new_id, u.guest_id)
+	FROM unnest(ie.guest_ids) WITH ORDINALITY AS u(guest_id, n)
+	LEFT JOIN guest_copies c ON c.invitation_id = ie.invitation_id AND c.old_id = u.guest_id
+	ORDER BY u.n
+)
+WHERE EXISTS (SELECT 1 FROM guest_copies c WHERE c.invitation_id = ie.invitation_id);
+
+CREATE UNIQUE INDEX invitation_guests_guest_id_key ON invitation_guests (guest_id);
+CREATE INDEX guests_invitation_id_idx ON guests (invitation_id);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS invitations_organization_id_email_key;
+ALTER TABLE invitations ADD CONSTRAINT invitations_email_key UNIQUE (email);
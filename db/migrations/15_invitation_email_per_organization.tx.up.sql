@@ -0,0 +1,7 @@
+-- Invitation emails were unique across every organization, so two clients couldn't invite the same
+-- household, and the error told one that the email was in use by another. They are now unique within
+-- an organization, ignoring case as the importer does. This fails if an organization already has
+-- emails that only differ by case, which need merging first.
+
+ALTER TABLE invitations DROP CONSTRAINT IF EXISTS invitations_email_key;
+CREATE UNIQUE INDEX invitations_organization_id_email_key ON invitations (organization_id, lower(email));
@@ -0,0 +1 @@
+DROP INDEX rsvps_invitation_id_key;
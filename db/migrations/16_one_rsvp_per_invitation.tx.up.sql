@@ -0,0 +1,12 @@
+-- An invitation has at most one RSVP out of the trash. Where one has several, all but the
+-- latest, which is the one exports already read, are moved to the trash.
+
+UPDATE rsvps r
+SET deleted_at = now(), version = version + 1
+WHERE r.deleted_at IS NULL
+	AND EXISTS (
+		SELECT 1 FROM rsvps newer
+		WHERE newer.invitation_id = r.invitation_id AND newer.deleted_at IS NULL AND newer.id > r.id
+	);
+
+CREATE UNIQUE INDEX rsvps_invitation_id_key ON rsvps (invitation_id) WHERE deleted_at IS NULL;
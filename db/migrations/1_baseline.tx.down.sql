@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS rsvp_guests;
+DROP TABLE IF EXISTS rsvps;
+DROP TABLE IF EXISTS invitations;
+DROP TABLE IF EXISTS guests;
+DROP TABLE IF EXISTS events;
+DROP TABLE IF EXISTS addresses;
@@ -0,0 +1,85 @@
+-- Baseline schema, matching what the CreateTable bootstrap produced from db/models.
+-- Tables are created only if missing so databases bootstrapped before migrations
+-- existed can be brought under version control with `rsvp-api migrate up`.
+
+CREATE TABLE IF NOT EXISTS addresses (
+	id bigserial NOT NULL,
+	line1 text NOT NULL,
+	line2 text,
+	city text NOT NULL,
+	state text NOT NULL,
+	zip text NOT NULL,
+	PRIMARY KEY (id)
+);
+
+CREATE TABLE IF NOT EXISTS events (
+	id bigserial NOT NULL,
+	name text NOT NULL,
+	location text,
+	date text NOT NULL,
+	address_id bigint,
+	food_options jsonb,
+	PRIMARY KEY (id),
+	FOREIGN KEY (address_id) REFERENCES addresses (id)
+);
+
+CREATE TABLE IF NOT EXISTS guests (
+	id bigserial NOT NULL,
+	name text,
+	PRIMARY KEY (id)
+);
+
+CREATE TABLE IF NOT EXISTS invitations (
+	id bigserial NOT NULL,
+	name text NOT NULL,
+	email text NOT NULL,
+	plus_one boolean,
+	event_id bigint NOT NULL,
+	guest_ids bigint[] NOT NULL,
+	address_id bigint,
+	PRIMARY KEY (id),
+	UNIQUE (email),
+	FOREIGN KEY (event_id) REFERENCES events (id),
+	FOREIGN KEY (address_id) REFERENCES addresses (id)
+);
+
+CREATE TABLE IF NOT EXISTS rsvps (
+	id bigserial NOT NULL,
+	invitation_id bigint NOT NULL,
+	rsvp_guest_ids jsonb,
+	PRIMARY KEY (id)
+);
+
+CREATE TABLE IF NOT EXISTS rsvp_guests (
+	id bigserial NOT NULL,
+	rsvp_id bigint NOT NULL,
+	guest_id bigint NOT NULL,
+	attending boolean NOT NULL,
+	is_plus_one boolean DEFAULT false,
+	food_choice text,
+	PRIMARY KEY (id),
+	FOREIGN KEY (rsvp_id) REFERENCES rsvps (id),
+	FOREIGN KEY (guest_id) REFERENCES guests (id)
+);
+
+-- Columns added after the bootstrapped tables were first created
+
+ALTER TABLE events ADD COLUMN IF NOT EXISTS rsvp_deadline timestamptz;
+ALTER TABLE events ADD COLUMN IF NOT EXISTS allow_late_rsvps boolean NOT NULL DEFAULT false;
+
+ALTER TABLE rsvps ADD COLUMN IF NOT EXISTS late boolean NOT NULL DEFAULT false;
+
+-- Existing invitations get a code here; codes for new invitations are generated
+-- with crypto/rand in CreateInvitation. Both use the same alphabet, which leaves out
+-- characters that are easily confused when read off a card (0/O, 1/I).
+ALTER TABLE invitations ADD COLUMN IF NOT EXISTS rsvp_code text;
+UPDATE invitations
+SET rsvp_code = (
+	SELECT string_agg(substr('ABCDEFGHJKLMNPQRSTUVWXYZ23456789', 1 + floor(random() * 32)::int, 1), '')
+	FROM generate_series(1, 10)
+	-- referencing the row makes postgres build a new code for each invitation
+	WHERE invitations.id IS NOT NULL
+)
+WHERE rsvp_code IS NULL;
+ALTER TABLE invitations ALTER COLUMN rsvp_code SET NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS invitations_rsvp_code_key ON invitations (rsvp_code);
@@ -0,0 +1,14 @@
+ALTER TABLE invitations ADD COLUMN guest_ids bigint[] NOT NULL DEFAULT '{}';
+
+UPDATE invitations i
+SET guest_ids = ig.guest_ids
+FROM (
+	SELECT invitation_id, array_agg(guest_id ORDER BY position) AS guest_ids
+	FROM invitation_guests
+	GROUP BY invitation_id
+) ig
+WHERE ig.invitation_id = i.id;
+
+ALTER TABLE invitations ALTER COLUMN guest_ids DROP DEFAULT;
+
+DROP TABLE invitation_guests;
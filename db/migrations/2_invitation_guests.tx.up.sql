@@ -0,0 +1,21 @@
+-- Move the invitation -> guest relationship out of the invitations.guest_ids array
+-- and into a join table with real foreign keys.
+
+CREATE TABLE invitation_guests (
+	invitation_id bigint NOT NULL REFERENCES invitations (id) ON DELETE CASCADE,
+	guest_id bigint NOT NULL REFERENCES guests (id) ON DELETE CASCADE,
+	position integer NOT NULL,
+	PRIMARY KEY (invitation_id, guest_id)
+);
+
+CREATE INDEX invitation_guests_guest_id_idx ON invitation_guests (guest_id);
+
+-- Keep the array order, and drop IDs that no longer point at a guest
+INSERT INTO invitation_guests (invitation_id, guest_id, position)
+SELECT i.id, ids.guest_id, min(ids.position) - 1
+FROM invitations i
+CROSS JOIN LATERAL unnest(i.guest_ids) WITH ORDINALITY AS ids (guest_id, position)
+JOIN guests g ON g.id = ids.guest_id
+GROUP BY i.id, ids.guest_id;
+
+ALTER TABLE invitations DROP COLUMN guest_ids;
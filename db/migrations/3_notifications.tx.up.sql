@@ -0,0 +1,24 @@
+-- Every email we send is recorded here first. The idempotency key stops a retried
+-- request, or a second instance, from sending the same email twice.
+
+CREATE TABLE notifications (
+	id bigserial NOT NULL,
+	kind text NOT NULL,
+	idempotency_key text NOT NULL,
+	invitation_id bigint REFERENCES invitations (id) ON DELETE SET NULL,
+	recipients text[] NOT NULL,
+	subject text NOT NULL,
+	text_body text NOT NULL,
+	html_body text NOT NULL,
+	status text NOT NULL DEFAULT 'sending',
+	attempts integer NOT NULL DEFAULT 1,
+	error text,
+	created_at timestamptz NOT NULL DEFAULT now(),
+	claimed_at timestamptz NOT NULL DEFAULT now(),
+	sent_at timestamptz,
+	PRIMARY KEY (id),
+	UNIQUE (idempotency_key)
+);
+
+CREATE INDEX notifications_invitation_id_idx ON notifications (invitation_id);
+CREATE INDEX notifications_status_idx ON notifications (status);
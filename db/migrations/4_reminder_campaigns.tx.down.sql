@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS notifications_kind_idx;
+DROP TABLE IF EXISTS reminder_campaigns;
@@ -0,0 +1,16 @@
+-- A campaign reminds an event's non-responders the given numbers of days before its
+-- RSVP deadline. The reminders themselves are recorded in notifications, keyed by
+-- campaign, invitation and offset so each is only ever sent once.
+
+CREATE TABLE reminder_campaigns (
+	id bigserial NOT NULL,
+	event_id bigint NOT NULL REFERENCES events (id) ON DELETE CASCADE,
+	name text NOT NULL DEFAULT '',
+	offset_days integer[] NOT NULL,
+	paused boolean NOT NULL DEFAULT false,
+	created_at timestamptz NOT NULL DEFAULT now(),
+	PRIMARY KEY (id)
+);
+
+CREATE INDEX reminder_campaigns_event_id_idx ON reminder_campaigns (event_id);
+CREATE INDEX notifications_kind_idx ON notifications (kind);
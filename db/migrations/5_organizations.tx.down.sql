@@ -0,0 +1,11 @@
+DO $$
+DECLARE
+	t text;
+BEGIN
+	FOREACH t IN ARRAY ARRAY['addresses', 'events', 'guests', 'invitations', 'rsvps', 'rsvp_guests',
+		'notifications', 'reminder_campaigns'] LOOP
+		EXECUTE format('ALTER TABLE %I DROP COLUMN IF EXISTS organization_id', t);
+	END LOOP;
+END $$;
+
+DROP TABLE IF EXISTS organizations;
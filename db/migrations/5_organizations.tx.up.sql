@@ -0,0 +1,26 @@
+-- Each client is an organization, and every row belongs to one. Rows that existed
+-- before organizations belong to the "default" organization.
+
+CREATE TABLE organizations (
+	id bigserial NOT NULL,
+	slug text NOT NULL,
+	name text NOT NULL,
+	created_at timestamptz NOT NULL DEFAULT now(),
+	PRIMARY KEY (id),
+	UNIQUE (slug)
+);
+
+INSERT INTO organizations (slug, name) VALUES ('default', 'Default');
+
+DO $$
+DECLARE
+	t text;
+BEGIN
+	FOREACH t IN ARRAY ARRAY['addresses', 'events', 'guests', 'invitations', 'rsvps', 'rsvp_guests',
+		'notifications', 'reminder_campaigns'] LOOP
+		EXECUTE format('ALTER TABLE %I ADD COLUMN organization_id bigint REFERENCES organizations (id) ON DELETE CASCADE', t);
+		EXECUTE format('UPDATE %I SET organization_id = (SELECT id FROM organizations WHERE slug = %L)', t, 'default');
+		EXECUTE format('ALTER TABLE %I ALTER COLUMN organization_id SET NOT NULL', t);
+		EXECUTE format('CREATE INDEX %I ON %I (organization_id)', t || '_organization_id_idx', t);
+	END LOOP;
+END $$;
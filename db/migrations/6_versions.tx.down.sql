@@ -0,0 +1,8 @@
+DO $$
+DECLARE
+	t text;
+BEGIN
+	FOREACH t IN ARRAY ARRAY['addresses', 'events', 'guests', 'invitations', 'rsvps', 'reminder_campaigns'] LOOP
+		EXECUTE format('ALTER TABLE %I DROP COLUMN IF EXISTS version', t);
+	END LOOP;
+END $$;
@@ -0,0 +1,11 @@
+-- Every editable row has a version, bumped on each write, which the API serves as an ETag
+-- so that stale writes can be rejected.
+
+DO $$
+DECLARE
+	t text;
+BEGIN
+	FOREACH t IN ARRAY ARRAY['addresses', 'events', 'guests', 'invitations', 'rsvps', 'reminder_campaigns'] LOOP
+		EXECUTE format('ALTER TABLE %I ADD COLUMN version bigint NOT NULL DEFAULT 1', t);
+	END LOOP;
+END $$;
@@ -0,0 +1,27 @@
+-- Every change the API makes to a row is recorded here, with who made it and the row
+-- before and after as JSON. Entries are only ever inserted; the trigger rejects
+-- updates and deletes so the history can't be rewritten.
+
+CREATE TABLE audit_entries (
+	id bigserial NOT NULL,
+	organization_id bigint NOT NULL REFERENCES organizations (id),
+	actor text NOT NULL,
+	entity text NOT NULL,
+	entity_id bigint NOT NULL,
+	action text NOT NULL,
+	changed text[] NOT NULL,
+	before jsonb,
+	after jsonb,
+	created_at timestamptz NOT NULL DEFAULT now(),
+	PRIMARY KEY (id)
+);
+
+CREATE INDEX audit_entries_entity_idx ON audit_entries (organization_id, entity, entity_id);
+
+CREATE FUNCTION audit_entries_append_only() RETURNS trigger AS $$
+BEGIN
+	RAISE EXCEPTION 'audit_entries is append-only';
+END $$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_entries_append_only BEFORE UPDATE OR DELETE ON audit_entries
+	FOR EACH ROW EXECUTE PROCEDURE audit_entries_append_only();
@@ -0,0 +1,18 @@
+-- Rows in the trash are gone for good once the column is dropped, so purge them first
+DELETE FROM rsvp_guests WHERE rsvp_id IN (
+	SELECT r.id FROM rsvps r JOIN invitations i ON i.id = r.invitation_id
+	WHERE r.deleted_at IS NOT NULL OR i.deleted_at IS NOT NULL
+);
+DELETE FROM rsvps WHERE deleted_at IS NOT NULL
+	OR invitation_id IN (SELECT id FROM invitations WHERE deleted_at IS NOT NULL);
+DELETE FROM invitations WHERE deleted_at IS NOT NULL;
+DELETE FROM events WHERE deleted_at IS NOT NULL;
+
+DO $$
+DECLARE
+	t text;
+BEGIN
+	FOREACH t IN ARRAY ARRAY['events', 'invitations', 'rsvps'] LOOP
+		EXECUTE format('ALTER TABLE %I DROP COLUMN IF EXISTS deleted_at', t);
+	END LOOP;
+END $$;
@@ -0,0 +1,13 @@
+-- Deleting an event, invitation or RSVP only marks it deleted, so that it can be restored
+-- from the trash until the purge job removes it for good after the retention period.
+
+DO $$
+DECLARE
+	t text;
+BEGIN
+	FOREACH t IN ARRAY ARRAY['events', 'invitations', 'rsvps'] LOOP
+		EXECUTE format('ALTER TABLE %I ADD COLUMN deleted_at timestamptz', t);
+		EXECUTE format('CREATE INDEX %I ON %I (organization_id, deleted_at) WHERE deleted_at IS NOT NULL',
+			t || '_deleted_at_idx', t);
+	END LOOP;
+END $$;
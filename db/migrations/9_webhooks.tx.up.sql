@@ -0,0 +1,38 @@
+-- A webhook POSTs the events it subscribes to to its URL. Each event is queued in
+-- webhook_deliveries, in the same transaction as the change it describes, so an
+-- event is delivered if and only if its change commits.
+
+CREATE TABLE webhooks (
+	id bigserial NOT NULL,
+	organization_id bigint NOT NULL REFERENCES organizations (id) ON DELETE CASCADE,
+	version bigint NOT NULL DEFAULT 1,
+	url text NOT NULL,
+	secret text NOT NULL,
+	events text[] NOT NULL,
+	active boolean NOT NULL DEFAULT true,
+	created_at timestamptz NOT NULL DEFAULT now(),
+	PRIMARY KEY (id)
+);
+
+CREATE INDEX webhooks_organization_id_idx ON webhooks (organization_id);
+
+CREATE TABLE webhook_deliveries (
+	id bigserial NOT NULL,
+	organization_id bigint NOT NULL REFERENCES organizations (id) ON DELETE CASCADE,
+	webhook_id bigint NOT NULL REFERENCES webhooks (id) ON DELETE CASCADE,
+	event text NOT NULL,
+	data jsonb NOT NULL,
+	status text NOT NULL DEFAULT 'pending',
+	attempts integer NOT NULL DEFAULT 0,
+	next_attempt_at timestamptz NOT NULL DEFAULT now(),
+	claimed_at timestamptz,
+	response_status integer,
+	error text,
+	created_at timestamptz NOT NULL DEFAULT now(),
+	delivered_at timestamptz,
+	PRIMARY KEY (id)
+);
+
+CREATE INDEX webhook_deliveries_webhook_id_idx ON webhook_deliveries (webhook_id);
+CREATE INDEX webhook_deliveries_due_idx ON webhook_deliveries (organization_id, next_attempt_at)
+	WHERE status IN ('pending', 'delivering');
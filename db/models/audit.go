@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// AuditEntry records one change to a row: who made it, and the row before and after as JSON.
+// Before is null for creates and After is null for deletes.
+type AuditEntry struct {
+	ID             int64                  `json:"id" db:"id" sql:",notnull"`
+	OrganizationID int64                  `json:"-" db:"organization_id" sql:",notnull"`
+	Actor          string                 `json:"actor" db:"actor" sql:",notnull"`
+	Entity         string                 `json:"entity" db:"entity" sql:",notnull"`
+	EntityID       int64                  `json:"entity_id" db:"entity_id" sql:",notnull"`
+	Action         string                 `json:"action" db:"action" sql:",notnull"`
+	Changed        []string               `json:"changed" db:"changed" sql:",notnull,array"`
+	Before         map[string]interface{} `json:"before" db:"before"`
+	After          map[string]interface{} `json:"after" db:"after"`
+	CreatedAt      time.Time              `json:"created_at" db:"created_at" sql:"default:now()"`
+}
@@ -1,12 +1,19 @@
 package models
 
+import "time"
+
 // Event type
 type Event struct {
-	ID          int64    `json:"id" db:"id" sql:",notnull"`
-	Name        string   `json:"name" db:"name" sql:",notnull"`
-	Location    string   `json:"location" db:"location"`
-	Date        string   `json:"date" db:"date" sql:",notnull,date"`
-	AddressID   int64    `json:"-" db:"address_id"`
-	Address     *Address `json:"address"`
-	FoodOptions []string `json:"food_options" db:"food_options"`
+	ID             int64      `json:"id" db:"id" sql:",notnull"`
+	OrganizationID int64      `json:"-" db:"organization_id" sql:",notnull"`
+	Version        int64      `json:"version" db:"version" sql:",notnull,default:1"`
+	Name           string     `json:"name" db:"name" sql:",notnull"`
+	Location       string     `json:"location" db:"location"`
+	Date           string     `json:"date" db:"date" sql:",notnull,date"`
+	RSVPDeadline   *time.Time `json:"rsvp_deadline" db:"rsvp_deadline"`
+	AllowLateRSVPs bool       `json:"allow_late_rsvps" db:"allow_late_rsvps" sql:",notnull,default:false"`
+	AddressID      int64      `json:"-" db:"address_id"`
+	Address        *Address   `json:"address"`
+	FoodOptions    []string   `json:"food_options" db:"food_options"`
+	DeletedAt      *time.Time `json:"deleted_at,omitempty" db:"deleted_at"`
 }
@@ -0,0 +1,33 @@
+package models
+
+// GuestExportRow is one guest with their invitation, address, event and RSVP status, flattened for export
+type GuestExportRow struct {
+	GuestID        int64  `json:"guest_id"`
+	GuestName      string `json:"guest_name"`
+	InvitationID   int64  `json:"invitation_id"`
+	InvitationName string `json:"invitation_name"`
+	Email          string `json:"email"`
+	Line1          string `json:"line1"`
+	Line2          string `json:"line2"`
+	City           string `json:"city"`
+	State          string `json:"state"`
+	Zip            string `json:"zip"`
+	EventID        int64  `json:"event_id"`
+	EventName      string `json:"event_name"`
+	Status         string `json:"status"`
+	FoodChoice     string `json:"food_choice"`
+	IsPlusOne      bool   `json:"is_plus_one"`
+}
+
+// Guest export statuses
+const (
+	GuestStatusAttending  = "attending"
+	GuestStatusDeclined   = "declined"
+	GuestStatusNoResponse = "no_response"
+)
+
+// GuestExportFilter narrows a guest export. Zero values match everything.
+type GuestExportFilter struct {
+	EventID int64
+	Status  string
+}
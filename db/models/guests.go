@@ -1,7 +1,39 @@
 package models
 
-// Guest type
+import "strings"
+
+// Guest type. Every guest belongs to one invitation, either as one of its guests or as a plus one
+// named when it was answered. Name is the first and last name together.
 type Guest struct {
-	ID   int64  `json:"id" db:"id"`
-	Name string `json:"name" db:"name"`
+	ID             int64  `json:"id" db:"id"`
+	OrganizationID int64  `json:"-" db:"organization_id" sql:",notnull"`
+	Version        int64  `json:"version" db:"version" sql:",notnull,default:1"`
+	InvitationID   int64  `json:"invitation_id,omitempty" db:"invitation_id"`
+	PlusOne        bool   `json:"plus_one" db:"plus_one" sql:",notnull"`
+	Name           string `json:"name" db:"name" sql:",notnull"`
+	FirstName      string `json:"first_name" db:"first_name" sql:",notnull"`
+	LastName       string `json:"last_name" db:"last_name" sql:",notnull"`
+	Email          string `json:"email,omitempty" db:"email" sql:",notnull"`
+	Phone          string `json:"phone,omitempty" db:"phone" sql:",notnull"`
+}
+
+// SetNames fills in Name from the first and last names, or splits Name into them when neither is given
+func (g *Guest) SetNames() {
+	g.FirstName = strings.TrimSpace(g.FirstName)
+	g.LastName = strings.TrimSpace(g.LastName)
+	if g.FirstName == "" && g.LastName == "" {
+		parts := strings.SplitN(strings.TrimSpace(g.Name), " ", 2)
+		g.FirstName = parts[0]
+		if len(parts) == 2 {
+			g.LastName = strings.TrimSpace(parts[1])
+		}
+	}
+	g.Name = strings.TrimSpace(g.FirstName + " " + g.LastName)
+}
+
+// DuplicateGuests are guests on the same invitation with the same name, which may be one person
+type DuplicateGuests struct {
+	InvitationID int64   `json:"invitation_id"`
+	Name         string  `json:"name"`
+	Guests       []Guest `json:"guests"`
 }
@@ -0,0 +1,25 @@
+package models
+
+// InvitationEvent is one of the events an invitation covers, in the order they are listed, and
+// which of the invitation's guests are invited to it. Every guest is invited if GuestIDs is empty.
+type InvitationEvent struct {
+	tableName    struct{} `sql:"invitation_events"`
+	InvitationID int64    `json:"-" db:"invitation_id" sql:",pk"`
+	EventID      int64    `json:"event_id" db:"event_id" sql:",pk"`
+	Position     int      `json:"-" db:"position" sql:",notnull"`
+	GuestIDs     []int64  `json:"guest_ids" db:"guest_ids" sql:",notnull,array"`
+	Event        *Event   `json:"event,omitempty" sql:"-"`
+}
+
+// Invites reports whether a guest on the invitation is invited to the event
+func (e InvitationEvent) Invites(guestID int64) bool {
+	if len(e.GuestIDs) == 0 {
+		return true
+	}
+	for _, id := range e.GuestIDs {
+		if id == guestID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,9 @@
+package models
+
+// InvitationGuest links a guest to an invitation, in the order they are listed
+type InvitationGuest struct {
+	tableName    struct{} `sql:"invitation_guests"`
+	InvitationID int64    `json:"invitation_id" db:"invitation_id" sql:",pk"`
+	GuestID      int64    `json:"guest_id" db:"guest_id" sql:",pk"`
+	Position     int      `json:"position" db:"position" sql:",notnull"`
+}
@@ -1,15 +1,59 @@
 package models
 
+import "time"
+
 // Invitation type
 type Invitation struct {
-	ID        int64    `json:"id" db:"id" sql:",notnull"`
-	Name      string   `json:"name" db:"name" sql:",notnull"`
-	Email     string   `json:"email" db:"email" sql:",notnull,unique"`
-	PlusOne   bool     `json:"plus_one" db:"plus_one"`
-	EventID   int64    `json:"-" db:"event_id" sql:",notnull"`
-	Event     *Event   `json:"event"`
-	GuestIds  []int64  `json:"-" db:"guest_ids" sql:",notnull,array"`
-	Guests    *[]Guest `json:"guests"`
-	AddressID int64    `json:"-" db:"address_id"`
-	Address   *Address `json:"address"`
+	ID             int64             `json:"id" db:"id" sql:",notnull"`
+	OrganizationID int64             `json:"-" db:"organization_id" sql:",notnull"`
+	Version        int64             `json:"version" db:"version" sql:",notnull,default:1"`
+	Name           string            `json:"name" db:"name" sql:",notnull"`
+	Email          string            `json:"email" db:"email" sql:",notnull"`
+	PlusOnes       int               `json:"plus_ones" db:"plus_ones" sql:",notnull"`
+	RSVPCode       string            `json:"rsvp_code" db:"rsvp_code" sql:",notnull,unique"`
+	Events         []InvitationEvent `json:"events" sql:"-"`
+	Guests         *[]Guest          `json:"guests" sql:"-"`
+	AddressID      int64             `json:"-" db:"address_id"`
+	Address        *Address          `json:"address"`
+	DeletedAt      *time.Time        `json:"deleted_at,omitempty" db:"deleted_at"`
+}
+
+// EventIDs gets the ids of the events the invitation covers
+func (i *Invitation) EventIDs() []int64 {
+	eventIDs := []int64{}
+	for _, event := range i.Events {
+		eventIDs = append(eventIDs, event.EventID)
+	}
+	return eventIDs
+}
+
+// ResponseEvent gets the invitation's entry for the event a response is for, or nil if it doesn't cover it.
+// Responses can leave out the event when the invitation covers only one.
+func (i *Invitation) ResponseEvent(eventID int64) *InvitationEvent {
+	if eventID == 0 && len(i.Events) == 1 {
+		return &i.Events[0]
+	}
+	for j := range i.Events {
+		if i.Events[j].EventID == eventID {
+			return &i.Events[j]
+		}
+	}
+	return nil
+}
+
+// GuestInvitation is the guest-facing view of an invitation, looked up by RSVP code.
+// It leaves out the email and mailing address, which only admins should see.
+type GuestInvitation struct {
+	Name           string                 `json:"name"`
+	PlusOnes       int                    `json:"plus_ones"`
+	Events         []GuestInvitationEvent `json:"events"`
+	Guests         *[]Guest               `json:"guests"`
+	RSVP           *RSVP                  `json:"rsvp"`
+	DietaryOptions DietaryOptions         `json:"dietary_options"`
+}
+
+// GuestInvitationEvent is one of the events on a guest-facing invitation, with the guests invited to it
+type GuestInvitationEvent struct {
+	Event  *Event  `json:"event"`
+	Guests []Guest `json:"guests"`
 }
@@ -1,11 +0,0 @@
-package models
-
-// Models is a list of models
-var Models = []interface{}{
-	(*Address)(nil),
-	(*Event)(nil),
-	(*Guest)(nil),
-	(*Invitation)(nil),
-	(*RSVP)(nil),
-	(*RSVPGuest)(nil),
-}
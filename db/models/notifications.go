@@ -0,0 +1,36 @@
+package models
+
+import "time"
+
+// Notification kinds
+const (
+	NotificationInvitation       = "invitation"
+	NotificationRSVPConfirmation = "rsvp_confirmation"
+	NotificationAdminAlert       = "admin_alert"
+)
+
+// Notification statuses
+const (
+	NotificationSending = "sending"
+	NotificationSent    = "sent"
+	NotificationFailed  = "failed"
+)
+
+// Notification is a record of an email we have sent, or tried to send
+type Notification struct {
+	ID             int64      `json:"id" db:"id" sql:",notnull"`
+	OrganizationID int64      `json:"-" db:"organization_id" sql:",notnull"`
+	Kind           string     `json:"kind" db:"kind" sql:",notnull"`
+	IdempotencyKey string     `json:"idempotency_key" db:"idempotency_key" sql:",notnull,unique"`
+	InvitationID   *int64     `json:"invitation_id" db:"invitation_id"`
+	Recipients     []string   `json:"recipients" db:"recipients" sql:",notnull,array"`
+	Subject        string     `json:"subject" db:"subject" sql:",notnull"`
+	TextBody       string     `json:"-" db:"text_body" sql:",notnull"`
+	HTMLBody       string     `json:"-" db:"html_body" sql:",notnull"`
+	Status         string     `json:"status" db:"status" sql:",notnull"`
+	Attempts       int        `json:"attempts" db:"attempts" sql:",notnull"`
+	Error          string     `json:"error" db:"error"`
+	CreatedAt      time.Time  `json:"created_at" db:"created_at" sql:",notnull"`
+	ClaimedAt      time.Time  `json:"-" db:"claimed_at" sql:",notnull"`
+	SentAt         *time.Time `json:"sent_at" db:"sent_at"`
+}
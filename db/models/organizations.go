@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// Organization is a client with their own events, invitations and guests.
+// Tokens name the organization they act for by its slug.
+type Organization struct {
+	ID        int64     `json:"id" db:"id" sql:",notnull"`
+	Slug      string    `json:"slug" db:"slug" sql:",notnull,unique"`
+	Name      string    `json:"name" db:"name" sql:",notnull"`
+	CreatedAt time.Time `json:"created_at" db:"created_at" sql:"default:now()"`
+}
@@ -0,0 +1,45 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// NotificationReminder is the notification kind for reminder emails
+const NotificationReminder = "reminder"
+
+// ReminderCampaign reminds an event's non-responders a number of days before its RSVP deadline
+type ReminderCampaign struct {
+	ID             int64     `json:"id" db:"id" sql:",notnull"`
+	OrganizationID int64     `json:"-" db:"organization_id" sql:",notnull"`
+	Version        int64     `json:"version" db:"version" sql:",notnull,default:1"`
+	EventID        int64     `json:"event_id" db:"event_id" sql:",notnull"`
+	Name           string    `json:"name" db:"name" sql:",notnull"`
+	OffsetDays     []int     `json:"offset_days" db:"offset_days" sql:",notnull,array"`
+	Paused         bool      `json:"paused" db:"paused" sql:",notnull,default:false"`
+	CreatedAt      time.Time `json:"created_at" db:"created_at" sql:"default:now()"`
+}
+
+// ReminderKey is the idempotency key of the reminder a campaign sends an invitation at an offset.
+// ReminderCampaignsAccess builds the same key in sql.
+func ReminderKey(campaignID int64, invitationID int64, offsetDays int) string {
+	return fmt.Sprintf("%s:%d:%d:%d", NotificationReminder, campaignID, invitationID, offsetDays)
+}
+
+// ReminderRecipient is an invitation that hasn't responded and is due a reminder
+type ReminderRecipient struct {
+	InvitationID   int64      `json:"invitation_id"`
+	Name           string     `json:"name"`
+	Email          string     `json:"email"`
+	RemindersSent  int        `json:"reminders_sent"`
+	LastRemindedAt *time.Time `json:"last_reminded_at"`
+}
+
+// ReminderPreview is who a campaign will remind next, and when
+type ReminderPreview struct {
+	Campaign     *ReminderCampaign   `json:"campaign"`
+	RSVPDeadline *time.Time          `json:"rsvp_deadline"`
+	OffsetDays   *int                `json:"offset_days"`
+	SendsAt      *time.Time          `json:"sends_at"`
+	Recipients   []ReminderRecipient `json:"recipients"`
+}
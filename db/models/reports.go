@@ -0,0 +1,50 @@
+package models
+
+// EventReport holds the headcount, meal totals and dietary needs for an event
+type EventReport struct {
+	EventID              int64               `json:"event_id"`
+	EventName            string              `json:"event_name"`
+	Invitations          int                 `json:"invitations"`
+	InvitationsResponded int                 `json:"invitations_responded"`
+	Invited              int                 `json:"invited"`
+	Responded            int                 `json:"responded"`
+	Attending            int                 `json:"attending"`
+	Declined             int                 `json:"declined"`
+	NoResponse           int                 `json:"no_response"`
+	PlusOnes             int                 `json:"plus_ones"`
+	FoodCounts           []FoodCount         `json:"food_counts"`
+	MissingFoodChoice    []MissingFoodChoice `json:"missing_food_choice"`
+	Allergens            []DietaryCount      `json:"allergens"`
+	Diets                []DietaryCount      `json:"diets"`
+}
+
+// FoodCount is the number of attending guests who picked a food option
+type FoodCount struct {
+	FoodChoice string `json:"food_choice"`
+	Count      int    `json:"count"`
+}
+
+// MissingFoodChoice is an attending guest who hasn't picked a food option
+type MissingFoodChoice struct {
+	GuestID        int64  `json:"guest_id"`
+	GuestName      string `json:"guest_name"`
+	InvitationID   int64  `json:"invitation_id"`
+	InvitationName string `json:"invitation_name"`
+	IsPlusOne      bool   `json:"is_plus_one"`
+}
+
+// DietaryCount is the number of attending guests with an allergen or following a diet, and who they are
+type DietaryCount struct {
+	Name   string         `json:"name"`
+	Count  int            `json:"count"`
+	Guests []DietaryGuest `json:"guests"`
+}
+
+// DietaryGuest is an attending guest with an allergen or following a diet
+type DietaryGuest struct {
+	GuestID         int64  `json:"guest_id"`
+	GuestName       string `json:"guest_name"`
+	InvitationName  string `json:"invitation_name"`
+	AllergySeverity string `json:"allergy_severity,omitempty"`
+	DietaryNotes    string `json:"dietary_notes,omitempty"`
+}
@@ -1,13 +1,44 @@
 package models
 
-// RSVPGuest Type
+// Allergens a guest can report
+var Allergens = []string{"peanuts", "tree_nuts", "gluten", "dairy", "eggs", "soy", "fish", "shellfish", "sesame"}
+
+// Diets a guest can follow
+var Diets = []string{"vegetarian", "vegan", "pescatarian", "kosher", "halal"}
+
+// AllergySeverities are how severe a guest's allergies are, from least to most
+var AllergySeverities = []string{"mild", "moderate", "severe"}
+
+// RSVPGuest is one guest's response to one of the events on their invitation
 type RSVPGuest struct {
-	ID         int64  `json:"id" db:"id" sql:",notnull"`
-	RsvpID     int64  `json:"-" db:"rsvp_id" sql:",notnull"`
-	RSVP       *RSVP  `json:"-"`
-	GuestID    int64  `json:"-" db:"guest_id" sql:",notnull"`
-	Guest      *Guest `json:"guest"`
-	Attending  bool   `json:"attending" db:"attending" sql:",notnull"`
-	IsPlusOne  bool   `json:"is_plus_one" db:"is_plus_one" sql:"default:false"`
-	FoodChoice string `json:"food_choice" db:"food_choice"`
+	ID              int64    `json:"id" db:"id" sql:",notnull"`
+	OrganizationID  int64    `json:"-" db:"organization_id" sql:",notnull"`
+	RsvpID          int64    `json:"-" db:"rsvp_id" sql:",notnull"`
+	RSVP            *RSVP    `json:"-"`
+	GuestID         int64    `json:"-" db:"guest_id" sql:",notnull"`
+	Guest           *Guest   `json:"guest"`
+	EventID         int64    `json:"event_id" db:"event_id" sql:",notnull"`
+	Attending       bool     `json:"attending" db:"attending" sql:",notnull"`
+	IsPlusOne       bool     `json:"is_plus_one" db:"is_plus_one" sql:"default:false"`
+	FoodChoice      string   `json:"food_choice" db:"food_choice"`
+	Allergens       []string `json:"allergens" db:"allergens" sql:",notnull,array"`
+	Diets           []string `json:"diets" db:"diets" sql:",notnull,array"`
+	AllergySeverity string   `json:"allergy_severity" db:"allergy_severity" sql:",notnull"`
+	DietaryNotes    string   `json:"dietary_notes" db:"dietary_notes" sql:",notnull"`
+}
+
+// DietaryOptions are the allergens, diets and severities guests can pick from
+type DietaryOptions struct {
+	Allergens         []string `json:"allergens"`
+	Diets             []string `json:"diets"`
+	AllergySeverities []string `json:"allergy_severities"`
+}
+
+// GetDietaryOptions gets the allergens, diets and severities guests can pick from
+func GetDietaryOptions() DietaryOptions {
+	return DietaryOptions{
+		Allergens:         Allergens,
+		Diets:             Diets,
+		AllergySeverities: AllergySeverities,
+	}
 }
@@ -1,9 +1,15 @@
 package models
 
+import "time"
+
 // RSVP Type
 type RSVP struct {
-	ID           int64       `json:"id" db:"id" sql:",notnull"`
-	InvitationID int64       `json:"invitation_id" db:"invitation_id" sql:",notnull"`
-	RSVPGuestIds []int64     `json:"-" db:"rsvp_guest_ids"`
-	RSVPGuests   []RSVPGuest `json:"rsvp_guests" db:"rsvp_guests"`
+	ID             int64       `json:"id" db:"id" sql:",notnull"`
+	OrganizationID int64       `json:"-" db:"organization_id" sql:",notnull"`
+	Version        int64       `json:"version" db:"version" sql:",notnull,default:1"`
+	InvitationID   int64       `json:"invitation_id" db:"invitation_id" sql:",notnull"`
+	Late           bool        `json:"late" db:"late" sql:",notnull,default:false"`
+	RSVPGuestIds   []int64     `json:"-" db:"rsvp_guest_ids"`
+	RSVPGuests     []RSVPGuest `json:"rsvp_guests" db:"rsvp_guests"`
+	DeletedAt      *time.Time  `json:"deleted_at,omitempty" db:"deleted_at"`
 }
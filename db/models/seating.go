@@ -0,0 +1,69 @@
+package models
+
+import "time"
+
+// Seating constraint kinds
+const (
+	SeatingTogether = "together"
+	SeatingApart    = "apart"
+)
+
+// SeatingTable is a table at an event that seats up to Capacity guests
+type SeatingTable struct {
+	ID             int64     `json:"id" db:"id" sql:",notnull"`
+	OrganizationID int64     `json:"-" db:"organization_id" sql:",notnull"`
+	Version        int64     `json:"version" db:"version" sql:",notnull,default:1"`
+	EventID        int64     `json:"event_id" db:"event_id" sql:",notnull"`
+	Name           string    `json:"name" db:"name" sql:",notnull"`
+	Capacity       int       `json:"capacity" db:"capacity" sql:",notnull"`
+	CreatedAt      time.Time `json:"created_at" db:"created_at" sql:"default:now()"`
+}
+
+// Seat puts an attending RSVP guest at a table, or takes them off theirs if TableID is nil
+type Seat struct {
+	RSVPGuestID int64  `json:"rsvp_guest_id"`
+	TableID     *int64 `json:"table_id"`
+}
+
+// SeatingConstraint keeps its guests, and the attending guests on its invitations, at the same
+// table, or each at a different table
+type SeatingConstraint struct {
+	ID             int64     `json:"id" db:"id" sql:",notnull"`
+	OrganizationID int64     `json:"-" db:"organization_id" sql:",notnull"`
+	Version        int64     `json:"version" db:"version" sql:",notnull,default:1"`
+	EventID        int64     `json:"event_id" db:"event_id" sql:",notnull"`
+	Kind           string    `json:"kind" db:"kind" sql:",notnull"`
+	GuestIDs       []int64   `json:"guest_ids" db:"guest_ids" sql:",notnull,array"`
+	InvitationIDs  []int64   `json:"invitation_ids" db:"invitation_ids" sql:",notnull,array"`
+	CreatedAt      time.Time `json:"created_at" db:"created_at" sql:"default:now()"`
+}
+
+// SeatingGuest is a guest attending an event, from their accepted rsvp_guests row, and the table
+// they are seated at, if any
+type SeatingGuest struct {
+	RSVPGuestID    int64  `json:"rsvp_guest_id"`
+	GuestID        int64  `json:"guest_id"`
+	Name           string `json:"name"`
+	InvitationID   int64  `json:"invitation_id"`
+	InvitationName string `json:"invitation_name"`
+	IsPlusOne      bool   `json:"is_plus_one"`
+	FoodChoice     string `json:"food_choice"`
+	TableID        *int64 `json:"table_id"`
+}
+
+// SeatingChartTable is a table and the guests seated at it
+type SeatingChartTable struct {
+	Table     SeatingTable   `json:"table"`
+	Guests    []SeatingGuest `json:"guests"`
+	SeatsLeft int            `json:"seats_left"`
+}
+
+// SeatingChart is who sits where at an event, along with the attending guests who aren't seated
+// yet and the constraints the seating breaks
+type SeatingChart struct {
+	EventID    int64               `json:"event_id"`
+	EventName  string              `json:"event_name"`
+	Tables     []SeatingChartTable `json:"tables"`
+	Unseated   []SeatingGuest      `json:"unseated"`
+	Violations []string            `json:"violations"`
+}
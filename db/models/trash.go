@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// TrashItem is a deleted event, invitation or RSVP, which can be restored until it is purged.
+// RSVPs deleted along with their invitation come back with it, so they aren't listed.
+type TrashItem struct {
+	Entity    string    `json:"entity"`
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	Version   int64     `json:"version"`
+	DeletedAt time.Time `json:"deleted_at"`
+	PurgeAt   time.Time `json:"purge_at" sql:"-"`
+}
+
+// PurgeResult counts the rows a purge removed for good
+type PurgeResult struct {
+	Events      int `json:"events"`
+	Invitations int `json:"invitations"`
+	RSVPs       int `json:"rsvps"`
+}
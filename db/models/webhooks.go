@@ -0,0 +1,61 @@
+package models
+
+import "time"
+
+// Webhook event types
+const (
+	WebhookRSVPCreated       = "rsvp.created"
+	WebhookRSVPUpdated       = "rsvp.updated"
+	WebhookRSVPDeleted       = "rsvp.deleted"
+	WebhookInvitationCreated = "invitation.created"
+)
+
+// WebhookEvents are the event types a webhook can subscribe to
+var WebhookEvents = []string{WebhookRSVPCreated, WebhookRSVPUpdated, WebhookRSVPDeleted, WebhookInvitationCreated}
+
+// Webhook delivery statuses
+const (
+	WebhookPending    = "pending"
+	WebhookDelivering = "delivering"
+	WebhookDelivered  = "delivered"
+	WebhookDead       = "dead"
+)
+
+// Webhook is a subscription that POSTs the events it lists to its URL, signed with its secret
+type Webhook struct {
+	ID             int64     `json:"id" db:"id" sql:",notnull"`
+	OrganizationID int64     `json:"-" db:"organization_id" sql:",notnull"`
+	Version        int64     `json:"version" db:"version" sql:",notnull,default:1"`
+	URL            string    `json:"url" db:"url" sql:",notnull"`
+	Secret         string    `json:"secret" db:"secret" sql:",notnull"`
+	Events         []string  `json:"events" db:"events" sql:",notnull,array"`
+	Active         bool      `json:"active" db:"active" sql:",notnull,default:true"`
+	CreatedAt      time.Time `json:"created_at" db:"created_at" sql:"default:now()"`
+}
+
+// WebhookDelivery is one event queued for one webhook. Deliveries are written in the same
+// transaction as the change they describe, and sent afterwards until the webhook accepts them
+// or they run out of attempts and are dead.
+type WebhookDelivery struct {
+	ID             int64                  `json:"id" db:"id" sql:",notnull"`
+	OrganizationID int64                  `json:"-" db:"organization_id" sql:",notnull"`
+	WebhookID      int64                  `json:"webhook_id" db:"webhook_id" sql:",notnull"`
+	Event          string                 `json:"event" db:"event" sql:",notnull"`
+	Data           map[string]interface{} `json:"data" db:"data" sql:",notnull"`
+	Status         string                 `json:"status" db:"status" sql:",notnull"`
+	Attempts       int                    `json:"attempts" db:"attempts" sql:",notnull"`
+	NextAttemptAt  time.Time              `json:"next_attempt_at" db:"next_attempt_at" sql:",notnull"`
+	ClaimedAt      *time.Time             `json:"-" db:"claimed_at"`
+	ResponseStatus *int                   `json:"response_status" db:"response_status"`
+	Error          string                 `json:"error" db:"error"`
+	CreatedAt      time.Time              `json:"created_at" db:"created_at" sql:"default:now()"`
+	DeliveredAt    *time.Time             `json:"delivered_at" db:"delivered_at"`
+}
+
+// WebhookPayload is the body POSTed to a webhook for a delivery
+type WebhookPayload struct {
+	ID        int64                  `json:"id"`
+	Event     string                 `json:"event"`
+	CreatedAt time.Time              `json:"created_at"`
+	Data      map[string]interface{} `json:"data"`
+}
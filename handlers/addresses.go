@@ -2,40 +2,32 @@ package handlers
 
 import (
 	"encoding/json"
-	"github.com/go-pg/pg/v9"
-	"github.com/kyrstenkelly/rsvp-api/db"
 	"github.com/kyrstenkelly/rsvp-api/db/access"
 	"github.com/kyrstenkelly/rsvp-api/db/models"
 	"github.com/kyrstenkelly/rsvp-api/utils"
 	log "github.com/sirupsen/logrus"
+	"io/ioutil"
 	"net/http"
 )
 
 // AddressesHandler type
 type AddressesHandler struct {
-	dao access.AddressesAccess
+	transactor access.Transactor
+	dao        access.AddressesAccess
 }
 
-// NewAddressesHandler creates a new handler with the given dao
-func NewAddressesHandler(dao access.AddressesAccess) *AddressesHandler {
-	return &AddressesHandler{dao: dao}
+// NewAddressesHandler creates a new handler with the given transactor and dao
+func NewAddressesHandler(transactor access.Transactor, dao access.AddressesAccess) *AddressesHandler {
+	return &AddressesHandler{transactor: transactor, dao: dao}
 }
 
-// GetAddressesHandler gets a list of all addresses
+// GetAddressesHandler gets a page of addresses
 func (handler *AddressesHandler) GetAddressesHandler(r *http.Request, vars map[string]string) ([]byte, int, error) {
 	log.Info("Getting all addresses")
-	conn := db.GetDBConn()
-
-	var addresses []models.Address
-	err := conn.RunInTransaction(func(tx *pg.Tx) (err error) {
-		addresses, err = handler.dao.GetAddresses(tx)
-		return err
+	return serveList(r, handler.transactor, access.AddressesList, func(tx access.Tx, query access.ListQuery) (interface{}, int, error) {
+		addresses, total, err := handler.dao.GetAddresses(tx, query)
+		return addresses, total, err
 	})
-	if err != nil {
-		log.Error("Error getting addresses")
-		return nil, http.StatusBadRequest, err
-	}
-	return utils.SerializeResponse(addresses, http.StatusOK)
 }
 
 // GetAddressHandler gets an address by id
@@ -46,26 +38,31 @@ func (handler *AddressesHandler) GetAddressHandler(r *http.Request, vars map[str
 		"id": id,
 	}).Info("Getting address by ID")
 
-	address, err := utils.RunWithTransaction(func(tx *pg.Tx) (interface{}, error) {
+	address, err := access.Run(r.Context(), handler.transactor, func(tx access.Tx) (interface{}, error) {
 		return handler.dao.GetAddress(tx, id)
 	})
 	if err != nil {
 		log.Error("Error getting address")
 		return nil, http.StatusInternalServerError, err
 	}
-	return utils.SerializeResponse(address, http.StatusOK)
+	if address.(*models.Address) == nil {
+		return nil, http.StatusNotFound, utils.HTTPNotFoundError.Here()
+	}
+	return utils.SerializeVersioned(r, address, address.(*models.Address).Version)
 }
 
 // FindOrCreateAddressHandler handles creating an address
 func (handler *AddressesHandler) FindOrCreateAddressHandler(r *http.Request, vars map[string]string) ([]byte, int, error) {
 	var address *models.Address
-	json.NewDecoder(r.Body).Decode(&address)
+	if err := json.NewDecoder(r.Body).Decode(&address); err != nil || address == nil {
+		return nil, http.StatusBadRequest, utils.RequestBodyError
+	}
 
 	log.WithFields(log.Fields{
 		"address": address,
 	}).Info("Creating address")
 
-	createdAddress, err := utils.RunWithTransaction(func(tx *pg.Tx) (interface{}, error) {
+	createdAddress, err := access.Run(r.Context(), handler.transactor, func(tx access.Tx) (interface{}, error) {
 		return handler.dao.FindOrCreateAddress(tx, address)
 	})
 	if err != nil {
@@ -76,26 +73,48 @@ func (handler *AddressesHandler) FindOrCreateAddressHandler(r *http.Request, var
 	return utils.SerializeResponse(createdAddress, http.StatusOK)
 }
 
-// UpdateAddressHandler updates an existing address
+// addressImmutableFields are the address fields PUT and PATCH may not change
+var addressImmutableFields = []string{"id"}
+
+// UpdateAddressHandler replaces an address with the body of a PUT, or merges the JSON Merge Patch in the body of a PATCH into it
 func (handler *AddressesHandler) UpdateAddressHandler(r *http.Request, vars map[string]string) ([]byte, int, error) {
 	id := utils.GetIDFromVars(vars)
-	var address *models.Address
-	json.NewDecoder(r.Body).Decode(&address)
-	address.ID = id
+	version, err := utils.IfMatchVersion(r)
+	if err != nil {
+		return nil, utils.StatusCode(err, http.StatusBadRequest), err
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
 
 	log.WithFields(log.Fields{
-		"address": address,
+		"id":     id,
+		"method": r.Method,
 	}).Info("Updating address")
 
-	updatedAddress, err := utils.RunWithTransaction(func(tx *pg.Tx) (interface{}, error) {
+	updatedAddress, err := access.Run(r.Context(), handler.transactor, func(tx access.Tx) (interface{}, error) {
+		current, err := handler.dao.GetAddress(tx, id)
+		if err != nil {
+			return nil, err
+		}
+		if current == nil {
+			return nil, utils.HTTPNotFoundError.Here()
+		}
+		address := new(models.Address)
+		if err := readReplacement(r, body, current, addressImmutableFields, address); err != nil {
+			return nil, err
+		}
+		address.ID = id
+		address.Version = version
 		return handler.dao.UpdateAddress(tx, address)
 	})
 	if err != nil {
 		log.Error("Error updating address")
-		return nil, http.StatusBadRequest, err
+		return nil, utils.StatusCode(err, http.StatusBadRequest), err
 	}
 
-	return utils.SerializeResponse(updatedAddress, http.StatusOK)
+	return utils.SerializeVersioned(r, updatedAddress, updatedAddress.(*models.Address).Version)
 }
 
 // DeleteAddressHandler deletes an address
@@ -106,12 +125,16 @@ func (handler *AddressesHandler) DeleteAddressHandler(r *http.Request, vars map[
 		"id": id,
 	}).Info("Deleting address")
 
-	_, err := utils.RunWithTransaction(func(tx *pg.Tx) (interface{}, error) {
-		return handler.dao.DeleteAddress(tx, id)
+	version, err := utils.IfMatchVersion(r)
+	if err != nil {
+		return nil, utils.StatusCode(err, http.StatusBadRequest), err
+	}
+	_, err = access.Run(r.Context(), handler.transactor, func(tx access.Tx) (interface{}, error) {
+		return handler.dao.DeleteAddress(tx, id, version)
 	})
 	if err != nil {
 		log.Error("Error deleting address")
-		return nil, http.StatusBadRequest, err
+		return nil, utils.StatusCode(err, http.StatusBadRequest), err
 	}
 	return utils.SerializeResponse(nil, http.StatusOK)
 }
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"github.com/kyrstenkelly/rsvp-api/db/access"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+)
+
+// AuditHandler type
+type AuditHandler struct {
+	transactor access.Transactor
+	dao        access.AuditAccess
+}
+
+// NewAuditHandler creates a new handler with the given transactor and dao
+func NewAuditHandler(transactor access.Transactor, dao access.AuditAccess) *AuditHandler {
+	return &AuditHandler{transactor: transactor, dao: dao}
+}
+
+// GetAuditHandler gets a page of the audit log. ?entity=rsvp&id=1 narrows it to one row's history;
+// id is short for the entity_id filter.
+func (handler *AuditHandler) GetAuditHandler(r *http.Request, vars map[string]string) ([]byte, int, error) {
+	log.Info("Getting audit log")
+	r = r.Clone(r.Context())
+	params := r.URL.Query()
+	if id, ok := params["id"]; ok {
+		params.Del("id")
+		params["entity_id"] = id
+	}
+	r.URL.RawQuery = params.Encode()
+	return handler.serveAuditList(r)
+}
+
+// HistoryHandler creates a handler that gets a page of the history of the entity with the id in
+// the path, oldest change first
+func (handler *AuditHandler) HistoryHandler(entity string) func(*http.Request, map[string]string) ([]byte, int, error) {
+	return func(r *http.Request, vars map[string]string) ([]byte, int, error) {
+		log.WithFields(log.Fields{
+			"entity": entity,
+			"id":     vars["id"],
+		}).Info("Getting history")
+		r = r.Clone(r.Context())
+		params := r.URL.Query()
+		params.Set("entity", entity)
+		params.Set("entity_id", vars["id"])
+		r.URL.RawQuery = params.Encode()
+		return handler.serveAuditList(r)
+	}
+}
+
+func (handler *AuditHandler) serveAuditList(r *http.Request) ([]byte, int, error) {
+	return serveList(r, handler.transactor, access.AuditList, func(tx access.Tx, query access.ListQuery) (interface{}, int, error) {
+		entries, total, err := handler.dao.GetAuditEntries(tx, query)
+		return entries, total, err
+	})
+}
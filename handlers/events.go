@@ -2,40 +2,32 @@ package handlers
 
 import (
 	"encoding/json"
-	"github.com/go-pg/pg/v9"
-	"github.com/kyrstenkelly/rsvp-api/db"
 	"github.com/kyrstenkelly/rsvp-api/db/access"
 	"github.com/kyrstenkelly/rsvp-api/db/models"
 	"github.com/kyrstenkelly/rsvp-api/utils"
 	log "github.com/sirupsen/logrus"
+	"io/ioutil"
 	"net/http"
 )
 
 // EventsHandler type
 type EventsHandler struct {
-	dao access.EventsAccess
+	transactor access.Transactor
+	dao        access.EventsAccess
 }
 
-// NewEventsHandler creates a new handler with the given dao
-func NewEventsHandler(dao access.EventsAccess) *EventsHandler {
-	return &EventsHandler{dao: dao}
+// NewEventsHandler creates a new handler with the given transactor and dao
+func NewEventsHandler(transactor access.Transactor, dao access.EventsAccess) *EventsHandler {
+	return &EventsHandler{transactor: transactor, dao: dao}
 }
 
-// GetEventsHandler gets a list of all events
+// GetEventsHandler gets a page of events
 func (handler *EventsHandler) GetEventsHandler(r *http.Request, vars map[string]string) ([]byte, int, error) {
 	log.Info("Getting all events")
-	conn := db.GetDBConn()
-
-	var events []models.Event
-	err := conn.RunInTransaction(func(tx *pg.Tx) (err error) {
-		events, err = handler.dao.GetEvents(tx)
-		return err
+	return serveList(r, handler.transactor, access.EventsList, func(tx access.Tx, query access.ListQuery) (interface{}, int, error) {
+		events, total, err := handler.dao.GetEvents(tx, query)
+		return events, total, err
 	})
-	if err != nil {
-		log.Error("Error getting events")
-		return nil, http.StatusBadRequest, err
-	}
-	return utils.SerializeResponse(events, http.StatusOK)
 }
 
 // GetEventHandler gets an event by id
@@ -46,26 +38,31 @@ func (handler *EventsHandler) GetEventHandler(r *http.Request, vars map[string]s
 		"id": id,
 	}).Info("Getting event by ID")
 
-	event, err := utils.RunWithTransaction(func(tx *pg.Tx) (interface{}, error) {
+	event, err := access.Run(r.Context(), handler.transactor, func(tx access.Tx) (interface{}, error) {
 		return handler.dao.GetEvent(tx, id)
 	})
 	if err != nil {
 		log.Error("Error getting event")
 		return nil, http.StatusInternalServerError, err
 	}
-	return utils.SerializeResponse(event, http.StatusOK)
+	if event.(*models.Event) == nil {
+		return nil, http.StatusNotFound, utils.HTTPNotFoundError.Here()
+	}
+	return utils.SerializeVersioned(r, event, event.(*models.Event).Version)
 }
 
 // CreateEventHandler handles creating an event
 func (handler *EventsHandler) CreateEventHandler(r *http.Request, vars map[string]string) ([]byte, int, error) {
 	var event *models.Event
-	json.NewDecoder(r.Body).Decode(&event)
+	if err := json.NewDecoder(r.Body).Decode(&event); err != nil || event == nil {
+		return nil, http.StatusBadRequest, utils.RequestBodyError
+	}
 
 	log.WithFields(log.Fields{
 		"event": event,
 	}).Info("Creating event")
 
-	createdEvent, err := utils.RunWithTransaction(func(tx *pg.Tx) (interface{}, error) {
+	createdEvent, err := access.Run(r.Context(), handler.transactor, func(tx access.Tx) (interface{}, error) {
 		return handler.dao.CreateEvent(tx, event)
 	})
 	if err != nil {
@@ -76,29 +73,51 @@ func (handler *EventsHandler) CreateEventHandler(r *http.Request, vars map[strin
 	return utils.SerializeResponse(createdEvent, http.StatusOK)
 }
 
-// UpdateEventHandler updates an existing event
+// eventImmutableFields are the event fields PUT and PATCH may not change
+var eventImmutableFields = []string{"id"}
+
+// UpdateEventHandler replaces an event with the body of a PUT, or merges the JSON Merge Patch in the body of a PATCH into it
 func (handler *EventsHandler) UpdateEventHandler(r *http.Request, vars map[string]string) ([]byte, int, error) {
 	id := utils.GetIDFromVars(vars)
-	var event *models.Event
-	json.NewDecoder(r.Body).Decode(&event)
-	event.ID = id
+	version, err := utils.IfMatchVersion(r)
+	if err != nil {
+		return nil, utils.StatusCode(err, http.StatusBadRequest), err
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
 
 	log.WithFields(log.Fields{
-		"event": event,
+		"id":     id,
+		"method": r.Method,
 	}).Info("Updating event")
 
-	updatedEvent, err := utils.RunWithTransaction(func(tx *pg.Tx) (interface{}, error) {
+	updatedEvent, err := access.Run(r.Context(), handler.transactor, func(tx access.Tx) (interface{}, error) {
+		current, err := handler.dao.GetEvent(tx, id)
+		if err != nil {
+			return nil, err
+		}
+		if current == nil {
+			return nil, utils.HTTPNotFoundError.Here()
+		}
+		event := new(models.Event)
+		if err := readReplacement(r, body, current, eventImmutableFields, event); err != nil {
+			return nil, err
+		}
+		event.ID = id
+		event.Version = version
 		return handler.dao.UpdateEvent(tx, event)
 	})
 	if err != nil {
 		log.Error("Error updating event")
-		return nil, http.StatusBadRequest, err
+		return nil, utils.StatusCode(err, http.StatusBadRequest), err
 	}
 
-	return utils.SerializeResponse(updatedEvent, http.StatusOK)
+	return utils.SerializeVersioned(r, updatedEvent, updatedEvent.(*models.Event).Version)
 }
 
-// DeleteEventHandler deletes an event
+// DeleteEventHandler moves an event to the trash
 func (handler *EventsHandler) DeleteEventHandler(r *http.Request, vars map[string]string) ([]byte, int, error) {
 	id := utils.GetIDFromVars(vars)
 
@@ -106,12 +125,37 @@ func (handler *EventsHandler) DeleteEventHandler(r *http.Request, vars map[strin
 		"id": id,
 	}).Info("Deleting event")
 
-	_, err := utils.RunWithTransaction(func(tx *pg.Tx) (interface{}, error) {
-		return handler.dao.DeleteEvent(tx, id)
+	version, err := utils.IfMatchVersion(r)
+	if err != nil {
+		return nil, utils.StatusCode(err, http.StatusBadRequest), err
+	}
+	_, err = access.Run(r.Context(), handler.transactor, func(tx access.Tx) (interface{}, error) {
+		return handler.dao.DeleteEvent(tx, id, version)
 	})
 	if err != nil {
 		log.Error("Error deleting event")
-		return nil, http.StatusBadRequest, err
+		return nil, utils.StatusCode(err, http.StatusBadRequest), err
 	}
 	return utils.SerializeResponse(nil, http.StatusOK)
 }
+
+// RestoreEventHandler takes a deleted event back out of the trash
+func (handler *EventsHandler) RestoreEventHandler(r *http.Request, vars map[string]string) ([]byte, int, error) {
+	id := utils.GetIDFromVars(vars)
+
+	log.WithFields(log.Fields{
+		"id": id,
+	}).Info("Restoring event")
+
+	restored, err := access.Run(r.Context(), handler.transactor, func(tx access.Tx) (interface{}, error) {
+		return handler.dao.RestoreEvent(tx, id)
+	})
+	if err != nil {
+		log.Error("Error restoring event")
+		return nil, utils.StatusCode(err, http.StatusBadRequest), err
+	}
+	if restored.(*models.Event) == nil {
+		return nil, http.StatusNotFound, utils.HTTPNotFoundError.Here()
+	}
+	return utils.SerializeVersioned(r, restored, restored.(*models.Event).Version)
+}
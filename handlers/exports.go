@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"github.com/kyrstenkelly/rsvp-api/db/access"
+	"github.com/kyrstenkelly/rsvp-api/db/models"
+	"github.com/kyrstenkelly/rsvp-api/utils"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+)
+
+var guestExportHeader = []string{
+	"guest_id", "guest_name", "invitation_id", "invitation_name", "email",
+	"line1", "line2", "city", "state", "zip",
+	"event_id", "event_name", "status", "food_choice", "is_plus_one",
+}
+
+// ExportsHandler type
+type ExportsHandler struct {
+	transactor access.Transactor
+	dao        access.ExportsAccess
+}
+
+// NewExportsHandler creates a new handler with the given transactor and dao
+func NewExportsHandler(transactor access.Transactor, dao access.ExportsAccess) *ExportsHandler {
+	return &ExportsHandler{transactor: transactor, dao: dao}
+}
+
+// ExportGuestsHandler streams one row per guest as csv, json or ndjson. Rows are written as they
+// are read from the database, so it writes the response itself rather than going through WrapHandler.
+func (handler *ExportsHandler) ExportGuestsHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	format := query.Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	var rowWriter guestRowWriter
+	switch format {
+	case "csv":
+		rowWriter = &csvGuestRowWriter{}
+	case "json":
+		rowWriter = &jsonGuestRowWriter{}
+	case "ndjson":
+		rowWriter = &ndjsonGuestRowWriter{}
+	default:
+		utils.WriteError(w, utils.ArgumentError.Here().WithMessagef("Unknown format %q, expected csv, json or ndjson", format), http.StatusBadRequest)
+		return
+	}
+
+	var filter models.GuestExportFilter
+	if eventID := query.Get("event_id"); eventID != "" {
+		id, err := strconv.ParseInt(eventID, 10, 64)
+		if err != nil {
+			utils.WriteError(w, utils.ArgumentError.Here().WithMessage("event_id must be a number"), http.StatusBadRequest)
+			return
+		}
+		filter.EventID = id
+	}
+	switch status := query.Get("status"); status {
+	case "", models.GuestStatusAttending, models.GuestStatusDeclined, models.GuestStatusNoResponse:
+		filter.Status = status
+	default:
+		utils.WriteError(w, utils.ArgumentError.Here().WithMessagef("Unknown status %q, expected attending, declined or no_response", status), http.StatusBadRequest)
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"format":   format,
+		"event_id": filter.EventID,
+		"status":   filter.Status,
+	}).Info("Exporting guests")
+
+	w.Header().Set("Content-Type", rowWriter.contentType())
+	w.Header().Set("Content-Disposition", "attachment; filename=\"guests."+format+"\"")
+	w.WriteHeader(http.StatusOK)
+
+	buffered := bufio.NewWriter(w)
+	rowWriter.start(buffered)
+	err := handler.transactor.RunInTransaction(r.Context(), func(tx access.Tx) error {
+		return handler.dao.ForEachGuestRow(tx, filter, func(row *models.GuestExportRow) error {
+			return rowWriter.write(row)
+		})
+	})
+	if err != nil {
+		// The status has already been sent, so all we can do is cut the export short
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Error("Error exporting guests")
+		return
+	}
+	err = rowWriter.finish()
+	if err == nil {
+		err = buffered.Flush()
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Error("Unable to write guest export")
+	}
+}
+
+// guestRowWriter writes export rows in one format
+type guestRowWriter interface {
+	contentType() string
+	start(w *bufio.Writer)
+	write(row *models.GuestExportRow) error
+	finish() error
+}
+
+type csvGuestRowWriter struct {
+	writer *csv.Writer
+	err    error
+}
+
+func (c *csvGuestRowWriter) contentType() string {
+	return "text/csv"
+}
+
+func (c *csvGuestRowWriter) start(w *bufio.Writer) {
+	c.writer = csv.NewWriter(w)
+	c.err = c.writer.Write(guestExportHeader)
+}
+
+func (c *csvGuestRowWriter) write(row *models.GuestExportRow) error {
+	if c.err != nil {
+		return c.err
+	}
+	return c.writer.Write([]string{
+		strconv.FormatInt(row.GuestID, 10),
+		row.GuestName,
+		strconv.FormatInt(row.InvitationID, 10),
+		row.InvitationName,
+		row.Email,
+		row.Line1,
+		row.Line2,
+		row.City,
+		row.State,
+		row.Zip,
+		strconv.FormatInt(row.EventID, 10),
+		row.EventName,
+		row.Status,
+		row.FoodChoice,
+		strconv.FormatBool(row.IsPlusOne),
+	})
+}
+
+func (c *csvGuestRowWriter) finish() error {
+	c.writer.Flush()
+	return c.writer.Error()
+}
+
+type jsonGuestRowWriter struct {
+	writer *bufio.Writer
+	rows   int
+}
+
+func (j *jsonGuestRowWriter) contentType() string {
+	return "application/json"
+}
+
+func (j *jsonGuestRowWriter) start(w *bufio.Writer) {
+	j.writer = w
+	j.writer.WriteString("[")
+}
+
+func (j *jsonGuestRowWriter) write(row *models.GuestExportRow) error {
+	buf, err := json.Marshal(row)
+	if err != nil {
+		return err
+	}
+	if j.rows > 0 {
+		j.writer.WriteString(",")
+	}
+	j.rows++
+	_, err = j.writer.Write(buf)
+	return err
+}
+
+func (j *jsonGuestRowWriter) finish() error {
+	_, err := j.writer.WriteString("]")
+	return err
+}
+
+type ndjsonGuestRowWriter struct {
+	encoder *json.Encoder
+}
+
+func (n *ndjsonGuestRowWriter) contentType() string {
+	return "application/x-ndjson"
+}
+
+func (n *ndjsonGuestRowWriter) start(w *bufio.Writer) {
+	n.encoder = json.NewEncoder(w)
+}
+
+func (n *ndjsonGuestRowWriter) write(row *models.GuestExportRow) error {
+	return n.encoder.Encode(row)
+}
+
+func (n *ndjsonGuestRowWriter) finish() error {
+	return nil
+}
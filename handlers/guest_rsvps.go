@@ -0,0 +1,230 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/kyrstenkelly/rsvp-api/db/access"
+	"github.com/kyrstenkelly/rsvp-api/db/models"
+	"github.com/kyrstenkelly/rsvp-api/notifications"
+	"github.com/kyrstenkelly/rsvp-api/utils"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"strings"
+)
+
+// GuestRSVPsHandler handles the guest-facing RSVP flow, keyed by an invitation's RSVP code
+type GuestRSVPsHandler struct {
+	transactor     access.Transactor
+	invitationsDAO access.InvitationsAccess
+	rsvpsDAO       access.RSVPsAccess
+	notifier       *notifications.Notifier
+}
+
+// NewGuestRSVPsHandler creates a new handler with the given transactor, daos and notifier
+func NewGuestRSVPsHandler(transactor access.Transactor, invitationsDAO access.InvitationsAccess, rsvpsDAO access.RSVPsAccess,
+	notifier *notifications.Notifier) *GuestRSVPsHandler {
+	return &GuestRSVPsHandler{
+		transactor:     transactor,
+		invitationsDAO: invitationsDAO,
+		rsvpsDAO:       rsvpsDAO,
+		notifier:       notifier,
+	}
+}
+
+// GetGuestInvitationHandler gets the guest-facing view of an invitation by RSVP code
+func (handler *GuestRSVPsHandler) GetGuestInvitationHandler(r *http.Request, vars map[string]string) ([]byte, int, error) {
+	log.Info("Getting invitation by RSVP code")
+	ctx, err := handler.codeContext(r, vars["code"])
+	if err != nil {
+		return nil, utils.StatusCode(err, http.StatusInternalServerError), err
+	}
+	var guestInvitation *models.GuestInvitation
+	err = handler.transactor.RunInTransaction(ctx, func(tx access.Tx) (err error) {
+		guestInvitation, err = handler.getGuestInvitation(tx, vars["code"])
+		return err
+	})
+	if err != nil {
+		log.Error("Error getting invitation by RSVP code")
+		return nil, utils.StatusCode(err, http.StatusInternalServerError), err
+	}
+	return utils.SerializeResponse(guestInvitation, http.StatusOK)
+}
+
+// SubmitGuestRSVPHandler creates or updates the rsvp for the invitation with the given RSVP code
+func (handler *GuestRSVPsHandler) SubmitGuestRSVPHandler(r *http.Request, vars map[string]string) ([]byte, int, error) {
+	var rsvp *models.RSVP
+	err := json.NewDecoder(r.Body).Decode(&rsvp)
+	if err != nil || rsvp == nil {
+		return nil, http.StatusBadRequest, utils.RequestBodyError
+	}
+
+	log.Info("Submitting rsvp by RSVP code")
+	ctx, err := handler.codeContext(r, vars["code"])
+	if err != nil {
+		return nil, utils.StatusCode(err, http.StatusInternalServerError), err
+	}
+
+	savedRSVP, err := access.Run(ctx, handler.transactor, func(tx access.Tx) (interface{}, error) {
+		invitation, err := handler.invitationsDAO.GetInvitationByCode(tx, vars["code"])
+		if err != nil {
+			return nil, err
+		}
+		if invitation == nil {
+			return nil, utils.HTTPNotFoundError.Here()
+		}
+		rsvp.InvitationID = invitation.ID
+
+		existing, err := handler.rsvpsDAO.GetRSVPByInvitation(tx, invitation.ID)
+		if err != nil {
+			return nil, err
+		}
+		if existing == nil {
+			if err := checkInvitationGuests(invitation, rsvp.RSVPGuests); err != nil {
+				return nil, err
+			}
+			return handler.rsvpsDAO.CreateRSVP(tx, rsvp, true)
+		}
+
+		// Guests may only update the rsvp guests that belong to their own rsvp, and add
+		// responses for their own guests to events they haven't responded to yet
+		owned := map[int64]bool{}
+		for _, rsvpGuest := range existing.RSVPGuests {
+			owned[rsvpGuest.ID] = true
+		}
+		var added []models.RSVPGuest
+		for _, rsvpGuest := range rsvp.RSVPGuests {
+			if rsvpGuest.ID == 0 {
+				added = append(added, rsvpGuest)
+			} else if !owned[rsvpGuest.ID] {
+				return nil, utils.ArgumentError.Here().WithMessage("RSVP guest does not belong to this invitation")
+			}
+		}
+		if err := checkInvitationGuests(invitation, added); err != nil {
+			return nil, err
+		}
+		rsvp.ID = existing.ID
+		rsvp.Version = access.AnyVersion
+		return handler.rsvpsDAO.UpdateRSVP(tx, rsvp, true)
+	})
+	if err != nil {
+		log.Error("Error submitting rsvp by RSVP code")
+		return nil, utils.StatusCode(err, http.StatusBadRequest), err
+	}
+	go handler.notifier.RSVPSubmitted(access.BackgroundContext(ctx), savedRSVP.(*models.RSVP).ID)
+
+	return utils.SerializeResponse(savedRSVP, http.StatusOK)
+}
+
+// codeContext scopes a guest's request to the organization of the invitation with their RSVP code,
+// since guests have no token to take it from, and records their changes as made by the code
+func (handler *GuestRSVPsHandler) codeContext(r *http.Request, code string) (context.Context, error) {
+	organizationID, err := access.Run(r.Context(), handler.transactor, func(tx access.Tx) (interface{}, error) {
+		return handler.invitationsDAO.GetOrganizationIDByCode(tx, code)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if organizationID.(int64) == 0 {
+		return nil, utils.HTTPNotFoundError.Here()
+	}
+	ctx := access.WithOrganization(r.Context(), organizationID.(int64))
+	return access.WithActor(ctx, "rsvp:"+strings.ToUpper(code)), nil
+}
+
+func (handler *GuestRSVPsHandler) getGuestInvitation(tx access.Tx, code string) (*models.GuestInvitation, error) {
+	invitation, err := handler.invitationsDAO.GetInvitationByCode(tx, code)
+	if err != nil {
+		return nil, err
+	}
+	if invitation == nil {
+		return nil, utils.HTTPNotFoundError.Here()
+	}
+
+	rsvp, err := handler.rsvpsDAO.GetRSVPByInvitation(tx, invitation.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Guests' emails and phone numbers are left out along with the invitation's
+	guests := []models.Guest{}
+	if invitation.Guests != nil {
+		for _, guest := range *invitation.Guests {
+			guests = append(guests, withoutContactDetails(guest))
+		}
+	}
+	if rsvp != nil {
+		for i := range rsvp.RSVPGuests {
+			if guest := rsvp.RSVPGuests[i].Guest; guest != nil {
+				private := withoutContactDetails(*guest)
+				rsvp.RSVPGuests[i].Guest = &private
+			}
+		}
+	}
+
+	// Every event is shown with the guests invited to it, so they can all be answered in one form
+	events := []models.GuestInvitationEvent{}
+	for _, invitationEvent := range invitation.Events {
+		if invitationEvent.Event == nil {
+			continue
+		}
+		invited := []models.Guest{}
+		for _, guest := range guests {
+			if invitationEvent.Invites(guest.ID) {
+				invited = append(invited, guest)
+			}
+		}
+		events = append(events, models.GuestInvitationEvent{
+			Event:  invitationEvent.Event,
+			Guests: invited,
+		})
+	}
+
+	return &models.GuestInvitation{
+		Name:           invitation.Name,
+		PlusOnes:       invitation.PlusOnes,
+		Events:         events,
+		Guests:         &guests,
+		RSVP:           rsvp,
+		DietaryOptions: models.GetDietaryOptions(),
+	}, nil
+}
+
+// withoutContactDetails copies a guest without their email and phone
+func withoutContactDetails(guest models.Guest) models.Guest {
+	guest.Email = ""
+	guest.Phone = ""
+	return guest
+}
+
+// checkInvitationGuests makes sure every guest being RSVP'd for, other than a plus one, is on the invitation,
+// by id, and invited to the event they are responding to
+func checkInvitationGuests(invitation *models.Invitation, rsvpGuests []models.RSVPGuest) error {
+	invited := map[int64]bool{}
+	if invitation.Guests != nil {
+		for _, guest := range *invitation.Guests {
+			invited[guest.ID] = true
+		}
+	}
+	for _, rsvpGuest := range rsvpGuests {
+		guestID := rsvpGuest.GuestID
+		if guestID == 0 && rsvpGuest.Guest != nil {
+			guestID = rsvpGuest.Guest.ID
+		}
+		if rsvpGuest.IsPlusOne {
+			if invitation.PlusOnes == 0 {
+				return utils.ArgumentError.Here().WithMessage("Invitation does not include a plus one")
+			}
+			continue
+		}
+		if guestID == 0 {
+			return utils.ArgumentError.Here().WithMessage("Each response must give the id of the invitation's guest it is for")
+		}
+		if !invited[guestID] {
+			return utils.ArgumentError.Here().WithMessagef("Guest %d is not on this invitation", guestID)
+		}
+		if invitationEvent := invitation.ResponseEvent(rsvpGuest.EventID); invitationEvent != nil && !invitationEvent.Invites(guestID) {
+			return utils.ArgumentError.Here().WithMessagef("Guest %d is not invited to event %d", guestID, invitationEvent.EventID)
+		}
+	}
+	return nil
+}
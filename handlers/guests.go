@@ -2,40 +2,33 @@ package handlers
 
 import (
 	"encoding/json"
-	"github.com/go-pg/pg/v9"
-	"github.com/kyrstenkelly/rsvp-api/db"
 	"github.com/kyrstenkelly/rsvp-api/db/access"
 	"github.com/kyrstenkelly/rsvp-api/db/models"
 	"github.com/kyrstenkelly/rsvp-api/utils"
 	log "github.com/sirupsen/logrus"
+	"io/ioutil"
 	"net/http"
+	"strconv"
 )
 
 // GuestsHandler type
 type GuestsHandler struct {
-	dao access.GuestsAccess
+	transactor access.Transactor
+	dao        access.GuestsAccess
 }
 
-// NewGuestsHandler creates a new handler with the given dao
-func NewGuestsHandler(dao access.GuestsAccess) *GuestsHandler {
-	return &GuestsHandler{dao: dao}
+// NewGuestsHandler creates a new handler with the given transactor and dao
+func NewGuestsHandler(transactor access.Transactor, dao access.GuestsAccess) *GuestsHandler {
+	return &GuestsHandler{transactor: transactor, dao: dao}
 }
 
-// GetGuestsHandler gets a list of all guests
+// GetGuestsHandler gets a page of guests
 func (handler *GuestsHandler) GetGuestsHandler(r *http.Request, vars map[string]string) ([]byte, int, error) {
 	log.Info("Getting all guests")
-	conn := db.GetDBConn()
-
-	var guests []models.Guest
-	err := conn.RunInTransaction(func(tx *pg.Tx) (err error) {
-		guests, err = handler.dao.GetGuests(tx, nil)
-		return err
+	return serveList(r, handler.transactor, access.GuestsList, func(tx access.Tx, query access.ListQuery) (interface{}, int, error) {
+		guests, total, err := handler.dao.GetGuests(tx, query)
+		return guests, total, err
 	})
-	if err != nil {
-		log.Error("Error getting guests")
-		return nil, http.StatusBadRequest, err
-	}
-	return utils.SerializeResponse(guests, http.StatusOK)
 }
 
 // GetGuestHandler gets an guest by id
@@ -46,56 +39,177 @@ func (handler *GuestsHandler) GetGuestHandler(r *http.Request, vars map[string]s
 		"id": id,
 	}).Info("Getting guest by ID")
 
-	guest, err := utils.RunWithTransaction(func(tx *pg.Tx) (interface{}, error) {
+	guest, err := access.Run(r.Context(), handler.transactor, func(tx access.Tx) (interface{}, error) {
 		return handler.dao.GetGuest(tx, id)
 	})
 	if err != nil {
 		log.Error("Error getting guest")
 		return nil, http.StatusInternalServerError, err
 	}
-	return utils.SerializeResponse(guest, http.StatusOK)
+	if guest.(*models.Guest) == nil {
+		return nil, http.StatusNotFound, utils.HTTPNotFoundError.Here()
+	}
+	return utils.SerializeVersioned(r, guest, guest.(*models.Guest).Version)
 }
 
-// FindOrCreateGuestHandler handles creating an guest
-func (handler *GuestsHandler) FindOrCreateGuestHandler(r *http.Request, vars map[string]string) ([]byte, int, error) {
-	var guest *models.Guest
-	json.NewDecoder(r.Body).Decode(&guest)
+// GetDuplicateGuestsHandler gets the guests sharing their name with another guest on the same invitation,
+// which may need merging
+func (handler *GuestsHandler) GetDuplicateGuestsHandler(r *http.Request, vars map[string]string) ([]byte, int, error) {
+	log.Info("Getting duplicate guests")
+
+	duplicates, err := access.Run(r.Context(), handler.transactor, func(tx access.Tx) (interface{}, error) {
+		return handler.dao.GetDuplicateGuests(tx)
+	})
+	if err != nil {
+		log.Error("Error getting duplicate guests")
+		return nil, http.StatusInternalServerError, err
+	}
+	return utils.SerializeResponse(duplicates, http.StatusOK)
+}
+
+// MergeGuestsHandler merges the duplicates in the body's guest_ids into a guest
+func (handler *GuestsHandler) MergeGuestsHandler(r *http.Request, vars map[string]string) ([]byte, int, error) {
+	id := utils.GetIDFromVars(vars)
+	var body struct {
+		GuestIDs []int64 `json:"guest_ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return nil, http.StatusBadRequest, utils.RequestBodyError.Here().WithMessagef("Invalid request body: %s", err)
+	}
 
 	log.WithFields(log.Fields{
-		"guest": guest,
-	}).Info("Creating guest")
+		"id":       id,
+		"guestIDs": body.GuestIDs,
+	}).Info("Merging guests")
 
-	createdGuest, err := utils.RunWithTransaction(func(tx *pg.Tx) (interface{}, error) {
-		return handler.dao.FindOrCreateGuest(tx, guest)
+	merged, err := access.Run(r.Context(), handler.transactor, func(tx access.Tx) (interface{}, error) {
+		return handler.dao.MergeGuests(tx, id, body.GuestIDs)
 	})
 	if err != nil {
-		log.Error("Error creating guest")
-		return nil, http.StatusBadRequest, err
+		log.Error("Error merging guests")
+		return nil, utils.StatusCode(err, http.StatusBadRequest), err
 	}
-
-	return utils.SerializeResponse(createdGuest, http.StatusOK)
+	return utils.SerializeVersioned(r, merged, merged.(*models.Guest).Version)
 }
 
-// UpdateGuestHandler updates an existing guest
+// guestImmutableFields are the guest fields PUT and PATCH may not change
+var guestImmutableFields = []string{"id", "invitation_id", "plus_one"}
+
+// UpdateGuestHandler replaces a guest with the body of a PUT, or merges the JSON Merge Patch in the body of a PATCH into it
 func (handler *GuestsHandler) UpdateGuestHandler(r *http.Request, vars map[string]string) ([]byte, int, error) {
 	id := utils.GetIDFromVars(vars)
-	var guest *models.Guest
-	json.NewDecoder(r.Body).Decode(&guest)
-	guest.ID = id
+	version, err := utils.IfMatchVersion(r)
+	if err != nil {
+		return nil, utils.StatusCode(err, http.StatusBadRequest), err
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
 
 	log.WithFields(log.Fields{
-		"guest": guest,
+		"id":     id,
+		"method": r.Method,
 	}).Info("Updating guest")
 
-	updatedGuest, err := utils.RunWithTransaction(func(tx *pg.Tx) (interface{}, error) {
+	updatedGuest, err := access.Run(r.Context(), handler.transactor, func(tx access.Tx) (interface{}, error) {
+		current, err := handler.dao.GetGuest(tx, id)
+		if err != nil {
+			return nil, err
+		}
+		if current == nil {
+			return nil, utils.HTTPNotFoundError.Here()
+		}
+		guest := new(models.Guest)
+		if err := readReplacement(r, body, current, guestImmutableFields, guest); err != nil {
+			return nil, err
+		}
+		renameGuest(current, guest)
+		guest.ID = id
+		guest.Version = version
 		return handler.dao.UpdateGuest(tx, guest)
 	})
 	if err != nil {
 		log.Error("Error updating guest")
+		return nil, utils.StatusCode(err, http.StatusBadRequest), err
+	}
+
+	return utils.SerializeVersioned(r, updatedGuest, updatedGuest.(*models.Guest).Version)
+}
+
+// GetPlusOnesHandler gets the plus ones an invitation has brought
+func (handler *GuestsHandler) GetPlusOnesHandler(r *http.Request, vars map[string]string) ([]byte, int, error) {
+	invitationID := utils.GetIDFromVars(vars)
+
+	log.WithFields(log.Fields{
+		"invitationID": invitationID,
+	}).Info("Getting plus ones")
+
+	plusOnes, err := access.Run(r.Context(), handler.transactor, func(tx access.Tx) (interface{}, error) {
+		return handler.dao.GetPlusOnes(tx, invitationID)
+	})
+	if err != nil {
+		log.Error("Error getting plus ones")
+		return nil, http.StatusInternalServerError, err
+	}
+	return utils.SerializeResponse(plusOnes, http.StatusOK)
+}
+
+// UpdatePlusOneHandler renames one of an invitation's plus ones, with the body of a PUT or the JSON Merge Patch
+// in the body of a PATCH
+func (handler *GuestsHandler) UpdatePlusOneHandler(r *http.Request, vars map[string]string) ([]byte, int, error) {
+	id := utils.GetIDFromVars(vars)
+	invitationID, err := strconv.ParseInt(vars["invitation_id"], 10, 64)
+	if err != nil {
+		return nil, http.StatusNotFound, utils.HTTPNotFoundError.Here()
+	}
+	version, err := utils.IfMatchVersion(r)
+	if err != nil {
+		return nil, utils.StatusCode(err, http.StatusBadRequest), err
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
 		return nil, http.StatusBadRequest, err
 	}
 
-	return utils.SerializeResponse(updatedGuest, http.StatusOK)
+	log.WithFields(log.Fields{
+		"id":           id,
+		"invitationID": invitationID,
+		"method":       r.Method,
+	}).Info("Updating plus one")
+
+	updatedGuest, err := access.Run(r.Context(), handler.transactor, func(tx access.Tx) (interface{}, error) {
+		current, err := handler.dao.GetGuest(tx, id)
+		if err != nil {
+			return nil, err
+		}
+		if current == nil || !current.PlusOne || current.InvitationID != invitationID {
+			return nil, utils.HTTPNotFoundError.Here()
+		}
+		guest := new(models.Guest)
+		if err := readReplacement(r, body, current, guestImmutableFields, guest); err != nil {
+			return nil, err
+		}
+		renameGuest(current, guest)
+		guest.ID = id
+		guest.Version = version
+		return handler.dao.UpdateGuest(tx, guest)
+	})
+	if err != nil {
+		log.Error("Error updating plus one")
+		return nil, utils.StatusCode(err, http.StatusBadRequest), err
+	}
+
+	return utils.SerializeVersioned(r, updatedGuest, updatedGuest.(*models.Guest).Version)
+}
+
+// renameGuest makes a change to only a guest's name replace their first and last names, which otherwise
+// make up their name
+func renameGuest(current *models.Guest, guest *models.Guest) {
+	if guest.Name != current.Name && guest.FirstName == current.FirstName && guest.LastName == current.LastName {
+		guest.FirstName = ""
+		guest.LastName = ""
+	}
 }
 
 // DeleteGuestHandler deletes an guest
@@ -106,12 +220,16 @@ func (handler *GuestsHandler) DeleteGuestHandler(r *http.Request, vars map[strin
 		"id": id,
 	}).Info("Deleting guest")
 
-	_, err := utils.RunWithTransaction(func(tx *pg.Tx) (interface{}, error) {
-		return handler.dao.DeleteGuest(tx, id)
+	version, err := utils.IfMatchVersion(r)
+	if err != nil {
+		return nil, utils.StatusCode(err, http.StatusBadRequest), err
+	}
+	_, err = access.Run(r.Context(), handler.transactor, func(tx access.Tx) (interface{}, error) {
+		return handler.dao.DeleteGuest(tx, id, version)
 	})
 	if err != nil {
 		log.Error("Error deleting guest")
-		return nil, http.StatusBadRequest, err
+		return nil, utils.StatusCode(err, http.StatusBadRequest), err
 	}
 	return utils.SerializeResponse(nil, http.StatusOK)
 }
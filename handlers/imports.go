@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"github.com/kyrstenkelly/rsvp-api/db/access"
+	"github.com/kyrstenkelly/rsvp-api/importer"
+	"github.com/kyrstenkelly/rsvp-api/utils"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// maxImportSize caps uploaded import files at 10MB
+const maxImportSize = 10 << 20
+
+// ImportsHandler type
+type ImportsHandler struct {
+	transactor access.Transactor
+	importer   *importer.Importer
+}
+
+// NewImportsHandler creates a new handler with the given transactor and importer
+func NewImportsHandler(transactor access.Transactor, importer *importer.Importer) *ImportsHandler {
+	return &ImportsHandler{transactor: transactor, importer: importer}
+}
+
+// ImportInvitationsHandler creates invitations from an uploaded csv or tsv file. The file can be
+// sent as the request body or as the `file` field of a multipart form. Each household is invited
+// to every event in the comma separated `event_id`.
+func (handler *ImportsHandler) ImportInvitationsHandler(r *http.Request, vars map[string]string) ([]byte, int, error) {
+	query := r.URL.Query()
+	if query.Get("event_id") == "" {
+		return nil, http.StatusBadRequest, utils.ArgumentError.Here().WithMessage("event_id is required")
+	}
+	eventIDs, err := importer.ParseEventIDs(query.Get("event_id"))
+	if err != nil {
+		return nil, http.StatusBadRequest, utils.ArgumentError.Here().WithMessage(err.Error())
+	}
+	dryRun := query.Get("dry_run") == "true"
+
+	r.Body = http.MaxBytesReader(nil, r.Body, maxImportSize)
+	var file io.Reader = r.Body
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		if err := r.ParseMultipartForm(maxImportSize); err != nil {
+			return nil, http.StatusBadRequest, utils.RequestBodyError.Here().WithMessage("Unable to read the upload, import files can be at most 10MB")
+		}
+		upload, _, err := r.FormFile("file")
+		if err != nil {
+			return nil, http.StatusBadRequest, utils.RequestBodyError.Here().WithMessage("Expected an import file in the `file` field")
+		}
+		defer upload.Close()
+		file = upload
+	}
+
+	log.WithFields(log.Fields{
+		"event_id": eventIDs,
+		"dry_run":  dryRun,
+	}).Info("Importing invitations")
+
+	result, err := handler.importer.ImportInTransaction(r.Context(), handler.transactor, file, eventIDs, dryRun)
+	if err != nil {
+		log.Error("Error importing invitations")
+		return nil, http.StatusInternalServerError, err
+	}
+	if len(result.Errors) > 0 {
+		return utils.SerializeResponse(result, http.StatusUnprocessableEntity)
+	}
+	return utils.SerializeResponse(result, http.StatusOK)
+}
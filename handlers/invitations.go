@@ -2,40 +2,34 @@ package handlers
 
 import (
 	"encoding/json"
-	"github.com/go-pg/pg/v9"
-	"github.com/kyrstenkelly/rsvp-api/db"
 	"github.com/kyrstenkelly/rsvp-api/db/access"
 	"github.com/kyrstenkelly/rsvp-api/db/models"
+	"github.com/kyrstenkelly/rsvp-api/notifications"
 	"github.com/kyrstenkelly/rsvp-api/utils"
 	log "github.com/sirupsen/logrus"
+	"io/ioutil"
 	"net/http"
 )
 
 // InvitationsHandler type
 type InvitationsHandler struct {
-	dao access.InvitationsAccess
+	transactor access.Transactor
+	dao        access.InvitationsAccess
+	notifier   *notifications.Notifier
 }
 
-// NewInvitationsHandler creates a new handler with the given dao
-func NewInvitationsHandler(dao access.InvitationsAccess) *InvitationsHandler {
-	return &InvitationsHandler{dao: dao}
+// NewInvitationsHandler creates a new handler with the given transactor, dao and notifier
+func NewInvitationsHandler(transactor access.Transactor, dao access.InvitationsAccess, notifier *notifications.Notifier) *InvitationsHandler {
+	return &InvitationsHandler{transactor: transactor, dao: dao, notifier: notifier}
 }
 
-// GetInvitationsHandler gets a list of all invitations
+// GetInvitationsHandler gets a page of invitations
 func (handler *InvitationsHandler) GetInvitationsHandler(r *http.Request, vars map[string]string) ([]byte, int, error) {
 	log.Info("Getting all invitations")
-	conn := db.GetDBConn()
-
-	var invitations []models.Invitation
-	err := conn.RunInTransaction(func(tx *pg.Tx) (err error) {
-		invitations, err = handler.dao.GetInvitations(tx)
-		return err
+	return serveList(r, handler.transactor, access.InvitationsList, func(tx access.Tx, query access.ListQuery) (interface{}, int, error) {
+		invitations, total, err := handler.dao.GetInvitations(tx, query)
+		return invitations, total, err
 	})
-	if err != nil {
-		log.Error("Error getting invitations")
-		return nil, http.StatusBadRequest, err
-	}
-	return utils.SerializeResponse(invitations, http.StatusOK)
 }
 
 // GetInvitationHandler gets an invitation by id
@@ -46,26 +40,31 @@ func (handler *InvitationsHandler) GetInvitationHandler(r *http.Request, vars ma
 		"id": id,
 	}).Info("Getting invitation by ID")
 
-	invitation, err := utils.RunWithTransaction(func(tx *pg.Tx) (interface{}, error) {
+	invitation, err := access.Run(r.Context(), handler.transactor, func(tx access.Tx) (interface{}, error) {
 		return handler.dao.GetInvitation(tx, id)
 	})
 	if err != nil {
 		log.Error("Error getting invitation")
 		return nil, http.StatusInternalServerError, err
 	}
-	return utils.SerializeResponse(invitation, http.StatusOK)
+	if invitation.(*models.Invitation) == nil {
+		return nil, http.StatusNotFound, utils.HTTPNotFoundError.Here()
+	}
+	return utils.SerializeVersioned(r, invitation, invitation.(*models.Invitation).Version)
 }
 
 // CreateInvitationHandler handles creating an invitation
 func (handler *InvitationsHandler) CreateInvitationHandler(r *http.Request, vars map[string]string) ([]byte, int, error) {
 	var invitation *models.Invitation
-	json.NewDecoder(r.Body).Decode(&invitation)
+	if err := json.NewDecoder(r.Body).Decode(&invitation); err != nil || invitation == nil {
+		return nil, http.StatusBadRequest, utils.RequestBodyError
+	}
 
 	log.WithFields(log.Fields{
 		"invitation": invitation,
 	}).Info("Creating invitation")
 
-	createdInvitation, err := utils.RunWithTransaction(func(tx *pg.Tx) (interface{}, error) {
+	createdInvitation, err := access.Run(r.Context(), handler.transactor, func(tx access.Tx) (interface{}, error) {
 		return handler.dao.CreateInvitation(tx, invitation)
 	})
 	if err != nil {
@@ -76,29 +75,51 @@ func (handler *InvitationsHandler) CreateInvitationHandler(r *http.Request, vars
 	return utils.SerializeResponse(createdInvitation, http.StatusOK)
 }
 
-// UpdateInvitationHandler updates an existing invitation
+// invitationImmutableFields are the invitation fields PUT and PATCH may not change
+var invitationImmutableFields = []string{"id", "rsvp_code"}
+
+// UpdateInvitationHandler replaces an invitation with the body of a PUT, or merges the JSON Merge Patch in the body of a PATCH into it
 func (handler *InvitationsHandler) UpdateInvitationHandler(r *http.Request, vars map[string]string) ([]byte, int, error) {
 	id := utils.GetIDFromVars(vars)
-	var invitation *models.Invitation
-	json.NewDecoder(r.Body).Decode(&invitation)
-	invitation.ID = id
+	version, err := utils.IfMatchVersion(r)
+	if err != nil {
+		return nil, utils.StatusCode(err, http.StatusBadRequest), err
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
 
 	log.WithFields(log.Fields{
-		"invitation": invitation,
+		"id":     id,
+		"method": r.Method,
 	}).Info("Updating invitation")
 
-	updatedInvitation, err := utils.RunWithTransaction(func(tx *pg.Tx) (interface{}, error) {
+	updatedInvitation, err := access.Run(r.Context(), handler.transactor, func(tx access.Tx) (interface{}, error) {
+		current, err := handler.dao.GetInvitation(tx, id)
+		if err != nil {
+			return nil, err
+		}
+		if current == nil {
+			return nil, utils.HTTPNotFoundError.Here()
+		}
+		invitation := new(models.Invitation)
+		if err := readReplacement(r, body, current, invitationImmutableFields, invitation); err != nil {
+			return nil, err
+		}
+		invitation.ID = id
+		invitation.Version = version
 		return handler.dao.UpdateInvitation(tx, invitation)
 	})
 	if err != nil {
 		log.Error("Error updating invitation")
-		return nil, http.StatusBadRequest, err
+		return nil, utils.StatusCode(err, http.StatusBadRequest), err
 	}
 
-	return utils.SerializeResponse(updatedInvitation, http.StatusOK)
+	return utils.SerializeVersioned(r, updatedInvitation, updatedInvitation.(*models.Invitation).Version)
 }
 
-// DeleteInvitationHandler deletes an invitation
+// DeleteInvitationHandler moves an invitation to the trash
 func (handler *InvitationsHandler) DeleteInvitationHandler(r *http.Request, vars map[string]string) ([]byte, int, error) {
 	id := utils.GetIDFromVars(vars)
 
@@ -106,12 +127,58 @@ func (handler *InvitationsHandler) DeleteInvitationHandler(r *http.Request, vars
 		"id": id,
 	}).Info("Deleting invitation")
 
-	_, err := utils.RunWithTransaction(func(tx *pg.Tx) (interface{}, error) {
-		return handler.dao.DeleteInvitation(tx, id)
+	version, err := utils.IfMatchVersion(r)
+	if err != nil {
+		return nil, utils.StatusCode(err, http.StatusBadRequest), err
+	}
+	_, err = access.Run(r.Context(), handler.transactor, func(tx access.Tx) (interface{}, error) {
+		return handler.dao.DeleteInvitation(tx, id, version)
 	})
 	if err != nil {
 		log.Error("Error deleting invitation")
-		return nil, http.StatusBadRequest, err
+		return nil, utils.StatusCode(err, http.StatusBadRequest), err
 	}
 	return utils.SerializeResponse(nil, http.StatusOK)
 }
+
+// RestoreInvitationHandler takes a deleted invitation back out of the trash
+func (handler *InvitationsHandler) RestoreInvitationHandler(r *http.Request, vars map[string]string) ([]byte, int, error) {
+	id := utils.GetIDFromVars(vars)
+
+	log.WithFields(log.Fields{
+		"id": id,
+	}).Info("Restoring invitation")
+
+	restored, err := access.Run(r.Context(), handler.transactor, func(tx access.Tx) (interface{}, error) {
+		return handler.dao.RestoreInvitation(tx, id)
+	})
+	if err != nil {
+		log.Error("Error restoring invitation")
+		return nil, utils.StatusCode(err, http.StatusBadRequest), err
+	}
+	if restored.(*models.Invitation) == nil {
+		return nil, http.StatusNotFound, utils.HTTPNotFoundError.Here()
+	}
+	return utils.SerializeVersioned(r, restored, restored.(*models.Invitation).Version)
+}
+
+// SendInvitationHandler emails an invitation with its RSVP link. Pass `?resend=true` to send it again.
+func (handler *InvitationsHandler) SendInvitationHandler(r *http.Request, vars map[string]string) ([]byte, int, error) {
+	id := utils.GetIDFromVars(vars)
+	resend := r.URL.Query().Get("resend") == "true"
+
+	log.WithFields(log.Fields{
+		"id":     id,
+		"resend": resend,
+	}).Info("Sending invitation")
+
+	notification, err := handler.notifier.SendInvitation(r.Context(), id, resend)
+	if err != nil {
+		log.Error("Error sending invitation")
+		return nil, utils.StatusCode(err, http.StatusBadGateway), err
+	}
+	if notification == nil {
+		return nil, http.StatusNoContent, nil
+	}
+	return utils.SerializeResponse(notification, http.StatusOK)
+}
@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"fmt"
+	"github.com/kyrstenkelly/rsvp-api/db/access"
+	"github.com/kyrstenkelly/rsvp-api/utils"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+)
+
+// listPage gets one page of a list in a transaction, along with the total number of matching rows
+type listPage func(tx access.Tx, query access.ListQuery) (interface{}, int, error)
+
+// serveList is shared by the list endpoints. It parses the limit, offset, sort, q and filter
+// parameters against spec, rejecting any it doesn't know, gets the page, and sets the
+// X-Total-Count header and a Link header pointing at the next page if there is one.
+func serveList(r *http.Request, transactor access.Transactor, spec access.ListSpec, page listPage) ([]byte, int, error) {
+	query, err := access.ParseListQuery(spec, r.URL.Query())
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	var rows interface{}
+	var total int
+	err = transactor.RunInTransaction(r.Context(), func(tx access.Tx) (err error) {
+		rows, total, err = page(tx, query)
+		return err
+	})
+	if err != nil {
+		log.Error("Error getting list")
+		return nil, http.StatusBadRequest, err
+	}
+
+	utils.SetResponseHeader(r, "X-Total-Count", strconv.Itoa(total))
+	if next := query.Offset + query.Limit; next < total {
+		nextURL := *r.URL
+		params := nextURL.Query()
+		params.Set("limit", strconv.Itoa(query.Limit))
+		params.Set("offset", strconv.Itoa(next))
+		nextURL.RawQuery = params.Encode()
+		utils.SetResponseHeader(r, "Link", fmt.Sprintf(`<%s>; rel="next"`, nextURL.RequestURI()))
+	}
+	return utils.SerializeResponse(rows, http.StatusOK)
+}
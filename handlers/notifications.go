@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"github.com/kyrstenkelly/rsvp-api/db/access"
+	"github.com/kyrstenkelly/rsvp-api/notifications"
+	"github.com/kyrstenkelly/rsvp-api/utils"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+)
+
+// retryBatchSize is how many failed notifications one retry request resends
+const retryBatchSize = 50
+
+// NotificationsHandler type
+type NotificationsHandler struct {
+	transactor access.Transactor
+	dao        access.NotificationsAccess
+	notifier   *notifications.Notifier
+}
+
+// NewNotificationsHandler creates a new handler with the given transactor, dao and notifier
+func NewNotificationsHandler(transactor access.Transactor, dao access.NotificationsAccess, notifier *notifications.Notifier) *NotificationsHandler {
+	return &NotificationsHandler{transactor: transactor, dao: dao, notifier: notifier}
+}
+
+// GetNotificationsHandler gets the emails sent, optionally for one `?invitation_id=` and `?kind=`
+func (handler *NotificationsHandler) GetNotificationsHandler(r *http.Request, vars map[string]string) ([]byte, int, error) {
+	var invitationID int64
+	if param := r.URL.Query().Get("invitation_id"); param != "" {
+		id, err := strconv.ParseInt(param, 10, 64)
+		if err != nil {
+			return nil, http.StatusBadRequest, utils.ArgumentError.Here().WithMessage("invitation_id must be a number")
+		}
+		invitationID = id
+	}
+
+	kind := r.URL.Query().Get("kind")
+
+	log.WithFields(log.Fields{
+		"invitation_id": invitationID,
+		"kind":          kind,
+	}).Info("Getting notifications")
+
+	notifications, err := access.Run(r.Context(), handler.transactor, func(tx access.Tx) (interface{}, error) {
+		return handler.dao.GetNotifications(tx, invitationID, kind)
+	})
+	if err != nil {
+		log.Error("Error getting notifications")
+		return nil, http.StatusInternalServerError, err
+	}
+	return utils.SerializeResponse(notifications, http.StatusOK)
+}
+
+// RetryNotificationsHandler resends notifications that failed
+func (handler *NotificationsHandler) RetryNotificationsHandler(r *http.Request, vars map[string]string) ([]byte, int, error) {
+	log.Info("Retrying failed notifications")
+
+	sent, err := handler.notifier.RetryFailed(r.Context(), retryBatchSize)
+	if err != nil {
+		log.Error("Error retrying notifications")
+		return nil, utils.StatusCode(err, http.StatusInternalServerError), err
+	}
+	return utils.SerializeResponse(map[string]int{"sent": sent}, http.StatusOK)
+}
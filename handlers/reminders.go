@@ -0,0 +1,246 @@
+package handlers
+
+import (
+	"encoding/json"
+	"github.com/kyrstenkelly/rsvp-api/db/access"
+	"github.com/kyrstenkelly/rsvp-api/db/models"
+	"github.com/kyrstenkelly/rsvp-api/notifications"
+	"github.com/kyrstenkelly/rsvp-api/utils"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"time"
+)
+
+// RemindersHandler type
+type RemindersHandler struct {
+	transactor       access.Transactor
+	dao              access.ReminderCampaignsAccess
+	eventsDAO        access.EventsAccess
+	notificationsDAO access.NotificationsAccess
+	scheduler        *notifications.ReminderScheduler
+}
+
+// NewRemindersHandler creates a new handler with the given transactor, daos and scheduler
+func NewRemindersHandler(transactor access.Transactor, dao access.ReminderCampaignsAccess, eventsDAO access.EventsAccess,
+	notificationsDAO access.NotificationsAccess, scheduler *notifications.ReminderScheduler) *RemindersHandler {
+	return &RemindersHandler{
+		transactor:       transactor,
+		dao:              dao,
+		eventsDAO:        eventsDAO,
+		notificationsDAO: notificationsDAO,
+		scheduler:        scheduler,
+	}
+}
+
+// validateOffsetDays checks a campaign's cadence is a list of positive numbers of days
+func validateOffsetDays(offsetDays []int) error {
+	if len(offsetDays) == 0 {
+		return utils.ArgumentError.Here().WithMessage("offset_days must list the days before the deadline to send reminders")
+	}
+	for _, offset := range offsetDays {
+		if offset <= 0 {
+			return utils.ArgumentError.Here().WithMessagef("offset_days must be positive, got %d", offset)
+		}
+	}
+	return nil
+}
+
+// GetCampaignsHandler gets a list of all reminder campaigns
+func (handler *RemindersHandler) GetCampaignsHandler(r *http.Request, vars map[string]string) ([]byte, int, error) {
+	log.Info("Getting all reminder campaigns")
+
+	campaigns, err := access.Run(r.Context(), handler.transactor, func(tx access.Tx) (interface{}, error) {
+		return handler.dao.GetCampaigns(tx)
+	})
+	if err != nil {
+		log.Error("Error getting reminder campaigns")
+		return nil, http.StatusInternalServerError, err
+	}
+	return utils.SerializeResponse(campaigns, http.StatusOK)
+}
+
+// GetCampaignHandler gets a reminder campaign by id
+func (handler *RemindersHandler) GetCampaignHandler(r *http.Request, vars map[string]string) ([]byte, int, error) {
+	id := utils.GetIDFromVars(vars)
+
+	log.WithFields(log.Fields{
+		"id": id,
+	}).Info("Getting reminder campaign by ID")
+
+	campaign, err := access.Run(r.Context(), handler.transactor, func(tx access.Tx) (interface{}, error) {
+		return handler.dao.GetCampaign(tx, id)
+	})
+	if err != nil {
+		log.Error("Error getting reminder campaign")
+		return nil, http.StatusInternalServerError, err
+	}
+	if campaign.(*models.ReminderCampaign) == nil {
+		return nil, http.StatusNotFound, utils.HTTPNotFoundError.Here()
+	}
+	return utils.SerializeVersioned(r, campaign, campaign.(*models.ReminderCampaign).Version)
+}
+
+// CreateCampaignHandler handles creating a reminder campaign for an event
+func (handler *RemindersHandler) CreateCampaignHandler(r *http.Request, vars map[string]string) ([]byte, int, error) {
+	var campaign *models.ReminderCampaign
+	if err := json.NewDecoder(r.Body).Decode(&campaign); err != nil || campaign == nil {
+		return nil, http.StatusBadRequest, utils.ArgumentError.Here().WithMessage("Invalid reminder campaign")
+	}
+	if err := validateOffsetDays(campaign.OffsetDays); err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	log.WithFields(log.Fields{
+		"campaign": campaign,
+	}).Info("Creating reminder campaign")
+
+	createdCampaign, err := access.Run(r.Context(), handler.transactor, func(tx access.Tx) (interface{}, error) {
+		event, err := handler.eventsDAO.GetEvent(tx, campaign.EventID)
+		if err != nil {
+			return nil, err
+		}
+		if event == nil {
+			return nil, utils.ArgumentError.Here().WithMessagef("Event %d does not exist", campaign.EventID)
+		}
+		return handler.dao.CreateCampaign(tx, campaign)
+	})
+	if err != nil {
+		log.Error("Error creating reminder campaign")
+		return nil, utils.StatusCode(err, http.StatusBadRequest), err
+	}
+	return utils.SerializeResponse(createdCampaign, http.StatusOK)
+}
+
+// UpdateCampaignHandler updates the name or cadence of a reminder campaign
+func (handler *RemindersHandler) UpdateCampaignHandler(r *http.Request, vars map[string]string) ([]byte, int, error) {
+	id := utils.GetIDFromVars(vars)
+	version, err := utils.IfMatchVersion(r)
+	if err != nil {
+		return nil, utils.StatusCode(err, http.StatusBadRequest), err
+	}
+	var campaign *models.ReminderCampaign
+	if err := json.NewDecoder(r.Body).Decode(&campaign); err != nil || campaign == nil {
+		return nil, http.StatusBadRequest, utils.ArgumentError.Here().WithMessage("Invalid reminder campaign")
+	}
+	if campaign.OffsetDays != nil {
+		if err := validateOffsetDays(campaign.OffsetDays); err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+	}
+	campaign.ID = id
+	campaign.Version = version
+
+	log.WithFields(log.Fields{
+		"campaign": campaign,
+	}).Info("Updating reminder campaign")
+
+	updatedCampaign, err := access.Run(r.Context(), handler.transactor, func(tx access.Tx) (interface{}, error) {
+		return handler.dao.UpdateCampaign(tx, campaign)
+	})
+	if err != nil {
+		log.Error("Error updating reminder campaign")
+		return nil, utils.StatusCode(err, http.StatusBadRequest), err
+	}
+	if updatedCampaign.(*models.ReminderCampaign) == nil {
+		return nil, http.StatusNotFound, utils.HTTPNotFoundError.Here()
+	}
+	return utils.SerializeVersioned(r, updatedCampaign, updatedCampaign.(*models.ReminderCampaign).Version)
+}
+
+// PauseCampaignHandler stops a campaign from sending reminders until it is resumed
+func (handler *RemindersHandler) PauseCampaignHandler(r *http.Request, vars map[string]string) ([]byte, int, error) {
+	return handler.setPaused(r, vars, true)
+}
+
+// ResumeCampaignHandler lets a paused campaign send reminders again
+func (handler *RemindersHandler) ResumeCampaignHandler(r *http.Request, vars map[string]string) ([]byte, int, error) {
+	return handler.setPaused(r, vars, false)
+}
+
+func (handler *RemindersHandler) setPaused(r *http.Request, vars map[string]string, paused bool) ([]byte, int, error) {
+	id := utils.GetIDFromVars(vars)
+
+	log.WithFields(log.Fields{
+		"id":     id,
+		"paused": paused,
+	}).Info("Pausing reminder campaign")
+
+	campaign, err := access.Run(r.Context(), handler.transactor, func(tx access.Tx) (interface{}, error) {
+		return handler.dao.SetCampaignPaused(tx, id, paused)
+	})
+	if err != nil {
+		log.Error("Error pausing reminder campaign")
+		return nil, http.StatusBadRequest, err
+	}
+	if campaign.(*models.ReminderCampaign) == nil {
+		return nil, http.StatusNotFound, utils.HTTPNotFoundError.Here()
+	}
+	return utils.SerializeResponse(campaign, http.StatusOK)
+}
+
+// DeleteCampaignHandler deletes a reminder campaign
+func (handler *RemindersHandler) DeleteCampaignHandler(r *http.Request, vars map[string]string) ([]byte, int, error) {
+	id := utils.GetIDFromVars(vars)
+
+	log.WithFields(log.Fields{
+		"id": id,
+	}).Info("Deleting reminder campaign")
+
+	version, err := utils.IfMatchVersion(r)
+	if err != nil {
+		return nil, utils.StatusCode(err, http.StatusBadRequest), err
+	}
+	_, err = access.Run(r.Context(), handler.transactor, func(tx access.Tx) (interface{}, error) {
+		return handler.dao.DeleteCampaign(tx, id, version)
+	})
+	if err != nil {
+		log.Error("Error deleting reminder campaign")
+		return nil, utils.StatusCode(err, http.StatusBadRequest), err
+	}
+	return utils.SerializeResponse(nil, http.StatusOK)
+}
+
+// PreviewCampaignHandler gets who a campaign will remind next and when
+func (handler *RemindersHandler) PreviewCampaignHandler(r *http.Request, vars map[string]string) ([]byte, int, error) {
+	id := utils.GetIDFromVars(vars)
+
+	log.WithFields(log.Fields{
+		"id": id,
+	}).Info("Previewing reminder campaign")
+
+	campaign, err := access.Run(r.Context(), handler.transactor, func(tx access.Tx) (interface{}, error) {
+		return handler.dao.GetCampaign(tx, id)
+	})
+	if err != nil {
+		log.Error("Error getting reminder campaign")
+		return nil, http.StatusInternalServerError, err
+	}
+	if campaign.(*models.ReminderCampaign) == nil {
+		return nil, http.StatusNotFound, utils.HTTPNotFoundError.Here()
+	}
+
+	preview, err := handler.scheduler.Preview(r.Context(), campaign.(*models.ReminderCampaign), time.Now())
+	if err != nil {
+		log.Error("Error previewing reminder campaign")
+		return nil, utils.StatusCode(err, http.StatusInternalServerError), err
+	}
+	return utils.SerializeResponse(preview, http.StatusOK)
+}
+
+// GetInvitationRemindersHandler gets the reminders sent to an invitation, newest first
+func (handler *RemindersHandler) GetInvitationRemindersHandler(r *http.Request, vars map[string]string) ([]byte, int, error) {
+	id := utils.GetIDFromVars(vars)
+
+	log.WithFields(log.Fields{
+		"id": id,
+	}).Info("Getting reminders for invitation")
+
+	reminders, err := access.Run(r.Context(), handler.transactor, func(tx access.Tx) (interface{}, error) {
+		return handler.notificationsDAO.GetNotifications(tx, id, models.NotificationReminder)
+	})
+	if err != nil {
+		log.Error("Error getting reminders")
+		return nil, http.StatusInternalServerError, err
+	}
+	return utils.SerializeResponse(reminders, http.StatusOK)
+}
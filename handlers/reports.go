@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"github.com/kyrstenkelly/rsvp-api/db/access"
+	"github.com/kyrstenkelly/rsvp-api/db/models"
+	"github.com/kyrstenkelly/rsvp-api/utils"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ReportsHandler type
+type ReportsHandler struct {
+	transactor access.Transactor
+	dao        access.ReportsAccess
+}
+
+// NewReportsHandler creates a new handler with the given transactor and dao
+func NewReportsHandler(transactor access.Transactor, dao access.ReportsAccess) *ReportsHandler {
+	return &ReportsHandler{transactor: transactor, dao: dao}
+}
+
+// GetEventReportHandler gets the headcount and meal totals for an event, as json or csv
+func (handler *ReportsHandler) GetEventReportHandler(r *http.Request, vars map[string]string) ([]byte, int, error) {
+	id := utils.GetIDFromVars(vars)
+	format, err := reportFormat(r)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	log.WithFields(log.Fields{
+		"id":     id,
+		"format": format,
+	}).Info("Getting event report")
+
+	report, err := access.Run(r.Context(), handler.transactor, func(tx access.Tx) (interface{}, error) {
+		return handler.dao.GetEventReport(tx, id)
+	})
+	if err != nil {
+		log.Error("Error getting event report")
+		return nil, http.StatusInternalServerError, err
+	}
+	if report.(*models.EventReport) == nil {
+		return nil, http.StatusNotFound, utils.HTTPNotFoundError
+	}
+
+	if format == "csv" {
+		buf, err := eventReportCSV(report.(*models.EventReport))
+		if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		utils.SetResponseHeader(r, "Content-Type", "text/csv")
+		utils.SetResponseHeader(r, "Content-Disposition", fmt.Sprintf("attachment; filename=\"event-%d-report.csv\"", id))
+		return buf, http.StatusOK, nil
+	}
+	return utils.SerializeResponse(report, http.StatusOK)
+}
+
+// reportFormat reads the format from the query string, falling back to the Accept header
+func reportFormat(r *http.Request) (string, error) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		if strings.Contains(r.Header.Get("Accept"), "text/csv") {
+			return "csv", nil
+		}
+		return "json", nil
+	}
+	if format != "json" && format != "csv" {
+		return "", utils.ArgumentError.Here().WithMessagef("Unknown format %q, expected json or csv", format)
+	}
+	return format, nil
+}
+
+// eventReportCSV flattens a report into section,name,value rows
+func eventReportCSV(report *models.EventReport) ([]byte, error) {
+	rows := [][]string{
+		{"section", "name", "value"},
+		{"event", "name", report.EventName},
+		{"totals", "invitations", strconv.Itoa(report.Invitations)},
+		{"totals", "invitations_responded", strconv.Itoa(report.InvitationsResponded)},
+		{"totals", "invited", strconv.Itoa(report.Invited)},
+		{"totals", "responded", strconv.Itoa(report.Responded)},
+		{"totals", "attending", strconv.Itoa(report.Attending)},
+		{"totals", "declined", strconv.Itoa(report.Declined)},
+		{"totals", "no_response", strconv.Itoa(report.NoResponse)},
+		{"totals", "plus_ones", strconv.Itoa(report.PlusOnes)},
+	}
+	for _, food := range report.FoodCounts {
+		rows = append(rows, []string{"food", food.FoodChoice, strconv.Itoa(food.Count)})
+	}
+	for _, missing := range report.MissingFoodChoice {
+		rows = append(rows, []string{"missing_food_choice", missing.GuestName, missing.InvitationName})
+	}
+	for _, allergen := range report.Allergens {
+		rows = append(rows, []string{"allergen", allergen.Name, strconv.Itoa(allergen.Count)})
+		for _, guest := range allergen.Guests {
+			rows = append(rows, []string{"allergen:" + allergen.Name, guest.GuestName, dietaryDetails(guest)})
+		}
+	}
+	for _, diet := range report.Diets {
+		rows = append(rows, []string{"diet", diet.Name, strconv.Itoa(diet.Count)})
+		for _, guest := range diet.Guests {
+			rows = append(rows, []string{"diet:" + diet.Name, guest.GuestName, dietaryDetails(guest)})
+		}
+	}
+
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	err := writer.WriteAll(rows)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// dietaryDetails describes how severe a guest's allergy is and any notes, for the caterer
+func dietaryDetails(guest models.DietaryGuest) string {
+	var details []string
+	if guest.AllergySeverity != "" {
+		details = append(details, guest.AllergySeverity)
+	}
+	if guest.DietaryNotes != "" {
+		details = append(details, guest.DietaryNotes)
+	}
+	return strings.Join(details, ": ")
+}
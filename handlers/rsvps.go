@@ -2,40 +2,34 @@ package handlers
 
 import (
 	"encoding/json"
-	"github.com/go-pg/pg/v9"
-	"github.com/kyrstenkelly/rsvp-api/db"
 	"github.com/kyrstenkelly/rsvp-api/db/access"
 	"github.com/kyrstenkelly/rsvp-api/db/models"
+	"github.com/kyrstenkelly/rsvp-api/notifications"
 	"github.com/kyrstenkelly/rsvp-api/utils"
 	log "github.com/sirupsen/logrus"
+	"io/ioutil"
 	"net/http"
 )
 
 // RSVPsHandler type
 type RSVPsHandler struct {
-	dao access.RSVPsAccess
+	transactor access.Transactor
+	dao        access.RSVPsAccess
+	notifier   *notifications.Notifier
 }
 
-// NewRSVPsHandler creates a new handler with the given dao
-func NewRSVPsHandler(dao access.RSVPsAccess) *RSVPsHandler {
-	return &RSVPsHandler{dao: dao}
+// NewRSVPsHandler creates a new handler with the given transactor, dao and notifier
+func NewRSVPsHandler(transactor access.Transactor, dao access.RSVPsAccess, notifier *notifications.Notifier) *RSVPsHandler {
+	return &RSVPsHandler{transactor: transactor, dao: dao, notifier: notifier}
 }
 
-// GetRSVPsHandler gets a list of all rsvps
+// GetRSVPsHandler gets a page of rsvps
 func (handler *RSVPsHandler) GetRSVPsHandler(r *http.Request, vars map[string]string) ([]byte, int, error) {
 	log.Info("Getting all rsvps")
-	conn := db.GetDBConn()
-
-	var rsvps []models.RSVP
-	err := conn.RunInTransaction(func(tx *pg.Tx) (err error) {
-		rsvps, err = handler.dao.GetRSVPs(tx)
-		return err
+	return serveList(r, handler.transactor, access.RSVPsList, func(tx access.Tx, query access.ListQuery) (interface{}, int, error) {
+		rsvps, total, err := handler.dao.GetRSVPs(tx, query)
+		return rsvps, total, err
 	})
-	if err != nil {
-		log.Error("Error getting rsvps")
-		return nil, http.StatusBadRequest, err
-	}
-	return utils.SerializeResponse(rsvps, http.StatusOK)
 }
 
 // GetRSVPHandler gets an rsvp by id
@@ -46,60 +40,108 @@ func (handler *RSVPsHandler) GetRSVPHandler(r *http.Request, vars map[string]str
 		"id": id,
 	}).Info("Getting rsvp by ID")
 
-	rsvp, err := utils.RunWithTransaction(func(tx *pg.Tx) (interface{}, error) {
+	rsvp, err := access.Run(r.Context(), handler.transactor, func(tx access.Tx) (interface{}, error) {
 		return handler.dao.GetRSVP(tx, id)
 	})
 	if err != nil {
 		log.Error("Error getting rsvp")
 		return nil, http.StatusInternalServerError, err
 	}
-	return utils.SerializeResponse(rsvp, http.StatusOK)
+	if rsvp.(*models.RSVP) == nil {
+		return nil, http.StatusNotFound, utils.HTTPNotFoundError.Here()
+	}
+	return utils.SerializeVersioned(r, rsvp, rsvp.(*models.RSVP).Version)
 }
 
-// CreateRSVPHandler handles creating an rsvp
+// CreateRSVPHandler handles creating an rsvp, subject to the event's RSVP deadline
 func (handler *RSVPsHandler) CreateRSVPHandler(r *http.Request, vars map[string]string) ([]byte, int, error) {
+	return handler.createRSVP(r, true)
+}
+
+// AdminCreateRSVPHandler handles creating an rsvp on a guest's behalf, ignoring the RSVP deadline
+func (handler *RSVPsHandler) AdminCreateRSVPHandler(r *http.Request, vars map[string]string) ([]byte, int, error) {
+	return handler.createRSVP(r, false)
+}
+
+// UpdateRSVPHandler replaces (PUT) or patches (PATCH) an existing rsvp, subject to the event's RSVP deadline
+func (handler *RSVPsHandler) UpdateRSVPHandler(r *http.Request, vars map[string]string) ([]byte, int, error) {
+	return handler.updateRSVP(r, vars, true)
+}
+
+// AdminUpdateRSVPHandler replaces (PUT) or patches (PATCH) an existing rsvp on a guest's behalf, ignoring the RSVP deadline
+func (handler *RSVPsHandler) AdminUpdateRSVPHandler(r *http.Request, vars map[string]string) ([]byte, int, error) {
+	return handler.updateRSVP(r, vars, false)
+}
+
+func (handler *RSVPsHandler) createRSVP(r *http.Request, enforceDeadline bool) ([]byte, int, error) {
 	var rsvp *models.RSVP
-	json.NewDecoder(r.Body).Decode(&rsvp)
+	if err := json.NewDecoder(r.Body).Decode(&rsvp); err != nil || rsvp == nil {
+		return nil, http.StatusBadRequest, utils.RequestBodyError
+	}
 
 	log.WithFields(log.Fields{
-		"invitation_id": rsvp.InvitationID,
+		"invitation_id":    rsvp.InvitationID,
+		"enforce_deadline": enforceDeadline,
 	}).Info("Creating rsvp")
 
-	createdRSVP, err := utils.RunWithTransaction(func(tx *pg.Tx) (interface{}, error) {
-		return handler.dao.CreateRSVP(tx, rsvp)
+	createdRSVP, err := access.Run(r.Context(), handler.transactor, func(tx access.Tx) (interface{}, error) {
+		return handler.dao.CreateRSVP(tx, rsvp, enforceDeadline)
 	})
 	if err != nil {
 		log.Error("Error creating rsvp")
-		return nil, http.StatusBadRequest, err
+		return nil, utils.StatusCode(err, http.StatusBadRequest), err
 	}
+	go handler.notifier.RSVPSubmitted(access.BackgroundContext(r.Context()), createdRSVP.(*models.RSVP).ID)
 
 	return utils.SerializeResponse(createdRSVP, http.StatusOK)
 }
 
-// UpdateRSVPHandler updates an existing rsvp
-func (handler *RSVPsHandler) UpdateRSVPHandler(r *http.Request, vars map[string]string) ([]byte, int, error) {
+// rsvpImmutableFields are the rsvp fields PUT and PATCH may not change
+var rsvpImmutableFields = []string{"id", "invitation_id", "late"}
+
+func (handler *RSVPsHandler) updateRSVP(r *http.Request, vars map[string]string, enforceDeadline bool) ([]byte, int, error) {
 	id := utils.GetIDFromVars(vars)
-	var rsvp *models.RSVP
-	json.NewDecoder(r.Body).Decode(&rsvp)
-	rsvp.ID = id
+	version, err := utils.IfMatchVersion(r)
+	if err != nil {
+		return nil, utils.StatusCode(err, http.StatusBadRequest), err
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
 
 	log.WithFields(log.Fields{
-		"invitation_id": rsvp.InvitationID,
-		"rsvp_guests":   rsvp.RSVPGuests,
+		"id":               id,
+		"method":           r.Method,
+		"enforce_deadline": enforceDeadline,
 	}).Info("Updating rsvp")
 
-	updatedRSVP, err := utils.RunWithTransaction(func(tx *pg.Tx) (interface{}, error) {
-		return handler.dao.UpdateRSVP(tx, rsvp)
+	updatedRSVP, err := access.Run(r.Context(), handler.transactor, func(tx access.Tx) (interface{}, error) {
+		current, err := handler.dao.GetRSVP(tx, id)
+		if err != nil {
+			return nil, err
+		}
+		if current == nil {
+			return nil, utils.HTTPNotFoundError.Here()
+		}
+		rsvp := new(models.RSVP)
+		if err := readReplacement(r, body, current, rsvpImmutableFields, rsvp); err != nil {
+			return nil, err
+		}
+		rsvp.ID = id
+		rsvp.Version = version
+		return handler.dao.UpdateRSVP(tx, rsvp, enforceDeadline)
 	})
 	if err != nil {
 		log.Error("Error updating rsvp")
-		return nil, http.StatusBadRequest, err
+		return nil, utils.StatusCode(err, http.StatusBadRequest), err
 	}
+	go handler.notifier.RSVPSubmitted(access.BackgroundContext(r.Context()), updatedRSVP.(*models.RSVP).ID)
 
-	return utils.SerializeResponse(updatedRSVP, http.StatusOK)
+	return utils.SerializeVersioned(r, updatedRSVP, updatedRSVP.(*models.RSVP).Version)
 }
 
-// DeleteRSVPHandler deletes an rsvp
+// DeleteRSVPHandler moves an rsvp to the trash
 func (handler *RSVPsHandler) DeleteRSVPHandler(r *http.Request, vars map[string]string) ([]byte, int, error) {
 	id := utils.GetIDFromVars(vars)
 
@@ -107,12 +149,37 @@ func (handler *RSVPsHandler) DeleteRSVPHandler(r *http.Request, vars map[string]
 		"id": id,
 	}).Info("Deleting rsvp")
 
-	_, err := utils.RunWithTransaction(func(tx *pg.Tx) (interface{}, error) {
-		return handler.dao.DeleteRSVP(tx, id)
+	version, err := utils.IfMatchVersion(r)
+	if err != nil {
+		return nil, utils.StatusCode(err, http.StatusBadRequest), err
+	}
+	_, err = access.Run(r.Context(), handler.transactor, func(tx access.Tx) (interface{}, error) {
+		return handler.dao.DeleteRSVP(tx, id, version)
 	})
 	if err != nil {
 		log.Error("Error deleting rsvp")
-		return nil, http.StatusBadRequest, err
+		return nil, utils.StatusCode(err, http.StatusBadRequest), err
 	}
 	return utils.SerializeResponse(nil, http.StatusOK)
 }
+
+// RestoreRSVPHandler takes a deleted rsvp back out of the trash
+func (handler *RSVPsHandler) RestoreRSVPHandler(r *http.Request, vars map[string]string) ([]byte, int, error) {
+	id := utils.GetIDFromVars(vars)
+
+	log.WithFields(log.Fields{
+		"id": id,
+	}).Info("Restoring rsvp")
+
+	restored, err := access.Run(r.Context(), handler.transactor, func(tx access.Tx) (interface{}, error) {
+		return handler.dao.RestoreRSVP(tx, id)
+	})
+	if err != nil {
+		log.Error("Error restoring rsvp")
+		return nil, utils.StatusCode(err, http.StatusBadRequest), err
+	}
+	if restored.(*models.RSVP) == nil {
+		return nil, http.StatusNotFound, utils.HTTPNotFoundError.Here()
+	}
+	return utils.SerializeVersioned(r, restored, restored.(*models.RSVP).Version)
+}
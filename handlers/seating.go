@@ -0,0 +1,585 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/kyrstenkelly/rsvp-api/db/access"
+	"github.com/kyrstenkelly/rsvp-api/db/models"
+	"github.com/kyrstenkelly/rsvp-api/seating"
+	"github.com/kyrstenkelly/rsvp-api/utils"
+	log "github.com/sirupsen/logrus"
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// SeatingHandler type
+type SeatingHandler struct {
+	transactor access.Transactor
+	dao        access.SeatingAccess
+	eventsDAO  access.EventsAccess
+}
+
+// NewSeatingHandler creates a new handler with the given transactor and daos
+func NewSeatingHandler(transactor access.Transactor, dao access.SeatingAccess, eventsDAO access.EventsAccess) *SeatingHandler {
+	return &SeatingHandler{transactor: transactor, dao: dao, eventsDAO: eventsDAO}
+}
+
+// seatingTableImmutableFields are the table fields PUT and PATCH may not change
+var seatingTableImmutableFields = []string{"id", "event_id", "created_at"}
+
+// getEvent gets the event with the given id, or a not found error if there is none
+func (handler *SeatingHandler) getEvent(tx access.Tx, id int64) (*models.Event, error) {
+	event, err := handler.eventsDAO.GetEvent(tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if event == nil {
+		return nil, utils.HTTPNotFoundError.Here()
+	}
+	return event, nil
+}
+
+// checkSeatingTable checks a table has a name and room for at least one guest
+func checkSeatingTable(table *models.SeatingTable) error {
+	if strings.TrimSpace(table.Name) == "" {
+		return utils.ArgumentError.Here().WithMessage("name is required")
+	}
+	if table.Capacity < 1 {
+		return utils.ArgumentError.Here().WithMessage("capacity must be at least 1")
+	}
+	return nil
+}
+
+// checkCapacity checks no table has more guests seated at it than it seats
+func checkCapacity(tables []models.SeatingTable, guests []models.SeatingGuest) error {
+	seated := map[int64]int{}
+	for _, guest := range guests {
+		if guest.TableID != nil {
+			seated[*guest.TableID]++
+		}
+	}
+	for _, table := range tables {
+		if seated[table.ID] > table.Capacity {
+			return utils.ArgumentError.Here().WithMessagef("%s seats %d, so it can't seat %d guests",
+				table.Name, table.Capacity, seated[table.ID])
+		}
+	}
+	return nil
+}
+
+// GetSeatingTablesHandler gets the tables of the event with the id in the path
+func (handler *SeatingHandler) GetSeatingTablesHandler(r *http.Request, vars map[string]string) ([]byte, int, error) {
+	eventID := utils.GetIDFromVars(vars)
+
+	log.WithFields(log.Fields{
+		"event_id": eventID,
+	}).Info("Getting seating tables")
+
+	tables, err := access.Run(r.Context(), handler.transactor, func(tx access.Tx) (interface{}, error) {
+		if _, err := handler.getEvent(tx, eventID); err != nil {
+			return nil, err
+		}
+		return handler.dao.GetSeatingTables(tx, eventID)
+	})
+	if err != nil {
+		log.Error("Error getting seating tables")
+		return nil, utils.StatusCode(err, http.StatusInternalServerError), err
+	}
+	return utils.SerializeResponse(tables, http.StatusOK)
+}
+
+// CreateSeatingTableHandler handles creating a table for the event with the id in the path
+func (handler *SeatingHandler) CreateSeatingTableHandler(r *http.Request, vars map[string]string) ([]byte, int, error) {
+	eventID := utils.GetIDFromVars(vars)
+	table := new(models.SeatingTable)
+	if err := json.NewDecoder(r.Body).Decode(table); err != nil {
+		return nil, http.StatusBadRequest, utils.ArgumentError.Here().WithMessage("Invalid table")
+	}
+	table.EventID = eventID
+	if err := checkSeatingTable(table); err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	log.WithFields(log.Fields{
+		"event_id": eventID,
+		"name":     table.Name,
+		"capacity": table.Capacity,
+	}).Info("Creating seating table")
+
+	createdTable, err := access.Run(r.Context(), handler.transactor, func(tx access.Tx) (interface{}, error) {
+		if _, err := handler.getEvent(tx, eventID); err != nil {
+			return nil, err
+		}
+		return handler.dao.CreateSeatingTable(tx, table)
+	})
+	if err != nil {
+		log.Error("Error creating seating table")
+		return nil, utils.StatusCode(err, http.StatusBadRequest), err
+	}
+	return utils.SerializeResponse(createdTable, http.StatusOK)
+}
+
+// GetSeatingTableHandler gets a table by id
+func (handler *SeatingHandler) GetSeatingTableHandler(r *http.Request, vars map[string]string) ([]byte, int, error) {
+	id := utils.GetIDFromVars(vars)
+
+	log.WithFields(log.Fields{
+		"id": id,
+	}).Info("Getting seating table by ID")
+
+	table, err := access.Run(r.Context(), handler.transactor, func(tx access.Tx) (interface{}, error) {
+		return handler.dao.GetSeatingTable(tx, id)
+	})
+	if err != nil {
+		log.Error("Error getting seating table")
+		return nil, http.StatusInternalServerError, err
+	}
+	if table.(*models.SeatingTable) == nil {
+		return nil, http.StatusNotFound, utils.HTTPNotFoundError.Here()
+	}
+	return utils.SerializeVersioned(r, table, table.(*models.SeatingTable).Version)
+}
+
+// UpdateSeatingTableHandler renames or resizes a table with the body of a PUT, or the JSON Merge
+// Patch in the body of a PATCH. A table can't be made smaller than the number of guests seated at it.
+func (handler *SeatingHandler) UpdateSeatingTableHandler(r *http.Request, vars map[string]string) ([]byte, int, error) {
+	id := utils.GetIDFromVars(vars)
+	version, err := utils.IfMatchVersion(r)
+	if err != nil {
+		return nil, utils.StatusCode(err, http.StatusBadRequest), err
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	log.WithFields(log.Fields{
+		"id":     id,
+		"method": r.Method,
+	}).Info("Updating seating table")
+
+	updatedTable, err := access.Run(r.Context(), handler.transactor, func(tx access.Tx) (interface{}, error) {
+		current, err := handler.dao.GetSeatingTable(tx, id)
+		if err != nil {
+			return nil, err
+		}
+		if current == nil {
+			return nil, utils.HTTPNotFoundError.Here()
+		}
+		table := new(models.SeatingTable)
+		if err := readReplacement(r, body, current, seatingTableImmutableFields, table); err != nil {
+			return nil, err
+		}
+		if err := checkSeatingTable(table); err != nil {
+			return nil, err
+		}
+		guests, err := handler.dao.GetSeatingGuests(tx, current.EventID)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkCapacity([]models.SeatingTable{*table}, guests); err != nil {
+			return nil, err
+		}
+		table.ID = id
+		table.Version = version
+		return handler.dao.UpdateSeatingTable(tx, table)
+	})
+	if err != nil {
+		log.Error("Error updating seating table")
+		return nil, utils.StatusCode(err, http.StatusBadRequest), err
+	}
+	return utils.SerializeVersioned(r, updatedTable, updatedTable.(*models.SeatingTable).Version)
+}
+
+// DeleteSeatingTableHandler deletes a table, unseating the guests at it
+func (handler *SeatingHandler) DeleteSeatingTableHandler(r *http.Request, vars map[string]string) ([]byte, int, error) {
+	id := utils.GetIDFromVars(vars)
+
+	log.WithFields(log.Fields{
+		"id": id,
+	}).Info("Deleting seating table")
+
+	version, err := utils.IfMatchVersion(r)
+	if err != nil {
+		return nil, utils.StatusCode(err, http.StatusBadRequest), err
+	}
+	_, err = access.Run(r.Context(), handler.transactor, func(tx access.Tx) (interface{}, error) {
+		return handler.dao.DeleteSeatingTable(tx, id, version)
+	})
+	if err != nil {
+		log.Error("Error deleting seating table")
+		return nil, utils.StatusCode(err, http.StatusBadRequest), err
+	}
+	return utils.SerializeResponse(nil, http.StatusOK)
+}
+
+// SetSeatsHandler seats guests of the event with the id in the path at its tables. The body is a
+// list of seats; one with a null table_id unseats its guest. Guests not in the list keep their
+// seats, and no table may end up with more guests than it seats.
+func (handler *SeatingHandler) SetSeatsHandler(r *http.Request, vars map[string]string) ([]byte, int, error) {
+	eventID := utils.GetIDFromVars(vars)
+	var seats []models.Seat
+	if err := json.NewDecoder(r.Body).Decode(&seats); err != nil {
+		return nil, http.StatusBadRequest, utils.ArgumentError.Here().WithMessage("Invalid seats")
+	}
+
+	log.WithFields(log.Fields{
+		"event_id": eventID,
+		"seats":    len(seats),
+	}).Info("Setting seats")
+
+	chart, err := access.Run(r.Context(), handler.transactor, func(tx access.Tx) (interface{}, error) {
+		event, err := handler.getEvent(tx, eventID)
+		if err != nil {
+			return nil, err
+		}
+		tables, err := handler.dao.GetSeatingTables(tx, eventID)
+		if err != nil {
+			return nil, err
+		}
+		guests, err := handler.dao.GetSeatingGuests(tx, eventID)
+		if err != nil {
+			return nil, err
+		}
+
+		tableIDs := map[int64]bool{}
+		for _, table := range tables {
+			tableIDs[table.ID] = true
+		}
+		byID := map[int64]*models.SeatingGuest{}
+		for i := range guests {
+			byID[guests[i].RSVPGuestID] = &guests[i]
+		}
+		for _, seat := range seats {
+			guest, ok := byID[seat.RSVPGuestID]
+			if !ok {
+				return nil, utils.ArgumentError.Here().WithMessagef("rsvp guest %d isn't attending event %d", seat.RSVPGuestID, eventID)
+			}
+			if seat.TableID != nil && !tableIDs[*seat.TableID] {
+				return nil, utils.ArgumentError.Here().WithMessagef("table %d isn't a table of event %d", *seat.TableID, eventID)
+			}
+			guest.TableID = seat.TableID
+		}
+		if err := checkCapacity(tables, guests); err != nil {
+			return nil, err
+		}
+		if err := handler.dao.SetSeats(tx, seats); err != nil {
+			return nil, err
+		}
+		return handler.chart(tx, event, tables, guests)
+	})
+	if err != nil {
+		log.Error("Error setting seats")
+		return nil, utils.StatusCode(err, http.StatusBadRequest), err
+	}
+	return utils.SerializeResponse(chart, http.StatusOK)
+}
+
+// AutoAssignSeatsHandler seats the guests of the event with the id in the path, keeping
+// households and together constraints at one table and apart constraints at different ones.
+// Guests who are already seated stay put unless reset=true. With dry_run=true the chart is
+// returned without saving the seats.
+func (handler *SeatingHandler) AutoAssignSeatsHandler(r *http.Request, vars map[string]string) ([]byte, int, error) {
+	eventID := utils.GetIDFromVars(vars)
+	reset := r.URL.Query().Get("reset") == "true"
+	dryRun := r.URL.Query().Get("dry_run") == "true"
+
+	log.WithFields(log.Fields{
+		"event_id": eventID,
+		"reset":    reset,
+		"dry_run":  dryRun,
+	}).Info("Auto-assigning seats")
+
+	chart, err := access.Run(r.Context(), handler.transactor, func(tx access.Tx) (interface{}, error) {
+		event, err := handler.getEvent(tx, eventID)
+		if err != nil {
+			return nil, err
+		}
+		tables, err := handler.dao.GetSeatingTables(tx, eventID)
+		if err != nil {
+			return nil, err
+		}
+		guests, err := handler.dao.GetSeatingGuests(tx, eventID)
+		if err != nil {
+			return nil, err
+		}
+		constraints, err := handler.dao.GetSeatingConstraints(tx, eventID)
+		if err != nil {
+			return nil, err
+		}
+
+		seats, err := seating.Solve(guests, tables, constraints, !reset)
+		if err != nil {
+			return nil, err
+		}
+		for i, seat := range seats {
+			guests[i].TableID = seat.TableID
+		}
+		if !dryRun {
+			if err := handler.dao.SetSeats(tx, seats); err != nil {
+				return nil, err
+			}
+		}
+		return seating.Chart(event, tables, guests, constraints), nil
+	})
+	if err != nil {
+		log.Error("Error auto-assigning seats")
+		return nil, utils.StatusCode(err, http.StatusBadRequest), err
+	}
+	return utils.SerializeResponse(chart, http.StatusOK)
+}
+
+// chart builds the seating chart of an event from its tables and guests
+func (handler *SeatingHandler) chart(tx access.Tx, event *models.Event, tables []models.SeatingTable,
+	guests []models.SeatingGuest) (*models.SeatingChart, error) {
+	constraints, err := handler.dao.GetSeatingConstraints(tx, event.ID)
+	if err != nil {
+		return nil, err
+	}
+	return seating.Chart(event, tables, guests, constraints), nil
+}
+
+// GetSeatingChartHandler gets the seating chart of the event with the id in the path, as json,
+// csv or a printable html page
+func (handler *SeatingHandler) GetSeatingChartHandler(r *http.Request, vars map[string]string) ([]byte, int, error) {
+	eventID := utils.GetIDFromVars(vars)
+	format, err := seatingFormat(r)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	log.WithFields(log.Fields{
+		"event_id": eventID,
+		"format":   format,
+	}).Info("Getting seating chart")
+
+	chart, err := access.Run(r.Context(), handler.transactor, func(tx access.Tx) (interface{}, error) {
+		event, err := handler.getEvent(tx, eventID)
+		if err != nil {
+			return nil, err
+		}
+		tables, err := handler.dao.GetSeatingTables(tx, eventID)
+		if err != nil {
+			return nil, err
+		}
+		guests, err := handler.dao.GetSeatingGuests(tx, eventID)
+		if err != nil {
+			return nil, err
+		}
+		return handler.chart(tx, event, tables, guests)
+	})
+	if err != nil {
+		log.Error("Error getting seating chart")
+		return nil, utils.StatusCode(err, http.StatusInternalServerError), err
+	}
+
+	var buf []byte
+	switch format {
+	case "csv":
+		buf, err = seatingChartCSV(chart.(*models.SeatingChart))
+	case "html":
+		buf, err = seatingChartHTML(chart.(*models.SeatingChart))
+	default:
+		return utils.SerializeResponse(chart, http.StatusOK)
+	}
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	if format == "csv" {
+		utils.SetResponseHeader(r, "Content-Type", "text/csv")
+		utils.SetResponseHeader(r, "Content-Disposition", fmt.Sprintf("attachment; filename=\"event-%d-seating.csv\"", eventID))
+	} else {
+		utils.SetResponseHeader(r, "Content-Type", "text/html; charset=utf-8")
+	}
+	return buf, http.StatusOK, nil
+}
+
+// seatingFormat reads the chart format from the query string, falling back to the Accept header
+func seatingFormat(r *http.Request) (string, error) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		accept := r.Header.Get("Accept")
+		if strings.Contains(accept, "text/csv") {
+			return "csv", nil
+		}
+		if strings.Contains(accept, "text/html") {
+			return "html", nil
+		}
+		return "json", nil
+	}
+	if format != "json" && format != "csv" && format != "html" {
+		return "", utils.ArgumentError.Here().WithMessagef("Unknown format %q, expected json, csv or html", format)
+	}
+	return format, nil
+}
+
+// seatingChartCSV flattens a chart into one row per guest, seated guests by table and then the unseated
+func seatingChartCSV(chart *models.SeatingChart) ([]byte, error) {
+	rows := [][]string{{"table", "guest", "invitation", "plus_one", "food_choice"}}
+	guestRow := func(table string, guest models.SeatingGuest) []string {
+		return []string{table, guest.Name, guest.InvitationName, strconv.FormatBool(guest.IsPlusOne), guest.FoodChoice}
+	}
+	for _, table := range chart.Tables {
+		for _, guest := range table.Guests {
+			rows = append(rows, guestRow(table.Table.Name, guest))
+		}
+	}
+	for _, guest := range chart.Unseated {
+		rows = append(rows, guestRow("", guest))
+	}
+
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	err := writer.WriteAll(rows)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// seatingChartTemplate lays a chart out for printing, one block per table
+var seatingChartTemplate = template.Must(template.New("seating").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.EventName}} seating chart</title>
+<style>
+body { font-family: Georgia, serif; margin: 2em; }
+.tables { display: flex; flex-wrap: wrap; gap: 1.5em; }
+.table { border: 1px solid #444; border-radius: 6px; padding: 0.75em 1.25em; min-width: 14em; break-inside: avoid; }
+.table h2 { font-size: 1.1em; margin: 0 0 0.5em; }
+.table ul { list-style: none; padding: 0; margin: 0; }
+.food, .seats { color: #666; font-size: 0.85em; }
+.violations { color: #a00; }
+</style>
+</head>
+<body>
+<h1>{{.EventName}}</h1>
+<div class="tables">
+{{- range .Tables}}
+<div class="table">
+<h2>{{.Table.Name}} <span class="seats">({{len .Guests}} of {{.Table.Capacity}})</span></h2>
+<ul>
+{{- range .Guests}}
+<li>{{.Name}}{{if .IsPlusOne}} (guest){{end}}{{if .FoodChoice}} <span class="food">{{.FoodChoice}}</span>{{end}}</li>
+{{- end}}
+</ul>
+</div>
+{{- end}}
+</div>
+{{- if .Unseated}}
+<h2>Not seated</h2>
+<ul>
+{{- range .Unseated}}
+<li>{{.Name}} <span class="food">{{.InvitationName}}</span></li>
+{{- end}}
+</ul>
+{{- end}}
+{{- if .Violations}}
+<h2>Problems</h2>
+<ul class="violations">
+{{- range .Violations}}
+<li>{{.}}</li>
+{{- end}}
+</ul>
+{{- end}}
+</body>
+</html>
+`))
+
+// seatingChartHTML renders a chart as a printable html page
+func seatingChartHTML(chart *models.SeatingChart) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := seatingChartTemplate.Execute(&buf, chart); err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// GetSeatingConstraintsHandler gets the seating constraints of the event with the id in the path
+func (handler *SeatingHandler) GetSeatingConstraintsHandler(r *http.Request, vars map[string]string) ([]byte, int, error) {
+	eventID := utils.GetIDFromVars(vars)
+
+	log.WithFields(log.Fields{
+		"event_id": eventID,
+	}).Info("Getting seating constraints")
+
+	constraints, err := access.Run(r.Context(), handler.transactor, func(tx access.Tx) (interface{}, error) {
+		if _, err := handler.getEvent(tx, eventID); err != nil {
+			return nil, err
+		}
+		return handler.dao.GetSeatingConstraints(tx, eventID)
+	})
+	if err != nil {
+		log.Error("Error getting seating constraints")
+		return nil, utils.StatusCode(err, http.StatusInternalServerError), err
+	}
+	return utils.SerializeResponse(constraints, http.StatusOK)
+}
+
+// CreateSeatingConstraintHandler handles creating a seating constraint for the event with the id
+// in the path. It keeps the guests and invitations it lists together, or apart, and must cover at
+// least two of them.
+func (handler *SeatingHandler) CreateSeatingConstraintHandler(r *http.Request, vars map[string]string) ([]byte, int, error) {
+	eventID := utils.GetIDFromVars(vars)
+	constraint := new(models.SeatingConstraint)
+	if err := json.NewDecoder(r.Body).Decode(constraint); err != nil {
+		return nil, http.StatusBadRequest, utils.ArgumentError.Here().WithMessage("Invalid seating constraint")
+	}
+	constraint.EventID = eventID
+	if constraint.Kind != models.SeatingTogether && constraint.Kind != models.SeatingApart {
+		return nil, http.StatusBadRequest, utils.ArgumentError.Here().WithMessagef("kind must be %q or %q",
+			models.SeatingTogether, models.SeatingApart)
+	}
+	if len(constraint.GuestIDs)+len(constraint.InvitationIDs) < 2 {
+		return nil, http.StatusBadRequest, utils.ArgumentError.Here().WithMessage("A seating constraint needs at least two guests or invitations")
+	}
+
+	log.WithFields(log.Fields{
+		"event_id":       eventID,
+		"kind":           constraint.Kind,
+		"guest_ids":      constraint.GuestIDs,
+		"invitation_ids": constraint.InvitationIDs,
+	}).Info("Creating seating constraint")
+
+	createdConstraint, err := access.Run(r.Context(), handler.transactor, func(tx access.Tx) (interface{}, error) {
+		if _, err := handler.getEvent(tx, eventID); err != nil {
+			return nil, err
+		}
+		return handler.dao.CreateSeatingConstraint(tx, constraint)
+	})
+	if err != nil {
+		log.Error("Error creating seating constraint")
+		return nil, utils.StatusCode(err, http.StatusBadRequest), err
+	}
+	return utils.SerializeResponse(createdConstraint, http.StatusOK)
+}
+
+// DeleteSeatingConstraintHandler deletes a seating constraint
+func (handler *SeatingHandler) DeleteSeatingConstraintHandler(r *http.Request, vars map[string]string) ([]byte, int, error) {
+	id := utils.GetIDFromVars(vars)
+
+	log.WithFields(log.Fields{
+		"id": id,
+	}).Info("Deleting seating constraint")
+
+	version, err := utils.IfMatchVersion(r)
+	if err != nil {
+		return nil, utils.StatusCode(err, http.StatusBadRequest), err
+	}
+	_, err = access.Run(r.Context(), handler.transactor, func(tx access.Tx) (interface{}, error) {
+		return handler.dao.DeleteSeatingConstraint(tx, id, version)
+	})
+	if err != nil {
+		log.Error("Error deleting seating constraint")
+		return nil, utils.StatusCode(err, http.StatusBadRequest), err
+	}
+	return utils.SerializeResponse(nil, http.StatusOK)
+}
@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"github.com/kyrstenkelly/rsvp-api/db/access"
+	"github.com/kyrstenkelly/rsvp-api/db/models"
+	"github.com/kyrstenkelly/rsvp-api/trash"
+	"github.com/kyrstenkelly/rsvp-api/utils"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"time"
+)
+
+// TrashHandler type
+type TrashHandler struct {
+	transactor access.Transactor
+	dao        access.TrashAccess
+	purger     *trash.Purger
+}
+
+// NewTrashHandler creates a new handler with the given transactor, dao and purger
+func NewTrashHandler(transactor access.Transactor, dao access.TrashAccess, purger *trash.Purger) *TrashHandler {
+	return &TrashHandler{transactor: transactor, dao: dao, purger: purger}
+}
+
+// GetTrashHandler gets everything in the trash, most recently deleted first, with when it will be purged
+func (handler *TrashHandler) GetTrashHandler(r *http.Request, vars map[string]string) ([]byte, int, error) {
+	log.Info("Getting trash")
+
+	items, err := access.Run(r.Context(), handler.transactor, func(tx access.Tx) (interface{}, error) {
+		return handler.dao.GetTrash(tx)
+	})
+	if err != nil {
+		log.Error("Error getting trash")
+		return nil, http.StatusInternalServerError, err
+	}
+
+	trashItems := items.([]models.TrashItem)
+	for i := range trashItems {
+		trashItems[i].PurgeAt = trashItems[i].DeletedAt.Add(handler.purger.Retention())
+	}
+	return utils.SerializeResponse(trashItems, http.StatusOK)
+}
+
+// PurgeTrashHandler purges the trash now rather than waiting for the next scheduled purge. Only
+// what has been in it for longer than the retention period is removed.
+func (handler *TrashHandler) PurgeTrashHandler(r *http.Request, vars map[string]string) ([]byte, int, error) {
+	log.Info("Purging trash")
+
+	purged, err := handler.purger.Purge(r.Context(), time.Now())
+	if err != nil {
+		log.Error("Error purging trash")
+		return nil, http.StatusInternalServerError, err
+	}
+	return utils.SerializeResponse(purged, http.StatusOK)
+}
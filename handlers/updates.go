@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"encoding/json"
+	"github.com/kyrstenkelly/rsvp-api/utils"
+	"net/http"
+)
+
+// readReplacement builds the full replacement for a resource from the body of a PUT or PATCH.
+// A PUT body is the replacement as is, so fields it leaves out are cleared. A PATCH body is a
+// JSON Merge Patch applied to current, so fields it leaves out are kept and null fields are
+// cleared. Either way, giving an immutable field a new value is a 422.
+func readReplacement(r *http.Request, body []byte, current interface{}, immutable []string, replacement interface{}) error {
+	err := utils.CheckImmutable(body, current, immutable...)
+	if err != nil {
+		return err
+	}
+
+	document := body
+	if r.Method == http.MethodPatch {
+		currentDocument, err := json.Marshal(current)
+		if err != nil {
+			return utils.JSONMarshalingError.Here()
+		}
+		document, err = utils.MergePatch(currentDocument, body)
+		if err != nil {
+			return err
+		}
+	}
+	if err := json.Unmarshal(document, replacement); err != nil {
+		return utils.RequestBodyError.Here().WithMessagef("Invalid request body: %s", err)
+	}
+	return nil
+}
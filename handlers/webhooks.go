@@ -0,0 +1,245 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/kyrstenkelly/rsvp-api/db/access"
+	"github.com/kyrstenkelly/rsvp-api/db/models"
+	"github.com/kyrstenkelly/rsvp-api/utils"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+)
+
+// WebhooksHandler type
+type WebhooksHandler struct {
+	transactor access.Transactor
+	dao        access.WebhooksAccess
+}
+
+// NewWebhooksHandler creates a new handler with the given transactor and dao
+func NewWebhooksHandler(transactor access.Transactor, dao access.WebhooksAccess) *WebhooksHandler {
+	return &WebhooksHandler{transactor: transactor, dao: dao}
+}
+
+// webhookImmutableFields are the webhook fields PUT and PATCH may not change
+var webhookImmutableFields = []string{"id", "created_at"}
+
+// prepareWebhook checks a webhook has an http(s) url and subscribes to known events, and gives it
+// a new random secret if it has none
+func prepareWebhook(webhook *models.Webhook) error {
+	u, err := url.Parse(webhook.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return utils.ArgumentError.Here().WithMessage("url must be an absolute http or https url")
+	}
+	if len(webhook.Events) == 0 {
+		return utils.ArgumentError.Here().WithMessagef("events must list one or more of %v", models.WebhookEvents)
+	}
+	for _, event := range webhook.Events {
+		known := false
+		for _, webhookEvent := range models.WebhookEvents {
+			known = known || event == webhookEvent
+		}
+		if !known {
+			return utils.ArgumentError.Here().WithMessagef("Unknown event %q, expected one of %v", event, models.WebhookEvents)
+		}
+	}
+	if webhook.Secret == "" {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return err
+		}
+		webhook.Secret = hex.EncodeToString(secret)
+	}
+	return nil
+}
+
+// GetWebhooksHandler gets a list of all webhooks
+func (handler *WebhooksHandler) GetWebhooksHandler(r *http.Request, vars map[string]string) ([]byte, int, error) {
+	log.Info("Getting all webhooks")
+
+	webhooks, err := access.Run(r.Context(), handler.transactor, func(tx access.Tx) (interface{}, error) {
+		return handler.dao.GetWebhooks(tx)
+	})
+	if err != nil {
+		log.Error("Error getting webhooks")
+		return nil, http.StatusInternalServerError, err
+	}
+	return utils.SerializeResponse(webhooks, http.StatusOK)
+}
+
+// GetWebhookHandler gets a webhook by id
+func (handler *WebhooksHandler) GetWebhookHandler(r *http.Request, vars map[string]string) ([]byte, int, error) {
+	id := utils.GetIDFromVars(vars)
+
+	log.WithFields(log.Fields{
+		"id": id,
+	}).Info("Getting webhook by ID")
+
+	webhook, err := access.Run(r.Context(), handler.transactor, func(tx access.Tx) (interface{}, error) {
+		return handler.dao.GetWebhook(tx, id)
+	})
+	if err != nil {
+		log.Error("Error getting webhook")
+		return nil, http.StatusInternalServerError, err
+	}
+	if webhook.(*models.Webhook) == nil {
+		return nil, http.StatusNotFound, utils.HTTPNotFoundError.Here()
+	}
+	return utils.SerializeVersioned(r, webhook, webhook.(*models.Webhook).Version)
+}
+
+// CreateWebhookHandler handles creating a webhook. It is active unless the body says otherwise.
+func (handler *WebhooksHandler) CreateWebhookHandler(r *http.Request, vars map[string]string) ([]byte, int, error) {
+	webhook := &models.Webhook{Active: true}
+	if err := json.NewDecoder(r.Body).Decode(webhook); err != nil {
+		return nil, http.StatusBadRequest, utils.ArgumentError.Here().WithMessage("Invalid webhook")
+	}
+	if err := prepareWebhook(webhook); err != nil {
+		return nil, utils.StatusCode(err, http.StatusInternalServerError), err
+	}
+
+	log.WithFields(log.Fields{
+		"url":    webhook.URL,
+		"events": webhook.Events,
+	}).Info("Creating webhook")
+
+	createdWebhook, err := access.Run(r.Context(), handler.transactor, func(tx access.Tx) (interface{}, error) {
+		return handler.dao.CreateWebhook(tx, webhook)
+	})
+	if err != nil {
+		log.Error("Error creating webhook")
+		return nil, utils.StatusCode(err, http.StatusBadRequest), err
+	}
+	return utils.SerializeResponse(createdWebhook, http.StatusOK)
+}
+
+// UpdateWebhookHandler replaces a webhook with the body of a PUT, or merges the JSON Merge Patch in the body of a PATCH into it
+func (handler *WebhooksHandler) UpdateWebhookHandler(r *http.Request, vars map[string]string) ([]byte, int, error) {
+	id := utils.GetIDFromVars(vars)
+	version, err := utils.IfMatchVersion(r)
+	if err != nil {
+		return nil, utils.StatusCode(err, http.StatusBadRequest), err
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	log.WithFields(log.Fields{
+		"id":     id,
+		"method": r.Method,
+	}).Info("Updating webhook")
+
+	updatedWebhook, err := access.Run(r.Context(), handler.transactor, func(tx access.Tx) (interface{}, error) {
+		current, err := handler.dao.GetWebhook(tx, id)
+		if err != nil {
+			return nil, err
+		}
+		if current == nil {
+			return nil, utils.HTTPNotFoundError.Here()
+		}
+		webhook := new(models.Webhook)
+		if err := readReplacement(r, body, current, webhookImmutableFields, webhook); err != nil {
+			return nil, err
+		}
+		if err := prepareWebhook(webhook); err != nil {
+			return nil, err
+		}
+		webhook.ID = id
+		webhook.Version = version
+		return handler.dao.UpdateWebhook(tx, webhook)
+	})
+	if err != nil {
+		log.Error("Error updating webhook")
+		return nil, utils.StatusCode(err, http.StatusBadRequest), err
+	}
+	return utils.SerializeVersioned(r, updatedWebhook, updatedWebhook.(*models.Webhook).Version)
+}
+
+// DeleteWebhookHandler deletes a webhook along with its deliveries
+func (handler *WebhooksHandler) DeleteWebhookHandler(r *http.Request, vars map[string]string) ([]byte, int, error) {
+	id := utils.GetIDFromVars(vars)
+
+	log.WithFields(log.Fields{
+		"id": id,
+	}).Info("Deleting webhook")
+
+	version, err := utils.IfMatchVersion(r)
+	if err != nil {
+		return nil, utils.StatusCode(err, http.StatusBadRequest), err
+	}
+	_, err = access.Run(r.Context(), handler.transactor, func(tx access.Tx) (interface{}, error) {
+		return handler.dao.DeleteWebhook(tx, id, version)
+	})
+	if err != nil {
+		log.Error("Error deleting webhook")
+		return nil, utils.StatusCode(err, http.StatusBadRequest), err
+	}
+	return utils.SerializeResponse(nil, http.StatusOK)
+}
+
+// GetWebhookDeliveriesHandler gets a page of the deliveries queued for the webhook with the id in
+// the path, oldest first
+func (handler *WebhooksHandler) GetWebhookDeliveriesHandler(r *http.Request, vars map[string]string) ([]byte, int, error) {
+	log.WithFields(log.Fields{
+		"id": vars["id"],
+	}).Info("Getting webhook deliveries")
+
+	r = r.Clone(r.Context())
+	params := r.URL.Query()
+	params.Set("webhook_id", vars["id"])
+	r.URL.RawQuery = params.Encode()
+	return serveList(r, handler.transactor, access.WebhookDeliveriesList, func(tx access.Tx, query access.ListQuery) (interface{}, int, error) {
+		deliveries, total, err := handler.dao.GetWebhookDeliveries(tx, query)
+		return deliveries, total, err
+	})
+}
+
+// ReplayWebhookDeliveryHandler queues a delivery to be sent again, whether it was delivered or is dead
+func (handler *WebhooksHandler) ReplayWebhookDeliveryHandler(r *http.Request, vars map[string]string) ([]byte, int, error) {
+	id := utils.GetIDFromVars(vars)
+
+	log.WithFields(log.Fields{
+		"id": id,
+	}).Info("Replaying webhook delivery")
+
+	delivery, err := access.Run(r.Context(), handler.transactor, func(tx access.Tx) (interface{}, error) {
+		return handler.dao.ReplayWebhookDelivery(tx, id)
+	})
+	if err != nil {
+		log.Error("Error replaying webhook delivery")
+		return nil, utils.StatusCode(err, http.StatusInternalServerError), err
+	}
+	if delivery.(*models.WebhookDelivery) == nil {
+		return nil, http.StatusNotFound, utils.HTTPNotFoundError.Here()
+	}
+	return utils.SerializeResponse(delivery, http.StatusOK)
+}
+
+// ReplayDeadWebhookDeliveriesHandler queues every dead delivery of a webhook to be sent again
+func (handler *WebhooksHandler) ReplayDeadWebhookDeliveriesHandler(r *http.Request, vars map[string]string) ([]byte, int, error) {
+	id := utils.GetIDFromVars(vars)
+
+	log.WithFields(log.Fields{
+		"id": id,
+	}).Info("Replaying dead webhook deliveries")
+
+	replayed, err := access.Run(r.Context(), handler.transactor, func(tx access.Tx) (interface{}, error) {
+		webhook, err := handler.dao.GetWebhook(tx, id)
+		if err != nil {
+			return nil, err
+		}
+		if webhook == nil {
+			return nil, utils.HTTPNotFoundError.Here()
+		}
+		return handler.dao.ReplayDeadWebhookDeliveries(tx, id)
+	})
+	if err != nil {
+		log.Error("Error replaying dead webhook deliveries")
+		return nil, utils.StatusCode(err, http.StatusInternalServerError), err
+	}
+	return utils.SerializeResponse(map[string]int{"replayed": replayed.(int)}, http.StatusOK)
+}
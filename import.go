@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/kyrstenkelly/rsvp-api/db"
+	"github.com/kyrstenkelly/rsvp-api/db/access"
+	"github.com/kyrstenkelly/rsvp-api/importer"
+	"os"
+)
+
+// runImport handles `rsvp-api import --org <slug> --event <id>[,<id>...] [--dry-run] <file>`
+func runImport(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	slug := flags.String("org", "default", "slug of the organization the event belongs to")
+	events := flags.String("event", "", "comma separated IDs of the events the invitations are for")
+	dryRun := flags.Bool("dry-run", false, "validate the file and report errors without creating anything")
+	flags.Parse(args)
+
+	if *events == "" || flags.NArg() != 1 {
+		return fmt.Errorf("usage: rsvp-api import [--org <slug>] --event <id>[,<id>...] [--dry-run] <file>")
+	}
+	eventIDs, err := importer.ParseEventIDs(*events)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	err = db.InitDb()
+	if err != nil {
+		return err
+	}
+
+	store := access.NewPostgresStore(db.GetDBConn())
+	ctx, err := organizationContext(store.Transactor, *slug)
+	if err != nil {
+		return err
+	}
+
+	imp := importer.NewImporter(store.Invitations, store.Events)
+	result, err := imp.ImportInTransaction(access.WithActor(ctx, "import"), store.Transactor, file, eventIDs, *dryRun)
+	if err != nil {
+		return err
+	}
+
+	out, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(out))
+
+	if len(result.Errors) > 0 {
+		return fmt.Errorf("%d errors, nothing was imported", len(result.Errors))
+	}
+	return nil
+}
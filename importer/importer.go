@@ -0,0 +1,332 @@
+package importer
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"github.com/kyrstenkelly/rsvp-api/db/access"
+	"github.com/kyrstenkelly/rsvp-api/db/models"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Columns are the headers an import file must have, in any order.
+// Guests are separated by semicolons within their column.
+var Columns = []string{"name", "email", "line1", "line2", "city", "state", "zip", "guests", "plus_one"}
+
+// errNotCommitted unwinds the import transaction for dry runs and files with errors
+var errNotCommitted = errors.New("Import not committed")
+
+// Row is one household from an import file
+type Row struct {
+	Line       int
+	Invitation models.Invitation
+}
+
+// RowError is a validation or database error for one line of an import file
+type RowError struct {
+	Line    int    `json:"line"`
+	Email   string `json:"email,omitempty"`
+	Message string `json:"message"`
+}
+
+// Result summarizes an import
+type Result struct {
+	DryRun  bool       `json:"dry_run"`
+	Rows    int        `json:"rows"`
+	Created int        `json:"created"`
+	Errors  []RowError `json:"errors"`
+}
+
+// Committed reports whether the import's invitations were saved
+func (r *Result) Committed() bool {
+	return !r.DryRun && len(r.Errors) == 0
+}
+
+// Importer creates invitations, guests and addresses from a csv or tsv file
+type Importer struct {
+	invitationAccess access.InvitationsAccess
+	eventAccess      access.EventsAccess
+}
+
+// NewImporter creates a new importer with the given daos
+func NewImporter(invitationAccess access.InvitationsAccess, eventAccess access.EventsAccess) *Importer {
+	return &Importer{
+		invitationAccess: invitationAccess,
+		eventAccess:      eventAccess,
+	}
+}
+
+// ParseEventIDs reads the comma separated ids of the events imported invitations cover
+func ParseEventIDs(value string) ([]int64, error) {
+	var eventIDs []int64
+	for _, field := range strings.Split(value, ",") {
+		eventID, err := strconv.ParseInt(strings.TrimSpace(field), 10, 64)
+		if err != nil || eventID <= 0 {
+			return nil, fmt.Errorf("Event ids must be comma separated numbers, got %q", value)
+		}
+		eventIDs = append(eventIDs, eventID)
+	}
+	return eventIDs, nil
+}
+
+// Parse reads households from a csv or tsv file, picking the delimiter from the header line.
+// Rows that can't be parsed are returned as errors rather than stopping the parse. Every
+// household is invited to each of the events.
+func Parse(reader io.Reader, eventIDs []int64) ([]Row, []RowError) {
+	buffered := bufio.NewReader(reader)
+	header, err := buffered.Peek(1024)
+	if err != nil && err != io.EOF {
+		return nil, []RowError{{Line: 1, Message: err.Error()}}
+	}
+
+	csvReader := csv.NewReader(buffered)
+	if strings.Contains(strings.SplitN(string(header), "\n", 2)[0], "\t") {
+		csvReader.Comma = '\t'
+	}
+	csvReader.FieldsPerRecord = -1
+	csvReader.TrimLeadingSpace = true
+
+	records, err := csvReader.ReadAll()
+	if err != nil {
+		return nil, []RowError{{Message: err.Error()}}
+	}
+	if len(records) == 0 {
+		return nil, []RowError{{Line: 1, Message: "File is empty"}}
+	}
+
+	columns := map[string]int{}
+	for i, name := range records[0] {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	var missing []string
+	for _, name := range Columns {
+		if _, ok := columns[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, []RowError{{Line: 1, Message: "Missing columns: " + strings.Join(missing, ", ")}}
+	}
+
+	var rows []Row
+	var errs []RowError
+	for i, record := range records[1:] {
+		line := i + 2
+		value := func(name string) string {
+			index := columns[name]
+			if index >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[index])
+		}
+
+		row, rowErr := buildRow(line, eventIDs, value)
+		if rowErr != nil {
+			errs = append(errs, *rowErr)
+			continue
+		}
+		rows = append(rows, row)
+	}
+	return rows, errs
+}
+
+func buildRow(line int, eventIDs []int64, value func(string) string) (Row, *RowError) {
+	email := value("email")
+	rowError := func(message string) *RowError {
+		return &RowError{Line: line, Email: email, Message: message}
+	}
+
+	var guests []models.Guest
+	for _, name := range strings.Split(value("guests"), ";") {
+		name = strings.TrimSpace(name)
+		if name != "" {
+			guests = append(guests, models.Guest{Name: name})
+		}
+	}
+
+	plusOnes, ok := parsePlusOnes(value("plus_one"))
+	if !ok {
+		return Row{}, rowError(fmt.Sprintf("plus_one must be yes, no or how many plus ones, got %q", value("plus_one")))
+	}
+
+	var events []models.InvitationEvent
+	for _, eventID := range eventIDs {
+		events = append(events, models.InvitationEvent{EventID: eventID})
+	}
+
+	invitation := models.Invitation{
+		Name:     value("name"),
+		Email:    email,
+		PlusOnes: plusOnes,
+		Events:   events,
+		Guests:   &guests,
+		Address: &models.Address{
+			Line1: value("line1"),
+			Line2: value("line2"),
+			City:  value("city"),
+			State: value("state"),
+			Zip:   value("zip"),
+		},
+	}
+
+	var missing []string
+	for _, field := range []struct {
+		name  string
+		value string
+	}{
+		{"name", invitation.Name},
+		{"email", invitation.Email},
+		{"line1", invitation.Address.Line1},
+		{"city", invitation.Address.City},
+		{"state", invitation.Address.State},
+		{"zip", invitation.Address.Zip},
+	} {
+		if field.value == "" {
+			missing = append(missing, field.name)
+		}
+	}
+	if len(guests) == 0 {
+		missing = append(missing, "guests")
+	}
+	if len(missing) > 0 {
+		return Row{}, rowError("Missing " + strings.Join(missing, ", "))
+	}
+	if !strings.Contains(email, "@") {
+		return Row{}, rowError("Email is not valid")
+	}
+
+	return Row{Line: line, Invitation: invitation}, nil
+}
+
+func parseFlag(value string) (bool, bool) {
+	switch strings.ToLower(value) {
+	case "", "no", "n", "false", "0":
+		return false, true
+	case "yes", "y", "true", "1", "x":
+		return true, true
+	}
+	return false, false
+}
+
+// parsePlusOnes reads how many plus ones a row allows, either as a yes or no for one or as a count
+func parsePlusOnes(value string) (int, bool) {
+	if plusOne, ok := parseFlag(value); ok {
+		if plusOne {
+			return 1, true
+		}
+		return 0, true
+	}
+	plusOnes, err := strconv.Atoi(strings.TrimSpace(value))
+	return plusOnes, err == nil && plusOnes >= 0
+}
+
+// Validate checks the parsed rows against each other and the database, reporting
+// duplicate emails within the file and households that have already been invited.
+// Emails are compared ignoring case in both.
+func (i *Importer) Validate(tx access.Tx, eventIDs []int64, rows []Row) ([]RowError, error) {
+	for _, eventID := range eventIDs {
+		event, err := i.eventAccess.GetEvent(tx, eventID)
+		if err != nil {
+			return nil, err
+		}
+		if event == nil {
+			return []RowError{{Message: fmt.Sprintf("Event %d does not exist", eventID)}}, nil
+		}
+	}
+
+	var errs []RowError
+	seen := map[string]int{}
+	for _, row := range rows {
+		email := strings.ToLower(row.Invitation.Email)
+		if firstLine, ok := seen[email]; ok {
+			errs = append(errs, RowError{
+				Line:    row.Line,
+				Email:   row.Invitation.Email,
+				Message: fmt.Sprintf("Duplicate of line %d", firstLine),
+			})
+			continue
+		}
+		seen[email] = row.Line
+
+		existing, err := i.invitationAccess.GetInvitationByEmail(tx, row.Invitation.Email)
+		if err != nil {
+			return nil, err
+		}
+		if existing != nil {
+			errs = append(errs, RowError{
+				Line:    row.Line,
+				Email:   row.Invitation.Email,
+				Message: fmt.Sprintf("Already invited as %q", existing.Name),
+			})
+		}
+	}
+	return errs, nil
+}
+
+// Import parses and validates a file, then creates every invitation within the transaction.
+// Nothing is created when dryRun is set or any row has an error, in which case the caller
+// must roll back the transaction; see ImportInTransaction.
+func (i *Importer) Import(tx access.Tx, reader io.Reader, eventIDs []int64, dryRun bool) (*Result, error) {
+	rows, errs := Parse(reader, eventIDs)
+	result := &Result{
+		DryRun: dryRun,
+		Rows:   len(rows) + len(errs),
+		Errors: errs,
+	}
+
+	validationErrs, err := i.Validate(tx, eventIDs, rows)
+	if err != nil {
+		return nil, err
+	}
+	result.Errors = append(result.Errors, validationErrs...)
+	if result.Errors == nil {
+		result.Errors = []RowError{}
+	}
+	if len(result.Errors) > 0 || dryRun {
+		return result, nil
+	}
+
+	for _, row := range rows {
+		invitation := row.Invitation
+		_, err := i.invitationAccess.CreateInvitation(tx, &invitation)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"line":  row.Line,
+				"email": invitation.Email,
+			}).Error("Unable to import invitation")
+			result.Errors = append(result.Errors, RowError{
+				Line:    row.Line,
+				Email:   invitation.Email,
+				Message: err.Error(),
+			})
+			// The transaction is aborted after a failed statement, so stop here
+			result.Created = 0
+			return result, nil
+		}
+		result.Created++
+	}
+	return result, nil
+}
+
+// ImportInTransaction runs Import in its own unit of work, which is only committed
+// when every row was created and this isn't a dry run
+func (i *Importer) ImportInTransaction(ctx context.Context, transactor access.Transactor, reader io.Reader,
+	eventIDs []int64, dryRun bool) (*Result, error) {
+	var result *Result
+	err := transactor.RunInTransaction(ctx, func(tx access.Tx) (err error) {
+		result, err = i.Import(tx, reader, eventIDs, dryRun)
+		if err == nil && !result.Committed() {
+			return errNotCommitted
+		}
+		return err
+	})
+	if err == errNotCommitted {
+		return result, nil
+	}
+	return result, err
+}
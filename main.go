@@ -1,18 +1,58 @@
 package main
 
 import (
+	"context"
+	"flag"
 	_ "github.com/joho/godotenv/autoload"
 	"github.com/kyrstenkelly/rsvp-api/api"
 	"github.com/kyrstenkelly/rsvp-api/db"
+	"github.com/kyrstenkelly/rsvp-api/db/access"
 	log "github.com/sirupsen/logrus"
+	"net/http"
+	"os"
+	"strings"
 )
 
 func main() {
 	log.SetFormatter(&log.TextFormatter{})
 	log.SetLevel(log.DebugLevel)
-	err := db.InitDb()
-	if err != nil {
-		log.Fatal(err)
+
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		var err error
+		switch os.Args[1] {
+		case "migrate":
+			err = db.Migrate(os.Args[2:]...)
+		case "import":
+			err = runImport(os.Args[2:])
+		case "organizations":
+			err = runOrganizations(os.Args[2:])
+		default:
+			log.Fatalf("Unknown command %q, expected migrate, import or organizations", os.Args[1])
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
 	}
-	api.Serve(8000)
+
+	storeName := flag.String("store", "postgres", "where to keep data: postgres, or memory to run without a database")
+	flag.Parse()
+
+	var store *access.Store
+	switch *storeName {
+	case "postgres":
+		err := db.InitDb()
+		if err != nil {
+			log.Fatal(err)
+		}
+		store = access.NewPostgresStore(db.GetDBConn())
+	case "memory":
+		log.Warn("Keeping data in memory, it will be lost when the server stops")
+		store = access.NewMemoryStore()
+	default:
+		log.Fatalf("Unknown store %q, expected postgres or memory", *storeName)
+	}
+
+	log.Info("Serving on :8000")
+	log.Fatal(http.ListenAndServe(":8000", api.Serve(context.Background(), store)))
 }
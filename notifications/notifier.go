@@ -0,0 +1,352 @@
+package notifications
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/kelseyhightower/envconfig"
+	"github.com/kyrstenkelly/rsvp-api/db/access"
+	"github.com/kyrstenkelly/rsvp-api/db/models"
+	"github.com/kyrstenkelly/rsvp-api/utils"
+	log "github.com/sirupsen/logrus"
+	"strings"
+	"time"
+)
+
+// Config holds the email configuration
+type Config struct {
+	Transport string   `envconfig:"MAIL_TRANSPORT" default:"none"`
+	From      string   `envconfig:"MAIL_FROM"`
+	AdminTo   []string `envconfig:"MAIL_ADMIN_TO"`
+	RSVPURL   string   `envconfig:"MAIL_RSVP_URL" default:"http://localhost:8000/rsvp/"`
+	Dir       string   `envconfig:"MAIL_DIR" default:"mail"`
+	SMTPHost  string   `envconfig:"SMTP_HOST"`
+	SMTPPort  string   `envconfig:"SMTP_PORT" default:"587"`
+	SMTPUser  string   `envconfig:"SMTP_USER"`
+	SMTPPass  string   `envconfig:"SMTP_PASS"`
+
+	ReminderInterval time.Duration `envconfig:"REMINDER_INTERVAL" default:"1h"`
+}
+
+// GetConfig loads the config object from env vars and returns it
+func GetConfig() (*Config, error) {
+	var config Config
+
+	if err := envconfig.Process("", &config); err != nil {
+		return nil, err
+	}
+
+	return &config, nil
+}
+
+// NewMailer builds the mailer for the configured transport: smtp, file or memory.
+// It returns nil when email is turned off.
+func NewMailer(config *Config) (Mailer, error) {
+	switch config.Transport {
+	case "none", "":
+		return nil, nil
+	case "smtp":
+		return NewSMTPMailer(config.SMTPHost, config.SMTPPort, config.SMTPUser, config.SMTPPass, config.From), nil
+	case "file":
+		return NewFileMailer(config.Dir, config.From), nil
+	case "memory":
+		return NewMemoryMailer(), nil
+	}
+	return nil, fmt.Errorf("unknown MAIL_TRANSPORT %q, expected none, smtp, file or memory", config.Transport)
+}
+
+// Notifier renders and sends the emails for invitations and RSVPs, recording each one so
+// that a retried send never reaches the guest twice. A nil Notifier sends nothing.
+type Notifier struct {
+	mailer             Mailer
+	config             Config
+	transactor         access.Transactor
+	invitationAccess   access.InvitationsAccess
+	eventAccess        access.EventsAccess
+	rsvpAccess         access.RSVPsAccess
+	notificationAccess access.NotificationsAccess
+}
+
+// NewNotifier creates a new notifier with the given mailer, transactor and daos
+func NewNotifier(mailer Mailer, config Config, transactor access.Transactor, invitationAccess access.InvitationsAccess, eventAccess access.EventsAccess,
+	rsvpAccess access.RSVPsAccess, notificationAccess access.NotificationsAccess) *Notifier {
+	return &Notifier{
+		mailer:             mailer,
+		config:             config,
+		transactor:         transactor,
+		invitationAccess:   invitationAccess,
+		eventAccess:        eventAccess,
+		rsvpAccess:         rsvpAccess,
+		notificationAccess: notificationAccess,
+	}
+}
+
+// NewNotifierFromEnv creates a notifier from the MAIL_ and SMTP_ env vars, or returns nil if
+// email is turned off or misconfigured
+func NewNotifierFromEnv(transactor access.Transactor, invitationAccess access.InvitationsAccess, eventAccess access.EventsAccess,
+	rsvpAccess access.RSVPsAccess, notificationAccess access.NotificationsAccess) *Notifier {
+	config, err := GetConfig()
+	if err != nil {
+		log.Error("Unable to configure email")
+		return nil
+	}
+	mailer, err := NewMailer(config)
+	if err != nil {
+		log.Error(err)
+		return nil
+	}
+	if mailer == nil {
+		log.Info("Email is turned off")
+		return nil
+	}
+	return NewNotifier(mailer, *config, transactor, invitationAccess, eventAccess, rsvpAccess, notificationAccess)
+}
+
+// Enabled reports whether the notifier will send anything
+func (n *Notifier) Enabled() bool {
+	return n != nil
+}
+
+// RSVPURL is the link a guest follows to respond to their invitation
+func (n *Notifier) RSVPURL(invitation *models.Invitation) string {
+	return n.config.RSVPURL + invitation.RSVPCode
+}
+
+// SendInvitation emails an invitation with its RSVP link. Sending the same invitation again
+// is a no-op unless resend is set.
+func (n *Notifier) SendInvitation(ctx context.Context, invitationID int64, resend bool) (*models.Notification, error) {
+	if n == nil {
+		return nil, utils.HTTPServiceUnavailableError.Here().WithMessage("Email is not configured")
+	}
+
+	data, err := n.loadTemplateData(ctx, invitationID, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	if data.Invitation.Email == "" {
+		return nil, utils.ArgumentError.Here().WithMessage("Invitation has no email address")
+	}
+	msg, err := invitationTemplate.render([]string{data.Invitation.Email}, data)
+	if err != nil {
+		return nil, err
+	}
+
+	key := fmt.Sprintf("%s:%d", models.NotificationInvitation, invitationID)
+	if resend {
+		key = fmt.Sprintf("%s:%d", key, time.Now().UnixNano())
+	}
+	return n.send(ctx, models.NotificationInvitation, contentKey(key, msg), invitationID, msg)
+}
+
+// RSVPSubmitted sends the guest a confirmation and the admins an alert for a new or updated rsvp.
+// It only logs failures, so it can be run in the background once the rsvp has been saved, with
+// a context from access.BackgroundContext.
+func (n *Notifier) RSVPSubmitted(ctx context.Context, rsvpID int64) {
+	if n == nil {
+		return
+	}
+	logger := log.WithFields(log.Fields{
+		"rsvp_id": rsvpID,
+	})
+
+	rsvp, err := access.Run(ctx, n.transactor, func(tx access.Tx) (interface{}, error) {
+		return n.rsvpAccess.GetRSVP(tx, rsvpID)
+	})
+	if err != nil || rsvp.(*models.RSVP) == nil {
+		logger.Error("Unable to load rsvp for notifications")
+		return
+	}
+	data, err := n.loadTemplateData(ctx, rsvp.(*models.RSVP).InvitationID, 0, rsvpID)
+	if err != nil {
+		logger.Error("Unable to load invitation for notifications")
+		return
+	}
+
+	if data.Invitation.Email != "" {
+		msg, err := rsvpConfirmationTemplate.render([]string{data.Invitation.Email}, data)
+		if err == nil {
+			_, err = n.send(ctx, models.NotificationRSVPConfirmation,
+				contentKey(fmt.Sprintf("%s:%d", models.NotificationRSVPConfirmation, rsvpID), msg), data.Invitation.ID, msg)
+		}
+		if err != nil {
+			logger.WithFields(log.Fields{
+				"error": err,
+			}).Error("Unable to send rsvp confirmation")
+		}
+	}
+
+	if len(n.config.AdminTo) > 0 {
+		msg, err := adminAlertTemplate.render(n.config.AdminTo, data)
+		if err == nil {
+			_, err = n.send(ctx, models.NotificationAdminAlert,
+				contentKey(fmt.Sprintf("%s:%d", models.NotificationAdminAlert, rsvpID), msg), data.Invitation.ID, msg)
+		}
+		if err != nil {
+			logger.WithFields(log.Fields{
+				"error": err,
+			}).Error("Unable to send admin alert")
+		}
+	}
+}
+
+// RetryFailed resends up to limit notifications that failed, returning how many were sent
+func (n *Notifier) RetryFailed(ctx context.Context, limit int) (int, error) {
+	if n == nil {
+		return 0, utils.HTTPServiceUnavailableError.Here().WithMessage("Email is not configured")
+	}
+
+	claimed, err := access.Run(ctx, n.transactor, func(tx access.Tx) (interface{}, error) {
+		return n.notificationAccess.ClaimRetryableNotifications(tx, limit)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, notification := range claimed.([]models.Notification) {
+		notification := notification
+		if err := n.deliver(ctx, &notification); err == nil {
+			sent++
+		}
+	}
+	return sent, nil
+}
+
+// SendReminder emails an invitation that hasn't responded to the campaign's event a reminder. Each
+// campaign reminds an invitation at most once per offset, however many instances try to send it.
+func (n *Notifier) SendReminder(ctx context.Context, campaign *models.ReminderCampaign, invitationID int64, offsetDays int) (*models.Notification, error) {
+	if n == nil {
+		return nil, utils.HTTPServiceUnavailableError.Here().WithMessage("Email is not configured")
+	}
+
+	data, err := n.loadTemplateData(ctx, invitationID, campaign.EventID, 0)
+	if err != nil {
+		return nil, err
+	}
+	if data.Invitation.Email == "" {
+		return nil, utils.ArgumentError.Here().WithMessage("Invitation has no email address")
+	}
+	data.DaysLeft = offsetDays
+	msg, err := reminderTemplate.render([]string{data.Invitation.Email}, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return n.send(ctx, models.NotificationReminder, models.ReminderKey(campaign.ID, invitationID, offsetDays), invitationID, msg)
+}
+
+// loadTemplateData gets the invitation, its events and, if rsvpID is set, the rsvp for an email. The
+// email is about eventID, or the invitation's first event if it is 0.
+func (n *Notifier) loadTemplateData(ctx context.Context, invitationID int64, eventID int64, rsvpID int64) (*templateData, error) {
+	data, err := access.Run(ctx, n.transactor, func(tx access.Tx) (interface{}, error) {
+		invitation, err := n.invitationAccess.GetInvitation(tx, invitationID)
+		if err != nil {
+			return nil, err
+		}
+		if invitation == nil {
+			return nil, utils.HTTPNotFoundError.Here()
+		}
+		data := &templateData{
+			Invitation: invitation,
+			RSVPURL:    n.RSVPURL(invitation),
+		}
+		for _, invitationEvent := range invitation.Events {
+			if invitationEvent.Event != nil {
+				data.Events = append(data.Events, *invitationEvent.Event)
+			}
+		}
+		if eventID == 0 && len(invitation.Events) > 0 {
+			eventID = invitation.Events[0].EventID
+		}
+		event, err := n.eventAccess.GetEvent(tx, eventID)
+		if err != nil {
+			return nil, err
+		}
+		data.Event = event
+		if rsvpID > 0 {
+			data.RSVP, err = n.rsvpAccess.GetRSVP(tx, rsvpID)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return data, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return data.(*templateData), nil
+}
+
+// contentKey adds a hash of the message to an idempotency key, so that an rsvp is confirmed
+// again when it changes but not when it is saved unchanged
+func contentKey(key string, msg Message) string {
+	hash := sha256.Sum256([]byte(strings.Join(msg.To, ",") + "\n" + msg.Subject + "\n" + msg.Text))
+	return key + ":" + hex.EncodeToString(hash[:8])
+}
+
+// send claims the notification under its idempotency key, then delivers it if nobody else has
+func (n *Notifier) send(ctx context.Context, kind string, key string, invitationID int64, msg Message) (*models.Notification, error) {
+	notification := &models.Notification{
+		Kind:           kind,
+		IdempotencyKey: key,
+		InvitationID:   &invitationID,
+		Recipients:     msg.To,
+		Subject:        msg.Subject,
+		TextBody:       msg.Text,
+		HTMLBody:       msg.HTML,
+	}
+
+	claimed, err := access.Run(ctx, n.transactor, func(tx access.Tx) (interface{}, error) {
+		return n.notificationAccess.ClaimNotification(tx, notification)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if claimed.(*models.Notification) == nil {
+		log.WithFields(log.Fields{
+			"key": notification.IdempotencyKey,
+		}).Info("Notification already sent, skipping")
+		return nil, nil
+	}
+
+	notification = claimed.(*models.Notification)
+	err = n.deliver(ctx, notification)
+	return notification, err
+}
+
+// deliver sends a claimed notification and records whether it went out
+func (n *Notifier) deliver(ctx context.Context, notification *models.Notification) error {
+	sendErr := n.mailer.Send(Message{
+		To:      notification.Recipients,
+		Subject: notification.Subject,
+		Text:    notification.TextBody,
+		HTML:    notification.HTMLBody,
+	})
+
+	_, err := access.Run(ctx, n.transactor, func(tx access.Tx) (interface{}, error) {
+		if sendErr != nil {
+			return nil, n.notificationAccess.MarkNotificationFailed(tx, notification.ID, sendErr.Error())
+		}
+		return nil, n.notificationAccess.MarkNotificationSent(tx, notification.ID)
+	})
+	if err != nil {
+		log.WithFields(log.Fields{
+			"id":    notification.ID,
+			"error": err,
+		}).Error("Unable to record notification status")
+	}
+
+	if sendErr != nil {
+		notification.Status = models.NotificationFailed
+		notification.Error = sendErr.Error()
+		log.WithFields(log.Fields{
+			"id":    notification.ID,
+			"kind":  notification.Kind,
+			"error": sendErr,
+		}).Error("Unable to send notification")
+		return sendErr
+	}
+	notification.Status = models.NotificationSent
+	return nil
+}
@@ -0,0 +1,172 @@
+package notifications
+
+import (
+	"context"
+	"github.com/kyrstenkelly/rsvp-api/db/access"
+	"github.com/kyrstenkelly/rsvp-api/db/models"
+	"github.com/kyrstenkelly/rsvp-api/utils"
+	log "github.com/sirupsen/logrus"
+	"math"
+	"sort"
+	"time"
+)
+
+// nextReminder works out which of a campaign's offsets to send at now. When one or more offsets
+// have passed it returns the latest of them, so a campaign created or restarted late sends one
+// reminder rather than catching up on every missed one. Otherwise it returns the next offset
+// and when it falls due. It returns false once the deadline has passed or no offsets are left.
+func nextReminder(offsetDays []int, deadline time.Time, now time.Time) (int, time.Time, bool) {
+	if !now.Before(deadline) {
+		return 0, time.Time{}, false
+	}
+	daysLeft := int(math.Ceil(deadline.Sub(now).Hours() / 24))
+
+	offsets := append([]int(nil), offsetDays...)
+	sort.Ints(offsets)
+	for _, offset := range offsets {
+		if offset >= daysLeft {
+			return offset, deadline.AddDate(0, 0, -offset), true
+		}
+	}
+	for i := len(offsets) - 1; i >= 0; i-- {
+		if offsets[i] > 0 && offsets[i] < daysLeft {
+			return offsets[i], deadline.AddDate(0, 0, -offsets[i]), true
+		}
+	}
+	return 0, time.Time{}, false
+}
+
+// ReminderScheduler periodically emails the invitations that haven't responded to an event,
+// following each of its reminder campaigns. Everything it needs is in the database, so it
+// picks up where it left off after a restart, and the notifications' idempotency keys stop
+// two instances from sending the same reminder.
+type ReminderScheduler struct {
+	transactor     access.Transactor
+	notifier       *Notifier
+	campaignAccess access.ReminderCampaignsAccess
+	eventAccess    access.EventsAccess
+}
+
+// NewReminderScheduler creates a new scheduler with the given transactor, notifier and daos
+func NewReminderScheduler(transactor access.Transactor, notifier *Notifier, campaignAccess access.ReminderCampaignsAccess,
+	eventAccess access.EventsAccess) *ReminderScheduler {
+	return &ReminderScheduler{
+		transactor:     transactor,
+		notifier:       notifier,
+		campaignAccess: campaignAccess,
+		eventAccess:    eventAccess,
+	}
+}
+
+// Start runs the scheduler in the background every REMINDER_INTERVAL until ctx is done. It does nothing if
+// email is turned off.
+func (s *ReminderScheduler) Start(ctx context.Context) {
+	if !s.notifier.Enabled() {
+		log.Info("Email is turned off, not scheduling reminders")
+		return
+	}
+
+	interval := s.notifier.config.ReminderInterval
+	log.WithFields(log.Fields{
+		"interval": interval,
+	}).Info("Scheduling reminders")
+
+	go func() {
+		for {
+			if _, err := s.RunOnce(ctx, time.Now()); err != nil && ctx.Err() == nil {
+				log.WithFields(log.Fields{
+					"error": err,
+				}).Error("Unable to send reminders")
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(interval):
+			}
+		}
+	}()
+}
+
+// RunOnce sends every reminder that is due at now in every organization, returning how many were sent
+func (s *ReminderScheduler) RunOnce(ctx context.Context, now time.Time) (int, error) {
+	campaigns, err := access.Run(ctx, s.transactor, func(tx access.Tx) (interface{}, error) {
+		return s.campaignAccess.GetActiveCampaigns(tx)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, campaign := range campaigns.([]models.ReminderCampaign) {
+		campaign := campaign
+		campaignCtx := access.WithOrganization(ctx, campaign.OrganizationID)
+		preview, err := s.Preview(campaignCtx, &campaign, now)
+		if err != nil {
+			return sent, err
+		}
+		if preview.SendsAt == nil || preview.SendsAt.After(now) {
+			continue
+		}
+
+		for _, recipient := range preview.Recipients {
+			notification, err := s.notifier.SendReminder(campaignCtx, &campaign, recipient.InvitationID, *preview.OffsetDays)
+			if err != nil {
+				log.WithFields(log.Fields{
+					"campaign_id":   campaign.ID,
+					"invitation_id": recipient.InvitationID,
+					"error":         err,
+				}).Error("Unable to send reminder")
+				continue
+			}
+			if notification != nil {
+				sent++
+			}
+		}
+	}
+
+	if sent > 0 {
+		log.WithFields(log.Fields{
+			"sent": sent,
+		}).Info("Sent reminders")
+	}
+	return sent, nil
+}
+
+// Preview gets who the campaign will remind next and when, as of now. Paused campaigns are previewed
+// as if they were running.
+func (s *ReminderScheduler) Preview(ctx context.Context, campaign *models.ReminderCampaign, now time.Time) (*models.ReminderPreview, error) {
+	preview, err := access.Run(ctx, s.transactor, func(tx access.Tx) (interface{}, error) {
+		event, err := s.eventAccess.GetEvent(tx, campaign.EventID)
+		if err != nil {
+			return nil, err
+		}
+		if event == nil {
+			return nil, utils.HTTPNotFoundError.Here().WithMessage("Campaign event not found")
+		}
+
+		preview := &models.ReminderPreview{
+			Campaign:     campaign,
+			RSVPDeadline: event.RSVPDeadline,
+			Recipients:   []models.ReminderRecipient{},
+		}
+		if event.RSVPDeadline == nil {
+			return preview, nil
+		}
+		offset, sendsAt, ok := nextReminder(campaign.OffsetDays, *event.RSVPDeadline, now)
+		if !ok {
+			return preview, nil
+		}
+		preview.OffsetDays = &offset
+		preview.SendsAt = &sendsAt
+
+		preview.Recipients, err = s.campaignAccess.GetReminderRecipients(tx, campaign, offset)
+		if err != nil {
+			return nil, err
+		}
+		return preview, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return preview.(*models.ReminderPreview), nil
+}
@@ -0,0 +1,131 @@
+package notifications
+
+import (
+	"bytes"
+	"github.com/kyrstenkelly/rsvp-api/db/models"
+	htmlTemplate "html/template"
+	"strings"
+	textTemplate "text/template"
+)
+
+// templateData is what every email template is rendered with. Event is the event the email is
+// about, and Events all of those the invitation covers.
+type templateData struct {
+	Invitation *models.Invitation
+	Event      *models.Event
+	Events     []models.Event
+	RSVP       *models.RSVP
+	RSVPURL    string
+	DaysLeft   int
+}
+
+// EventNames lists the names of the invitation's events for a subject line
+func (d *templateData) EventNames() string {
+	var names []string
+	for _, event := range d.Events {
+		names = append(names, event.Name)
+	}
+	if len(names) < 2 {
+		return strings.Join(names, "")
+	}
+	return strings.Join(names[:len(names)-1], ", ") + " and " + names[len(names)-1]
+}
+
+// emailTemplate holds the subject, plain text and html templates for one kind of email
+type emailTemplate struct {
+	subject *textTemplate.Template
+	text    *textTemplate.Template
+	html    *htmlTemplate.Template
+}
+
+func newEmailTemplate(name string, subject string, text string, html string) *emailTemplate {
+	return &emailTemplate{
+		subject: textTemplate.Must(textTemplate.New(name + "_subject").Parse(subject)),
+		text:    textTemplate.Must(textTemplate.New(name + "_text").Parse(text)),
+		html:    htmlTemplate.Must(htmlTemplate.New(name + "_html").Parse(html)),
+	}
+}
+
+// render builds a message for the recipients from the templates
+func (t *emailTemplate) render(to []string, data *templateData) (Message, error) {
+	var subject, text, html bytes.Buffer
+	if err := t.subject.Execute(&subject, data); err != nil {
+		return Message{}, err
+	}
+	if err := t.text.Execute(&text, data); err != nil {
+		return Message{}, err
+	}
+	if err := t.html.Execute(&html, data); err != nil {
+		return Message{}, err
+	}
+	return Message{
+		To:      to,
+		Subject: strings.TrimSpace(subject.String()),
+		Text:    text.String(),
+		HTML:    html.String(),
+	}, nil
+}
+
+var invitationTemplate = newEmailTemplate("invitation",
+	`You're invited to {{.EventNames}}`,
+	`Hi {{.Invitation.Name}},
+{{range .Events}}
+You're invited to {{.Name}} on {{.Date}}{{if .Location}} at {{.Location}}{{end}}.{{if .RSVPDeadline}} Kindly respond by {{.RSVPDeadline.Format "January 2, 2006"}}.{{end}}
+{{end}}
+Please let us know if you can make it:
+{{.RSVPURL}}
+`,
+	`<p>Hi {{.Invitation.Name}},</p>
+{{range .Events}}<p>You're invited to <strong>{{.Name}}</strong> on {{.Date}}{{if .Location}} at {{.Location}}{{end}}.{{if .RSVPDeadline}} Kindly respond by {{.RSVPDeadline.Format "January 2, 2006"}}.{{end}}</p>
+{{end}}<p><a href="{{.RSVPURL}}">Let us know if you can make it</a></p>
+`)
+
+var rsvpConfirmationTemplate = newEmailTemplate("rsvp_confirmation",
+	`Your RSVP for {{.EventNames}}`,
+	`Hi {{.Invitation.Name}},
+
+Thanks for responding to {{.EventNames}}. Here's what we have:
+{{range $event := .Events}}
+{{$event.Name}}:{{range $.RSVP.RSVPGuests}}{{if eq .EventID $event.ID}}
+  {{if .Guest}}{{.Guest.Name}}{{end}}: {{if .Attending}}attending{{if .FoodChoice}} ({{.FoodChoice}}){{end}}{{else}}not attending{{end}}{{end}}{{end}}
+{{end}}
+You can change your response at {{.RSVPURL}}
+`,
+	`<p>Hi {{.Invitation.Name}},</p>
+<p>Thanks for responding to <strong>{{.EventNames}}</strong>. Here's what we have:</p>
+{{range $event := .Events}}<p><strong>{{$event.Name}}</strong></p>
+<ul>
+{{range $.RSVP.RSVPGuests}}{{if eq .EventID $event.ID}}<li>{{if .Guest}}{{.Guest.Name}}{{end}}: {{if .Attending}}attending{{if .FoodChoice}} ({{.FoodChoice}}){{end}}{{else}}not attending{{end}}</li>
+{{end}}{{end}}</ul>
+{{end}}<p>You can <a href="{{.RSVPURL}}">change your response</a> at any time.</p>
+`)
+
+var adminAlertTemplate = newEmailTemplate("admin_alert",
+	`New RSVP from {{.Invitation.Name}}{{if .RSVP.Late}} (late){{end}}`,
+	`{{.Invitation.Name}} ({{.Invitation.Email}}) responded to {{.EventNames}}{{if .RSVP.Late}} after the RSVP deadline{{end}}:
+{{range $event := .Events}}
+{{$event.Name}}:{{range $.RSVP.RSVPGuests}}{{if eq .EventID $event.ID}}
+  {{if .Guest}}{{.Guest.Name}}{{end}}{{if .IsPlusOne}} (plus one){{end}}: {{if .Attending}}attending{{if .FoodChoice}} ({{.FoodChoice}}){{end}}{{else}}not attending{{end}}{{end}}{{end}}
+{{end}}`,
+	`<p>{{.Invitation.Name}} ({{.Invitation.Email}}) responded to <strong>{{.EventNames}}</strong>{{if .RSVP.Late}} after the RSVP deadline{{end}}:</p>
+{{range $event := .Events}}<p><strong>{{$event.Name}}</strong></p>
+<ul>
+{{range $.RSVP.RSVPGuests}}{{if eq .EventID $event.ID}}<li>{{if .Guest}}{{.Guest.Name}}{{end}}{{if .IsPlusOne}} (plus one){{end}}: {{if .Attending}}attending{{if .FoodChoice}} ({{.FoodChoice}}){{end}}{{else}}not attending{{end}}</li>
+{{end}}{{end}}</ul>
+{{end}}`)
+
+var reminderTemplate = newEmailTemplate("reminder",
+	`Reminder: please RSVP to {{.Event.Name}}`,
+	`Hi {{.Invitation.Name}},
+
+We haven't heard from you yet about {{.Event.Name}} on {{.Event.Date}}.
+{{if .Event.RSVPDeadline}}Responses are due in {{.DaysLeft}} day{{if ne .DaysLeft 1}}s{{end}}, by {{.Event.RSVPDeadline.Format "January 2, 2006"}}.
+{{end}}
+Please let us know if you can make it:
+{{.RSVPURL}}
+`,
+	`<p>Hi {{.Invitation.Name}},</p>
+<p>We haven't heard from you yet about <strong>{{.Event.Name}}</strong> on {{.Event.Date}}.</p>
+{{if .Event.RSVPDeadline}}<p>Responses are due in {{.DaysLeft}} day{{if ne .DaysLeft 1}}s{{end}}, by {{.Event.RSVPDeadline.Format "January 2, 2006"}}.</p>{{end}}
+<p><a href="{{.RSVPURL}}">Let us know if you can make it</a></p>
+`)
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/kyrstenkelly/rsvp-api/db"
+	"github.com/kyrstenkelly/rsvp-api/db/access"
+	"github.com/kyrstenkelly/rsvp-api/db/models"
+	"strings"
+)
+
+// runOrganizations handles `rsvp-api organizations list` and `rsvp-api organizations create <slug> <name>`
+func runOrganizations(args []string) error {
+	usage := fmt.Errorf("usage: rsvp-api organizations list|create <slug> <name>")
+	if len(args) == 0 {
+		return usage
+	}
+
+	err := db.InitDb()
+	if err != nil {
+		return err
+	}
+	transactor := access.NewPostgresTransactor(db.GetDBConn())
+	dao := access.NewOrganizationsDAO()
+
+	switch args[0] {
+	case "list":
+		organizations, err := access.Run(context.Background(), transactor, func(tx access.Tx) (interface{}, error) {
+			return dao.GetOrganizations(tx)
+		})
+		if err != nil {
+			return err
+		}
+		for _, organization := range organizations.([]models.Organization) {
+			fmt.Printf("%d\t%s\t%s\n", organization.ID, organization.Slug, organization.Name)
+		}
+		return nil
+	case "create":
+		if len(args) < 3 {
+			return usage
+		}
+		organization := &models.Organization{
+			Slug: args[1],
+			Name: strings.Join(args[2:], " "),
+		}
+		_, err := access.Run(context.Background(), transactor, func(tx access.Tx) (interface{}, error) {
+			return dao.CreateOrganization(tx, organization)
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Created organization %d %q\n", organization.ID, organization.Slug)
+		return nil
+	}
+	return usage
+}
+
+// organizationContext scopes a command to the organization with the given slug
+func organizationContext(transactor access.Transactor, slug string) (context.Context, error) {
+	organization, err := access.Run(context.Background(), transactor, func(tx access.Tx) (interface{}, error) {
+		return access.NewOrganizationsDAO().GetOrganizationBySlug(tx, slug)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if organization.(*models.Organization) == nil {
+		return nil, fmt.Errorf("no organization with slug %q", slug)
+	}
+	return access.WithOrganization(context.Background(), organization.(*models.Organization).ID), nil
+}
@@ -0,0 +1,423 @@
+package seating
+
+import (
+	"fmt"
+	"github.com/kyrstenkelly/rsvp-api/db/models"
+	"github.com/kyrstenkelly/rsvp-api/utils"
+	"sort"
+	"strings"
+)
+
+// searchBudget caps how many placements Solve tries before settling for a greedy seating
+const searchBudget = 100000
+
+// party is a set of guests that must sit at the same table: a household, merged with any other
+// households or guests a together constraint ties it to
+type party struct {
+	guests []int64
+	// apart are the other parties it can't share a table with
+	apart map[int]bool
+	// table is where the party's seated guests are, if it is kept there
+	table *int64
+}
+
+// parties groups the guests into parties, by invitation and together constraints, and works out
+// which parties the apart constraints keep from each other
+func parties(guests []models.SeatingGuest, constraints []models.SeatingConstraint) ([]*party, error) {
+	parent := make([]int, len(guests))
+	for i := range guests {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	union := func(members []int) {
+		for _, m := range members[1:] {
+			parent[find(m)] = find(members[0])
+		}
+	}
+
+	households := map[int64][]int{}
+	for i, guest := range guests {
+		households[guest.InvitationID] = append(households[guest.InvitationID], i)
+	}
+	for _, household := range households {
+		union(household)
+	}
+	for _, constraint := range constraints {
+		if members := constraintMembers(guests, constraint); constraint.Kind == models.SeatingTogether && len(members) > 1 {
+			union(members)
+		}
+	}
+
+	var groups []*party
+	roots := map[int]int{}
+	partyOf := map[int64]int{}
+	for i, guest := range guests {
+		root := find(i)
+		p, ok := roots[root]
+		if !ok {
+			p = len(groups)
+			roots[root] = p
+			groups = append(groups, &party{apart: map[int]bool{}})
+		}
+		groups[p].guests = append(groups[p].guests, guest.RSVPGuestID)
+		partyOf[guest.RSVPGuestID] = p
+	}
+
+	for _, constraint := range constraints {
+		if constraint.Kind != models.SeatingApart {
+			continue
+		}
+		members := constraintMembers(guests, constraint)
+		for x := range members {
+			for y := x + 1; y < len(members); y++ {
+				if sameMember(constraint, guests[members[x]], guests[members[y]]) {
+					continue
+				}
+				px, py := partyOf[guests[members[x]].RSVPGuestID], partyOf[guests[members[y]].RSVPGuestID]
+				if px == py {
+					return nil, utils.ArgumentError.Here().WithMessagef(
+						"%s and %s must sit together, so seating constraint %d can't keep them apart",
+						guests[members[x]].Name, guests[members[y]].Name, constraint.ID)
+				}
+				groups[px].apart[py] = true
+				groups[py].apart[px] = true
+			}
+		}
+	}
+	return groups, nil
+}
+
+// constraintMembers gets the indexes of the guests a constraint covers: its guests, and every
+// attending guest on its invitations
+func constraintMembers(guests []models.SeatingGuest, constraint models.SeatingConstraint) []int {
+	guestIDs := map[int64]bool{}
+	for _, id := range constraint.GuestIDs {
+		guestIDs[id] = true
+	}
+	invitationIDs := map[int64]bool{}
+	for _, id := range constraint.InvitationIDs {
+		invitationIDs[id] = true
+	}
+	var members []int
+	for i, guest := range guests {
+		if guestIDs[guest.GuestID] || invitationIDs[guest.InvitationID] {
+			members = append(members, i)
+		}
+	}
+	return members
+}
+
+// sameMember reports whether two of the guests a constraint covers are covered as one member of
+// it, by being on the same one of its invitations. An apart constraint doesn't separate them.
+func sameMember(constraint models.SeatingConstraint, x models.SeatingGuest, y models.SeatingGuest) bool {
+	if x.InvitationID != y.InvitationID {
+		return false
+	}
+	for _, id := range constraint.InvitationIDs {
+		if id == x.InvitationID {
+			return true
+		}
+	}
+	return false
+}
+
+// Solve seats the guests at the tables, keeping each household and each together constraint at
+// one table, the parties of each apart constraint at different tables, and no table over its
+// capacity. If keepExisting is set, guests who are already seated stay where they are and the
+// rest of their party joins them if there's room. It returns a seat for every guest, with no
+// table for those it couldn't seat.
+func Solve(guests []models.SeatingGuest, tables []models.SeatingTable, constraints []models.SeatingConstraint,
+	keepExisting bool) ([]models.Seat, error) {
+	groups, err := parties(guests, constraints)
+	if err != nil {
+		return nil, err
+	}
+
+	capacity := map[int64]int{}
+	for _, table := range tables {
+		capacity[table.ID] = table.Capacity
+	}
+	seated := map[int64]int64{}
+	occupants := map[int64]map[int]bool{}
+	for _, table := range tables {
+		occupants[table.ID] = map[int]bool{}
+	}
+	seat := func(p int, guestID int64, tableID int64) {
+		seated[guestID] = tableID
+		capacity[tableID]--
+		occupants[tableID][p] = true
+	}
+
+	tableOf := map[int64]*int64{}
+	for _, guest := range guests {
+		tableOf[guest.RSVPGuestID] = guest.TableID
+	}
+	var free []int
+	for p, group := range groups {
+		if keepExisting {
+			counts := map[int64]int{}
+			for _, guestID := range group.guests {
+				if tableID := tableOf[guestID]; tableID != nil && occupants[*tableID] != nil {
+					seat(p, guestID, *tableID)
+					counts[*tableID]++
+				}
+			}
+			if len(counts) > 0 {
+				anchor := mostSeated(counts)
+				group.table = &anchor
+				continue
+			}
+		}
+		free = append(free, p)
+	}
+	for p, group := range groups {
+		if group.table == nil {
+			continue
+		}
+		for _, guestID := range group.guests {
+			if _, ok := seated[guestID]; !ok && capacity[*group.table] > 0 {
+				seat(p, guestID, *group.table)
+			}
+		}
+	}
+
+	// Seat the parties with the most apart constraints, then the largest, first
+	sort.SliceStable(free, func(i, j int) bool {
+		x, y := groups[free[i]], groups[free[j]]
+		if len(x.apart) != len(y.apart) {
+			return len(x.apart) > len(y.apart)
+		}
+		return len(x.guests) > len(y.guests)
+	})
+
+	fits := func(p int, tableID int64) bool {
+		if capacity[tableID] < len(groups[p].guests) {
+			return false
+		}
+		for other := range groups[p].apart {
+			if occupants[tableID][other] {
+				return false
+			}
+		}
+		return true
+	}
+	// candidates lists the tables a party fits at, the fullest first so large tables are kept for large parties
+	candidates := func(p int) []int64 {
+		var ids []int64
+		for _, table := range tables {
+			if fits(p, table.ID) {
+				ids = append(ids, table.ID)
+			}
+		}
+		sort.SliceStable(ids, func(i, j int) bool { return capacity[ids[i]] < capacity[ids[j]] })
+		return ids
+	}
+	// Seating others only takes seats away, so a party that fits at no table to begin with, such as one
+	// larger than every table, never will. Leave it unseated rather than searching every seating for it.
+	searchable := free[:0]
+	for _, p := range free {
+		if len(candidates(p)) > 0 {
+			searchable = append(searchable, p)
+		}
+	}
+	free = searchable
+
+	place := func(p int, tableID int64) {
+		for _, guestID := range groups[p].guests {
+			seat(p, guestID, tableID)
+		}
+	}
+	unplace := func(p int, tableID int64) {
+		for _, guestID := range groups[p].guests {
+			delete(seated, guestID)
+			capacity[tableID]++
+		}
+		delete(occupants[tableID], p)
+	}
+
+	steps := 0
+	var search func(i int) bool
+	search = func(i int) bool {
+		if i == len(free) {
+			return true
+		}
+		for _, tableID := range candidates(free[i]) {
+			if steps++; steps > searchBudget {
+				return false
+			}
+			place(free[i], tableID)
+			if search(i + 1) {
+				return true
+			}
+			unplace(free[i], tableID)
+		}
+		return false
+	}
+	if !search(0) {
+		// No seating fits everyone, or it's too costly to find: seat whoever fits, in the same order
+		for _, p := range free {
+			for _, guestID := range groups[p].guests {
+				if tableID, ok := seated[guestID]; ok {
+					unplace(p, tableID)
+					break
+				}
+			}
+		}
+		for _, p := range free {
+			if ids := candidates(p); len(ids) > 0 {
+				place(p, ids[0])
+			}
+		}
+	}
+
+	seats := []models.Seat{}
+	for _, guest := range guests {
+		s := models.Seat{RSVPGuestID: guest.RSVPGuestID}
+		if tableID, ok := seated[guest.RSVPGuestID]; ok {
+			s.TableID = &tableID
+		}
+		seats = append(seats, s)
+	}
+	return seats, nil
+}
+
+// mostSeated gets the table with the most of a party's guests, the lowest id on a tie
+func mostSeated(counts map[int64]int) int64 {
+	var best int64
+	for tableID, count := range counts {
+		if best == 0 || count > counts[best] || (count == counts[best] && tableID < best) {
+			best = tableID
+		}
+	}
+	return best
+}
+
+// Chart lays the guests out by table, listing who isn't seated and how the seating breaks the
+// constraints: split households, together constraints split across tables or left unseated,
+// apart constraints sharing a table, and tables over capacity.
+func Chart(event *models.Event, tables []models.SeatingTable, guests []models.SeatingGuest,
+	constraints []models.SeatingConstraint) *models.SeatingChart {
+	chart := &models.SeatingChart{
+		EventID:    event.ID,
+		EventName:  event.Name,
+		Tables:     []models.SeatingChartTable{},
+		Unseated:   []models.SeatingGuest{},
+		Violations: []string{},
+	}
+	byTable := map[int64]int{}
+	for _, table := range tables {
+		byTable[table.ID] = len(chart.Tables)
+		chart.Tables = append(chart.Tables, models.SeatingChartTable{Table: table, Guests: []models.SeatingGuest{}, SeatsLeft: table.Capacity})
+	}
+	for _, guest := range guests {
+		t, ok := 0, false
+		if guest.TableID != nil {
+			t, ok = byTable[*guest.TableID]
+		}
+		if !ok {
+			chart.Unseated = append(chart.Unseated, guest)
+			continue
+		}
+		chart.Tables[t].Guests = append(chart.Tables[t].Guests, guest)
+		chart.Tables[t].SeatsLeft--
+	}
+
+	for _, table := range chart.Tables {
+		if table.SeatsLeft < 0 {
+			chart.Violations = append(chart.Violations,
+				fmt.Sprintf("%s seats %d but has %d guests", table.Table.Name, table.Table.Capacity, len(table.Guests)))
+		}
+	}
+	tableName := func(guest models.SeatingGuest) string {
+		if t, ok := byTable[derefTable(guest.TableID)]; ok {
+			return chart.Tables[t].Table.Name
+		}
+		return "no table"
+	}
+
+	households := map[int64][]models.SeatingGuest{}
+	var invitationIDs []int64
+	for _, guest := range guests {
+		if _, ok := households[guest.InvitationID]; !ok {
+			invitationIDs = append(invitationIDs, guest.InvitationID)
+		}
+		households[guest.InvitationID] = append(households[guest.InvitationID], guest)
+	}
+	for _, invitationID := range invitationIDs {
+		household := households[invitationID]
+		if split(household) {
+			chart.Violations = append(chart.Violations,
+				fmt.Sprintf("The %s household is split across %s", household[0].InvitationName, tableNames(household, tableName)))
+		}
+	}
+
+	for _, constraint := range constraints {
+		var members []models.SeatingGuest
+		for _, i := range constraintMembers(guests, constraint) {
+			members = append(members, guests[i])
+		}
+		switch constraint.Kind {
+		case models.SeatingTogether:
+			if len(members) > 1 && split(members) {
+				chart.Violations = append(chart.Violations, fmt.Sprintf("Seating constraint %d keeps %s together, but they are at %s",
+					constraint.ID, guestNames(members), tableNames(members, tableName)))
+			}
+		case models.SeatingApart:
+			for x := range members {
+				for y := x + 1; y < len(members); y++ {
+					if sameMember(constraint, members[x], members[y]) {
+						continue
+					}
+					if members[x].TableID != nil && members[y].TableID != nil && *members[x].TableID == *members[y].TableID {
+						chart.Violations = append(chart.Violations, fmt.Sprintf("Seating constraint %d keeps %s and %s apart, but they are both at %s",
+							constraint.ID, members[x].Name, members[y].Name, tableName(members[x])))
+					}
+				}
+			}
+		}
+	}
+	return chart
+}
+
+// split reports whether guests who should sit together aren't all at the same table
+func split(guests []models.SeatingGuest) bool {
+	for _, guest := range guests[1:] {
+		if derefTable(guest.TableID) != derefTable(guests[0].TableID) {
+			return true
+		}
+	}
+	return false
+}
+
+func derefTable(tableID *int64) int64 {
+	if tableID == nil {
+		return 0
+	}
+	return *tableID
+}
+
+func guestNames(guests []models.SeatingGuest) string {
+	names := []string{}
+	for _, guest := range guests {
+		names = append(names, guest.Name)
+	}
+	return strings.Join(names, ", ")
+}
+
+func tableNames(guests []models.SeatingGuest, tableName func(models.SeatingGuest) string) string {
+	seen := map[string]bool{}
+	names := []string{}
+	for _, guest := range guests {
+		if name := tableName(guest); !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, ", ")
+}
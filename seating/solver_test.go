@@ -0,0 +1,52 @@
+package seating
+
+import (
+	"github.com/kyrstenkelly/rsvp-api/db/models"
+	"testing"
+)
+
+func TestSolveSetsAsideOversizedParties(t *testing.T) {
+	tables := []models.SeatingTable{
+		{ID: 1, Name: "One", Capacity: 1},
+		{ID: 2, Name: "Two", Capacity: 2},
+		{ID: 3, Name: "Three", Capacity: 3},
+	}
+	var guests []models.SeatingGuest
+	household := func(invitationID int64, size int) {
+		for i := 0; i < size; i++ {
+			id := int64(len(guests) + 1)
+			guests = append(guests, models.SeatingGuest{RSVPGuestID: id, GuestID: id, InvitationID: invitationID})
+		}
+	}
+	household(1, 2)
+	household(2, 2)
+	household(3, 1)
+	household(4, 1)
+	// Larger than every table, and with the most apart constraints, so it would be searched first
+	household(5, 4)
+	constraints := []models.SeatingConstraint{
+		{ID: 1, Kind: models.SeatingApart, InvitationIDs: []int64{5, 3}},
+		{ID: 2, Kind: models.SeatingApart, InvitationIDs: []int64{5, 4}},
+	}
+
+	seats, err := Solve(guests, tables, constraints, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	taken := map[int64]int{}
+	for i, seat := range seats {
+		oversized := guests[i].InvitationID == 5
+		if oversized != (seat.TableID == nil) {
+			t.Errorf("guest %d of invitation %d has table %v", seat.RSVPGuestID, guests[i].InvitationID, seat.TableID)
+		}
+		if seat.TableID != nil {
+			taken[*seat.TableID]++
+		}
+	}
+	for _, table := range tables {
+		if taken[table.ID] != table.Capacity {
+			t.Errorf("table %s seats %d of %d", table.Name, taken[table.ID], table.Capacity)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package trash
+
+import (
+	"context"
+	"github.com/kelseyhightower/envconfig"
+	"github.com/kyrstenkelly/rsvp-api/db/access"
+	"github.com/kyrstenkelly/rsvp-api/db/models"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+// Config holds how long deleted rows are kept in the trash, and how often it is purged
+type Config struct {
+	Retention     time.Duration `envconfig:"TRASH_RETENTION" default:"720h"`
+	PurgeInterval time.Duration `envconfig:"TRASH_PURGE_INTERVAL" default:"1h"`
+}
+
+// GetConfig loads the config object from env vars and returns it
+func GetConfig() (*Config, error) {
+	var config Config
+
+	if err := envconfig.Process("", &config); err != nil {
+		return nil, err
+	}
+
+	return &config, nil
+}
+
+// Purger periodically removes everything that has been in the trash for longer than the retention
+// period for good, in every organization
+type Purger struct {
+	transactor         access.Transactor
+	organizationAccess access.OrganizationsAccess
+	trashAccess        access.TrashAccess
+	config             Config
+}
+
+// NewPurger creates a new purger with the given config, transactor and daos
+func NewPurger(config Config, transactor access.Transactor, organizationAccess access.OrganizationsAccess,
+	trashAccess access.TrashAccess) *Purger {
+	return &Purger{
+		transactor:         transactor,
+		organizationAccess: organizationAccess,
+		trashAccess:        trashAccess,
+		config:             config,
+	}
+}
+
+// NewPurgerFromEnv creates a new purger configured from env vars, falling back to the defaults
+// if they can't be read
+func NewPurgerFromEnv(transactor access.Transactor, organizationAccess access.OrganizationsAccess,
+	trashAccess access.TrashAccess) *Purger {
+	config, err := GetConfig()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Error("Unable to load trash config, using the defaults")
+		config = &Config{Retention: 30 * 24 * time.Hour, PurgeInterval: time.Hour}
+	}
+	return NewPurger(*config, transactor, organizationAccess, trashAccess)
+}
+
+// Retention is how long deleted rows are kept in the trash
+func (p *Purger) Retention() time.Duration {
+	return p.config.Retention
+}
+
+// Start runs the purger in the background every TRASH_PURGE_INTERVAL until ctx is done
+func (p *Purger) Start(ctx context.Context) {
+	log.WithFields(log.Fields{
+		"retention": p.config.Retention,
+		"interval":  p.config.PurgeInterval,
+	}).Info("Scheduling trash purges")
+
+	go func() {
+		for {
+			if _, err := p.RunOnce(ctx, time.Now()); err != nil && ctx.Err() == nil {
+				log.WithFields(log.Fields{
+					"error": err,
+				}).Error("Unable to purge the trash")
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(p.config.PurgeInterval):
+			}
+		}
+	}()
+}
+
+// RunOnce purges everything deleted more than the retention period before now in every
+// organization, returning how many rows were removed
+func (p *Purger) RunOnce(ctx context.Context, now time.Time) (*models.PurgeResult, error) {
+	organizations, err := access.Run(ctx, p.transactor, func(tx access.Tx) (interface{}, error) {
+		return p.organizationAccess.GetOrganizations(tx)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	total := &models.PurgeResult{}
+	for _, organization := range organizations.([]models.Organization) {
+		purged, err := p.Purge(access.WithOrganization(ctx, organization.ID), now)
+		if err != nil {
+			return total, err
+		}
+		total.Events += purged.Events
+		total.Invitations += purged.Invitations
+		total.RSVPs += purged.RSVPs
+	}
+	return total, nil
+}
+
+// Purge purges everything deleted more than the retention period before now in the context's organization
+func (p *Purger) Purge(ctx context.Context, now time.Time) (*models.PurgeResult, error) {
+	purged, err := access.Run(ctx, p.transactor, func(tx access.Tx) (interface{}, error) {
+		return p.trashAccess.PurgeTrash(tx, now.Add(-p.config.Retention))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := purged.(*models.PurgeResult)
+	if result.Events+result.Invitations+result.RSVPs > 0 {
+		log.WithFields(log.Fields{
+			"organization_id": access.ContextOrganizationID(ctx),
+			"events":          result.Events,
+			"invitations":     result.Invitations,
+			"rsvps":           result.RSVPs,
+		}).Info("Purged the trash")
+	}
+	return result, nil
+}
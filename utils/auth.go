@@ -0,0 +1,26 @@
+package utils
+
+import (
+	"context"
+	"net/http"
+)
+
+// Principal is who made an authenticated request, from their token
+type Principal struct {
+	Subject        string
+	Roles          []string
+	OrganizationID int64
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a copy of the request carrying the principal who made it
+func WithPrincipal(request *http.Request, principal *Principal) *http.Request {
+	return request.WithContext(context.WithValue(request.Context(), principalKey{}, principal))
+}
+
+// GetPrincipal gets who made a request, or nil if it didn't need a token
+func GetPrincipal(request *http.Request) *Principal {
+	principal, _ := request.Context().Value(principalKey{}).(*Principal)
+	return principal
+}
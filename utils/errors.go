@@ -18,6 +18,12 @@ var (
 
 	// StatusConflictError error with conflicting statuses
 	StatusConflictError = merry.WithMessage(InputError, "Conflicting status error").WithHTTPCode(http.StatusConflict)
+
+	// ImmutableFieldError a request tried to change a field the caller may not change
+	ImmutableFieldError = merry.WithMessage(InputError, "This field cannot be changed").WithHTTPCode(http.StatusUnprocessableEntity)
+
+	// RSVPDeadlinePassedError a guest tried to respond after the event's RSVP deadline
+	RSVPDeadlinePassedError = merry.WithMessage(InputError, "The RSVP deadline for this event has passed").WithHTTPCode(http.StatusForbidden)
 )
 
 // HTTP Errors
@@ -37,6 +43,18 @@ var (
 
 	// HTTPNotFoundError for 404 error codes
 	HTTPNotFoundError = HTTPError.WithMessage("404 Not Found").WithHTTPCode(http.StatusNotFound)
+
+	// HTTPPreconditionFailedError is for 412 error codes, when an If-Match header doesn't match the current version
+	HTTPPreconditionFailedError = HTTPError.WithMessage("412 Precondition Failed").WithHTTPCode(http.StatusPreconditionFailed)
+
+	// HTTPPreconditionRequiredError is for 428 error codes, when a write has no If-Match header
+	HTTPPreconditionRequiredError = HTTPError.WithMessage("428 Precondition Required").WithHTTPCode(http.StatusPreconditionRequired)
+
+	// HTTPServiceUnavailableError is for 503 error codes
+	HTTPServiceUnavailableError = HTTPError.WithMessage("503 Service Unavailable").WithHTTPCode(http.StatusServiceUnavailable)
+
+	// HTTPTooManyRequestsError is for 429 error codes
+	HTTPTooManyRequestsError = HTTPError.WithMessage("429 Too Many Requests").WithHTTPCode(http.StatusTooManyRequests)
 )
 
 // Marshalling Errors
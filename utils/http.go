@@ -1,14 +1,15 @@
 package utils
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"github.com/ansel1/merry"
-	"github.com/go-pg/pg/v9"
 	"github.com/gorilla/mux"
-	"github.com/kyrstenkelly/rsvp-api/db"
 	log "github.com/sirupsen/logrus"
 	"net/http"
 	"strconv"
+	"strings"
 )
 
 // ErrorResponse type
@@ -16,9 +17,72 @@ type ErrorResponse struct {
 	Message string
 }
 
+type responseHeadersKey struct{}
+
+// SetResponseHeader sets a header on the response for a request being served by WrapHandler,
+// overriding the default json content type if need be
+func SetResponseHeader(request *http.Request, key string, value string) {
+	headers, ok := request.Context().Value(responseHeadersKey{}).(http.Header)
+	if !ok {
+		log.WithFields(log.Fields{
+			"header": key,
+		}).Error("Response headers can only be set within WrapHandler")
+		return
+	}
+	headers.Set(key, value)
+}
+
+// ETag formats a resource's version as an entity tag
+func ETag(version int64) string {
+	return fmt.Sprintf(`"%d"`, version)
+}
+
+// parseETag gets the version from an entity tag, weak or strong
+func parseETag(tag string) (int64, bool) {
+	tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+	if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+		return 0, false
+	}
+	version, err := strconv.ParseInt(tag[1:len(tag)-1], 10, 64)
+	return version, err == nil && version > 0
+}
+
+// IfMatchVersion gets the version a PUT, PATCH or DELETE's If-Match header says it was made against.
+// If-Match is required, and "*" matches any version, which is returned as 0.
+func IfMatchVersion(request *http.Request) (int64, error) {
+	header := strings.TrimSpace(request.Header.Get("If-Match"))
+	if header == "" {
+		return 0, HTTPPreconditionRequiredError.Here().WithMessage("An If-Match header with the resource's ETag is required")
+	}
+	if header == "*" {
+		return 0, nil
+	}
+	version, ok := parseETag(header)
+	if !ok {
+		return 0, HTTPPreconditionFailedError.Here()
+	}
+	return version, nil
+}
+
+// SerializeVersioned serializes a resource for a GET, PUT or PATCH response with its version as the ETag.
+// A GET whose If-None-Match header matches the version is answered with a 304 and no body.
+func SerializeVersioned(request *http.Request, obj interface{}, version int64) ([]byte, int, error) {
+	SetResponseHeader(request, "ETag", ETag(version))
+	if request.Method == http.MethodGet || request.Method == http.MethodHead {
+		for _, tag := range strings.Split(request.Header.Get("If-None-Match"), ",") {
+			if matched, ok := parseETag(tag); strings.TrimSpace(tag) == "*" || ok && matched == version {
+				return nil, http.StatusNotModified, nil
+			}
+		}
+	}
+	return SerializeResponse(obj, http.StatusOK)
+}
+
 // WrapHandler Extract attributes of errors and write them to ResponseWriter
 func WrapHandler(handler func(request *http.Request, vars map[string]string) ([]byte, int, error)) http.HandlerFunc {
 	f := func(writer http.ResponseWriter, request *http.Request) {
+		headers := http.Header{}
+		request = request.WithContext(context.WithValue(request.Context(), responseHeadersKey{}, headers))
 		buf, statusCode, err := handler(request, mux.Vars(request))
 
 		if err != nil {
@@ -30,9 +94,15 @@ func WrapHandler(handler func(request *http.Request, vars map[string]string) ([]
 			}
 
 			buf, _ = json.Marshal(&responseBody)
+			headers = http.Header{}
 		}
 
-		writer.Header().Set("Content-Type", "application/json")
+		if statusCode != http.StatusNotModified {
+			writer.Header().Set("Content-Type", "application/json")
+		}
+		for key, values := range headers {
+			writer.Header()[key] = values
+		}
 		writer.WriteHeader(statusCode)
 		_, err = writer.Write(buf)
 
@@ -46,30 +116,17 @@ func WrapHandler(handler func(request *http.Request, vars map[string]string) ([]
 	return f
 }
 
-// RunWithTransaction runs a database access call within a transaction
-func RunWithTransaction(call func(*pg.Tx) (interface{}, error)) (interface{}, error) {
-	conn := db.GetDBConn()
-	tx, err := conn.Begin()
-	if err != nil {
-		return nil, SQLError
-	}
-
-	result, err := call(tx)
-	if err != nil {
-		rollbackErr := tx.Rollback()
-		if rollbackErr != nil {
-			return nil, rollbackErr
-		}
-		log.Debug("Transaction rollback successful")
-		return nil, err
-	}
-
-	commitErr := tx.Commit()
-	if commitErr != nil {
-		return nil, commitErr
-	}
+// WriteError writes an error response in the same shape as WrapHandler, for handlers
+// that stream their own responses
+func WriteError(writer http.ResponseWriter, err error, statusCode int) {
+	log.Error(err)
 
-	return result, nil
+	buf, _ := json.Marshal(&ErrorResponse{
+		Message: merry.Message(err),
+	})
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(statusCode)
+	writer.Write(buf)
 }
 
 // SerializeResponse will marshal a response object into json
@@ -85,6 +142,15 @@ func SerializeResponse(obj interface{}, status int) ([]byte, int, error) {
 	return buf, status, nil
 }
 
+// StatusCode returns the http code attached to an input or http error,
+// falling back to the given status for any other error
+func StatusCode(err error, fallback int) int {
+	if merry.Is(err, InputError, HTTPError) {
+		return merry.HTTPCode(err)
+	}
+	return fallback
+}
+
 // GetIDFromVars parses the ID from vars to int64
 func GetIDFromVars(vars map[string]string) int64 {
 	id, err := strconv.ParseInt(vars["id"], 10, 64)
@@ -0,0 +1,64 @@
+package utils
+
+import (
+	"encoding/json"
+	"reflect"
+)
+
+// MergePatch applies a JSON Merge Patch (RFC 7386) to a json document. Fields absent from the
+// patch are kept, fields set to null are removed, objects are merged recursively and anything
+// else, arrays included, is replaced.
+func MergePatch(document []byte, patch []byte) ([]byte, error) {
+	var target, changes interface{}
+	if err := json.Unmarshal(document, &target); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(patch, &changes); err != nil {
+		return nil, RequestBodyError.Here().WithMessage("The patch is not valid json")
+	}
+	return json.Marshal(mergePatch(target, changes))
+}
+
+func mergePatch(target interface{}, patch interface{}) interface{} {
+	changes, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	merged, ok := target.(map[string]interface{})
+	if !ok {
+		merged = map[string]interface{}{}
+	}
+	for key, value := range changes {
+		if value == nil {
+			delete(merged, key)
+		} else {
+			merged[key] = mergePatch(merged[key], value)
+		}
+	}
+	return merged
+}
+
+// CheckImmutable returns an ImmutableFieldError if a json object sets any of the given fields
+// to something other than their value in current
+func CheckImmutable(body []byte, current interface{}, fields ...string) error {
+	var given map[string]interface{}
+	if err := json.Unmarshal(body, &given); err != nil {
+		return RequestBodyError.Here().WithMessage("The request body must be a json object")
+	}
+	buf, err := json.Marshal(current)
+	if err != nil {
+		return JSONMarshalingError.Here()
+	}
+	var existing map[string]interface{}
+	if err := json.Unmarshal(buf, &existing); err != nil {
+		return JSONMarshalingError.Here()
+	}
+
+	for _, field := range fields {
+		value, ok := given[field]
+		if ok && !reflect.DeepEqual(value, existing[field]) {
+			return ImmutableFieldError.Here().WithMessagef("%s cannot be changed", field)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,250 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/kelseyhightower/envconfig"
+	"github.com/kyrstenkelly/rsvp-api/db/access"
+	"github.com/kyrstenkelly/rsvp-api/db/models"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Headers sent with each delivery
+const (
+	HeaderDelivery  = "X-Webhook-Delivery"
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+// batchSize is how many deliveries are claimed at a time in each organization
+const batchSize = 50
+
+// Config holds how webhooks are delivered and retried
+type Config struct {
+	Interval    time.Duration `envconfig:"WEBHOOK_INTERVAL" default:"10s"`
+	Timeout     time.Duration `envconfig:"WEBHOOK_TIMEOUT" default:"10s"`
+	MaxAttempts int           `envconfig:"WEBHOOK_MAX_ATTEMPTS" default:"8"`
+	Backoff     time.Duration `envconfig:"WEBHOOK_BACKOFF" default:"30s"`
+	MaxBackoff  time.Duration `envconfig:"WEBHOOK_MAX_BACKOFF" default:"6h"`
+}
+
+// GetConfig loads the config object from env vars and returns it
+func GetConfig() (*Config, error) {
+	var config Config
+
+	if err := envconfig.Process("", &config); err != nil {
+		return nil, err
+	}
+
+	return &config, nil
+}
+
+// Sign gets the signature of a delivery's body sent at timestamp: the hex HMAC-SHA256 of
+// "<timestamp>.<body>" keyed with the webhook's secret, prefixed with "sha256="
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// backoff gets how long to wait before retrying a delivery that has failed attempts times. The
+// wait doubles with each attempt, up to the maximum.
+func backoff(config Config, attempts int) time.Duration {
+	wait := config.Backoff
+	for i := 1; i < attempts && wait < config.MaxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > config.MaxBackoff {
+		return config.MaxBackoff
+	}
+	return wait
+}
+
+// Dispatcher periodically sends the webhook deliveries that are due in every organization. A
+// delivery is claimed before it is sent, so two instances don't send it at once, and one that
+// fails is retried with exponential backoff until it has used up its attempts and is dead.
+type Dispatcher struct {
+	transactor         access.Transactor
+	organizationAccess access.OrganizationsAccess
+	webhookAccess      access.WebhooksAccess
+	config             Config
+	client             *http.Client
+}
+
+// NewDispatcher creates a new dispatcher with the given config, transactor and daos
+func NewDispatcher(config Config, transactor access.Transactor, organizationAccess access.OrganizationsAccess,
+	webhookAccess access.WebhooksAccess) *Dispatcher {
+	return &Dispatcher{
+		transactor:         transactor,
+		organizationAccess: organizationAccess,
+		webhookAccess:      webhookAccess,
+		config:             config,
+		client:             &http.Client{Timeout: config.Timeout},
+	}
+}
+
+// NewDispatcherFromEnv creates a new dispatcher configured from the WEBHOOK_ env vars, falling
+// back to the defaults if they can't be read
+func NewDispatcherFromEnv(transactor access.Transactor, organizationAccess access.OrganizationsAccess,
+	webhookAccess access.WebhooksAccess) *Dispatcher {
+	config, err := GetConfig()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Error("Unable to load webhook config, using the defaults")
+		config = &Config{Interval: 10 * time.Second, Timeout: 10 * time.Second, MaxAttempts: 8,
+			Backoff: 30 * time.Second, MaxBackoff: 6 * time.Hour}
+	}
+	return NewDispatcher(*config, transactor, organizationAccess, webhookAccess)
+}
+
+// Start runs the dispatcher in the background every WEBHOOK_INTERVAL until ctx is done
+func (d *Dispatcher) Start(ctx context.Context) {
+	log.WithFields(log.Fields{
+		"interval": d.config.Interval,
+	}).Info("Scheduling webhook deliveries")
+
+	go func() {
+		for {
+			if _, err := d.RunOnce(ctx); err != nil && ctx.Err() == nil {
+				log.WithFields(log.Fields{
+					"error": err,
+				}).Error("Unable to deliver webhooks")
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(d.config.Interval):
+			}
+		}
+	}()
+}
+
+// RunOnce sends the deliveries that are due in every organization, returning how many were delivered
+func (d *Dispatcher) RunOnce(ctx context.Context) (int, error) {
+	organizations, err := access.Run(ctx, d.transactor, func(tx access.Tx) (interface{}, error) {
+		return d.organizationAccess.GetOrganizations(tx)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	delivered := 0
+	for _, organization := range organizations.([]models.Organization) {
+		n, err := d.deliverDue(access.WithOrganization(ctx, organization.ID))
+		delivered += n
+		if err != nil {
+			return delivered, err
+		}
+	}
+	return delivered, nil
+}
+
+// deliverDue claims and sends a batch of the deliveries that are due in the context's organization
+func (d *Dispatcher) deliverDue(ctx context.Context) (int, error) {
+	var deliveries []models.WebhookDelivery
+	webhooks := map[int64]*models.Webhook{}
+	err := d.transactor.RunInTransaction(ctx, func(tx access.Tx) (err error) {
+		deliveries, err = d.webhookAccess.ClaimWebhookDeliveries(tx, batchSize)
+		if err != nil {
+			return err
+		}
+		for _, delivery := range deliveries {
+			if _, ok := webhooks[delivery.WebhookID]; ok {
+				continue
+			}
+			if webhooks[delivery.WebhookID], err = d.webhookAccess.GetWebhook(tx, delivery.WebhookID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	delivered := 0
+	for _, delivery := range deliveries {
+		webhook := webhooks[delivery.WebhookID]
+		if webhook == nil {
+			continue
+		}
+		responseStatus, sendErr := d.send(ctx, webhook, &delivery)
+		err := d.transactor.RunInTransaction(ctx, func(tx access.Tx) error {
+			if sendErr == nil {
+				return d.webhookAccess.MarkWebhookDelivered(tx, delivery.ID, *responseStatus)
+			}
+			var nextAttemptAt *time.Time
+			if delivery.Attempts < d.config.MaxAttempts {
+				next := time.Now().Add(backoff(d.config, delivery.Attempts))
+				nextAttemptAt = &next
+			}
+			return d.webhookAccess.MarkWebhookFailed(tx, delivery.ID, responseStatus, sendErr.Error(), nextAttemptAt)
+		})
+		if err != nil {
+			return delivered, err
+		}
+		if sendErr != nil {
+			log.WithFields(log.Fields{
+				"delivery_id": delivery.ID,
+				"webhook_id":  delivery.WebhookID,
+				"attempts":    delivery.Attempts,
+				"error":       sendErr,
+			}).Warn("Unable to deliver webhook")
+			continue
+		}
+		delivered++
+	}
+	return delivered, nil
+}
+
+// send POSTs a delivery to its webhook, signed with the webhook's secret. Any 2xx response is
+// success. It returns the response's status code, or nil if there was no response.
+func (d *Dispatcher) send(ctx context.Context, webhook *models.Webhook, delivery *models.WebhookDelivery) (*int, error) {
+	body, err := json.Marshal(models.WebhookPayload{
+		ID:        delivery.ID,
+		Event:     delivery.Event,
+		CreatedAt: delivery.CreatedAt,
+		Data:      delivery.Data,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	timestamp := time.Now().Unix()
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "rsvp-api-webhooks")
+	request.Header.Set(HeaderDelivery, strconv.FormatInt(delivery.ID, 10))
+	request.Header.Set(HeaderEvent, delivery.Event)
+	request.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	request.Header.Set(HeaderSignature, Sign(webhook.Secret, timestamp, body))
+
+	response, err := d.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(response.Body, 64*1024))
+
+	status := response.StatusCode
+	if status < 200 || status > 299 {
+		return &status, fmt.Errorf("webhook responded %s", response.Status)
+	}
+	return &status, nil
+}
@@ -0,0 +1,227 @@
+package webhooks
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/kyrstenkelly/rsvp-api/db/access"
+	"github.com/kyrstenkelly/rsvp-api/db/models"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	config := Config{Backoff: 30 * time.Second, MaxBackoff: 3 * time.Minute}
+	expected := map[int]time.Duration{
+		1: 30 * time.Second,
+		2: time.Minute,
+		3: 2 * time.Minute,
+		4: 3 * time.Minute,
+		9: 3 * time.Minute,
+	}
+	for attempts, wait := range expected {
+		if got := backoff(config, attempts); got != wait {
+			t.Errorf("After %d attempts waited %v, expected %v", attempts, got, wait)
+		}
+	}
+}
+
+// receiver is a webhook endpoint that answers each delivery with the next of its statuses, or 200
+// once they run out, and checks every delivery is signed with its secret
+type receiver struct {
+	t        *testing.T
+	secret   string
+	mu       sync.Mutex
+	statuses []int
+	received []models.WebhookPayload
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, request *http.Request) {
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		r.t.Error(err)
+	}
+	timestamp := request.Header.Get(HeaderTimestamp)
+	mac := hmac.New(sha256.New, []byte(r.secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	if signature := request.Header.Get(HeaderSignature); signature != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+		r.t.Errorf("Delivery signed %q at %s doesn't match its body", signature, timestamp)
+	}
+	if seconds, err := strconv.ParseInt(timestamp, 10, 64); err != nil || time.Since(time.Unix(seconds, 0)) > time.Minute {
+		r.t.Errorf("Delivery has timestamp %q", timestamp)
+	}
+	var payload models.WebhookPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		r.t.Error(err)
+	}
+	if event := request.Header.Get(HeaderEvent); event != payload.Event {
+		r.t.Errorf("Delivery of %s has the event header %q", payload.Event, event)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.received = append(r.received, payload)
+	status := http.StatusOK
+	if len(r.statuses) > 0 {
+		status, r.statuses = r.statuses[0], r.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+func (r *receiver) answer(statuses ...int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.statuses = statuses
+}
+
+func (r *receiver) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.received)
+}
+
+func (r *receiver) last() models.WebhookPayload {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.received[len(r.received)-1]
+}
+
+func TestDispatcher(t *testing.T) {
+	store := access.NewMemoryStore()
+	organizations, err := access.Run(context.Background(), store.Transactor, func(tx access.Tx) (interface{}, error) {
+		return store.Organizations.GetOrganizations(tx)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := access.WithOrganization(context.Background(), organizations.([]models.Organization)[0].ID)
+	run := func(call func(tx access.Tx) (interface{}, error)) interface{} {
+		t.Helper()
+		result, err := access.Run(ctx, store.Transactor, call)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	hook := &receiver{t: t, secret: "receiver-secret"}
+	server := httptest.NewServer(hook)
+	defer server.Close()
+	run(func(tx access.Tx) (interface{}, error) {
+		return store.Webhooks.CreateWebhook(tx, &models.Webhook{
+			URL: server.URL, Secret: hook.secret, Events: models.WebhookEvents, Active: true,
+		})
+	})
+
+	config := Config{Timeout: time.Second, MaxAttempts: 2, Backoff: 200 * time.Millisecond, MaxBackoff: 400 * time.Millisecond}
+	dispatcher := NewDispatcher(config, store.Transactor, store.Organizations, store.Webhooks)
+	dispatch := func() int {
+		t.Helper()
+		delivered, err := dispatcher.RunOnce(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return delivered
+	}
+	queue := func(rsvpID int64) *models.WebhookDelivery {
+		t.Helper()
+		return run(func(tx access.Tx) (interface{}, error) {
+			if err := store.Webhooks.QueueWebhookEvent(tx, models.WebhookRSVPCreated, map[string]interface{}{"id": rsvpID}); err != nil {
+				return nil, err
+			}
+			deliveries, _, err := store.Webhooks.GetWebhookDeliveries(tx, access.ListQuery{Sort: "id", Desc: true, Limit: 1})
+			if err != nil || len(deliveries) == 0 {
+				return nil, err
+			}
+			return &deliveries[0], nil
+		}).(*models.WebhookDelivery)
+	}
+	get := func(id int64) *models.WebhookDelivery {
+		t.Helper()
+		return run(func(tx access.Tx) (interface{}, error) {
+			return store.Webhooks.GetWebhookDelivery(tx, id)
+		}).(*models.WebhookDelivery)
+	}
+	// dispatchWhenDue runs the dispatcher until it sends something, as a retry waits out its backoff
+	dispatchWhenDue := func() int {
+		t.Helper()
+		sent := hook.count()
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+			if delivered := dispatch(); delivered > 0 || hook.count() > sent {
+				return delivered
+			}
+		}
+		t.Fatal("Nothing was sent once the backoff passed")
+		return 0
+	}
+
+	t.Run("RetriedAfterAFailure", func(t *testing.T) {
+		delivery := queue(1)
+		hook.answer(http.StatusInternalServerError)
+		failedAt := time.Now()
+		if delivered := dispatch(); delivered != 0 {
+			t.Fatalf("A delivery answered with a 500 was counted as delivered")
+		}
+		failed := get(delivery.ID)
+		if failed.Status != models.WebhookPending || failed.Attempts != 1 || failed.ResponseStatus == nil ||
+			*failed.ResponseStatus != http.StatusInternalServerError {
+			t.Fatalf("Expected a pending delivery with 1 attempt and a 500, got %+v", failed)
+		}
+		if wait := failed.NextAttemptAt.Sub(failedAt); wait < config.Backoff || wait > config.Backoff+time.Second {
+			t.Errorf("Expected the retry in %v, got %v", config.Backoff, wait)
+		}
+
+		sent := hook.count()
+		dispatch()
+		if hook.count() != sent {
+			t.Error("The delivery was retried before its backoff passed")
+		}
+		if delivered := dispatchWhenDue(); delivered != 1 {
+			t.Errorf("Expected the retry to be delivered, got %d", delivered)
+		}
+		if retried := get(delivery.ID); retried.Status != models.WebhookDelivered || retried.Attempts != 2 {
+			t.Errorf("Expected a delivery delivered on its second attempt, got %+v", retried)
+		}
+	})
+
+	t.Run("DeadAfterMaxAttemptsAndReplayed", func(t *testing.T) {
+		delivery := queue(2)
+		hook.answer(http.StatusInternalServerError, http.StatusServiceUnavailable)
+		dispatch()
+		dispatchWhenDue()
+		dead := get(delivery.ID)
+		if dead.Status != models.WebhookDead || dead.Attempts != config.MaxAttempts {
+			t.Fatalf("Expected a dead delivery after %d attempts, got %+v", config.MaxAttempts, dead)
+		}
+		sent := hook.count()
+		time.Sleep(config.MaxBackoff)
+		dispatch()
+		if hook.count() != sent {
+			t.Error("A dead delivery was sent again")
+		}
+
+		replayed := run(func(tx access.Tx) (interface{}, error) {
+			return store.Webhooks.ReplayWebhookDelivery(tx, delivery.ID)
+		}).(*models.WebhookDelivery)
+		if replayed.Status != models.WebhookPending || replayed.Attempts != 0 {
+			t.Fatalf("Expected a replay to be pending with no attempts, got %+v", replayed)
+		}
+		if delivered := dispatch(); delivered != 1 {
+			t.Errorf("Expected the replay to be delivered straight away, got %d", delivered)
+		}
+		if delivered := get(delivery.ID); delivered.Status != models.WebhookDelivered || delivered.Attempts != 1 {
+			t.Errorf("Expected the replay delivered on its first attempt, got %+v", delivered)
+		}
+		if last := hook.last(); last.ID != delivery.ID || last.Data["id"] != float64(2) {
+			t.Errorf("Expected the replay to send delivery %d again, got %+v", delivery.ID, last)
+		}
+	})
+}